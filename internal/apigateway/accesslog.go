@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRequestIDLength bounds the X-Request-ID accepted from clients.
+const maxRequestIDLength = 128
+
+// requestInfo collects what the access log reports about a request as the
+// request passes through the router, the auth middleware and the upstream
+// endpoints.
+type requestInfo struct {
+	mtx      sync.Mutex
+	route    string
+	user     string
+	instance string
+	// client is the address of the end client. It is set before the request
+	// is served and not changed afterwards.
+	client string
+}
+
+type requestInfoContextKey struct{}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey{}).(*requestInfo)
+	if info == nil {
+		// Not served through accessLog; record into a throwaway.
+		info = &requestInfo{}
+	}
+	return info
+}
+
+func (i *requestInfo) set(field *string, value string) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	*field = value
+}
+
+// accessLog assigns every request an ID, taken from a valid X-Request-ID
+// header or generated, which is echoed in the response, added to the context
+// for usersvc and logged. It also finds the address of the client, trusting
+// the X-Forwarded-For of proxies. Once the request is served it logs one line
+// with the outcome and records it in m.
+func accessLog(logger log.Logger, m *gatewayMetrics, proxies trustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{client: proxies.clientIP(r)}
+		ctx := userservice.ContextWithRequestID(r.Context(), id)
+		ctx = context.WithValue(ctx, requestInfoContextKey{}, info)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		info.mtx.Lock()
+		defer info.mtx.Unlock()
+		route := info.route
+		if route == "" {
+			// Keep the label set bounded for requests matching no route.
+			route = "unmatched"
+		}
+		m.requests.With("method", r.Method, "route", route, "code", strconv.Itoa(sw.status)).Add(1)
+		m.duration.With("method", r.Method, "route", route).Observe(time.Since(begin).Seconds())
+		logger := log.With(logger, "request_id", id)
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			logger = log.With(logger, "trace_id", sc.TraceID())
+		}
+		logger.Log(
+			"method", r.Method,
+			"route", info.route,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"took", time.Since(begin),
+			"user", info.user,
+			"upstream", info.instance,
+			"client", info.client,
+		)
+	})
+}
+
+// recordRoute is a mux middleware recording the template of the matched route,
+// and naming the request's span after it.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			info := requestInfoFromContext(r.Context())
+			info.set(&info.route, tmpl)
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + tmpl)
+			span.SetAttributes(semconv.HTTPRoute(tmpl))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordInstance wraps the endpoint of an upstream instance to record that it
+// served the request, or was the last to be tried.
+func recordInstance(instance string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		info := requestInfoFromContext(ctx)
+		info.set(&info.instance, instance)
+		return next(ctx, request)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusWriter records the status and size of a response. Unwrap gives
+// http.ResponseController access to the flushing and hijacking of the
+// underlying writer.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
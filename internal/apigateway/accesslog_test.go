@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLine keeps the key/value pairs of one log line.
+type logLine map[string]interface{}
+
+// captureLogger returns a logger appending every line to lines.
+func captureLogger(lines *[]logLine) log.Logger {
+	return log.LoggerFunc(func(keyvals ...interface{}) error {
+		line := logLine{}
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			line[keyvals[i].(string)] = keyvals[i+1]
+		}
+		*lines = append(*lines, line)
+		return nil
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	var lines []logLine
+	var forwarded string
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	r.Handle("/user/{uid}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = userservice.RequestIDFromContext(r.Context())
+		info := requestInfoFromContext(r.Context())
+		info.set(&info.user, "u1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	h := accessLog(captureLogger(&lines), testGatewayMetrics(), nil, r)
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		requestID string
+		keepID    bool
+		status    int
+		route     string
+	}{
+		{"client request ID", "/user/u1", "req-1", true, http.StatusCreated, "/user/{uid}"},
+		{"no request ID", "/user/u1", "", false, http.StatusCreated, "/user/{uid}"},
+		{"request ID too long", "/user/u1", strings.Repeat("a", maxRequestIDLength+1), false, http.StatusCreated, "/user/{uid}"},
+		{"request ID with spaces", "/user/u1", "req 1", false, http.StatusCreated, "/user/{uid}"},
+		{"unmatched route", "/nowhere", "req-2", true, http.StatusNotFound, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lines, forwarded = nil, ""
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.requestID != "" {
+				req.Header.Set("X-Request-ID", tc.requestID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			id := rec.Header().Get("X-Request-ID")
+			if !validRequestID(id) || (id == tc.requestID) != tc.keepID {
+				t.Errorf("X-Request-ID = %q for %q", id, tc.requestID)
+			}
+			if tc.status != http.StatusNotFound && forwarded != id {
+				t.Errorf("request ID %q forwarded, want %q", forwarded, id)
+			}
+			if len(lines) != 1 {
+				t.Fatalf("%d lines logged, want 1", len(lines))
+			}
+			line := lines[0]
+			if line["request_id"] != id || line["status"] != tc.status || line["route"] != tc.route || line["client"] != "192.0.2.1" {
+				t.Errorf("logged %v", line)
+			}
+			if tc.status == http.StatusCreated && (line["user"] != "u1" || line["bytes"] != 5) {
+				t.Errorf("logged %v", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	"net/http"
+	"time"
+)
+
+// routeAccounts serves the authsvc routes on r, which register email and
+// password accounts, sign them in and reset their passwords. Changing a
+// password needs a caller that signIn authenticates, so it isn't served
+// without one.
+func routeAccounts(r *mux.Router, set authendpoint.Set, signIn mux.MiddlewareFunc, options []httptransport.ServerOption) {
+	r.Path("/register").
+		Handler(httptransport.NewServer(set.RegisterEndpoint, decodeRegisterRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+	r.Path("/login").
+		Handler(httptransport.NewServer(idToken(set.LoginEndpoint), decodeLoginRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+	if signIn != nil {
+		r.Path("/password").
+			Handler(signIn(rejectAPIKeys(httptransport.NewServer(set.ChangePasswordEndpoint, decodeChangePasswordRequest, encodeResponse, options...)))).
+			Methods(http.MethodPost)
+	}
+	r.Path("/password/reset").
+		Handler(httptransport.NewServer(set.RequestPasswordResetEndpoint, decodeRequestPasswordResetRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+	r.Path("/password/reset/confirm").
+		Handler(httptransport.NewServer(set.ResetPasswordEndpoint, decodeResetPasswordRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+}
+
+// idTokenResponse carries the ID token authsvc signed at login, named as in
+// OpenID Connect. It can be exchanged at /auth/token for a session.
+type idTokenResponse struct {
+	IDToken   string `json:"id_token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// idToken wraps the Login endpoint to answer with an idTokenResponse.
+func idToken(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		resp := response.(authendpoint.LoginResponse)
+		if resp.Err != nil {
+			return resp, nil
+		}
+		return idTokenResponse{
+			IDToken:   resp.Token,
+			TokenType: "Bearer",
+			ExpiresIn: max(int(time.Until(resp.ExpiresAt).Seconds()), 0),
+		}, nil
+	}
+}
+
+func decodeRegisterRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return authendpoint.RegisterRequest{Email: body.Email, Password: body.Password}, nil
+}
+
+func decodeLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return authendpoint.LoginRequest{Email: body.Email, Password: body.Password}, nil
+}
+
+// decodeChangePasswordRequest changes the password of the caller's account.
+func decodeChangePasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	id, _ := claims["user-id"].(string)
+	return authendpoint.ChangePasswordRequest{ID: id, CurrentPassword: body.CurrentPassword, NewPassword: body.NewPassword}, nil
+}
+
+func decodeRequestPasswordResetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return authendpoint.RequestPasswordResetRequest{Email: body.Email}, nil
+}
+
+func decodeResetPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return authendpoint.ResetPasswordRequest{Token: body.Token, NewPassword: body.NewPassword}, nil
+}
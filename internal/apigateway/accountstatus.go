@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accountStatusLookup returns the roles of a user and the suspension in effect
+// for them.
+type accountStatusLookup func(ctx context.Context, uid string) (model.AccountStatus, error)
+
+// accountStatusCache remembers the account statuses looked up for up to ttl,
+// and failed lookups for up to errorTTL, so that AuthenticationMiddleware
+// needn't ask usersvc on every request.
+type accountStatusCache struct {
+	lookup   accountStatusLookup
+	ttl      time.Duration
+	errorTTL time.Duration
+	size     int
+	failOpen bool
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	entries map[string]accountStatusEntry
+}
+
+type accountStatusEntry struct {
+	status  model.AccountStatus
+	err     error
+	expires time.Time
+}
+
+func newAccountStatusCache(lookup accountStatusLookup, cfg config.Accounts, logger log.Logger) *accountStatusCache {
+	return &accountStatusCache{
+		lookup:   lookup,
+		ttl:      cfg.CacheTTL,
+		errorTTL: cfg.ErrorTTL,
+		size:     cfg.CacheSize,
+		failOpen: cfg.FailOpen,
+		logger:   logger,
+		entries:  map[string]accountStatusEntry{},
+	}
+}
+
+// status returns the roles of uid and the suspension in effect for them. If
+// it can't be looked up, it returns an unavailableError, or, failing open, a
+// zero status: the user holds no roles and is let through.
+func (c *accountStatusCache) status(ctx context.Context, uid string) (model.AccountStatus, error) {
+	now := time.Now()
+	c.mtx.Lock()
+	e, ok := c.entries[uid]
+	c.mtx.Unlock()
+	if !ok || !now.Before(e.expires) {
+		e = c.refresh(ctx, uid, now)
+	}
+	if e.err != nil && !c.failOpen {
+		return model.AccountStatus{}, unavailableError{reason: "account status unavailable", retryAfter: e.expires.Sub(now)}
+	}
+	return e.status, nil
+}
+
+// refresh looks up the status of uid and caches the outcome.
+func (c *accountStatusCache) refresh(ctx context.Context, uid string, now time.Time) accountStatusEntry {
+	status, err := c.lookup(ctx, uid)
+	if err != nil {
+		c.logger.Log("during", "account status lookup", "user", uid, "err", err)
+		e := accountStatusEntry{err: err, expires: now.Add(c.errorTTL)}
+		if ctx.Err() != nil {
+			// The request gave up; the next one may well succeed.
+			return e
+		}
+		c.store(uid, e, now)
+		return e
+	}
+	e := accountStatusEntry{status: status, expires: now.Add(c.ttl)}
+	if s := status.Suspension; s != nil && !s.Until.IsZero() && s.Until.Before(e.expires) {
+		// Let the user back in as soon as the suspension ends.
+		e.expires = s.Until
+	}
+	c.store(uid, e, now)
+	return e
+}
+
+func (c *accountStatusCache) store(uid string, e accountStatusEntry, now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.entries[uid]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+	c.entries[uid] = e
+}
+
+// evict drops the expired entries or, if there are none, an arbitrary one.
+func (c *accountStatusCache) evict(now time.Time) {
+	for uid, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, uid)
+		}
+	}
+	if len(c.entries) < c.size {
+		return
+	}
+	for uid := range c.entries {
+		delete(c.entries, uid)
+		return
+	}
+}
+
+// encodeSuspended rejects a request from a suspended user.
+func encodeSuspended(w http.ResponseWriter, s *model.Suspension) {
+	body := map[string]interface{}{
+		"error": "account suspended",
+		"code":  "account_suspended",
+	}
+	if !s.Until.IsZero() {
+		body["until"] = s.Until.UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingLookup answers with the statuses and errors it was given, counting
+// the lookups of each user.
+type countingLookup struct {
+	statuses map[string]model.AccountStatus
+	err      error
+	calls    map[string]int
+}
+
+func (l *countingLookup) lookup(_ context.Context, uid string) (model.AccountStatus, error) {
+	l.calls[uid]++
+	return l.statuses[uid], l.err
+}
+
+func TestAccountStatusCache(t *testing.T) {
+	soon := time.Now().Add(30 * time.Millisecond)
+	l := &countingLookup{
+		statuses: map[string]model.AccountStatus{
+			"admin":  {Roles: []string{model.RoleAdmin}},
+			"banned": {Suspension: &model.Suspension{Reason: "spam"}},
+			"brief":  {Suspension: &model.Suspension{Reason: "spam", Until: soon}},
+		},
+		calls: map[string]int{},
+	}
+	c := newAccountStatusCache(l.lookup, config.Accounts{CacheTTL: time.Hour, ErrorTTL: time.Hour, CacheSize: 10}, log.NewNopLogger())
+	ctx := context.Background()
+
+	for _, uid := range []string{"admin", "admin", "banned", "banned", "u1"} {
+		if _, err := c.status(ctx, uid); err != nil {
+			t.Fatalf("status(%q) = %v", uid, err)
+		}
+	}
+	for uid, want := range map[string]int{"admin": 1, "banned": 1, "u1": 1} {
+		if l.calls[uid] != want {
+			t.Errorf("%s looked up %d times, want %d", uid, l.calls[uid], want)
+		}
+	}
+	if s, _ := c.status(ctx, "banned"); s.Suspension == nil {
+		t.Error("cached suspension lost")
+	}
+
+	// A suspension ending before the TTL is only cached until it ends.
+	c.status(ctx, "brief")
+	time.Sleep(time.Until(soon))
+	l.statuses["brief"] = model.AccountStatus{}
+	if s, _ := c.status(ctx, "brief"); s.Suspension != nil {
+		t.Error("suspension still cached after it ended")
+	}
+}
+
+func TestAccountStatusCacheFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failOpen bool
+	}{
+		{"failing closed", false},
+		{"failing open", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &countingLookup{
+				statuses: map[string]model.AccountStatus{"u1": {Roles: []string{model.RoleAdmin}}},
+				err:      errors.New("usersvc unreachable"),
+				calls:    map[string]int{},
+			}
+			c := newAccountStatusCache(l.lookup, config.Accounts{CacheTTL: time.Hour, ErrorTTL: 20 * time.Millisecond, CacheSize: 10, FailOpen: tc.failOpen}, log.NewNopLogger())
+
+			for range 3 {
+				s, err := c.status(context.Background(), "u1")
+				var unavailable unavailableError
+				if tc.failOpen {
+					if err != nil || s.Roles != nil {
+						t.Fatalf("status() = %+v, %v, want no roles", s, err)
+					}
+				} else if !errors.As(err, &unavailable) || unavailable.retryAfter <= 0 {
+					t.Fatalf("status() = %v, want an unavailableError with a retry delay", err)
+				}
+			}
+			if l.calls["u1"] != 1 {
+				t.Errorf("failed lookup repeated %d times within errorTTL", l.calls["u1"])
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			l.err = nil
+			if s, err := c.status(context.Background(), "u1"); err != nil || len(s.Roles) != 1 {
+				t.Errorf("status() after errorTTL = %+v, %v", s, err)
+			}
+
+			// A lookup given up by its request isn't remembered.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			l.err = context.Canceled
+			c.status(ctx, "u2")
+			l.err = nil
+			if _, err := c.status(context.Background(), "u2"); err != nil {
+				t.Errorf("canceled lookup cached: %v", err)
+			}
+		})
+	}
+}
+
+func TestAccountStatusCacheEviction(t *testing.T) {
+	l := &countingLookup{calls: map[string]int{}}
+	c := newAccountStatusCache(l.lookup, config.Accounts{CacheTTL: time.Hour, ErrorTTL: time.Hour, CacheSize: 2}, log.NewNopLogger())
+	for _, uid := range []string{"u1", "u2", "u3"} {
+		c.status(context.Background(), uid)
+	}
+	if len(c.entries) != 2 {
+		t.Errorf("%d entries cached, want at most 2", len(c.entries))
+	}
+}
+
+func TestAuthenticationMiddlewareUnavailableAccounts(t *testing.T) {
+	l := &countingLookup{err: errors.New("usersvc unreachable"), calls: map[string]int{}}
+	a := &AuthenticationMiddleware{
+		TokenProviders: []tokenprovider.TokenProvider{staticTokenProvider{"t1": {"user-id": "u1"}}},
+		Accounts:       newAccountStatusCache(l.lookup, config.Accounts{CacheTTL: time.Hour, ErrorTTL: 5 * time.Second, CacheSize: 10}, log.NewNopLogger()),
+	}
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request served without an account status")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/user/u1", nil)
+	r.Header.Set("Authorization", "t1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" {
+		t.Errorf("status %d with Retry-After %q, want 503 after 5s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// adminHandler serves the operational endpoints of the admin listener:
+//
+//	GET /metrics         Prometheus metrics
+//	GET /admin/breakers  circuit breaker state per upstream and instance
+func adminHandler(h *reloadingHandler) http.Handler {
+	r := mux.NewRouter()
+	r.Path("/metrics").Methods(http.MethodGet).Handler(promhttp.Handler())
+	r.Path("/admin/breakers").Methods(http.MethodGet).HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		status := map[string]map[string]breakerStatus{}
+		for upstream, b := range h.gateway().breakers {
+			status[upstream] = b.status()
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(status)
+	})
+	return r
+}
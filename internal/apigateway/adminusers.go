@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// routeAdminUsers serves the /admin/users routes on r. Listing, reading and
+// (un)suspending need the moderator or admin role; updating and deleting need
+// admin.
+func routeAdminUsers(r *mux.Router, set userendpoint.Set, options []httptransport.ServerOption) {
+	adminOnly := requireRole(model.RoleAdmin)
+	r.Path("").
+		Handler(httptransport.NewServer(set.ListUsersEndpoint, decodeListUsersRequest, encodeResponse, options...)).
+		Methods(http.MethodGet)
+	r.Path("/{uid}").
+		Handler(httptransport.NewServer(set.GetUserEndpoint, decodeGetUserRequest, encodeResponse, options...)).
+		Methods(http.MethodGet)
+	r.Path("/{uid}").
+		Handler(adminOnly(httptransport.NewServer(set.UpdateUserEndpoint, decodeUpdateUserRequest, encodeResponse, options...))).
+		Methods(http.MethodPut)
+	r.Path("/{uid}").
+		Handler(adminOnly(httptransport.NewServer(set.ForceDeleteEndpoint, decodeForceDeleteRequest, encodeResponse, options...))).
+		Methods(http.MethodDelete)
+	r.Path("/{uid}/suspend").
+		Handler(httptransport.NewServer(set.SuspendEndpoint, decodeSuspendRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+	r.Path("/{uid}/unsuspend").
+		Handler(httptransport.NewServer(set.UnsuspendEndpoint, decodeUnsuspendRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+}
+
+// requireRole returns a middleware that rejects callers whose claims carry none
+// of roles. It saves a round trip for obvious denials; usersvc makes the
+// authoritative check.
+func requireRole(roles ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := ClaimsFromContext(r.Context())
+			if err != nil {
+				encodeError(r.Context(), userservice.ErrUnauthenticated, w)
+				return
+			}
+			for _, role := range claimRoles(claims) {
+				if slices.Contains(roles, role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			encodeError(r.Context(), userservice.ErrPermissionDenied, w)
+		})
+	}
+}
+
+// reasonBody is the body of the admin routes that change a user without
+// editing it.
+type reasonBody struct {
+	Reason string `json:"reason"`
+}
+
+func decodeListUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := userendpoint.ListUsersRequest{
+		Role:      q.Get("role"),
+		PageToken: q.Get("pageToken"),
+	}
+	if v := q.Get("suspended"); v != "" {
+		suspended, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, badRequestError{errors.New("invalid suspended")}
+		}
+		req.SuspendedOnly = suspended
+	}
+	if v := q.Get("pageSize"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			return nil, badRequestError{errors.New("invalid pageSize")}
+		}
+		req.PageSize = size
+	}
+	return req, nil
+}
+
+func decodeGetUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return userendpoint.GetUserRequest{UUID: mux.Vars(r)["uid"]}, nil
+}
+
+func decodeUpdateUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request struct {
+		Email          *string  `json:"email"`
+		PhoneNumber    *string  `json:"phone_number"`
+		UserName       *string  `json:"user_name"`
+		ProfilePicture *string  `json:"profile_picture"`
+		Bio            *string  `json:"bio"`
+		Roles          []string `json:"roles"`
+		Reason         string   `json:"reason"`
+	}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+	uid := mux.Vars(r)["uid"]
+	return userendpoint.UpdateUserRequest{
+		UUID:           &uid,
+		Email:          request.Email,
+		PhoneNumber:    request.PhoneNumber,
+		UserName:       request.UserName,
+		ProfilePicture: request.ProfilePicture,
+		Bio:            request.Bio,
+		Roles:          request.Roles,
+		Reason:         request.Reason,
+	}, nil
+}
+
+// decodeSuspendRequest reads a reason and, unless the suspension is indefinite,
+// an RFC 3339 until time.
+func decodeSuspendRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Reason string    `json:"reason"`
+		Until  time.Time `json:"until"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.SuspendRequest{UUID: mux.Vars(r)["uid"], Reason: body.Reason, Until: body.Until}, nil
+}
+
+func decodeUnsuspendRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body reasonBody
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.UnsuspendRequest{UUID: mux.Vars(r)["uid"], Reason: body.Reason}, nil
+}
+
+func decodeForceDeleteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body reasonBody
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.ForceDeleteRequest{UUID: mux.Vars(r)["uid"], Reason: body.Reason}, nil
+}
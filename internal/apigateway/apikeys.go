@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// routeAPIKeys serves the /user/me/apikeys routes on r, which manage the API
+// keys the caller created. They can't be used with an API key.
+func routeAPIKeys(r *mux.Router, set userendpoint.Set, options []httptransport.ServerOption) {
+	r.Path("/me/apikeys").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.ListAPIKeysEndpoint, decodeListAPIKeysRequest, encodeResponse, options...))).
+		Methods(http.MethodGet)
+	r.Path("/me/apikeys").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.CreateAPIKeyEndpoint, decodeCreateAPIKeyRequest, encodeResponse, options...))).
+		Methods(http.MethodPost)
+	r.Path("/me/apikeys/{id}").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.RevokeAPIKeyEndpoint, decodeRevokeAPIKeyRequest, encodeResponse, options...))).
+		Methods(http.MethodDelete)
+}
+
+// isAPIKey reports whether claims were verified from an API key.
+func isAPIKey(claims map[string]interface{}) bool {
+	_, ok := claims["api-key-id"]
+	return ok
+}
+
+// requireScope rejects requests authenticated with an API key lacking the
+// scope their method needs: read for GET, HEAD and OPTIONS, write otherwise.
+func requireScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := ClaimsFromContext(r.Context())
+		if err != nil || !isAPIKey(claims) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope := model.ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = model.ScopeRead
+		}
+		var scopes []string
+		if v, ok := claims["scopes"].([]interface{}); ok {
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+		}
+		if !slices.Contains(scopes, scope) {
+			encodeError(r.Context(), userservice.ErrPermissionDenied, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectAPIKeys rejects requests authenticated with an API key, so that a key
+// can't be used to mint or revoke keys.
+func rejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, err := ClaimsFromContext(r.Context()); err == nil && isAPIKey(claims) {
+			encodeError(r.Context(), userservice.ErrPermissionDenied, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeListAPIKeysRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return userendpoint.ListAPIKeysRequest{}, nil
+}
+
+// decodeCreateAPIKeyRequest reads the key's name, scopes, optional RFC 3339
+// expiry and, for admins creating a key for a service account, its name.
+func decodeCreateAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request struct {
+		Name           string    `json:"name"`
+		Scopes         []string  `json:"scopes"`
+		ExpiresAt      time.Time `json:"expires_at"`
+		ServiceAccount string    `json:"service_account"`
+	}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+	req := userendpoint.CreateAPIKeyRequest{
+		Name:      request.Name,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if request.ServiceAccount != "" {
+		req.Subject = model.ServiceAccountPrefix + request.ServiceAccount
+	}
+	return req, nil
+}
+
+func decodeRevokeAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return userendpoint.RevokeAPIKeyRequest{ID: mux.Vars(r)["id"]}, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	key := func(scopes ...string) map[string]interface{} {
+		s := make([]interface{}, 0, len(scopes))
+		for _, scope := range scopes {
+			s = append(s, scope)
+		}
+		return map[string]interface{}{"user-id": "u1", "api-key-id": "k1", "scopes": s}
+	}
+	for _, tc := range []struct {
+		name   string
+		method string
+		claims map[string]interface{}
+		want   int
+	}{
+		{"read with read scope", http.MethodGet, key(model.ScopeRead), http.StatusOK},
+		{"HEAD with read scope", http.MethodHead, key(model.ScopeRead), http.StatusOK},
+		{"write with read scope", http.MethodPut, key(model.ScopeRead), http.StatusForbidden},
+		{"delete with read scope", http.MethodDelete, key(model.ScopeRead), http.StatusForbidden},
+		{"write with write scope", http.MethodPost, key(model.ScopeWrite), http.StatusOK},
+		{"read with write scope only", http.MethodGet, key(model.ScopeWrite), http.StatusForbidden},
+		{"both scopes", http.MethodDelete, key(model.ScopeRead, model.ScopeWrite), http.StatusOK},
+		{"no scopes", http.MethodGet, key(), http.StatusForbidden},
+		{"user token", http.MethodDelete, map[string]interface{}{"user-id": "u1"}, http.StatusOK},
+		{"anonymous", http.MethodGet, nil, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := requireScope(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			r := httptest.NewRequest(tc.method, "/user", nil)
+			if tc.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), "claims", tc.claims))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.want {
+				t.Errorf("status %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestRejectAPIKeys(t *testing.T) {
+	h := rejectAPIKeys(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for claim, want := range map[string]int{"api-key-id": http.StatusForbidden, "user-id": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodPost, "/user/me/apikeys", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "claims", map[string]interface{}{claim: "k1"}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("with %s: status %d, want %d", claim, rec.Code, want)
+		}
+	}
+}
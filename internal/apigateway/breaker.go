@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// unavailableError is returned when the gateway sheds a request instead of
+// sending it upstream. It is reported as 503 with a Retry-After header.
+type unavailableError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e unavailableError) Error() string { return e.reason }
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// breakerBuckets is the number of slices the breaker's window is counted in.
+const breakerBuckets = 10
+
+// breaker is the circuit breaker of one upstream instance, shared by every
+// endpoint calling it.
+type breaker struct {
+	cfg config.CircuitBreaker
+	now func() time.Time
+
+	mtx      sync.Mutex
+	state    breakerState
+	buckets  [breakerBuckets]breakerBucket
+	openedAt time.Time
+	probes   int // calls let through while half-open
+	passed   int // successful probes
+	refs     int
+}
+
+// breakerBucket counts the calls of one slice of the window, starting at start.
+type breakerBucket struct {
+	start           time.Time
+	calls, failures int
+}
+
+func (b *breaker) allow() (retryAfter time.Duration, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.cfg.OpenDuration).Sub(b.now()); wait > 0 {
+			return wait, false
+		}
+		b.state, b.probes, b.passed = breakerHalfOpen, 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return b.cfg.OpenDuration, false
+		}
+		b.probes++
+	}
+	return 0, true
+}
+
+func (b *breaker) record(failed bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.trip(now)
+			return
+		}
+		if b.passed++; b.passed >= b.cfg.HalfOpenRequests {
+			b.state = breakerClosed
+			b.buckets = [breakerBuckets]breakerBucket{}
+		}
+	case breakerClosed:
+		width := b.cfg.Window / breakerBuckets
+		start := now.Truncate(width)
+		bucket := &b.buckets[start.UnixNano()/int64(width)%breakerBuckets]
+		if !bucket.start.Equal(start) {
+			bucket.start, bucket.calls, bucket.failures = start, 0, 0
+		}
+		bucket.calls++
+		if failed {
+			bucket.failures++
+		}
+		if calls, failures := b.window(now); calls >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(calls) {
+			b.trip(now)
+		}
+	}
+}
+
+func (b *breaker) trip(now time.Time) {
+	b.state, b.openedAt = breakerOpen, now
+}
+
+// breakerStatus is the state of a breaker as reported by the admin endpoint.
+type breakerStatus struct {
+	State    string     `json:"state"`
+	Calls    int        `json:"calls"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+func (b *breaker) status() breakerStatus {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	s := breakerStatus{State: b.state.String()}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	s.Calls, s.Failures = b.window(b.now())
+	return s
+}
+
+// window sums the buckets within the window ending at now.
+func (b *breaker) window(now time.Time) (calls, failures int) {
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.cfg.Window {
+			calls, failures = calls+bk.calls, failures+bk.failures
+		}
+	}
+	return calls, failures
+}
+
+// callFailed reports whether a call failed in a way that counts against the
+// instance. usersvc endpoints return service errors, such as an unknown user,
+// inside the response, next to the errors of the gRPC call itself; only the
+// latter count. So does nothing about a caller giving up.
+func callFailed(response interface{}, err error) bool {
+	if err == nil {
+		f, ok := response.(endpoint.Failer)
+		if !ok || f.Failed() == nil {
+			return false
+		}
+		err = f.Failed()
+		if _, isStatus := status.FromError(err); !isStatus {
+			return false
+		}
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	case codes.Canceled:
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// breakers holds the breakers of an upstream's instances. An instance's breaker
+// lives as long as an endpoint for the instance does.
+type breakers struct {
+	cfg config.CircuitBreaker
+
+	mtx        sync.Mutex
+	byInstance map[string]*breaker
+}
+
+func newBreakers(cfg config.CircuitBreaker) *breakers {
+	return &breakers{cfg: cfg, byInstance: map[string]*breaker{}}
+}
+
+// factory wraps the endpoints made by f with the breaker of their instance.
+// Calls to an instance whose breaker is open fail at once with an
+// unavailableError, which breakerBalancer uses to skip the instance.
+func (bs *breakers) factory(f sd.Factory) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		e, c, err := f(instance)
+		if err != nil {
+			return nil, nil, err
+		}
+		b := bs.acquire(instance)
+		wrapped := func(ctx context.Context, request interface{}) (interface{}, error) {
+			retryAfter, ok := b.allow()
+			if !ok {
+				return nil, unavailableError{reason: fmt.Sprintf("circuit breaker for %s is open", instance), retryAfter: retryAfter}
+			}
+			begin := time.Now()
+			response, err := e(ctx, request)
+			slow := bs.cfg.SlowCall > 0 && time.Since(begin) > bs.cfg.SlowCall
+			b.record(slow || callFailed(response, err))
+			return response, err
+		}
+		var once sync.Once
+		release := closerFunc(func() error {
+			once.Do(func() { bs.release(instance) })
+			if c == nil {
+				return nil
+			}
+			return c.Close()
+		})
+		return wrapped, release, nil
+	}
+}
+
+func (bs *breakers) acquire(instance string) *breaker {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	b, ok := bs.byInstance[instance]
+	if !ok {
+		b = &breaker{cfg: bs.cfg, now: time.Now}
+		bs.byInstance[instance] = b
+	}
+	b.refs++
+	return b
+}
+
+func (bs *breakers) release(instance string) {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	if b := bs.byInstance[instance]; b != nil {
+		if b.refs--; b.refs == 0 {
+			delete(bs.byInstance, instance)
+		}
+	}
+}
+
+// status reports the state of every instance's breaker.
+func (bs *breakers) status() map[string]breakerStatus {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	s := make(map[string]breakerStatus, len(bs.byInstance))
+	for instance, b := range bs.byInstance {
+		s[instance] = b.status()
+	}
+	return s
+}
+
+// breakerBalancer balances round-robin over the endpoints of an endpointer,
+// passing over instances whose breaker is open within a single pick. It only
+// fails with an unavailableError when every instance is open.
+type breakerBalancer struct {
+	endpointer sd.Endpointer
+	rejected   metrics.Counter
+	counter    uint64
+}
+
+func newBreakerBalancer(s sd.Endpointer, rejected metrics.Counter) lb.Balancer {
+	return &breakerBalancer{endpointer: s, rejected: rejected}
+}
+
+func (b *breakerBalancer) Endpoint() (endpoint.Endpoint, error) {
+	endpoints, err := b.endpointer.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, lb.ErrNoEndpoints
+	}
+	start := atomic.AddUint64(&b.counter, 1) - 1
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		var unavailable unavailableError
+		for i := range endpoints {
+			e := endpoints[(start+uint64(i))%uint64(len(endpoints))]
+			response, err := e(ctx, request)
+			if !errors.As(err, &unavailable) {
+				return response, err
+			}
+		}
+		b.rejected.Add(1)
+		return nil, unavailableError{reason: "every instance's circuit breaker is open", retryAfter: unavailable.retryAfter}
+	}, nil
+}
+
+// bulkhead sheds the requests beyond max in flight through the endpoints it
+// wraps, which share the limit, counting them in rejected.
+func bulkhead(max int, rejected metrics.Counter) endpoint.Middleware {
+	sem := make(chan struct{}, max)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				return next(ctx, request)
+			default:
+				rejected.Add(1)
+				return nil, unavailableError{reason: "too many requests in flight upstream", retryAfter: time.Second}
+			}
+		}
+	}
+}
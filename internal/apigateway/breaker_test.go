@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/sd"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var testBreakerConfig = config.CircuitBreaker{
+	FailureRate:      0.5,
+	MinRequests:      4,
+	Window:           10 * time.Second,
+	OpenDuration:     30 * time.Second,
+	HalfOpenRequests: 2,
+}
+
+// fakeClock is a time source tests move forward by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestBreaker(t *testing.T) {
+	// Each step records a call outcome, checks whether calls are allowed or
+	// moves the clock, and then checks the state if given.
+	type step struct {
+		record  *bool
+		advance time.Duration
+		allowed *bool
+		state   *breakerState
+	}
+	var (
+		ok, fail              = false, true
+		yes, no               = true, false
+		closed, open, probing = breakerClosed, breakerOpen, breakerHalfOpen
+	)
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "too few calls to trip",
+			steps: []step{
+				{record: &fail}, {record: &fail},
+				{record: &fail, state: &closed},
+				{allowed: &yes, state: &closed},
+			},
+		},
+		{
+			name: "trips at the failure rate",
+			steps: []step{
+				{record: &ok}, {record: &ok}, {record: &fail},
+				{record: &fail, state: &open},
+				{allowed: &no, state: &open},
+			},
+		},
+		{
+			name: "old failures leave the window",
+			steps: []step{
+				{record: &fail}, {record: &fail}, {record: &fail},
+				{advance: 11 * time.Second},
+				{record: &ok, state: &closed},
+			},
+		},
+		{
+			name: "half-open probes close it",
+			steps: []step{
+				{record: &fail}, {record: &fail}, {record: &fail}, {record: &fail},
+				{allowed: &no},
+				{advance: 30 * time.Second},
+				{allowed: &yes, state: &probing},
+				{allowed: &yes, state: &probing},
+				{allowed: &no, state: &probing}, // only HalfOpenRequests probes
+				{record: &ok, state: &probing},
+				{record: &ok, state: &closed},
+				{allowed: &yes, state: &closed},
+			},
+		},
+		{
+			name: "failed probe opens it again",
+			steps: []step{
+				{record: &fail}, {record: &fail}, {record: &fail}, {record: &fail},
+				{advance: 30 * time.Second},
+				{allowed: &yes, state: &probing},
+				{record: &fail, state: &open},
+				{allowed: &no, state: &open},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1000, 0)}
+			b := &breaker{cfg: testBreakerConfig, now: clock.now}
+			for i, s := range tc.steps {
+				switch {
+				case s.record != nil:
+					b.record(*s.record)
+				case s.advance != 0:
+					clock.advance(s.advance)
+				case s.allowed != nil:
+					if _, allowed := b.allow(); allowed != *s.allowed {
+						t.Fatalf("step %d: allowed %v, want %v", i, allowed, *s.allowed)
+					}
+				}
+				if s.state != nil && b.state != *s.state {
+					t.Fatalf("step %d: state %s, want %s", i, b.state, *s.state)
+				}
+			}
+		})
+	}
+}
+
+type failer struct{ err error }
+
+func (f failer) Failed() error { return f.err }
+
+func TestCallFailed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response interface{}
+		err      error
+		want     bool
+	}{
+		{"success", failer{}, nil, false},
+		{"service error in the response", failer{errors.New("user not found")}, nil, false},
+		{"unavailable in the response", failer{status.Error(codes.Unavailable, "down")}, nil, true},
+		{"unavailable", nil, status.Error(codes.Unavailable, "down"), true},
+		{"deadline exceeded", nil, status.Error(codes.DeadlineExceeded, "slow"), true},
+		{"canceled by the caller", nil, status.Error(codes.Canceled, "gone"), false},
+		{"context canceled", nil, context.Canceled, false},
+		{"other error", nil, errors.New("dial failed"), true},
+	} {
+		if got := callFailed(tc.response, tc.err); got != tc.want {
+			t.Errorf("%s: callFailed() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// fixedEndpointer serves a fixed list of endpoints.
+type fixedEndpointer []endpoint.Endpoint
+
+func (e fixedEndpointer) Endpoints() ([]endpoint.Endpoint, error) { return e, nil }
+
+var _ sd.Endpointer = fixedEndpointer(nil)
+
+func TestBreakerBalancer(t *testing.T) {
+	open := func(context.Context, interface{}) (interface{}, error) {
+		return nil, unavailableError{reason: "open", retryAfter: time.Second}
+	}
+	serving := func(context.Context, interface{}) (interface{}, error) { return "served", nil }
+
+	for _, tc := range []struct {
+		name      string
+		endpoints fixedEndpointer
+		wantErr   bool
+	}{
+		{"skips open instances", fixedEndpointer{open, serving, open}, false},
+		{"fails when all are open", fixedEndpointer{open, open}, true},
+		{"fails without instances", fixedEndpointer{}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newBreakerBalancer(tc.endpoints, discard.NewCounter())
+			for range 3 {
+				e, err := b.Endpoint()
+				if err == nil {
+					_, err = e(context.Background(), nil)
+				}
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("err = %v, want error %v", err, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	e := bulkhead(1, discard.NewCounter())(func(context.Context, interface{}) (interface{}, error) {
+		entered <- struct{}{}
+		<-release
+		return nil, nil
+	})
+	done := make(chan struct{})
+	go func() {
+		e(context.Background(), nil)
+		close(done)
+	}()
+	<-entered
+
+	var unavailable unavailableError
+	if _, err := e(context.Background(), nil); !errors.As(err, &unavailable) {
+		t.Fatalf("err = %v, want an unavailableError", err)
+	}
+	close(release)
+	<-done
+	go func() { <-entered }()
+	if _, err := e(context.Background(), nil); err != nil {
+		t.Errorf("err = %v after the call in flight finished", err)
+	}
+}
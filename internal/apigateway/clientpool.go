@@ -0,0 +1,113 @@
+package main
+
+import (
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"io"
+	"sync"
+	"time"
+)
+
+// clientPool shares one gRPC connection, and the client built on it, per
+// upstream instance between every endpoint that calls the instance, including
+// those of gateways replaced by a reload. A connection is closed once the last
+// endpoint using it is released.
+type clientPool[C any] struct {
+	newClient func(*grpc.ClientConn) C
+	options   []grpc.DialOption
+
+	mtx     sync.Mutex
+	clients map[string]*pooledClient[C]
+}
+
+type pooledClient[C any] struct {
+	conn   *grpc.ClientConn
+	client C
+	refs   int
+}
+
+// keepaliveParams detect dead connections to instances that went away without
+// closing them. usersvc permits pings at this rate.
+var keepaliveParams = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// newClientPool connects to instances with creds, e.g. TLS credentials or
+// insecure.NewCredentials() for plaintext.
+func newClientPool[C any](newClient func(*grpc.ClientConn) C, creds credentials.TransportCredentials, options ...grpc.DialOption) *clientPool[C] {
+	return &clientPool[C]{
+		newClient: newClient,
+		options: append([]grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithKeepaliveParams(keepaliveParams),
+			// Propagate the trace context of calls to the upstream, but
+			// leave health checks untraced.
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		}, options...),
+		clients: map[string]*pooledClient[C]{},
+	}
+}
+
+// acquire returns the client for instance, connecting to it if needed. Closing
+// the returned io.Closer releases the client; closing it again has no effect.
+func (p *clientPool[C]) acquire(instance string) (C, io.Closer, error) {
+	pc, release, err := p.get(instance)
+	if err != nil {
+		var zero C
+		return zero, nil, err
+	}
+	return pc.client, release, nil
+}
+
+// acquireConn is like acquire, but returns the connection the client uses.
+func (p *clientPool[C]) acquireConn(instance string) (*grpc.ClientConn, io.Closer, error) {
+	pc, release, err := p.get(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc.conn, release, nil
+}
+
+func (p *clientPool[C]) get(instance string) (*pooledClient[C], io.Closer, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	pc, ok := p.clients[instance]
+	if !ok {
+		// NewClient doesn't connect until the first call, so this doesn't
+		// block while holding the lock.
+		conn, err := grpc.NewClient(instance, p.options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		pc = &pooledClient[C]{conn: conn, client: p.newClient(conn)}
+		p.clients[instance] = pc
+	}
+	pc.refs++
+	var once sync.Once
+	release := closerFunc(func() error {
+		var err error
+		once.Do(func() { err = p.release(instance, pc) })
+		return err
+	})
+	return pc, release, nil
+}
+
+func (p *clientPool[C]) release(instance string, pc *pooledClient[C]) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	pc.refs--
+	if pc.refs > 0 {
+		return nil
+	}
+	delete(p.clients, instance)
+	return pc.conn.Close()
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"testing"
+)
+
+func TestClientPool(t *testing.T) {
+	var built int
+	pool := newClientPool(func(conn *grpc.ClientConn) *grpc.ClientConn {
+		built++
+		return conn
+	}, insecure.NewCredentials())
+
+	a1, releaseA1, err := pool.acquire("10.0.0.1:8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, releaseA2, _ := pool.acquire("10.0.0.1:8081")
+	b, releaseB, _ := pool.acquire("10.0.0.2:8081")
+	if a1 != a2 {
+		t.Error("endpoints of an instance got different clients")
+	}
+	if a1 == b {
+		t.Error("instances share a client")
+	}
+	if built != 2 {
+		t.Errorf("built %d clients, want 2", built)
+	}
+
+	for _, tc := range []struct {
+		release func() error
+		open    int
+	}{
+		{releaseA1.Close, 2},
+		{releaseA1.Close, 2}, // releasing twice has no effect
+		{releaseA2.Close, 1},
+		{releaseB.Close, 0},
+	} {
+		if err := tc.release(); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(pool.clients); got != tc.open {
+			t.Errorf("%d clients open, want %d", got, tc.open)
+		}
+	}
+
+	// A released instance is connected to again.
+	if _, release, _ := pool.acquire("10.0.0.1:8081"); release != nil {
+		release.Close()
+	}
+	if built != 3 {
+		t.Errorf("built %d clients, want 3", built)
+	}
+}
@@ -0,0 +1,455 @@
+// Package config loads the API gateway configuration from a YAML file, overlaid
+// with environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables that override the config file.
+const EnvPrefix = "GATEWAY"
+
+type Config struct {
+	Listeners Listeners `yaml:"listeners"`
+	// Upstreams are keyed by service: "user" for usersvc, and optionally
+	// "auth" for authsvc.
+	Upstreams   map[string]Upstream `yaml:"upstreams"`
+	Auth        Auth                `yaml:"auth"`
+	CORS        CORS                `yaml:"cors"`
+	RateLimits  RateLimits          `yaml:"rateLimits"`
+	Idempotency Idempotency         `yaml:"idempotency"`
+	Accounts    Accounts            `yaml:"accounts"`
+	StepUp      StepUp              `yaml:"stepUp"`
+	Redis       Redis               `yaml:"redis"`
+	// SecurityHeaders are set on every response. Set a header to "" to omit
+	// one of the defaults.
+	SecurityHeaders map[string]string `yaml:"securityHeaders"`
+	// MaxBodyBytes caps request bodies; larger ones are rejected with 413.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// TrustedProxies lists the networks, in CIDR notation, of the load
+	// balancers in front of the gateway. Only their X-Forwarded-For headers
+	// are believed; requests from other addresses are attributed to their
+	// peer address.
+	TrustedProxies []string `yaml:"trustedProxies"`
+	Tracing        Tracing  `yaml:"tracing"`
+}
+
+type Listeners struct {
+	HTTP Listener `yaml:"http"`
+	// Admin serves operational endpoints such as metrics and circuit breaker
+	// state. Keep it on a private address; it is disabled when Addr is empty.
+	Admin AdminListener `yaml:"admin"`
+}
+
+type AdminListener struct {
+	Addr string `yaml:"addr"`
+}
+
+type Listener struct {
+	Addr string `yaml:"addr"`
+	TLS  TLS    `yaml:"tls"`
+	// ReadHeaderTimeout, ReadTimeout and WriteTimeout bound reading a
+	// request's header, reading all of it and writing its response. An idle
+	// keep-alive connection is closed after IdleTimeout.
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	// On shutdown, /readyz fails for DrainDelay while requests are still
+	// served, so load balancers stop sending new ones. The requests in
+	// progress then get up to ShutdownTimeout to finish.
+	DrainDelay      time.Duration `yaml:"drainDelay"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// TLS enables TLS on a listener when both files are set. The files are
+// reloaded when they change.
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Upstream is a backend service the gateway routes to.
+type Upstream struct {
+	// Instances lists the upstream's addresses when Discovery.Type is static.
+	Instances      []string       `yaml:"instances"`
+	Discovery      Discovery      `yaml:"discovery"`
+	Retry          Retry          `yaml:"retry"`
+	CircuitBreaker CircuitBreaker `yaml:"circuitBreaker"`
+	// MaxConcurrent caps the requests in flight to the upstream; requests
+	// beyond it are rejected with 503 rather than queued.
+	MaxConcurrent int              `yaml:"maxConcurrent"`
+	TLS           UpstreamTLS      `yaml:"tls"`
+	Identity      UpstreamIdentity `yaml:"identity"`
+}
+
+// UpstreamTLS enables TLS to an upstream when CAFile is set: its instances
+// must present a certificate signed by one of the CAs in CAFile, for
+// ServerName or, if that is empty, the host they are dialed at. With CertFile
+// and KeyFile, the gateway authenticates itself with a client certificate
+// (mTLS). The files are reloaded when they change, but switching TLS on or
+// off takes a restart.
+type UpstreamTLS struct {
+	CAFile     string `yaml:"caFile"`
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	ServerName string `yaml:"serverName"`
+}
+
+func (t UpstreamTLS) Enabled() bool {
+	return t.CAFile != ""
+}
+
+// UpstreamIdentity forwards the authenticated user to an upstream, signed with
+// the HMAC key in KeyFile that the upstream verifies it with. The signature is
+// valid for TTL, which should cover the clock skew between the hosts. Changes
+// take effect after a restart.
+type UpstreamIdentity struct {
+	KeyFile string        `yaml:"keyFile"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// Discovery selects how the instances of an upstream are found. Which fields
+// apply depends on Type:
+//
+//   - static (the default) uses Upstream.Instances.
+//   - dns polls Name every Interval. With Record srv, Name is an SRV name such
+//     as _grpc._tcp.usersvc.example.com; with Record a, it is a host:port whose
+//     host resolves to the instances' addresses.
+//   - file polls the file at Path every Interval. It lists one host:port per
+//     line; blank lines and lines starting with # are ignored.
+//   - consul watches the healthy instances of Service registered with the
+//     Consul agent at Address, optionally only those having all of Tags.
+type Discovery struct {
+	Type     string        `yaml:"type"`
+	Name     string        `yaml:"name"`
+	Record   string        `yaml:"record"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Address  string        `yaml:"address"`
+	Service  string        `yaml:"service"`
+	Tags     []string      `yaml:"tags"`
+}
+
+type Retry struct {
+	// Max is the number of attempts per request, each on a different instance.
+	Max int `yaml:"max"`
+	// Timeout bounds a request, including all of its retries and backoff.
+	Timeout time.Duration `yaml:"timeout"`
+	// Attempt n waits for a random time up to InitialBackoff * 2^(n-1), capped
+	// at MaxBackoff, after the previous one failed.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+// CircuitBreaker stops sending requests to an instance whose calls fail or are
+// slow. It opens once at least MinRequests calls were made within Window and
+// FailureRate of them failed or took longer than SlowCall (0 disables the
+// latency check). After OpenDuration it lets HalfOpenRequests calls through,
+// and closes again if they all succeed.
+type CircuitBreaker struct {
+	FailureRate      float64       `yaml:"failureRate"`
+	MinRequests      int           `yaml:"minRequests"`
+	Window           time.Duration `yaml:"window"`
+	SlowCall         time.Duration `yaml:"slowCall"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+	HalfOpenRequests int           `yaml:"halfOpenRequests"`
+}
+
+type Auth struct {
+	Providers map[string]AuthProvider `yaml:"providers"`
+}
+
+// AuthProvider configures a tokenprovider.TokenProvider. Which fields apply
+// depends on Type:
+//
+//   - firebase verifies Firebase ID tokens with the service account in
+//     CredentialsFile.
+//   - apikey verifies "ApiKey" Authorization headers with usersvc, remembering
+//     the outcome for CacheTTL; revoking a key takes up to that long to apply.
+//   - session issues the access tokens of usersvc sessions at /auth/token and
+//     /auth/refresh, signed with the HMAC key in KeyFile and valid for
+//     TokenTTL (15m if unset), and verifies them. Whether a session is still
+//     active is remembered for CacheTTL; revoking a session takes up to that
+//     long to apply. At most one provider may be of this type.
+//   - authsvc verifies the ID tokens authsvc signs at /auth/login with the
+//     PEM Ed25519 public key in KeyFile.
+type AuthProvider struct {
+	Type            string        `yaml:"type"`
+	CredentialsFile string        `yaml:"credentialsFile"`
+	CacheTTL        time.Duration `yaml:"cacheTTL"`
+	KeyFile         string        `yaml:"keyFile"`
+	TokenTTL        time.Duration `yaml:"tokenTTL"`
+}
+
+// CORS configures cross-origin requests. CORS is disabled when AllowedOrigins
+// is empty.
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowedOrigins"`
+	AllowedMethods   []string      `yaml:"allowedMethods"`
+	AllowedHeaders   []string      `yaml:"allowedHeaders"`
+	ExposedHeaders   []string      `yaml:"exposedHeaders"`
+	AllowCredentials bool          `yaml:"allowCredentials"`
+	MaxAge           time.Duration `yaml:"maxAge"`
+}
+
+// RateLimits configures per-client token buckets. Clients are identified by
+// their user ID, API key or IP address, in that order of preference. Routes
+// are keyed by "METHOD /path/template", e.g. "POST /user", and override
+// Default. Store is memory, enforcing the limits per gateway instance, or
+// redis, enforcing them across instances.
+type RateLimits struct {
+	Enabled   bool   `yaml:"enabled"`
+	Store     string `yaml:"store"`
+	KeyPrefix string `yaml:"keyPrefix"`
+	// IP limits the requests from each client address to all routes. It is
+	// checked before authentication, so that requests with invalid
+	// credentials are limited too, and should allow for clients sharing an
+	// address.
+	IP RateLimit `yaml:"ip"`
+	// Default and Routes limit each authenticated user or API key, or the
+	// client address on routes without authentication.
+	Default RateLimit            `yaml:"default"`
+	Routes  map[string]RateLimit `yaml:"routes"`
+}
+
+type RateLimit struct {
+	// Rate is the number of requests per second a client is allowed on average.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests a client may make at once.
+	Burst int `yaml:"burst"`
+}
+
+// Idempotency configures the replay of responses to mutating requests resent
+// with the same Idempotency-Key. Store is memory, local to each gateway
+// instance, or redis, shared through Redis.
+type Idempotency struct {
+	Enabled bool   `yaml:"enabled"`
+	Store   string `yaml:"store"`
+	// TTL is how long a response is replayed.
+	TTL time.Duration `yaml:"ttl"`
+	// LockTTL is how long a key stays claimed by a request that never
+	// completes, e.g. because the gateway stopped while serving it.
+	LockTTL time.Duration `yaml:"lockTTL"`
+	// Wait is how long a duplicate of a request in progress waits for it
+	// before it is rejected with 409.
+	Wait      time.Duration `yaml:"wait"`
+	KeyPrefix string        `yaml:"keyPrefix"`
+}
+
+// Accounts configures the lookup of the roles and suspension of authenticated
+// users in usersvc: requests from suspended users are rejected with 403, and
+// the roles a user holds there replace those of their token. The status of a
+// user is cached for up to CacheTTL, so changing their roles or suspension
+// takes that long to take effect. At most CacheSize users are cached. With
+// auth providers, it needs the "user" upstream's identity, without which
+// usersvc tells no one their status. Disabled, suspended users are served and
+// every caller acts without roles.
+type Accounts struct {
+	Enabled  bool          `yaml:"enabled"`
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// ErrorTTL is how long a failed lookup is remembered, so that the
+	// requests of a user don't each wait for usersvc while it fails.
+	ErrorTTL  time.Duration `yaml:"errorTTL"`
+	CacheSize int           `yaml:"cacheSize"`
+	// FailOpen lets the requests of a user whose status can't be looked up
+	// through, with no roles, instead of rejecting them with 503. Those
+	// needing usersvc fail anyway then, but the others are served even if
+	// the user is suspended.
+	FailOpen bool `yaml:"failOpen"`
+}
+
+// StepUp lists the routes, keyed by "METHOD /path/template", that require a
+// second factor of users who enabled one: a TOTP or recovery code in the
+// X-TOTP-Code header, on top of their token. Only /user and /admin/users
+// routes can require one.
+type StepUp struct {
+	Routes []string `yaml:"routes"`
+}
+
+// Redis is the Redis-protocol server used by the features configured to
+// share state between gateway instances.
+type Redis struct {
+	Addr string `yaml:"addr"`
+}
+
+// Tracing configures the export of request traces. Exporter is none, stdout
+// or otlp, which sends spans over gRPC to the collector at Endpoint.
+// SampleRatio is the fraction of new traces recorded; requests that arrive
+// with a trace context follow the caller's sampling decision. Tracing is set
+// up at startup, so changes take effect after a restart.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+var (
+	defaultRetry          = Retry{Max: 3, Timeout: 500 * time.Millisecond, InitialBackoff: 25 * time.Millisecond, MaxBackoff: 200 * time.Millisecond}
+	defaultDiscovery      = Discovery{Type: "static", Record: "srv", Interval: 10 * time.Second}
+	defaultCircuitBreaker = CircuitBreaker{
+		FailureRate:      0.5,
+		MinRequests:      20,
+		Window:           10 * time.Second,
+		OpenDuration:     30 * time.Second,
+		HalfOpenRequests: 3,
+	}
+	defaultMaxConcurrent = 100
+	defaultIdentityTTL   = 30 * time.Second
+	// defaultAuthTimeout is the retry timeout of the "auth" upstream, whose
+	// calls hash passwords, up to twice for a password change.
+	defaultAuthTimeout = 5 * time.Second
+)
+
+// Default returns the configuration used for anything the file and the
+// environment leave unset.
+func Default() Config {
+	return Config{
+		Listeners: Listeners{
+			HTTP: Listener{
+				Addr:              ":8000",
+				ReadHeaderTimeout: 5 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				WriteTimeout:      30 * time.Second,
+				IdleTimeout:       2 * time.Minute,
+				DrainDelay:        5 * time.Second,
+				ShutdownTimeout:   20 * time.Second,
+			},
+			Admin: AdminListener{Addr: "localhost:8001"},
+		},
+		Upstreams: map[string]Upstream{
+			"user": {
+				Instances:      []string{"localhost:8081"},
+				Discovery:      defaultDiscovery,
+				Retry:          defaultRetry,
+				CircuitBreaker: defaultCircuitBreaker,
+				MaxConcurrent:  defaultMaxConcurrent,
+				Identity:       UpstreamIdentity{TTL: defaultIdentityTTL},
+			},
+		},
+		Auth: Auth{
+			Providers: map[string]AuthProvider{},
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Idempotency-Key", "X-TOTP-Code"},
+			ExposedHeaders: []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Idempotent-Replayed"},
+			MaxAge:         10 * time.Minute,
+		},
+		RateLimits: RateLimits{
+			Store:     "memory",
+			KeyPrefix: "gateway:ratelimit:",
+			IP:        RateLimit{Rate: 50, Burst: 100},
+			Default:   RateLimit{Rate: 10, Burst: 20},
+			Routes:    map[string]RateLimit{},
+		},
+		Idempotency: Idempotency{
+			Enabled:   true,
+			Store:     "memory",
+			TTL:       24 * time.Hour,
+			LockTTL:   time.Minute,
+			Wait:      5 * time.Second,
+			KeyPrefix: "gateway:idempotency:",
+		},
+		Accounts: Accounts{
+			Enabled:   true,
+			CacheTTL:  15 * time.Second,
+			ErrorTTL:  2 * time.Second,
+			CacheSize: 10000,
+		},
+		StepUp: StepUp{
+			Routes: []string{"DELETE /user"},
+		},
+		SecurityHeaders: map[string]string{
+			"Cache-Control":           "no-store",
+			"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+			"Referrer-Policy":         "no-referrer",
+			"X-Content-Type-Options":  "nosniff",
+			"X-Frame-Options":         "DENY",
+		},
+		MaxBodyBytes: 1 << 20,
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "localhost:4317",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads the config file at path on top of Default, applies environment
+// overrides from environ (in os.Environ format) and validates the result.
+// An empty path skips the file.
+func Load(path string, environ []string) (Config, error) {
+	c := Default()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+		// Map entries from the file replace the defaults as a whole, so fill
+		// in what an upstream left unset.
+		for name, u := range c.Upstreams {
+			c.Upstreams[name] = withUpstreamDefaults(name, u)
+		}
+	}
+	if err := errors.Join(applyEnv(&c, EnvPrefix, environ), c.Validate()); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// withUpstreamDefaults fills in the settings upstream name leaves unset.
+func withUpstreamDefaults(name string, u Upstream) Upstream {
+	if name == "auth" {
+		setDefault(&u.Retry.Timeout, defaultAuthTimeout)
+	}
+	setDefault(&u.Retry.Max, defaultRetry.Max)
+	setDefault(&u.Retry.Timeout, defaultRetry.Timeout)
+	setDefault(&u.Retry.InitialBackoff, defaultRetry.InitialBackoff)
+	setDefault(&u.Retry.MaxBackoff, defaultRetry.MaxBackoff)
+	setDefault(&u.Discovery.Type, defaultDiscovery.Type)
+	setDefault(&u.Discovery.Record, defaultDiscovery.Record)
+	setDefault(&u.Discovery.Interval, defaultDiscovery.Interval)
+	setDefault(&u.CircuitBreaker.FailureRate, defaultCircuitBreaker.FailureRate)
+	setDefault(&u.CircuitBreaker.MinRequests, defaultCircuitBreaker.MinRequests)
+	setDefault(&u.CircuitBreaker.Window, defaultCircuitBreaker.Window)
+	setDefault(&u.CircuitBreaker.OpenDuration, defaultCircuitBreaker.OpenDuration)
+	setDefault(&u.CircuitBreaker.HalfOpenRequests, defaultCircuitBreaker.HalfOpenRequests)
+	setDefault(&u.MaxConcurrent, defaultMaxConcurrent)
+	setDefault(&u.Identity.TTL, defaultIdentityTTL)
+	return u
+}
+
+func setDefault[T comparable](v *T, def T) {
+	var zero T
+	if *v == zero {
+		*v = def
+	}
+}
+
+// YAML renders c in the format Load reads.
+func (c Config) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		file    string
+		environ []string
+		check   func(t *testing.T, c Config)
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Config) {
+				if changes := Diff(Default(), c); len(changes) > 0 {
+					t.Errorf("config differs from Default(): %+v", changes)
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			file: "listeners:\n  http:\n    addr: \":9000\"\nmaxBodyBytes: 42\n",
+			check: func(t *testing.T, c Config) {
+				if c.Listeners.HTTP.Addr != ":9000" || c.MaxBodyBytes != 42 {
+					t.Errorf("addr %q, maxBodyBytes %d", c.Listeners.HTTP.Addr, c.MaxBodyBytes)
+				}
+				if c.Listeners.HTTP.IdleTimeout != Default().Listeners.HTTP.IdleTimeout {
+					t.Error("unset idleTimeout lost its default")
+				}
+			},
+		},
+		{
+			name: "upstream from the file gets the defaults it leaves unset",
+			file: "upstreams:\n  user:\n    instances: [\"10.0.0.1:8081\"]\n    retry:\n      max: 5\n",
+			check: func(t *testing.T, c Config) {
+				u := c.Upstreams["user"]
+				if u.Retry.Max != 5 || u.Retry.Timeout != defaultRetry.Timeout || u.Discovery.Type != "static" {
+					t.Errorf("upstream = %+v", u)
+				}
+			},
+		},
+		{
+			name: "auth upstream gets a timeout long enough to hash passwords",
+			file: "upstreams:\n  user:\n    instances: [\"10.0.0.1:8081\"]\n  auth:\n    instances: [\"10.0.0.1:8083\"]\nrateLimits:\n  enabled: true\n",
+			check: func(t *testing.T, c Config) {
+				if got := c.Upstreams["auth"].Retry.Timeout; got != defaultAuthTimeout {
+					t.Errorf("auth timeout %s, want %s", got, defaultAuthTimeout)
+				}
+				if got := c.Upstreams["user"].Retry.Timeout; got != defaultRetry.Timeout {
+					t.Errorf("user timeout %s, want %s", got, defaultRetry.Timeout)
+				}
+			},
+		},
+		{
+			name: "environment over file",
+			file: "listeners:\n  http:\n    addr: \":9000\"\n",
+			environ: []string{
+				"GATEWAY_LISTENERS_HTTP_ADDR=:9100",
+				"GATEWAY_UPSTREAMS_USER_INSTANCES=a:1, b:2",
+				"GATEWAY_UPSTREAMS_USER_RETRY_TIMEOUT=2s",
+				"GATEWAY_RATE_LIMITS_ENABLED=true",
+				"OTHER_VARIABLE=ignored",
+			},
+			check: func(t *testing.T, c Config) {
+				if c.Listeners.HTTP.Addr != ":9100" {
+					t.Errorf("addr = %q", c.Listeners.HTTP.Addr)
+				}
+				u := c.Upstreams["user"]
+				if !reflect.DeepEqual(u.Instances, []string{"a:1", "b:2"}) || u.Retry.Timeout != 2*time.Second {
+					t.Errorf("upstream = %+v", u)
+				}
+				if !c.RateLimits.Enabled {
+					t.Error("rate limits not enabled")
+				}
+			},
+		},
+		{
+			name:    "unknown setting in the file",
+			file:    "listeners:\n  htp:\n    addr: \":9000\"\n",
+			wantErr: "field htp not found",
+		},
+		{
+			name:    "unknown setting in the environment",
+			environ: []string{"GATEWAY_LISTENERS_HTP_ADDR=:9000"},
+			wantErr: "GATEWAY_LISTENERS_HTP_ADDR: no such setting",
+		},
+		{
+			name:    "malformed value in the environment",
+			environ: []string{"GATEWAY_MAX_BODY_BYTES=lots"},
+			wantErr: "GATEWAY_MAX_BODY_BYTES",
+		},
+		{
+			name:    "invalid setting",
+			file:    "upstreams:\n  user:\n    instances: [\"nohostport\"]\n",
+			wantErr: "upstreams.user.instances[0]",
+		},
+		{
+			name:    "trusted proxy without a prefix length",
+			environ: []string{"GATEWAY_TRUSTED_PROXIES=10.0.0.0/8, 10.0.0.1"},
+			wantErr: "trustedProxies[1]",
+		},
+		{
+			name:    "circuit breaker window too short to count",
+			file:    "upstreams:\n  user:\n    instances: [\"10.0.0.1:8081\"]\n    circuitBreaker:\n      window: 1ns\n",
+			wantErr: "upstreams.user.circuitBreaker.window: must be at least 1s",
+		},
+		{
+			name:    "auth upstream without rate limits",
+			file:    "upstreams:\n  user:\n    instances: [\"10.0.0.1:8081\"]\n  auth:\n    instances: [\"10.0.0.1:8083\"]\n",
+			wantErr: "rateLimits.enabled: required with upstreams.auth",
+		},
+		{
+			name:    "account lookup without an identity key",
+			file:    "auth:\n  providers:\n    apikey:\n      type: apikey\n",
+			wantErr: "accounts.enabled: requires upstreams.user.identity.keyFile with auth.providers",
+		},
+		{
+			name:    "no account lookup without an identity key",
+			file:    "auth:\n  providers:\n    apikey:\n      type: apikey\n",
+			environ: []string{"GATEWAY_ACCOUNTS_ENABLED=false"},
+			check: func(t *testing.T, c Config) {
+				if c.Accounts.Enabled {
+					t.Errorf("accounts = %+v", c.Accounts)
+				}
+			},
+		},
+		{
+			name:    "account lookup with an identity key",
+			file:    "auth:\n  providers:\n    apikey:\n      type: apikey\n",
+			environ: []string{"GATEWAY_UPSTREAMS_USER_IDENTITY_KEY_FILE=/run/secrets/identity"},
+			check: func(t *testing.T, c Config) {
+				if !c.Accounts.Enabled || c.Accounts.ErrorTTL != Default().Accounts.ErrorTTL {
+					t.Errorf("accounts = %+v", c.Accounts)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+			if tc.file != "" {
+				path = writeConfig(t, tc.file)
+			}
+			c, err := Load(path, tc.environ)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, c)
+		})
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	if _, err := Load(filepath.Join("..", "etc", "config.yaml"), nil); err != nil {
+		t.Fatalf("etc/config.yaml: %v", err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"addr":         "ADDR",
+		"certFile":     "CERT_FILE",
+		"cacheTTL":     "CACHE_TTL",
+		"user-svc":     "USER_SVC",
+		"POST /user":   "POST__USER",
+		"maxBodyBytes": "MAX_BODY_BYTES",
+	} {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	b, err := Default().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(writeConfig(t, string(b)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(Default(), c); len(changes) > 0 {
+		t.Errorf("config differs from Default() after a round trip: %+v", changes)
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change is a setting whose value differs between two configs. Old or New is
+// empty if the setting only exists in one of them, e.g. an added upstream.
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// Diff lists the settings that differ between from and to, sorted by path.
+func Diff(from, to Config) []Change {
+	before, after := map[string]string{}, map[string]string{}
+	flatten(reflect.ValueOf(from), "", before)
+	flatten(reflect.ValueOf(to), "", after)
+
+	var changes []Change
+	for path, o := range before {
+		if n, ok := after[path]; !ok || n != o {
+			changes = append(changes, Change{Path: path, Old: o, New: n})
+		}
+	}
+	for path, n := range after {
+		if _, ok := before[path]; !ok {
+			changes = append(changes, Change{Path: path, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// flatten records every leaf setting beneath v under its dotted YAML path.
+func flatten(v reflect.Value, path string, out map[string]string) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			flatten(v.Field(i), join(tag), out)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			flatten(iter.Value(), join(iter.Key().String()), out)
+		}
+	default:
+		out[path] = fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(c *Config)
+		want   []Change
+	}{
+		{
+			name:   "unchanged",
+			change: func(c *Config) {},
+		},
+		{
+			name:   "setting",
+			change: func(c *Config) { c.Listeners.HTTP.IdleTimeout = time.Minute },
+			want:   []Change{{Path: "listeners.http.idleTimeout", Old: "2m0s", New: "1m0s"}},
+		},
+		{
+			name: "map entry added",
+			change: func(c *Config) {
+				c.RateLimits.Routes["POST /user"] = RateLimit{Rate: 1, Burst: 2}
+			},
+			want: []Change{
+				{Path: "rateLimits.routes.POST /user.burst", New: "2"},
+				{Path: "rateLimits.routes.POST /user.rate", New: "1"},
+			},
+		},
+		{
+			name: "map entry removed",
+			change: func(c *Config) {
+				delete(c.SecurityHeaders, "X-Frame-Options")
+			},
+			want: []Change{{Path: "securityHeaders.X-Frame-Options", Old: "DENY"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			to := Default()
+			tc.change(&to)
+			if got := Diff(Default(), to); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
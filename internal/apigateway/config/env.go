@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides fields of c from environment variables named after their
+// YAML path, e.g. GATEWAY_LISTENERS_HTTP_ADDR or GATEWAY_UPSTREAMS_USER_INSTANCES.
+// Map entries are addressed by key, so only entries present in the file (or the
+// defaults) can be overridden. Lists are comma-separated. Variables with the
+// prefix that match no setting are reported, since they are most likely typos.
+func applyEnv(c *Config, prefix string, environ []string) error {
+	env := map[string]string{}
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(k, prefix+"_") {
+			env[k] = v
+		}
+	}
+
+	var errs []error
+	applyEnvValue(reflect.ValueOf(c).Elem(), prefix, env, &errs)
+
+	var unknown []string
+	for k := range env {
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		errs = append(errs, fmt.Errorf("%s: no such setting", k))
+	}
+	return errors.Join(errs...)
+}
+
+// applyEnvValue sets v, or the fields beneath it, from env and removes every
+// variable it uses from env.
+func applyEnvValue(v reflect.Value, name string, env map[string]string, errs *[]error) {
+	switch {
+	case v.Kind() == reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			applyEnvValue(v.Field(i), name+"_"+envName(tag), env, errs)
+		}
+		return
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			applyEnvValue(elem, name+"_"+envName(iter.Key().String()), env, errs)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+		return
+	}
+
+	s, ok := env[name]
+	if !ok {
+		return
+	}
+	delete(env, name)
+	if err := setFromString(v, s); err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+	}
+}
+
+func setFromString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
+
+// envName turns a YAML key such as "certFile" or "user-svc" into CERT_FILE or
+// USER_SVC.
+func envName(key string) string {
+	var b strings.Builder
+	prevLower := false
+	for _, r := range key {
+		switch {
+		case unicode.IsUpper(r):
+			if prevLower {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			prevLower = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToUpper(r))
+			prevLower = true
+		default:
+			b.WriteByte('_')
+			prevLower = false
+		}
+	}
+	return b.String()
+}
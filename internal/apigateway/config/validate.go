@@ -0,0 +1,328 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requiredUpstreams are the upstreams the gateway can't serve without. The
+// "auth" upstream is optional: its routes are only served when it is set.
+var requiredUpstreams = []string{"user"}
+
+// minBreakerWindow is the shortest circuit breaker window.
+const minBreakerWindow = time.Second
+
+var authProviderTypes = map[string]bool{
+	"firebase": true,
+	"apikey":   true,
+	"session":  true,
+	"authsvc":  true,
+}
+
+// Validate reports every problem with c at once, each prefixed with the path of
+// the offending setting.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	validateListener(c.Listeners.HTTP, "listeners.http", fail)
+	if addr := c.Listeners.Admin.Addr; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			fail("listeners.admin.addr", "%q is not a listen address", addr)
+		}
+	}
+
+	for _, name := range requiredUpstreams {
+		if _, ok := c.Upstreams[name]; !ok {
+			fail("upstreams."+name, "required")
+		}
+	}
+	for _, name := range sortedKeys(c.Upstreams) {
+		u, path := c.Upstreams[name], "upstreams."+name
+		for i, instance := range u.Instances {
+			if _, _, err := net.SplitHostPort(instance); err != nil {
+				fail(fmt.Sprintf("%s.instances[%d]", path, i), "%q is not a host:port address", instance)
+			}
+		}
+		validateDiscovery(u, path, fail)
+		if u.Retry.Max < 1 {
+			fail(path+".retry.max", "must be at least 1, got %d", u.Retry.Max)
+		}
+		if u.Retry.Timeout <= 0 {
+			fail(path+".retry.timeout", "must be positive, got %s", u.Retry.Timeout)
+		}
+		if u.Retry.InitialBackoff <= 0 {
+			fail(path+".retry.initialBackoff", "must be positive, got %s", u.Retry.InitialBackoff)
+		}
+		if u.Retry.MaxBackoff < u.Retry.InitialBackoff {
+			fail(path+".retry.maxBackoff", "must be at least initialBackoff, got %s", u.Retry.MaxBackoff)
+		}
+		validateCircuitBreaker(u.CircuitBreaker, path+".circuitBreaker", fail)
+		if t := u.TLS; (t.CertFile == "") != (t.KeyFile == "") {
+			fail(path+".tls", "certFile and keyFile must be set together")
+		} else if t.CertFile != "" && !t.Enabled() {
+			fail(path+".tls.caFile", "required with a client certificate")
+		}
+		if u.Identity.TTL <= 0 {
+			fail(path+".identity.ttl", "must be positive, got %s", u.Identity.TTL)
+		}
+		if u.MaxConcurrent < 1 {
+			fail(path+".maxConcurrent", "must be at least 1, got %d", u.MaxConcurrent)
+		}
+	}
+
+	var sessionProvider string
+	for _, name := range sortedKeys(c.Auth.Providers) {
+		p, path := c.Auth.Providers[name], "auth.providers."+name
+		switch {
+		case !authProviderTypes[p.Type]:
+			fail(path+".type", "unknown provider type %q", p.Type)
+		case p.Type == "firebase" && p.CredentialsFile == "":
+			fail(path+".credentialsFile", "required for firebase")
+		case (p.Type == "session" || p.Type == "authsvc") && p.KeyFile == "":
+			fail(path+".keyFile", "required for %s", p.Type)
+		case p.Type == "session" && sessionProvider != "":
+			fail(path+".type", "only one session provider is allowed, %s is one already", sessionProvider)
+		case p.CacheTTL < 0:
+			fail(path+".cacheTTL", "must not be negative")
+		case p.TokenTTL < 0:
+			fail(path+".tokenTTL", "must not be negative")
+		}
+		if p.Type == "session" && sessionProvider == "" {
+			sessionProvider = name
+		}
+	}
+
+	for i, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" && c.CORS.AllowCredentials {
+			fail(fmt.Sprintf("cors.allowedOrigins[%d]", i), `"*" can't be combined with allowCredentials`)
+		}
+	}
+	for i, method := range c.CORS.AllowedMethods {
+		if strings.ToUpper(method) != method || strings.ContainsAny(method, " /") {
+			fail(fmt.Sprintf("cors.allowedMethods[%d]", i), "%q is not an HTTP method", method)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("cors.maxAge", "must not be negative")
+	}
+	for _, name := range sortedKeys(c.SecurityHeaders) {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			fail(fmt.Sprintf("securityHeaders[%q]", name), "is not a header name")
+		}
+	}
+	if c.MaxBodyBytes < 1 {
+		fail("maxBodyBytes", "must be at least 1, got %d", c.MaxBodyBytes)
+	}
+	for i, cidr := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			fail(fmt.Sprintf("trustedProxies[%d]", i), "%q is not a CIDR network", cidr)
+		}
+	}
+
+	if c.RateLimits.Enabled {
+		validateStore(c, c.RateLimits.Store, "rateLimits.store", fail)
+		validateRateLimit(c.RateLimits.IP, "rateLimits.ip", fail)
+		validateRateLimit(c.RateLimits.Default, "rateLimits.default", fail)
+	} else if _, ok := c.Upstreams["auth"]; ok {
+		// Nothing else slows down guessing passwords at /auth/login, or
+		// sending reset emails at /auth/password/reset, from many accounts.
+		fail("rateLimits.enabled", "required with upstreams.auth")
+	}
+	for _, route := range sortedKeys(c.RateLimits.Routes) {
+		path := fmt.Sprintf("rateLimits.routes[%q]", route)
+		method, pattern, ok := strings.Cut(route, " ")
+		if !ok || !isHTTPMethod(method) || !strings.HasPrefix(pattern, "/") {
+			fail(path, `must be of the form "METHOD /path"`)
+		}
+		validateRateLimit(c.RateLimits.Routes[route], path, fail)
+	}
+
+	if c.Idempotency.Enabled {
+		i := c.Idempotency
+		validateStore(c, i.Store, "idempotency.store", fail)
+		if i.TTL <= 0 {
+			fail("idempotency.ttl", "must be positive, got %s", i.TTL)
+		}
+		if i.LockTTL <= 0 {
+			fail("idempotency.lockTTL", "must be positive, got %s", i.LockTTL)
+		}
+		if i.Wait < 0 {
+			fail("idempotency.wait", "must not be negative")
+		}
+	}
+
+	if c.Accounts.Enabled {
+		if c.Accounts.CacheTTL <= 0 {
+			fail("accounts.cacheTTL", "must be positive, got %s", c.Accounts.CacheTTL)
+		}
+		if c.Accounts.ErrorTTL <= 0 {
+			fail("accounts.errorTTL", "must be positive, got %s", c.Accounts.ErrorTTL)
+		}
+		if c.Accounts.CacheSize < 1 {
+			fail("accounts.cacheSize", "must be at least 1, got %d", c.Accounts.CacheSize)
+		}
+		if u, ok := c.Upstreams["user"]; ok && u.Identity.KeyFile == "" && len(c.Auth.Providers) > 0 {
+			fail("accounts.enabled", "requires upstreams.user.identity.keyFile with auth.providers")
+		}
+	}
+
+	for i, route := range c.StepUp.Routes {
+		method, pattern, ok := strings.Cut(route, " ")
+		if !ok || !isHTTPMethod(method) || !strings.HasPrefix(pattern, "/") {
+			fail(fmt.Sprintf("stepUp.routes[%d]", i), `must be of the form "METHOD /path"`)
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			fail("tracing.endpoint", "required for the otlp exporter")
+		}
+	default:
+		fail("tracing.exporter", "must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		fail("tracing.sampleRatio", "must be in [0, 1], got %g", r)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateListener(l Listener, path string, fail func(path, format string, args ...interface{})) {
+	if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+		fail(path+".addr", "%q is not a listen address", l.Addr)
+	}
+	if l.TLS.Enabled() && (l.TLS.CertFile == "" || l.TLS.KeyFile == "") {
+		fail(path+".tls", "certFile and keyFile must be set together")
+	}
+	// 0 disables the timeouts of the http.Server.
+	if l.ReadHeaderTimeout < 0 {
+		fail(path+".readHeaderTimeout", "must not be negative")
+	}
+	if l.ReadTimeout < 0 {
+		fail(path+".readTimeout", "must not be negative")
+	}
+	if l.WriteTimeout < 0 {
+		fail(path+".writeTimeout", "must not be negative")
+	}
+	if l.IdleTimeout < 0 {
+		fail(path+".idleTimeout", "must not be negative")
+	}
+	if l.DrainDelay < 0 {
+		fail(path+".drainDelay", "must not be negative")
+	}
+	if l.ShutdownTimeout <= 0 {
+		fail(path+".shutdownTimeout", "must be positive, got %s", l.ShutdownTimeout)
+	}
+}
+
+func validateDiscovery(u Upstream, path string, fail func(path, format string, args ...interface{})) {
+	d := u.Discovery
+	if d.Type == "static" && len(u.Instances) == 0 {
+		fail(path+".instances", "at least one instance is required for static discovery")
+	}
+	path += ".discovery"
+	switch d.Type {
+	case "static":
+	case "dns":
+		switch d.Record {
+		case "srv":
+			if d.Name == "" {
+				fail(path+".name", "required for dns discovery")
+			}
+		case "a":
+			if _, _, err := net.SplitHostPort(d.Name); err != nil {
+				fail(path+".name", "%q is not a host:port address", d.Name)
+			}
+		default:
+			fail(path+".record", "must be srv or a, got %q", d.Record)
+		}
+	case "file":
+		if d.Path == "" {
+			fail(path+".path", "required for file discovery")
+		}
+	case "consul":
+		if d.Address == "" {
+			fail(path+".address", "required for consul discovery")
+		}
+		if d.Service == "" {
+			fail(path+".service", "required for consul discovery")
+		}
+	default:
+		fail(path+".type", "must be static, dns, file or consul, got %q", d.Type)
+	}
+	if (d.Type == "dns" || d.Type == "file") && d.Interval <= 0 {
+		fail(path+".interval", "must be positive, got %s", d.Interval)
+	}
+}
+
+func validateCircuitBreaker(b CircuitBreaker, path string, fail func(path, format string, args ...interface{})) {
+	if b.FailureRate <= 0 || b.FailureRate > 1 {
+		fail(path+".failureRate", "must be in (0, 1], got %g", b.FailureRate)
+	}
+	if b.MinRequests < 1 {
+		fail(path+".minRequests", "must be at least 1, got %d", b.MinRequests)
+	}
+	// The window is counted in buckets of a tenth of it, which must not
+	// round down to nothing.
+	if b.Window < minBreakerWindow {
+		fail(path+".window", "must be at least %s, got %s", minBreakerWindow, b.Window)
+	}
+	if b.SlowCall < 0 {
+		fail(path+".slowCall", "must not be negative")
+	}
+	if b.OpenDuration <= 0 {
+		fail(path+".openDuration", "must be positive, got %s", b.OpenDuration)
+	}
+	if b.HalfOpenRequests < 1 {
+		fail(path+".halfOpenRequests", "must be at least 1, got %d", b.HalfOpenRequests)
+	}
+}
+
+// validateStore checks the choice of a feature's state store.
+func validateStore(c Config, store, path string, fail func(path, format string, args ...interface{})) {
+	switch store {
+	case "memory":
+	case "redis":
+		if c.Redis.Addr == "" {
+			fail(path, "redis requires redis.addr")
+		}
+	default:
+		fail(path, "must be memory or redis, got %q", store)
+	}
+}
+
+func validateRateLimit(l RateLimit, path string, fail func(path, format string, args ...interface{})) {
+	if l.Rate <= 0 {
+		fail(path+".rate", "must be positive, got %g", l.Rate)
+	}
+	if l.Burst < 1 {
+		fail(path+".burst", "must be at least 1, got %d", l.Burst)
+	}
+}
+
+func isHTTPMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsHandler answers CORS preflight requests and adds the CORS headers to the
+// responses of next for allowed origins. It has to wrap the router: preflight
+// requests use OPTIONS, which no route matches.
+func corsHandler(c config.CORS, next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+	var (
+		anyOrigin      bool
+		origins        = map[string]bool{}
+		methods        = map[string]bool{}
+		headers        = map[string]bool{}
+		allowedMethods = strings.Join(c.AllowedMethods, ", ")
+		exposedHeaders = strings.Join(c.ExposedHeaders, ", ")
+		maxAge         = strconv.Itoa(int(c.MaxAge.Seconds()))
+	)
+	for _, o := range c.AllowedOrigins {
+		anyOrigin = anyOrigin || o == "*"
+		origins[o] = true
+	}
+	for _, m := range c.AllowedMethods {
+		methods[m] = true
+	}
+	for _, h := range c.AllowedHeaders {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := anyOrigin || origins[origin]
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !preflight {
+			if allowed {
+				setAllowOrigin(h, c, anyOrigin, origin)
+				if exposedHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !allowed || !methods[r.Header.Get("Access-Control-Request-Method")] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, requested := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if requested = strings.TrimSpace(requested); requested != "" && !headers[http.CanonicalHeaderKey(requested)] {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		setAllowOrigin(h, c, anyOrigin, origin)
+		h.Set("Access-Control-Allow-Methods", allowedMethods)
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		h.Set("Access-Control-Max-Age", maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func setAllowOrigin(h http.Header, c config.CORS, anyOrigin bool, origin string) {
+	if anyOrigin {
+		// Validation rules out "*" with credentials.
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,230 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/hashicorp/consul/api"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"github.com/yuisofull/gommunigate/internal/pkg/instance"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConsul is an in-process Consul agent serving the parts of the HTTP API
+// the registrar and the instancer use: service registration, TTL check
+// updates and blocking health queries.
+type fakeConsul struct {
+	mtx      sync.Mutex
+	index    uint64
+	changed  chan struct{} // closed and replaced on every change
+	services map[string]*api.AgentServiceRegistration
+	status   map[string]string // by check ID
+}
+
+// newFakeConsul starts a fakeConsul and returns it with its address and a
+// client of it.
+func newFakeConsul(t *testing.T) (*fakeConsul, string, *api.Client) {
+	f := &fakeConsul{
+		index:    1,
+		changed:  make(chan struct{}),
+		services: map[string]*api.AgentServiceRegistration{},
+		status:   map[string]string{},
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	client, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, addr, client
+}
+
+// change bumps the index and wakes up blocked queries. f.mtx must be held.
+func (f *fakeConsul) change() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPut && path == "/v1/agent/service/register":
+		var reg api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mtx.Lock()
+		f.services[reg.ID] = &reg
+		// Consul starts TTL checks critical until their first update.
+		f.status[reg.Check.CheckID] = api.HealthCritical
+		f.change()
+		f.mtx.Unlock()
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/deregister/")
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		reg, ok := f.services[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.services, id)
+		delete(f.status, reg.Check.CheckID)
+		f.change()
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/check/update/"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/update/")
+		var update struct{ Status, Output string }
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		old, ok := f.status[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if old != update.Status {
+			f.status[id] = update.Status
+			f.change()
+		}
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/health/service/"):
+		f.health(w, r, strings.TrimPrefix(path, "/v1/health/service/"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// health answers a blocking query for the instances of service, waiting for a
+// change past the index given, if any.
+func (f *fakeConsul) health(w http.ResponseWriter, r *http.Request, service string) {
+	q := r.URL.Query()
+	index, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+	f.mtx.Lock()
+	for index >= f.index {
+		changed := f.changed
+		f.mtx.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		f.mtx.Lock()
+	}
+	defer f.mtx.Unlock()
+
+	entries := []*api.ServiceEntry{}
+	for _, reg := range f.services {
+		status := f.status[reg.Check.CheckID]
+		if reg.Name != service || (q.Get("passing") != "" && status != api.HealthPassing) {
+			continue
+		}
+		if tag := q.Get("tag"); tag != "" && !contains(reg.Tags, tag) {
+			continue
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Address: "10.0.0.1"},
+			Service: &api.AgentService{ID: reg.ID, Service: reg.Name, Address: reg.Address, Port: reg.Port, Tags: reg.Tags},
+			Checks:  api.HealthChecks{{CheckID: reg.Check.CheckID, Status: status}},
+		})
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(entries)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForInstances reads events from events until one lists want.
+func waitForInstances(t *testing.T, events <-chan sd.Event, want ...string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	var last sd.Event
+	for {
+		select {
+		case e := <-events:
+			last = e
+			if e.Err == nil && (len(e.Instances) == 0 && len(want) == 0 || reflect.DeepEqual(e.Instances, want)) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("instances = %v (err %v), want %v", last.Instances, last.Err, want)
+		}
+	}
+}
+
+func TestConsul(t *testing.T) {
+	fake, addr, client := newFakeConsul(t)
+
+	instancer, err := New(config.Upstream{Discovery: config.Discovery{
+		Type:    "consul",
+		Address: addr,
+		Service: "usersvc",
+	}}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instancer.Stop()
+	events := make(chan sd.Event, 100)
+	instancer.Register(events)
+	defer instancer.Deregister(events)
+	waitForInstances(t, events)
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	check := func(context.Context) error {
+		if !healthy.Load() {
+			return errors.New("not serving")
+		}
+		return nil
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	registrar := instance.NewConsulRegistrar(client, "usersvc", "usersvc-1", "10.0.0.2", 8081, 30*time.Millisecond, check, log.NewNopLogger())
+	go func() { done <- registrar.Run(ctx) }()
+
+	// Registered and reported passing.
+	waitForInstances(t, events, "10.0.0.2:8081")
+
+	// A failing check turns the instance critical.
+	healthy.Store(false)
+	waitForInstances(t, events)
+	healthy.Store(true)
+	waitForInstances(t, events, "10.0.0.2:8081")
+
+	// Stopping deregisters it.
+	stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	waitForInstances(t, events)
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	if len(fake.services) != 0 {
+		t.Errorf("services still registered: %v", fake.services)
+	}
+}
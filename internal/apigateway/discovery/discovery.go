@@ -0,0 +1,42 @@
+// Package discovery builds the sd.Instancer that finds the instances of a
+// gateway upstream, as described by its config.Discovery.
+package discovery
+
+import (
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	sdconsul "github.com/go-kit/kit/sd/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"net"
+)
+
+// New returns the instancer for u. Callers must Stop it when they are done.
+func New(u config.Upstream, logger log.Logger) (sd.Instancer, error) {
+	d := u.Discovery
+	switch d.Type {
+	case "static", "":
+		return sd.FixedInstancer(u.Instances), nil
+	case "dns":
+		lookup := SRV(net.DefaultResolver, d.Name)
+		if d.Record == "a" {
+			var err error
+			if lookup, err = Host(net.DefaultResolver, d.Name); err != nil {
+				return nil, err
+			}
+		}
+		return NewPoller(lookup, d.Interval, log.With(logger, "discovery", "dns", "name", d.Name)), nil
+	case "file":
+		return NewPoller(File(d.Path), d.Interval, log.With(logger, "discovery", "file", "path", d.Path)), nil
+	case "consul":
+		client, err := api.NewClient(&api.Config{Address: d.Address})
+		if err != nil {
+			return nil, fmt.Errorf("consul client: %w", err)
+		}
+		// Only instances whose health checks pass are returned.
+		return sdconsul.NewInstancer(sdconsul.NewClient(client), logger, d.Service, d.Tags, true), nil
+	default:
+		return nil, fmt.Errorf("unknown discovery type %q", d.Type)
+	}
+}
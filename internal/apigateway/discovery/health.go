@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// HealthCheck watches the health of instance until ctx is done, calling update
+// with whether the instance is serving whenever that changes.
+type HealthCheck func(ctx context.Context, instance string, update func(serving bool))
+
+// HealthFilter is an sd.Instancer that passes on the instances of another one
+// except those their HealthCheck reports as not serving. Instances whose health
+// isn't known yet are passed on, so that a new instance takes requests at once;
+// the circuit breakers guard against those that turn out to be unreachable.
+type HealthFilter struct {
+	next   sd.Instancer
+	check  HealthCheck
+	logger log.Logger
+	events chan sd.Event
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mtx     sync.Mutex
+	err     error
+	watches map[string]context.CancelFunc
+	serving map[string]bool
+	state   sd.Event
+	subs    map[chan<- sd.Event]struct{}
+}
+
+// NewHealthFilter watches the health of the instances of next, starting and
+// stopping a check as they come and go.
+func NewHealthFilter(next sd.Instancer, check HealthCheck, logger log.Logger) *HealthFilter {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &HealthFilter{
+		next:    next,
+		check:   check,
+		logger:  logger,
+		events:  make(chan sd.Event),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		watches: map[string]context.CancelFunc{},
+		serving: map[string]bool{},
+		subs:    map[chan<- sd.Event]struct{}{},
+	}
+	go f.loop(ctx)
+	// Instancers send their current state on registration, so the loop must
+	// already be receiving.
+	next.Register(f.events)
+	return f
+}
+
+func (f *HealthFilter) loop(ctx context.Context) {
+	defer close(f.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-f.events:
+			f.update(ctx, event)
+		}
+	}
+}
+
+// update starts watching new instances, stops watching those that went away
+// and publishes the result.
+func (f *HealthFilter) update(ctx context.Context, event sd.Event) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.err = event.Err
+	if event.Err != nil {
+		// Keep the last instances, as sd.Endpointer does.
+		f.publish()
+		return
+	}
+
+	current := make(map[string]bool, len(event.Instances))
+	for _, instance := range event.Instances {
+		current[instance] = true
+		if _, ok := f.watches[instance]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		f.watches[instance] = cancel
+		go f.check(ctx, instance, func(serving bool) { f.setServing(instance, serving) })
+	}
+	for instance, cancel := range f.watches {
+		if !current[instance] {
+			cancel()
+			delete(f.watches, instance)
+			delete(f.serving, instance)
+		}
+	}
+	f.publish()
+}
+
+func (f *HealthFilter) setServing(instance string, serving bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if _, ok := f.watches[instance]; !ok {
+		// A late report about an instance that went away.
+		return
+	}
+	if known, ok := f.serving[instance]; ok && known == serving {
+		return
+	}
+	f.serving[instance] = serving
+	f.logger.Log("instance", instance, "serving", serving)
+	f.publish()
+}
+
+// publish sends the instances not known to be unhealthy to the subscribers if
+// they changed. It must be called with mtx held.
+func (f *HealthFilter) publish() {
+	instances := []string{}
+	for instance := range f.watches {
+		if serving, ok := f.serving[instance]; !ok || serving {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Strings(instances)
+	event := sd.Event{Instances: instances, Err: f.err}
+	if reflect.DeepEqual(event, f.state) {
+		return
+	}
+	f.state = event
+	for c := range f.subs {
+		c <- event
+	}
+}
+
+// Serving returns the number of instances known to be serving.
+func (f *HealthFilter) Serving() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	n := 0
+	for _, serving := range f.serving {
+		if serving {
+			n++
+		}
+	}
+	return n
+}
+
+// Register implements sd.Instancer. The current state is sent to ch at once.
+func (f *HealthFilter) Register(ch chan<- sd.Event) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.subs[ch] = struct{}{}
+	ch <- f.state
+}
+
+// Deregister implements sd.Instancer.
+func (f *HealthFilter) Deregister(ch chan<- sd.Event) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	delete(f.subs, ch)
+}
+
+// Stop implements sd.Instancer. It stops the health checks but not the
+// underlying instancer.
+func (f *HealthFilter) Stop() {
+	// Deregister while the loop still receives, as next may be sending.
+	f.next.Deregister(f.events)
+	f.cancel()
+	<-f.done
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for instance, cancel := range f.watches {
+		cancel()
+		delete(f.watches, instance)
+	}
+}
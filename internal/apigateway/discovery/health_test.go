@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeInstancer is an sd.Instancer whose instances are set by hand.
+type fakeInstancer struct {
+	mtx   sync.Mutex
+	state sd.Event
+	subs  map[chan<- sd.Event]struct{}
+}
+
+func newFakeInstancer(instances ...string) *fakeInstancer {
+	return &fakeInstancer{state: sd.Event{Instances: instances}, subs: map[chan<- sd.Event]struct{}{}}
+}
+
+func (i *fakeInstancer) set(instances ...string) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.state = sd.Event{Instances: instances}
+	for c := range i.subs {
+		c <- i.state
+	}
+}
+
+func (i *fakeInstancer) Register(ch chan<- sd.Event) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.subs[ch] = struct{}{}
+	ch <- i.state
+}
+
+func (i *fakeInstancer) Deregister(ch chan<- sd.Event) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	delete(i.subs, ch)
+}
+
+func (i *fakeInstancer) Stop() {}
+
+// fakeHealth is a HealthCheck whose reports are sent by hand.
+type fakeHealth struct {
+	mtx     sync.Mutex
+	updates map[string]func(serving bool)
+	// stopped is closed once the check of an instance returns.
+	stopped map[string]chan struct{}
+}
+
+func (h *fakeHealth) check(ctx context.Context, instance string, update func(serving bool)) {
+	stopped := make(chan struct{})
+	defer close(stopped)
+	h.mtx.Lock()
+	h.updates[instance], h.stopped[instance] = update, stopped
+	h.mtx.Unlock()
+	<-ctx.Done()
+}
+
+// report reports the health of instance once its check has started.
+func (h *fakeHealth) report(instance string, serving bool) {
+	for {
+		h.mtx.Lock()
+		update, ok := h.updates[instance]
+		h.mtx.Unlock()
+		if ok {
+			update(serving)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitStopped waits for the check of instance to return.
+func (h *fakeHealth) waitStopped(t *testing.T, instance string) {
+	t.Helper()
+	h.mtx.Lock()
+	stopped := h.stopped[instance]
+	h.mtx.Unlock()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("check of %s still running", instance)
+	}
+}
+
+func TestHealthFilter(t *testing.T) {
+	instancer := newFakeInstancer("a:1", "b:1")
+	h := &fakeHealth{updates: map[string]func(bool){}, stopped: map[string]chan struct{}{}}
+	f := NewHealthFilter(instancer, h.check, log.NewNopLogger())
+	defer f.Stop()
+	events := make(chan sd.Event, 100)
+	f.Register(events)
+	defer f.Deregister(events)
+
+	// Instances of unknown health are passed on.
+	waitForInstances(t, events, "a:1", "b:1")
+	if n := f.Serving(); n != 0 {
+		t.Errorf("%d instances serving before any report", n)
+	}
+
+	h.report("a:1", true)
+	h.report("b:1", false)
+	waitForInstances(t, events, "a:1")
+	if n := f.Serving(); n != 1 {
+		t.Errorf("%d instances serving, want 1", n)
+	}
+
+	h.report("b:1", true)
+	waitForInstances(t, events, "a:1", "b:1")
+
+	// An instance that goes away stops being checked, and so do the others
+	// once the filter stops.
+	instancer.set("b:1")
+	waitForInstances(t, events, "b:1")
+	h.waitStopped(t, "a:1")
+	f.Stop()
+	h.waitStopped(t, "b:1")
+}
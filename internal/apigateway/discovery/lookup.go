@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Resolver is the subset of *net.Resolver the DNS lookups use.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// SRV looks up the targets of the SRV records at name.
+func SRV(r Resolver, name string) Lookup {
+	return func(ctx context.Context) ([]string, error) {
+		_, records, err := r.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		instances := make([]string, 0, len(records))
+		for _, rec := range records {
+			host := strings.TrimSuffix(rec.Target, ".")
+			instances = append(instances, net.JoinHostPort(host, strconv.Itoa(int(rec.Port))))
+		}
+		return instances, nil
+	}
+}
+
+// Host looks up every address of the host in hostport, each with its port.
+func Host(r Resolver, hostport string) (Lookup, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) ([]string, error) {
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		instances := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			instances = append(instances, net.JoinHostPort(addr, port))
+		}
+		return instances, nil
+	}, nil
+}
+
+// File reads the host:port lines of the file at path.
+func File(path string) Lookup {
+	return func(context.Context) ([]string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var instances []string
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for n := 1; s.Scan(); n++ {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(line); err != nil {
+				return nil, fmt.Errorf("%s:%d: %q is not a host:port address", path, n, line)
+			}
+			instances = append(instances, line)
+		}
+		return instances, s.Err()
+	}
+}
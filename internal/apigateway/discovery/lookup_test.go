@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers lookups from its records.
+type fakeResolver struct {
+	srv   []*net.SRV
+	hosts []string
+	err   error
+}
+
+func (r fakeResolver) LookupSRV(context.Context, string, string, string) (string, []*net.SRV, error) {
+	return "", r.srv, r.err
+}
+
+func (r fakeResolver) LookupHost(context.Context, string) ([]string, error) {
+	return r.hosts, r.err
+}
+
+func TestLookups(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	host := func(r Resolver, hostport string) Lookup {
+		l, err := Host(r, hostport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	for _, tc := range []struct {
+		name    string
+		lookup  Lookup
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "srv",
+			lookup: SRV(fakeResolver{srv: []*net.SRV{{Target: "a.example.com.", Port: 8081}, {Target: "b.example.com.", Port: 8082}}}, "_grpc._tcp.usersvc"),
+			want:   []string{"a.example.com:8081", "b.example.com:8082"},
+		},
+		{
+			name:    "srv failure",
+			lookup:  SRV(fakeResolver{err: errors.New("no such host")}, "_grpc._tcp.usersvc"),
+			wantErr: true,
+		},
+		{
+			name:   "host",
+			lookup: host(fakeResolver{hosts: []string{"10.0.0.1", "::1"}}, "usersvc:8081"),
+			want:   []string{"10.0.0.1:8081", "[::1]:8081"},
+		},
+		{
+			name:   "file",
+			lookup: File(write("instances", "# usersvc\n10.0.0.1:8081\n\n  10.0.0.2:8081  \n")),
+			want:   []string{"10.0.0.1:8081", "10.0.0.2:8081"},
+		},
+		{
+			name:    "file with a malformed line",
+			lookup:  File(write("malformed", "10.0.0.1:8081\nusersvc\n")),
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			lookup:  File(filepath.Join(dir, "missing")),
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.lookup(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("instances = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPoller(t *testing.T) {
+	var (
+		mtx       sync.Mutex
+		instances = []string{"b:1", "a:1"}
+		err       error
+	)
+	set := func(i []string, e error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		instances, err = i, e
+	}
+	lookup := func(context.Context) ([]string, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]string(nil), instances...), err
+	}
+	p := NewPoller(lookup, 5*time.Millisecond, log.NewNopLogger())
+	defer p.Stop()
+
+	events := make(chan sd.Event, 100)
+	p.Register(events)
+	defer p.Deregister(events)
+	next := func() sd.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return sd.Event{}
+		}
+	}
+
+	if e := next(); !reflect.DeepEqual(e.Instances, []string{"a:1", "b:1"}) {
+		t.Errorf("initial instances = %v", e.Instances)
+	}
+	set([]string{"c:1"}, nil)
+	if e := next(); !reflect.DeepEqual(e.Instances, []string{"c:1"}) {
+		t.Errorf("updated instances = %v", e.Instances)
+	}
+	set(nil, errors.New("lookup failed"))
+	if e := next(); e.Err == nil || !reflect.DeepEqual(e.Instances, []string{"c:1"}) {
+		t.Errorf("failure = %+v, want the error with the last instances", e)
+	}
+	set([]string{"c:1"}, nil)
+	if e := next(); e.Err != nil || !reflect.DeepEqual(e.Instances, []string{"c:1"}) {
+		t.Errorf("recovery = %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("event without a change: %+v", e)
+	case <-time.After(25 * time.Millisecond):
+	}
+}
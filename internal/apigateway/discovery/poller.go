@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lookup returns the current instances of an upstream.
+type Lookup func(ctx context.Context) ([]string, error)
+
+// Poller is an sd.Instancer that calls a Lookup periodically and notifies its
+// subscribers when the result changes. A failed lookup is sent as an event
+// error; sd.Endpointer keeps using the last known instances meanwhile.
+type Poller struct {
+	lookup   Lookup
+	interval time.Duration
+	logger   log.Logger
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mtx   sync.Mutex
+	state sd.Event
+	subs  map[chan<- sd.Event]struct{}
+}
+
+// NewPoller looks up the instances once before returning, so the first
+// subscriber starts with them, and then again every interval.
+func NewPoller(lookup Lookup, interval time.Duration, logger log.Logger) *Poller {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Poller{
+		lookup:   lookup,
+		interval: interval,
+		logger:   logger,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		subs:     map[chan<- sd.Event]struct{}{},
+	}
+	p.update(ctx)
+	go p.loop(ctx)
+	return p
+}
+
+func (p *Poller) loop(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.update(ctx)
+		}
+	}
+}
+
+func (p *Poller) update(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	instances, err := p.lookup(ctx)
+	sort.Strings(instances)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	event := sd.Event{Instances: instances, Err: err}
+	switch {
+	case err != nil:
+		if p.state.Err != nil && p.state.Err.Error() == err.Error() {
+			return
+		}
+		p.logger.Log("err", err)
+		// Keep the last instances so new subscribers can still use them.
+		event.Instances = p.state.Instances
+	case p.state.Err == nil && reflect.DeepEqual(p.state.Instances, instances):
+		return
+	default:
+		p.logger.Log("instances", len(instances))
+	}
+	p.state = event
+	for c := range p.subs {
+		c <- event
+	}
+}
+
+// Register implements sd.Instancer. The current state is sent to ch at once.
+func (p *Poller) Register(ch chan<- sd.Event) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.subs[ch] = struct{}{}
+	ch <- p.state
+}
+
+// Deregister implements sd.Instancer.
+func (p *Poller) Deregister(ch chan<- sd.Event) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	delete(p.subs, ch)
+}
+
+// Stop implements sd.Instancer. It waits for a lookup in progress to finish.
+func (p *Poller) Stop() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"github.com/yuisofull/gommunigate/internal/apigateway/discovery"
+	"github.com/yuisofull/gommunigate/internal/apigateway/idempotency"
+	"github.com/yuisofull/gommunigate/internal/apigateway/ratelimit"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider/apikey"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider/authsvc"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider/firebase"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider/session"
+	authpb "github.com/yuisofull/gommunigate/internal/authsvc/pb"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	userpb "github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultAccessTokenTTL is how long the access tokens of sessions are valid
+// for when their provider doesn't say.
+const defaultAccessTokenTTL = 15 * time.Minute
+
+// gateway is everything built from one configuration: the router, the auth
+// providers and, per upstream, the instancer, endpointers and their references
+// to the pooled upstream clients.
+type gateway struct {
+	handler http.Handler
+	// breakers holds the circuit breakers of each upstream.
+	breakers map[string]*breakers
+	// health tracks the health of the instances of each upstream.
+	health         map[string]*discovery.HealthFilter
+	tokenProviders map[string]tokenprovider.TokenProvider
+
+	mtx     sync.Mutex
+	closers []io.Closer
+	stops   []func()
+}
+
+func newGateway(cfg config.Config, userClients *clientPool[userendpoint.Set], authClients *clientPool[authendpoint.Set], m *gatewayMetrics, logger log.Logger) (*gateway, error) {
+	g := &gateway{
+		breakers:       map[string]*breakers{},
+		health:         map[string]*discovery.HealthFilter{},
+		tokenProviders: map[string]tokenprovider.TokenProvider{},
+	}
+
+	// verifiers verifies API keys and checks sessions with usersvc once its
+	// endpoints are built below.
+	var (
+		verifiers      userendpoint.Set
+		tokenProviders []tokenprovider.TokenProvider
+		accessTokens   accessTokenIssuer
+	)
+	for _, name := range sortedKeys(cfg.Auth.Providers) {
+		tp, err := newTokenProvider(cfg.Auth.Providers[name], &verifiers, &verifiers)
+		if err != nil {
+			return nil, fmt.Errorf("auth provider %s: %w", name, err)
+		}
+		tokenProviders = append(tokenProviders, tp)
+		g.tokenProviders[name] = tp
+		if issuer, ok := tp.(accessTokenIssuer); ok {
+			accessTokens = issuer
+		}
+	}
+
+	var rdb *redis.Client
+	if cfg.Redis.Addr != "" {
+		rdb = redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr})
+		g.closers = append(g.closers, rdb)
+	}
+
+	var idempotencyStore idempotency.Store
+	switch cfg.Idempotency.Store {
+	case "redis":
+		idempotencyStore = idempotency.NewRedis(rdb, cfg.Idempotency.KeyPrefix)
+	default:
+		idempotencyStore = idempotency.NewMemory()
+	}
+
+	var limiter ratelimit.Limiter
+	switch cfg.RateLimits.Store {
+	case "redis":
+		limiter = ratelimit.NewRedis(rdb, cfg.RateLimits.KeyPrefix)
+	default:
+		limiter = ratelimit.NewLocal()
+	}
+	routeLimits := make(map[string]ratelimit.Limit, len(cfg.RateLimits.Routes))
+	for route, l := range cfg.RateLimits.Routes {
+		routeLimits[route] = ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+
+	var (
+		authRouter = r.PathPrefix("/auth").Subrouter()
+		signIn     mux.MiddlewareFunc
+	)
+	// The /auth routes take no Idempotency-Key: a refresh token can only be
+	// exchanged once, and authsvc counts failed logins and sends emails.
+	authOptions := []httptransport.ServerOption{
+		httptransport.ServerBefore(userCallContext),
+		httptransport.ServerErrorEncoder(encodeError),
+	}
+
+	// usersvc routes
+	{
+		makeEndpoint, err := upstreamEndpoints(g, "user", cfg.Upstreams["user"], userClients, userpb.User_ServiceDesc.ServiceName, userRetryPolicies, m, logger)
+		if err != nil {
+			return nil, err
+		}
+		var set userendpoint.Set
+		serviceEndpoint := func(route string, mk func(userservice.Service) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s userendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		adminEndpoint := func(route string, mk func(userservice.AdminService) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s userendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		apiKeyEndpoint := func(route string, mk func(userservice.APIKeyService) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s userendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		sessionEndpoint := func(route string, mk func(userservice.SessionService) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s userendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		twoFactorEndpoint := func(route string, mk func(userservice.TwoFactorService) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s userendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		set.GetProfileEndpoint = serviceEndpoint("GET /user/{uid}", userendpoint.MakeGetProfileEndpoint)
+		set.CreateProfileEndpoint = serviceEndpoint("POST /user", userendpoint.MakeCreateProfileEndpoint)
+		set.UpdateProfileEndpoint = serviceEndpoint("PUT /user", userendpoint.MakeUpdateProfileEndpoint)
+		set.DeleteProfileEndpoint = serviceEndpoint("DELETE /user", userendpoint.MakeDeleteProfileEndpoint)
+		set.ListUsersEndpoint = adminEndpoint("GET /admin/users", userendpoint.MakeListUsersEndpoint)
+		set.GetUserEndpoint = adminEndpoint("GET /admin/users/{uid}", userendpoint.MakeGetUserEndpoint)
+		set.UpdateUserEndpoint = adminEndpoint("PUT /admin/users/{uid}", userendpoint.MakeUpdateUserEndpoint)
+		set.SuspendEndpoint = adminEndpoint("POST /admin/users/{uid}/suspend", userendpoint.MakeSuspendEndpoint)
+		set.UnsuspendEndpoint = adminEndpoint("POST /admin/users/{uid}/unsuspend", userendpoint.MakeUnsuspendEndpoint)
+		set.ForceDeleteEndpoint = adminEndpoint("DELETE /admin/users/{uid}", userendpoint.MakeForceDeleteEndpoint)
+		set.ListAPIKeysEndpoint = apiKeyEndpoint("GET /user/me/apikeys", userendpoint.MakeListAPIKeysEndpoint)
+		set.CreateAPIKeyEndpoint = apiKeyEndpoint("POST /user/me/apikeys", userendpoint.MakeCreateAPIKeyEndpoint)
+		set.RevokeAPIKeyEndpoint = apiKeyEndpoint("DELETE /user/me/apikeys/{id}", userendpoint.MakeRevokeAPIKeyEndpoint)
+		set.ListSessionsEndpoint = sessionEndpoint("GET /user/me/sessions", userendpoint.MakeListSessionsEndpoint)
+		set.RevokeSessionsEndpoint = sessionEndpoint("DELETE /user/me/sessions", userendpoint.MakeRevokeSessionsEndpoint)
+		set.RevokeSessionEndpoint = sessionEndpoint("DELETE /user/me/sessions/{id}", userendpoint.MakeRevokeSessionEndpoint)
+		set.CreateSessionEndpoint = sessionEndpoint("POST /auth/token", userendpoint.MakeCreateSessionEndpoint)
+		set.RefreshSessionEndpoint = sessionEndpoint("POST /auth/refresh", userendpoint.MakeRefreshSessionEndpoint)
+		set.GetTwoFactorEndpoint = twoFactorEndpoint("GET /user/me/2fa", userendpoint.MakeGetTwoFactorEndpoint)
+		set.EnrollTOTPEndpoint = twoFactorEndpoint("POST /user/me/2fa/totp", userendpoint.MakeEnrollTOTPEndpoint)
+		set.ConfirmTOTPEndpoint = twoFactorEndpoint("POST /user/me/2fa/totp/confirm", userendpoint.MakeConfirmTOTPEndpoint)
+		set.DisableTOTPEndpoint = twoFactorEndpoint("DELETE /user/me/2fa/totp", userendpoint.MakeDisableTOTPEndpoint)
+		verifiers.VerifyAPIKeyEndpoint = apiKeyEndpoint("VerifyAPIKey", userendpoint.MakeVerifyAPIKeyEndpoint)
+		verifiers.CheckSessionEndpoint = sessionEndpoint("CheckSession", userendpoint.MakeCheckSessionEndpoint)
+
+		var (
+			userRouter  = r.PathPrefix("/user").Subrouter()
+			adminRouter = r.PathPrefix("/admin/users").Subrouter()
+			middlewares []mux.MiddlewareFunc
+		)
+		options := []httptransport.ServerOption{
+			httptransport.ServerBefore(userCallContext, idempotencyKeyToContext),
+			httptransport.ServerErrorEncoder(encodeError),
+		}
+
+		if cfg.RateLimits.Enabled {
+			// Before authentication, so that guessing credentials is limited
+			// too.
+			ipRateLimitMiddleware := &ratelimit.Middleware{
+				Limiter: limiter,
+				Default: ratelimit.Limit{Rate: cfg.RateLimits.IP.Rate, Burst: cfg.RateLimits.IP.Burst},
+				Client:  rateLimitAddress,
+				Logger:  log.With(logger, "component", "ratelimit"),
+			}
+			middlewares = append(middlewares, ipRateLimitMiddleware.Middleware)
+			authRouter.Use(ipRateLimitMiddleware.Middleware)
+		}
+		if len(tokenProviders) > 0 {
+			authMiddleware := &AuthenticationMiddleware{TokenProviders: tokenProviders}
+			if a := cfg.Accounts; a.Enabled {
+				lookup := userendpoint.Set{GetAccountStatusEndpoint: serviceEndpoint("GetAccountStatus", userendpoint.MakeGetAccountStatusEndpoint)}
+				authMiddleware.Accounts = newAccountStatusCache(lookup.GetAccountStatus, a, log.With(logger, "component", "accounts"))
+			}
+			middlewares = append(middlewares, authMiddleware.Middleware, requireScope)
+			signIn = authMiddleware.Middleware
+		}
+		if cfg.RateLimits.Enabled {
+			rateLimitMiddleware := &ratelimit.Middleware{
+				Limiter: limiter,
+				Default: ratelimit.Limit{Rate: cfg.RateLimits.Default.Rate, Burst: cfg.RateLimits.Default.Burst},
+				Routes:  routeLimits,
+				Client:  rateLimitClient,
+				Logger:  log.With(logger, "component", "ratelimit"),
+			}
+			middlewares = append(middlewares, rateLimitMiddleware.Middleware)
+			authRouter.Use(rateLimitMiddleware.Middleware)
+		}
+		if len(cfg.StepUp.Routes) > 0 {
+			stepUp := &stepUpMiddleware{
+				Routes: make(map[string]bool, len(cfg.StepUp.Routes)),
+				Verify: userendpoint.Set{VerifyTOTPEndpoint: twoFactorEndpoint("VerifyTOTP", userendpoint.MakeVerifyTOTPEndpoint)}.VerifyTOTP,
+			}
+			for _, route := range cfg.StepUp.Routes {
+				stepUp.Routes[route] = true
+			}
+			middlewares = append(middlewares, stepUp.Middleware)
+		}
+		if cfg.Idempotency.Enabled {
+			idempotencyMiddleware := &idempotency.Middleware{
+				Store:   idempotencyStore,
+				TTL:     cfg.Idempotency.TTL,
+				LockTTL: cfg.Idempotency.LockTTL,
+				Wait:    cfg.Idempotency.Wait,
+				Caller:  callerID,
+				Logger:  log.With(logger, "component", "idempotency"),
+			}
+			middlewares = append(middlewares, idempotencyMiddleware.Middleware)
+		}
+		userRouter.Use(middlewares...)
+		// Moderators and admins only; usersvc checks the roles again.
+		adminRouter.Use(append(middlewares, requireRole(model.RoleModerator, model.RoleAdmin))...)
+
+		userRouter.
+			Path("/{uid}").
+			Handler(httptransport.NewServer(set.GetProfileEndpoint, decodeGetProfileRequest, encodeResponse, options...)).
+			Methods(http.MethodGet)
+
+		userRouter.
+			Path("").
+			Handler(httptransport.NewServer(set.CreateProfileEndpoint, decodeCreateProfileRequest, encodeResponse, options...)).
+			Methods(http.MethodPost)
+
+		userRouter.
+			Path("").
+			Handler(httptransport.NewServer(set.UpdateProfileEndpoint, decodeUpdateProfileRequest, encodeResponse, options...)).
+			Methods(http.MethodPut)
+
+		userRouter.
+			Path("").
+			Handler(httptransport.NewServer(set.DeleteProfileEndpoint, decodeDeleteProfileRequest, encodeResponse, options...)).
+			Methods(http.MethodDelete)
+
+		routeAPIKeys(userRouter, set, options)
+		routeSessions(userRouter, set, options)
+		routeTwoFactor(userRouter, set, options)
+		if accessTokens != nil {
+			routeAuth(authRouter, set, accessTokens, signIn, authOptions)
+		}
+		routeAdminUsers(adminRouter, set, options)
+	}
+
+	// authsvc routes, when it is configured
+	if upstream, ok := cfg.Upstreams["auth"]; ok {
+		makeEndpoint, err := upstreamEndpoints(g, "auth", upstream, authClients, authpb.Auth_ServiceDesc.ServiceName, authRetryPolicies, m, logger)
+		if err != nil {
+			return nil, err
+		}
+		serviceEndpoint := func(route string, mk func(authservice.Service) endpoint.Endpoint) endpoint.Endpoint {
+			return makeEndpoint(route, func(s authendpoint.Set) endpoint.Endpoint { return mk(s) })
+		}
+		set := authendpoint.Set{
+			RegisterEndpoint:             serviceEndpoint("POST /auth/register", authendpoint.MakeRegisterEndpoint),
+			LoginEndpoint:                serviceEndpoint("POST /auth/login", authendpoint.MakeLoginEndpoint),
+			ChangePasswordEndpoint:       serviceEndpoint("POST /auth/password", authendpoint.MakeChangePasswordEndpoint),
+			RequestPasswordResetEndpoint: serviceEndpoint("POST /auth/password/reset", authendpoint.MakeRequestPasswordResetEndpoint),
+			ResetPasswordEndpoint:        serviceEndpoint("POST /auth/password/reset/confirm", authendpoint.MakeResetPasswordEndpoint),
+		}
+		routeAccounts(authRouter, set, signIn, authOptions)
+	}
+
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	securityHeaderValues := cfg.SecurityHeaders
+	if cfg.Listeners.HTTP.TLS.Enabled() {
+		securityHeaderValues = map[string]string{"Strict-Transport-Security": "max-age=31536000"}
+		for name, value := range cfg.SecurityHeaders {
+			securityHeaderValues[name] = value
+		}
+	}
+	// The server span is named after the method until recordRoute knows the
+	// route.
+	g.handler = otelhttp.NewHandler(
+		accessLog(log.With(logger, "component", "access"), m, proxies,
+			securityHeaders(securityHeaderValues, corsHandler(cfg.CORS, limitBody(cfg.MaxBodyBytes, r)))),
+		"gateway",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+	return g, nil
+}
+
+// upstreamEndpoints discovers the instances of upstream name, which serve
+// service, follows their health and guards them with circuit breakers and a
+// bulkhead. It returns a function making the endpoint of a route, which calls
+// the instances through the clients in pool and retries as policies allow.
+func upstreamEndpoints[C any](g *gateway, name string, upstream config.Upstream, pool *clientPool[C], service string, policies map[string]retryPolicy, m *gatewayMetrics, logger log.Logger) (func(route string, mk func(C) endpoint.Endpoint) endpoint.Endpoint, error) {
+	instancer, err := discovery.New(upstream, log.With(logger, "upstream", name))
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", name, err)
+	}
+	// Instances reporting NOT_SERVING are left out of load balancing.
+	health := discovery.NewHealthFilter(instancer, watchHealth(pool, service), log.With(logger, "upstream", name, "component", "health"))
+	var (
+		breakers = newBreakers(upstream.CircuitBreaker)
+		shed     = bulkhead(upstream.MaxConcurrent, m.rejected.With("upstream", name, "reason", "overloaded"))
+	)
+	g.stops = append(g.stops, health.Stop, instancer.Stop)
+	g.breakers[name] = breakers
+	g.health[name] = health
+
+	return func(route string, mk func(C) endpoint.Endpoint) endpoint.Endpoint {
+		factory := g.trackClosers(breakers.factory(m.instrumentFactory(name, svcFactory(mk, pool))))
+		endpointer := sd.NewEndpointer(health, factory, logger)
+		g.stops = append(g.stops, endpointer.Close)
+		balancer := newBreakerBalancer(endpointer, m.rejected.With("upstream", name, "reason", "circuit_open"))
+		retries := m.retries.With("upstream", name, "route", route)
+		return shed(retry(upstream.Retry, policies[route], balancer, retries))
+	}, nil
+}
+
+// trackClosers wraps f so that Close also releases every client f acquired.
+func (g *gateway) trackClosers(f sd.Factory) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		e, c, err := f(instance)
+		if err == nil && c != nil {
+			g.mtx.Lock()
+			g.closers = append(g.closers, c)
+			g.mtx.Unlock()
+		}
+		return e, c, err
+	}
+}
+
+// Close stops the endpointers and instancers and releases the upstream
+// clients. Requests still using the gateway fail afterwards.
+func (g *gateway) Close() {
+	for _, stop := range g.stops {
+		stop()
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for _, c := range g.closers {
+		// The endpointer may already have released the clients of instances
+		// that went away.
+		_ = c.Close()
+	}
+	g.closers = nil
+}
+
+// newTokenProvider builds the TokenProvider c describes. API keys are verified
+// with keys, and whether sessions are active is checked with sessions.
+func newTokenProvider(c config.AuthProvider, keys apikey.Verifier, sessions session.Checker) (tokenprovider.TokenProvider, error) {
+	switch c.Type {
+	case "firebase":
+		return firebase.NewTokenProvider(c.CredentialsFile)
+	case "apikey":
+		return apikey.NewTokenProvider(keys, c.CacheTTL), nil
+	case "authsvc":
+		key, err := authsvc.LoadPublicKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return authsvc.NewTokenProvider(key), nil
+	case "session":
+		key, err := session.LoadKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ttl := c.TokenTTL
+		if ttl == 0 {
+			ttl = defaultAccessTokenTTL
+		}
+		return session.NewTokenProvider(key, ttl, sessions, c.CacheTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown auth provider type %q", c.Type)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
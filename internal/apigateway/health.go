@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/yuisofull/gommunigate/internal/apigateway/discovery"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+const (
+	// healthRetry is how long to wait before watching the health of an
+	// instance again after the watch failed.
+	healthRetry = 2 * time.Second
+	// readinessTimeout bounds the checks of a /readyz request.
+	readinessTimeout = 2 * time.Second
+)
+
+// watchHealth returns a discovery.HealthCheck that watches service on an
+// instance through the gRPC health checking protocol, over the connection the
+// instance's endpoints share. An instance that can't be reached is not
+// serving; one that doesn't implement the protocol is taken to be.
+func watchHealth[C any](pool *clientPool[C], service string) discovery.HealthCheck {
+	return func(ctx context.Context, instance string, update func(serving bool)) {
+		conn, release, err := pool.acquireConn(instance)
+		if err != nil {
+			update(false)
+			return
+		}
+		defer release.Close()
+
+		client := healthpb.NewHealthClient(conn)
+		for {
+			err := watch(ctx, client, service, update)
+			if ctx.Err() != nil {
+				return
+			}
+			if status.Code(err) == codes.Unimplemented {
+				update(true)
+				return
+			}
+			update(false)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(healthRetry):
+			}
+		}
+	}
+}
+
+// watch reports the status updates of one Watch call until it fails.
+func watch(ctx context.Context, client healthpb.HealthClient, service string, update func(serving bool)) error {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		update(resp.GetStatus() == healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// withProbes serves the probes of the gateway in front of h:
+//
+//	GET /healthz  200 while the gateway is running
+//	GET /readyz   200 once every upstream has a serving instance and every
+//	              auth provider is ready, 503 otherwise and while shutting
+//	              down
+//
+// Both report the outcome of their checks as JSON.
+func withProbes(h *reloadingHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/healthz":
+			writeProbe(w, nil)
+		case "/readyz":
+			if h.draining.Load() {
+				writeProbe(w, map[string]string{"gateway": "shutting down"})
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+			writeProbe(w, h.gateway().readiness(ctx))
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// readiness checks each upstream and auth provider, keyed by "upstream NAME" and
+// "auth NAME", returning "ok" or what is wrong.
+func (g *gateway) readiness(ctx context.Context) map[string]string {
+	checks := map[string]string{}
+	for name, f := range g.health {
+		checks["upstream "+name] = "ok"
+		if f.Serving() == 0 {
+			checks["upstream "+name] = "no serving instances"
+		}
+	}
+	for name, tp := range g.tokenProviders {
+		checks["auth "+name] = "ok"
+		if rc, ok := tp.(tokenprovider.ReadinessChecker); ok {
+			if err := rc.Ready(ctx); err != nil {
+				checks["auth "+name] = err.Error()
+			}
+		}
+	}
+	return checks
+}
+
+func writeProbe(w http.ResponseWriter, checks map[string]string) {
+	code, state := http.StatusOK, "ok"
+	for _, result := range checks {
+		if result != "ok" {
+			code, state = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}{state, checks})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/yuisofull/gommunigate/internal/apigateway/discovery"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// notReadyProvider is a TokenProvider still loading its keys.
+type notReadyProvider struct{ staticTokenProvider }
+
+func (notReadyProvider) Ready(context.Context) error { return errors.New("keys not loaded") }
+
+var _ tokenprovider.ReadinessChecker = notReadyProvider{}
+
+func TestProbes(t *testing.T) {
+	g, _ := testGateway(http.StatusTeapot, nil)
+	g.tokenProviders = map[string]tokenprovider.TokenProvider{"static": staticTokenProvider{}}
+	h := newReloadingHandler(g)
+	probes := withProbes(h)
+	get := func(path string) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		probes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct{ Checks map[string]string }
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body.Checks
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz answered %d", code)
+	}
+	if code, _ := get("/user/u1"); code != http.StatusTeapot {
+		t.Errorf("other paths answered %d, want them served by the gateway", code)
+	}
+	if code, checks := get("/readyz"); code != http.StatusOK || checks["auth static"] != "ok" {
+		t.Errorf("/readyz answered %d with %v", code, checks)
+	}
+
+	g.tokenProviders["loading"] = notReadyProvider{}
+	if code, checks := get("/readyz"); code != http.StatusServiceUnavailable || checks["auth loading"] != "keys not loaded" {
+		t.Errorf("/readyz answered %d with %v while a provider loads", code, checks)
+	}
+	delete(g.tokenProviders, "loading")
+
+	h.draining.Store(true)
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz answered %d while draining", code)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz answered %d while draining", code)
+	}
+}
+
+func TestWatchHealth(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+	healthServer.SetServingStatus("pb.User", healthpb.HealthCheckResponse_SERVING)
+
+	pool := newClientPool(func(*grpc.ClientConn) struct{} { return struct{}{} }, insecure.NewCredentials())
+	updates := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchHealth(pool, "pb.User")(ctx, lis.Addr().String(), func(serving bool) { updates <- serving })
+		close(done)
+	}()
+	next := func() bool {
+		select {
+		case serving := <-updates:
+			return serving
+		case <-time.After(5 * time.Second):
+			t.Fatal("no health update")
+			return false
+		}
+	}
+
+	if !next() {
+		t.Error("serving instance reported not serving")
+	}
+	healthServer.SetServingStatus("pb.User", healthpb.HealthCheckResponse_NOT_SERVING)
+	if next() {
+		t.Error("instance reported serving after it stopped")
+	}
+	cancel()
+	<-done
+	if len(pool.clients) != 0 {
+		t.Error("connection kept after the watch stopped")
+	}
+}
+
+func TestReadinessOfUpstreams(t *testing.T) {
+	instancer := sd.FixedInstancer{"10.0.0.1:8081"}
+	serving := make(chan struct{})
+	filter := discovery.NewHealthFilter(instancer, func(ctx context.Context, instance string, update func(bool)) {
+		update(true)
+		close(serving)
+		<-ctx.Done()
+	}, log.NewNopLogger())
+	defer filter.Stop()
+	g := &gateway{health: map[string]*discovery.HealthFilter{"user": filter}}
+
+	<-serving
+	if checks := g.readiness(context.Background()); checks["upstream user"] != "ok" {
+		t.Errorf("readiness = %v", checks)
+	}
+	g.health["auth"] = discovery.NewHealthFilter(sd.FixedInstancer{}, nil, log.NewNopLogger())
+	defer g.health["auth"].Stop()
+	if checks := g.readiness(context.Background()); checks["upstream auth"] != "no serving instances" {
+		t.Errorf("readiness = %v with an upstream without instances", checks)
+	}
+}
@@ -0,0 +1,64 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Store local to one gateway instance.
+type Memory struct {
+	now func() time.Time
+
+	mtx       sync.Mutex
+	records   map[string]memoryRecord
+	nextSweep time.Time
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{now: time.Now, records: map[string]memoryRecord{}}
+}
+
+func (m *Memory) Claim(_ context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	now := m.now()
+	m.sweep(now)
+	if r, ok := m.records[key]; ok && now.Before(r.expires) {
+		return r.Record, false, nil
+	}
+	m.records[key] = memoryRecord{Record: Record{Fingerprint: fingerprint}, expires: now.Add(ttl)}
+	return Record{}, true, nil
+}
+
+func (m *Memory) Complete(_ context.Context, key string, r Record, ttl time.Duration) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.records[key] = memoryRecord{Record: r, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// sweep drops expired records, at most once a minute.
+func (m *Memory) sweep(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(time.Minute)
+	for key, r := range m.records {
+		if !now.Before(r.expires) {
+			delete(m.records, key)
+		}
+	}
+}
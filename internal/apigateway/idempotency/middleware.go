@@ -0,0 +1,214 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxKeyLength bounds the Idempotency-Key header, which clients usually set to
+// a UUID.
+const maxKeyLength = 255
+
+// pollInterval is how often a duplicate checks whether the original request
+// has completed.
+const pollInterval = 50 * time.Millisecond
+
+// Middleware makes mutating requests that carry an Idempotency-Key header safe
+// to resend. The first request for a caller's key is served and its response
+// stored for TTL; duplicates get the stored response replayed, with an
+// Idempotent-Replayed header. A duplicate arriving while the first request is
+// still being served waits up to Wait for it, and is then rejected with 409.
+// Reusing a key for a different request is rejected with 422. Responses with
+// a 5xx status aren't stored, so such requests can be retried.
+type Middleware struct {
+	Store Store
+	// TTL is how long a response is replayed.
+	TTL time.Duration
+	// LockTTL is how long a key stays claimed by a request that never
+	// completes, e.g. because the gateway stopped while serving it.
+	LockTTL time.Duration
+	Wait    time.Duration
+	// Caller scopes keys, so that callers can't replay each other's responses.
+	Caller func(*http.Request) string
+	Logger log.Logger
+}
+
+func (m *Middleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "can't read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var (
+			ctx         = r.Context()
+			storeKey    = m.Caller(r) + "\x00" + key
+			fingerprint = fingerprint(r, body)
+			giveUp      = time.Now().Add(m.Wait)
+		)
+		for {
+			existing, claimed, err := m.Store.Claim(ctx, storeKey, fingerprint, m.LockTTL)
+			switch {
+			case errors.Is(err, errExpired):
+				continue
+			case err != nil:
+				// Serving the request beats failing it because the store is down.
+				m.Logger.Log("idempotency", "claim", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			case claimed:
+				m.serve(w, r, next, storeKey, fingerprint)
+				return
+			case existing.Fingerprint != fingerprint:
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				return
+			case existing.Complete:
+				replay(w, existing)
+				return
+			case time.Now().After(giveUp):
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+				return
+			}
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// serve serves the request that claimed key and stores its response.
+func (m *Middleware) serve(w http.ResponseWriter, r *http.Request, next http.Handler, key, fingerprint string) {
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+
+	// Store the outcome even if the client went away meanwhile; it is likely
+	// to resend the request.
+	ctx := context.WithoutCancel(r.Context())
+	var err error
+	if rec.status >= 500 {
+		err = m.Store.Release(ctx, key)
+	} else {
+		err = m.Store.Complete(ctx, key, Record{
+			Fingerprint: fingerprint,
+			Complete:    true,
+			Status:      rec.status,
+			Header:      storedHeader(w.Header()),
+			Body:        rec.body.Bytes(),
+		}, m.TTL)
+	}
+	if err != nil {
+		m.Logger.Log("idempotency", "store", "err", err)
+	}
+}
+
+// replay writes a stored response. Hop-specific headers are left as this
+// request set them, also for records stored before they were left out.
+func replay(w http.ResponseWriter, r Record) {
+	for k, v := range r.Header {
+		if !hopSpecific(k) {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(r.Status)
+	w.Write(r.Body)
+}
+
+// storedHeader copies the headers of a response to be replayed, leaving out
+// the hop-specific ones.
+func storedHeader(h http.Header) http.Header {
+	stored := http.Header{}
+	for k, v := range h {
+		if !hopSpecific(k) {
+			stored[k] = append([]string(nil), v...)
+		}
+	}
+	return stored
+}
+
+// hopSpecific reports whether a response header describes the exchange that
+// carried it rather than the response, and so must not be replayed: the rate
+// limit state, the request ID and the date are those of the duplicate.
+func hopSpecific(canonicalKey string) bool {
+	switch canonicalKey {
+	case "X-Request-Id", "Date", "Idempotent-Replayed":
+		return true
+	}
+	return strings.HasPrefix(canonicalKey, "Ratelimit-")
+}
+
+// fingerprint identifies a request by its method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+}
+
+// recorder passes a response through while keeping a copy. Unwrap gives
+// http.ResponseController access to the underlying writer.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,225 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testMiddleware returns a Middleware over a Memory store in front of a
+// handler that answers with status and counts its calls in served. Every
+// response carries the hop-specific headers of the call that produced it.
+func testMiddleware(status int, served *atomic.Int32) http.Handler {
+	m := &Middleware{
+		Store:   NewMemory(),
+		TTL:     time.Minute,
+		LockTTL: time.Minute,
+		Wait:    20 * time.Millisecond,
+		Caller:  func(r *http.Request) string { return r.Header.Get("Caller") },
+		Logger:  log.NewNopLogger(),
+	}
+	return m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := served.Add(1)
+		w.Header().Set("Location", "/user/u1")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id":"u1","call":` + strconv.Itoa(int(n)) + `}`))
+	}))
+}
+
+func request(method, key, caller, body string) *http.Request {
+	r := httptest.NewRequest(method, "/user", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	r.Header.Set("Caller", caller)
+	return r
+}
+
+// serve serves r through h with the headers the outer middlewares set on
+// every response, taking their values from hop.
+func serve(h http.Handler, r *http.Request, hop string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", hop)
+	rec.Header().Set("RateLimit-Remaining", hop)
+	rec.Header().Set("Date", hop)
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		status     int
+		first      *http.Request
+		second     *http.Request
+		wantStatus int
+		wantServed int32
+		replayed   bool
+	}{
+		{
+			name:       "duplicate replayed",
+			status:     http.StatusCreated,
+			first:      request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			second:     request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			wantStatus: http.StatusCreated,
+			wantServed: 1,
+			replayed:   true,
+		},
+		{
+			name:       "key reused for another request",
+			status:     http.StatusCreated,
+			first:      request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			second:     request(http.MethodPost, "k1", "u1", `{"name":"b"}`),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantServed: 1,
+		},
+		{
+			name:       "keys are scoped to the caller",
+			status:     http.StatusCreated,
+			first:      request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			second:     request(http.MethodPost, "k1", "u2", `{"name":"a"}`),
+			wantStatus: http.StatusCreated,
+			wantServed: 2,
+		},
+		{
+			name:       "server errors aren't stored",
+			status:     http.StatusServiceUnavailable,
+			first:      request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			second:     request(http.MethodPost, "k1", "u1", `{"name":"a"}`),
+			wantStatus: http.StatusServiceUnavailable,
+			wantServed: 2,
+		},
+		{
+			name:       "without a key",
+			status:     http.StatusCreated,
+			first:      request(http.MethodPost, "", "u1", `{"name":"a"}`),
+			second:     request(http.MethodPost, "", "u1", `{"name":"a"}`),
+			wantStatus: http.StatusCreated,
+			wantServed: 2,
+		},
+		{
+			name:       "reads aren't replayed",
+			status:     http.StatusOK,
+			first:      request(http.MethodGet, "k1", "u1", ""),
+			second:     request(http.MethodGet, "k1", "u1", ""),
+			wantStatus: http.StatusOK,
+			wantServed: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var served atomic.Int32
+			h := testMiddleware(tc.status, &served)
+			first := serve(h, tc.first, "first")
+			second := serve(h, tc.second, "second")
+
+			if second.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", second.Code, tc.wantStatus)
+			}
+			if n := served.Load(); n != tc.wantServed {
+				t.Errorf("served %d times, want %d", n, tc.wantServed)
+			}
+			if got := second.Header().Get("Idempotent-Replayed") == "true"; got != tc.replayed {
+				t.Errorf("replayed = %v, want %v", got, tc.replayed)
+			}
+			if !tc.replayed {
+				return
+			}
+			if first.Body.String() != second.Body.String() {
+				t.Errorf("body = %s, want %s", second.Body, first.Body)
+			}
+			if got := second.Header().Get("Location"); got != "/user/u1" {
+				t.Errorf("Location = %q, want it replayed", got)
+			}
+			for _, k := range []string{"X-Request-ID", "RateLimit-Remaining", "Date"} {
+				if got := second.Header().Get(k); got != "second" {
+					t.Errorf("%s = %q, want the duplicate's own", k, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareInProgress(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	m := &Middleware{
+		Store:   NewMemory(),
+		TTL:     time.Minute,
+		LockTTL: time.Minute,
+		Wait:    10 * time.Millisecond,
+		Caller:  func(*http.Request) string { return "u1" },
+		Logger:  log.NewNopLogger(),
+	}
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		serve(h, request(http.MethodPost, "k1", "u1", "{}"), "first")
+		close(done)
+	}()
+	<-entered
+
+	rec := serve(h, request(http.MethodPost, "k1", "u1", "{}"), "second")
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("duplicate in progress answered %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	close(release)
+	<-done
+}
+
+func TestMiddlewareKeyTooLong(t *testing.T) {
+	var served atomic.Int32
+	rec := serve(testMiddleware(http.StatusCreated, &served), request(http.MethodPost, strings.Repeat("k", maxKeyLength+1), "u1", "{}"), "first")
+	if rec.Code != http.StatusBadRequest || served.Load() != 0 {
+		t.Errorf("answered %d after serving %d times", rec.Code, served.Load())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, claimed, _ := m.Claim(ctx, "k", "f1", time.Second); !claimed {
+		t.Fatal("first claim failed")
+	}
+	if existing, claimed, _ := m.Claim(ctx, "k", "f2", time.Second); claimed || existing.Fingerprint != "f1" {
+		t.Errorf("second claim = %+v, %v", existing, claimed)
+	}
+	now = now.Add(time.Second)
+	if _, claimed, _ := m.Claim(ctx, "k", "f2", time.Second); !claimed {
+		t.Error("claim of an expired key failed")
+	}
+	m.Release(ctx, "k")
+	if _, claimed, _ := m.Claim(ctx, "k", "f3", time.Second); !claimed {
+		t.Error("claim of a released key failed")
+	}
+}
+
+func TestRecorderUnwrap(t *testing.T) {
+	h := (&Middleware{
+		Store:   NewMemory(),
+		TTL:     time.Minute,
+		LockTTL: time.Minute,
+		Caller:  func(*http.Request) string { return "u1" },
+		Logger:  log.NewNopLogger(),
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() = %v", err)
+		}
+	}))
+	rec := serve(h, request(http.MethodPost, "k1", "u1", "{}"), "first")
+	if !rec.Flushed {
+		t.Error("response not flushed")
+	}
+}
@@ -0,0 +1,55 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Redis is a Store shared by gateway instances, backed by any server that
+// speaks the Redis protocol. Records are stored as JSON.
+type Redis struct {
+	client    redis.Cmdable
+	keyPrefix string
+}
+
+func NewRedis(client redis.Cmdable, keyPrefix string) *Redis {
+	return &Redis{client: client, keyPrefix: keyPrefix}
+}
+
+func (s *Redis) Claim(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	b, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return Record{}, false, err
+	}
+	claimed, err := s.client.SetNX(ctx, s.keyPrefix+key, b, ttl).Result()
+	if err != nil || claimed {
+		return Record{}, claimed, err
+	}
+	b, err = s.client.Get(ctx, s.keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Record{}, false, errExpired
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	var r Record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Record{}, false, err
+	}
+	return r, false, nil
+}
+
+func (s *Redis) Complete(ctx context.Context, key string, r Record, ttl time.Duration) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.keyPrefix+key, b, ttl).Err()
+}
+
+func (s *Redis) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.keyPrefix+key).Err()
+}
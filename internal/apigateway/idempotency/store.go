@@ -0,0 +1,35 @@
+// Package idempotency lets clients safely resend mutating requests: the first
+// response for an Idempotency-Key is stored and replayed for its duplicates.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Record is what is stored for a key: the fingerprint of the request that
+// claimed it and, once that request completed, its response.
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Complete    bool        `json:"complete"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Store keeps Records by key until they expire.
+type Store interface {
+	// Claim stores an incomplete record with fingerprint for key, unless key
+	// already has a record, which is then returned with claimed false.
+	Claim(ctx context.Context, key, fingerprint string, ttl time.Duration) (existing Record, claimed bool, err error)
+	// Complete replaces the record of a claimed key.
+	Complete(ctx context.Context, key string, r Record, ttl time.Duration) error
+	// Release forgets a claimed key so that the request can be tried again.
+	Release(ctx context.Context, key string) error
+}
+
+// errExpired is returned by Claim implementations that lost a race with the
+// expiry of the existing record; claiming again succeeds.
+var errExpired = errors.New("record expired while claiming")
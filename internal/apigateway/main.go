@@ -5,39 +5,59 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/sd"
-	"github.com/go-kit/kit/sd/lb"
-	httptransport "github.com/go-kit/kit/transport/http"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
 	"github.com/gorilla/mux"
 	"github.com/oklog/oklog/pkg/group"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	authtransport "github.com/yuisofull/gommunigate/internal/authsvc/pkg/transport"
+	"github.com/yuisofull/gommunigate/internal/pkg/certs"
+	"github.com/yuisofull/gommunigate/internal/pkg/tracing"
 	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
 	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
 	usertransport "github.com/yuisofull/gommunigate/internal/usersvc/pkg/transport"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
 	"time"
 )
 
-//var configFile = flag.String("f", "etc/config.yaml", "the config file")
-
 func main() {
 	var (
-		httpAddr             = flag.String("http.addr", ":8000", "Address for HTTP (JSON) server")
-		userServiceInstances = flag.String("user-service-instances", "localhost:8081", "Optional comma-separated list of URLs to user service")
-		//firebaseCredentialConfigFile = flag.String("firebase--credential-config-file", "/home/yui/github.com/yuisofull/gommunigate/internal/apigateway/etc/firebase-credential.json", "Firebase config file")
-		retryMax     = flag.Int("retry.max", 3, "per-request retries to different instances")
-		retryTimeout = flag.Duration("retry.timeout", 500*time.Millisecond, "per-request timeout, including retries")
+		configFile    = flag.String("f", "", "YAML config file; any setting can be overridden with a GATEWAY_* environment variable")
+		printConfig   = flag.Bool("print-config", false, "Print the effective configuration and exit")
+		watchInterval = flag.Duration("watch-interval", 5*time.Second, "How often to check the config and certificate files for changes, 0 to only reload the config on SIGHUP")
 	)
 	flag.Parse()
 
+	cfg, err := config.Load(*configFile, os.Environ())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		b, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
@@ -48,87 +68,159 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
-	r := mux.NewRouter()
+	shutdownTracing, err := tracing.Setup(ctx, "apigateway", tracing.Config(cfg.Tracing))
+	if err != nil {
+		logger.Log("during", "tracing.Setup", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+	logger.Log("tracing", cfg.Tracing.Exporter, "sampleRatio", cfg.Tracing.SampleRatio)
 
-	// usersvc routes
-	{
-		var (
-			instanceList = strings.Split(*userServiceInstances, ",")
-			instancer    = sd.FixedInstancer(instanceList)
-			set          userendpoint.Set
-		)
-
-		{
-			factory := userSvcFactory(userendpoint.MakeGetProfileEndpoint, logger)
-			endpointer := sd.NewEndpointer(instancer, factory, logger)
-			balancer := lb.NewRoundRobin(endpointer)
-			retry := lb.Retry(*retryMax, *retryTimeout, balancer)
-			set.GetProfileEndpoint = retry
-		}
+	// certReloaders reload the certificates of the listener and upstreams.
+	var certReloaders []*certs.Reloader
 
-		{
-			factory := userSvcFactory(userendpoint.MakeCreateProfileEndpoint, logger)
-			endpointer := sd.NewEndpointer(instancer, factory, logger)
-			balancer := lb.NewRoundRobin(endpointer)
-			retry := lb.Retry(*retryMax, *retryTimeout, balancer)
-			set.CreateProfileEndpoint = retry
+	userCreds, r, err := upstreamCredentials("user", cfg.Upstreams["user"].TLS, logger)
+	if err != nil {
+		logger.Log("upstream", "user", "during", "TLS", "err", err)
+		os.Exit(1)
+	}
+	if r != nil {
+		certReloaders = append(certReloaders, r)
+	}
+	var userOptions []kitgrpc.ClientOption
+	if id := cfg.Upstreams["user"].Identity; id.KeyFile != "" {
+		key, err := usertransport.LoadIdentityKey(id.KeyFile)
+		if err != nil {
+			logger.Log("upstream", "user", "during", "identity", "err", err)
+			os.Exit(1)
 		}
+		signer := usertransport.NewIdentitySigner(key, id.TTL)
+		userOptions = append(userOptions, kitgrpc.ClientBefore(signer.SignCaller))
+		logger.Log("upstream", "user", "identity", "signed", "ttl", id.TTL)
+	}
+	userClients := newClientPool(func(conn *grpc.ClientConn) userendpoint.Set {
+		return usertransport.NewGRPCClient(conn, logger, userOptions...)
+	}, userCreds)
+	// authsvc is optional, but its clients are pooled from the start so that a
+	// reload can add it.
+	authCreds, r, err := upstreamCredentials("auth", cfg.Upstreams["auth"].TLS, logger)
+	if err != nil {
+		logger.Log("upstream", "auth", "during", "TLS", "err", err)
+		os.Exit(1)
+	}
+	if r != nil {
+		certReloaders = append(certReloaders, r)
+	}
+	authClients := newClientPool(func(conn *grpc.ClientConn) authendpoint.Set {
+		return authtransport.NewGRPCClient(conn, logger)
+	}, authCreds)
+	metrics := newGatewayMetrics()
+	gw, err := newGateway(cfg, userClients, authClients, metrics, logger)
+	if err != nil {
+		logger.Log("during", "newGateway", "err", err)
+		os.Exit(1)
+	}
+	handler := newReloadingHandler(gw)
+	defer handler.Close()
+	rl := &reloader{path: *configFile, handler: handler, userClients: userClients, authClients: authClients, metrics: metrics, logger: log.With(logger, "component", "config"), config: cfg}
 
-		{
-			factory := userSvcFactory(userendpoint.MakeUpdateProfileEndpoint, logger)
-			endpointer := sd.NewEndpointer(instancer, factory, logger)
-			balancer := lb.NewRoundRobin(endpointer)
-			retry := lb.Retry(*retryMax, *retryTimeout, balancer)
-			set.UpdateProfileEndpoint = retry
+	var g group.Group
+	{
+		listener := cfg.Listeners.HTTP
+		httpListener, err := net.Listen("tcp", listener.Addr)
+		if err != nil {
+			logger.Log("transport", "HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
 		}
 
-		{
-			factory := userSvcFactory(userendpoint.MakeDeleteProfileEndpoint, logger)
-			endpointer := sd.NewEndpointer(instancer, factory, logger)
-			balancer := lb.NewRoundRobin(endpointer)
-			retry := lb.Retry(*retryMax, *retryTimeout, balancer)
-			set.DeleteProfileEndpoint = retry
+		server := newHTTPServer(listener, withProbes(handler))
+		if listener.TLS.Enabled() {
+			r, err := certs.NewReloader(listener.TLS.CertFile, listener.TLS.KeyFile, "", log.With(logger, "transport", "HTTPS", "component", "tls"))
+			if err != nil {
+				logger.Log("transport", "HTTPS", "during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			certReloaders = append(certReloaders, r)
+			server.TLSConfig = r.ServerConfig()
 		}
+		g.Add(func() error {
+			var err error
+			if listener.TLS.Enabled() {
+				logger.Log("transport", "HTTPS", "addr", listener.Addr)
+				// The certificate comes from TLSConfig.
+				err = server.ServeTLS(httpListener, "", "")
+			} else {
+				logger.Log("transport", "HTTP", "addr", listener.Addr)
+				err = server.Serve(httpListener)
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}, func(error) {
+			// The group waits for the drain before stopping the other actors,
+			// so the admin listener keeps serving metrics meanwhile.
+			shutdown(server, handler, listener, log.With(logger, "transport", "HTTP"))
+		})
+	}
 
-		userRouter := r.PathPrefix("/user").Subrouter()
-
-		//authMiddleware := &AuthenticationMiddleware{TokenProvider: firebase.MustNewTokenProvider(*firebaseCredentialConfigFile)}
-		//userRouter.Use(authMiddleware.Middleware)
-
-		userRouter.
-			Path("/{uid}").
-			Handler(httptransport.NewServer(set.GetProfileEndpoint, decodeGetProfileRequest, encodeResponse)).
-			Methods(http.MethodGet)
-
-		userRouter.
-			Path("").
-			Handler(httptransport.NewServer(set.CreateProfileEndpoint, decodeCreateProfileRequest, encodeResponse)).
-			Methods(http.MethodPost)
-
-		userRouter.
-			Path("").
-			Handler(httptransport.NewServer(set.UpdateProfileEndpoint, decodeUpdateProfileRequest, encodeResponse)).
-			Methods(http.MethodPut)
+	if addr := cfg.Listeners.Admin.Addr; addr != "" {
+		adminListener, err := net.Listen("tcp", addr)
+		if err != nil {
+			logger.Log("transport", "admin/HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
 
-		userRouter.
-			Path("").
-			Handler(httptransport.NewServer(set.DeleteProfileEndpoint, decodeDeleteProfileRequest, encodeResponse)).
-			Methods(http.MethodDelete)
+		g.Add(func() error {
+			logger.Log("transport", "admin/HTTP", "addr", addr)
+			return http.Serve(adminListener, adminHandler(handler))
+		}, func(error) {
+			_ = adminListener.Close()
+		})
 	}
 
-	var g group.Group
 	{
-		httpListener, err := net.Listen("tcp", *httpAddr)
-		if err != nil {
-			logger.Log("transport", "HTTP", "during", "Listen", "err", err)
-			os.Exit(1)
+		// SIGHUP reloads the config file.
+		ctx, cancel := context.WithCancel(ctx)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		g.Add(func() error {
+			for {
+				select {
+				case <-hup:
+					rl.logger.Log("reload", "SIGHUP")
+					rl.reload()
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, func(error) {
+			signal.Stop(hup)
+			cancel()
+		})
+	}
+
+	if *watchInterval > 0 {
+		for _, r := range certReloaders {
+			ctx, cancel := context.WithCancel(ctx)
+			g.Add(func() error {
+				return r.Run(ctx, *watchInterval)
+			}, func(error) {
+				cancel()
+			})
 		}
+	}
 
+	if *configFile != "" && *watchInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
 		g.Add(func() error {
-			logger.Log("transport", "HTTP", "addr", *httpAddr)
-			return http.Serve(httpListener, r)
+			return rl.watch(ctx, *watchInterval)
 		}, func(error) {
-			_ = httpListener.Close()
+			cancel()
 		})
 	}
 
@@ -138,24 +230,39 @@ func main() {
 			case <-ctx.Done():
 				return ctx.Err()
 			}
-		}, nil)
+		}, func(error) {
+			cancel()
+		})
 	}
 
 	logger.Log("exit", g.Run())
 
 }
 
-func userSvcFactory(makeEndpoint func(userservice.Service) endpoint.Endpoint, logger log.Logger) sd.Factory {
+// svcFactory makes endpoints that call the instances of an upstream through
+// the clients in pool.
+func svcFactory[C any](makeEndpoint func(C) endpoint.Endpoint, pool *clientPool[C]) sd.Factory {
 	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
-		conn, err := grpc.Dial(instance, grpc.WithInsecure())
+		client, release, err := pool.acquire(instance)
 		if err != nil {
 			return nil, nil, err
 		}
-		service := usertransport.NewGRPCClient(conn, logger)
-		endpoint := makeEndpoint(service)
+		return recordInstance(instance, makeEndpoint(client)), release, nil
+	}
+}
 
-		return endpoint, conn, nil
+// upstreamCredentials returns the transport credentials of the clients of
+// upstream name, with the reloader of their certificates if t enables TLS.
+func upstreamCredentials(name string, t config.UpstreamTLS, logger log.Logger) (credentials.TransportCredentials, *certs.Reloader, error) {
+	if !t.Enabled() {
+		return insecure.NewCredentials(), nil, nil
 	}
+	r, err := certs.NewReloader(t.CertFile, t.KeyFile, t.CAFile, log.With(logger, "upstream", name, "component", "tls"))
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Log("upstream", name, "tls", true, "mtls", t.CertFile != "")
+	return credentials.NewTLS(r.ClientConfig(t.ServerName)), r, nil
 }
 
 var (
@@ -179,10 +286,10 @@ func decodeGetProfileRequest(ctx context.Context, r *http.Request) (interface{},
 
 func decodeCreateProfileRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	var req userendpoint.CreateProfileRequest
-	//claims, err := ClaimsFromContext(ctx)
-	//if err != nil {
-	//	return nil, err
-	//}
+	claims, err := ClaimsFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 	//authProvider, _ := AuthProviderFromContext(ctx)
 
 	var request struct {
@@ -192,11 +299,11 @@ func decodeCreateProfileRequest(ctx context.Context, r *http.Request) (interface
 		Bio         *string `json:"bio"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeJSON(r, &request); err != nil {
 		return nil, err
 	}
-	//uuid := claims["user-id"].(string)
-	uuid := "test"
+	// usersvc only lets callers create their own profile.
+	uuid, _ := claims["user-id"].(string)
 	req = userendpoint.CreateProfileRequest{
 		UUID:        &uuid,
 		Email:       request.Email,
@@ -222,7 +329,7 @@ func decodeUpdateProfileRequest(ctx context.Context, r *http.Request) (interface
 		Bio         *string `json:"bio"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeJSON(r, &request); err != nil {
 		return nil, err
 	}
 	uuid := claims["user-id"].(string)
@@ -247,6 +354,22 @@ func decodeDeleteProfileRequest(ctx context.Context, r *http.Request) (interface
 	return req, nil
 }
 
+// decodeJSON decodes the JSON body of r into v, rejecting unknown fields and
+// anything after the JSON value.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errTrailingData
+	}
+	var maxBytesErr *http.MaxBytesError
+	if err != nil && !errors.As(err, &maxBytesErr) {
+		return badRequestError{err}
+	}
+	return err
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
 		encodeError(ctx, f.Failed(), w)
@@ -257,8 +380,44 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 }
 
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := http.StatusInternalServerError
+	var (
+		unavailable unavailableError
+		badRequest  badRequestError
+		maxBytesErr *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &unavailable):
+		code = http.StatusServiceUnavailable
+		seconds := int(math.Ceil(unavailable.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	case errors.As(err, &maxBytesErr):
+		code = http.StatusRequestEntityTooLarge
+	case errors.As(err, &badRequest):
+		code = http.StatusBadRequest
+	case errors.Is(err, userservice.ErrReasonRequired), errors.Is(err, userservice.ErrInvalidRole),
+		errors.Is(err, userservice.ErrInvalidExpiry), errors.Is(err, userservice.ErrInvalidScope),
+		errors.Is(err, authservice.ErrInvalidEmail), errors.Is(err, authservice.ErrWeakPassword),
+		errors.Is(err, authservice.ErrInvalidResetToken):
+		code = http.StatusBadRequest
+	case errors.Is(err, userservice.ErrUserNotFound), errors.Is(err, userservice.ErrProfileUnavailable),
+		errors.Is(err, userservice.ErrAPIKeyNotFound), errors.Is(err, userservice.ErrSessionNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, userservice.ErrUnauthenticated), errors.Is(err, userservice.ErrInvalidRefreshToken),
+		errors.Is(err, userservice.ErrRefreshTokenReused), errors.Is(err, authservice.ErrInvalidCredentials),
+		errors.Is(err, userservice.ErrTwoFactorRequired), errors.Is(err, userservice.ErrInvalidTwoFactorCode):
+		code = http.StatusUnauthorized
+	case errors.Is(err, userservice.ErrTwoFactorEnabled), errors.Is(err, userservice.ErrTwoFactorNotEnabled):
+		code = http.StatusConflict
+	case errors.Is(err, authservice.ErrEmailTaken):
+		code = http.StatusConflict
+	case errors.Is(err, authservice.ErrAccountLocked), errors.Is(err, userservice.ErrTwoFactorLocked):
+		code = http.StatusTooManyRequests
+	case errors.Is(err, userservice.ErrPermissionDenied):
+		code = http.StatusForbidden
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"error": err.Error(),
 	})
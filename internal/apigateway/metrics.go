@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/go-kit/kit/sd"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"time"
+)
+
+// gatewayMetrics are registered once per process and shared by every gateway
+// built on reload.
+type gatewayMetrics struct {
+	// requests and duration describe the requests served, by method and
+	// route template; requests is also labelled by the HTTP status code.
+	requests metrics.Counter
+	duration metrics.Histogram
+	// upstreamRequests and upstreamDuration describe the calls to each
+	// upstream instance; upstreamRequests is also labelled by gRPC code.
+	upstreamRequests metrics.Counter
+	upstreamDuration metrics.Histogram
+	// retries counts the calls repeated after a failure, by upstream and
+	// route, and rejected the requests shed by reason: "overloaded" or
+	// "circuit_open".
+	retries  metrics.Counter
+	rejected metrics.Counter
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	return &gatewayMetrics{
+		requests: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests served, by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		duration: kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: "gateway",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency in seconds, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		upstreamRequests: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "upstream",
+			Name:      "requests_total",
+			Help:      "Calls to upstream instances, by upstream, instance and gRPC code.",
+		}, []string{"upstream", "instance", "code"}),
+		upstreamDuration: kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: "gateway",
+			Subsystem: "upstream",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of calls to upstream instances in seconds, by upstream and instance.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream", "instance"}),
+		retries: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "upstream",
+			Name:      "retries_total",
+			Help:      "Upstream calls repeated after a retryable failure, by upstream and route.",
+		}, []string{"upstream", "route"}),
+		rejected: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "gateway",
+			Subsystem: "upstream",
+			Name:      "rejected_total",
+			Help:      "Requests shed without calling the upstream, by upstream and reason.",
+		}, []string{"upstream", "reason"}),
+	}
+}
+
+// instrumentFactory wraps the endpoints made by f to record each call to the
+// instance.
+func (m *gatewayMetrics) instrumentFactory(upstream string, f sd.Factory) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		e, c, err := f(instance)
+		if err != nil {
+			return nil, nil, err
+		}
+		var (
+			requests = m.upstreamRequests.With("upstream", upstream, "instance", instance)
+			duration = m.upstreamDuration.With("upstream", upstream, "instance", instance)
+		)
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				requests.With("code", upstreamCode(response, err).String()).Add(1)
+				duration.Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return e(ctx, request)
+		}, c, nil
+	}
+}
+
+// upstreamCode returns the gRPC code of a call. Service errors returned inside
+// the response count as OK, since the instance served them.
+func upstreamCode(response interface{}, err error) codes.Code {
+	if err == nil {
+		f, ok := response.(endpoint.Failer)
+		if !ok || f.Failed() == nil {
+			return codes.OK
+		}
+		if _, isStatus := status.FromError(f.Failed()); !isStatus {
+			return codes.OK
+		}
+		err = f.Failed()
+	}
+	return status.Code(err)
+}
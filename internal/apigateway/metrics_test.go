@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countsByLabels is a metrics.Counter adding up what is counted under each set
+// of label values, e.g. "upstream=user,instance=a:1,code=OK".
+type countsByLabels struct {
+	counts map[string]float64
+	labels []string
+}
+
+func newCountsByLabels() *countsByLabels {
+	return &countsByLabels{counts: map[string]float64{}}
+}
+
+func (c *countsByLabels) With(labelValues ...string) metrics.Counter {
+	return &countsByLabels{counts: c.counts, labels: append(append([]string(nil), c.labels...), labelValues...)}
+}
+
+func (c *countsByLabels) Add(delta float64) {
+	var pairs []string
+	for i := 0; i+1 < len(c.labels); i += 2 {
+		pairs = append(pairs, c.labels[i]+"="+c.labels[i+1])
+	}
+	c.counts[strings.Join(pairs, ",")] += delta
+}
+
+func TestUpstreamCode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response interface{}
+		err      error
+		want     codes.Code
+	}{
+		{"success", failer{}, nil, codes.OK},
+		{"service error", failer{errors.New("user not found")}, nil, codes.OK},
+		{"status in the response", failer{status.Error(codes.Unavailable, "down")}, nil, codes.Unavailable},
+		{"transport error", nil, status.Error(codes.DeadlineExceeded, "slow"), codes.DeadlineExceeded},
+		{"other error", nil, errors.New("dial failed"), codes.Unknown},
+	} {
+		if got := upstreamCode(tc.response, tc.err); got != tc.want {
+			t.Errorf("%s: upstreamCode() = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestInstrumentFactory(t *testing.T) {
+	requests := newCountsByLabels()
+	m := testGatewayMetrics()
+	m.upstreamRequests = requests
+	m.upstreamDuration = discard.NewHistogram()
+	errs := []error{nil, status.Error(codes.Unavailable, "down"), nil}
+	factory := m.instrumentFactory("user", func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		return func(context.Context, interface{}) (interface{}, error) {
+			err := errs[0]
+			errs = errs[1:]
+			return nil, err
+		}, nil, nil
+	})
+	e, _, err := factory("10.0.0.1:8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		e(context.Background(), nil)
+	}
+	want := map[string]float64{
+		"upstream=user,instance=10.0.0.1:8081,code=OK":          2,
+		"upstream=user,instance=10.0.0.1:8081,code=Unavailable": 1,
+	}
+	if !reflect.DeepEqual(requests.counts, want) {
+		t.Errorf("counted %v, want %v", requests.counts, want)
+	}
+}
@@ -4,24 +4,56 @@ import (
 	"context"
 	"errors"
 	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net"
 	"net/http"
+	"strings"
 )
 
+// AuthenticationMiddleware accepts a request if any of its TokenProviders, tried
+// in order, verifies the Authorization header. With Accounts, requests from
+// suspended users are rejected with 403, and the "roles" claim is replaced by
+// the roles the user holds in usersvc; API keys act without any. Requests of
+// users whose status can't be looked up are rejected with 503 unless Accounts
+// fails open. Without Accounts, token providers can't be trusted with roles,
+// so callers act without any.
 type AuthenticationMiddleware struct {
-	TokenProvider tokenprovider.TokenProvider
+	TokenProviders []tokenprovider.TokenProvider
+	Accounts       *accountStatusCache
 }
 
 func (a *AuthenticationMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		idToken := r.Header.Get("Authorization")
-		claims, err := a.TokenProvider.VerifyToken(idToken)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		for _, tp := range a.TokenProviders {
+			claims, err := tp.VerifyToken(idToken)
+			if err != nil {
+				continue
+			}
+			ctx := context.WithValue(r.Context(), "auth_provider", tp.Name())
+			if uid, ok := claims["user-id"].(string); ok && a.Accounts != nil {
+				caller := userservice.ContextWithCaller(ctx, userservice.Caller{ID: uid})
+				status, err := a.Accounts.status(caller, uid)
+				if err != nil {
+					encodeError(ctx, err, w)
+					return
+				}
+				if status.Suspension != nil {
+					encodeSuspended(w, status.Suspension)
+					return
+				}
+				if isAPIKey(claims) {
+					status.Roles = nil
+				}
+				claims = withRoles(claims, status.Roles)
+			} else {
+				claims = withRoles(claims, nil)
+			}
+			ctx = context.WithValue(ctx, "claims", claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
-		ctx := context.WithValue(r.Context(), "claims", claims)
-		ctx = context.WithValue(ctx, "auth_provider", a.TokenProvider.Name())
-		next.ServeHTTP(w, r.WithContext(ctx))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	})
 }
 
@@ -45,3 +77,153 @@ func AuthProviderFromContext(ctx context.Context) (string, error) {
 	}
 	return authProvider, nil
 }
+
+// userCallContext is a transport/http.RequestFunc that records who is calling and
+// from where, so that usersvc can authorize and attribute the call (e.g. in its
+// audit log).
+func userCallContext(ctx context.Context, r *http.Request) context.Context {
+	if claims, err := ClaimsFromContext(ctx); err == nil {
+		if uid, ok := claims["user-id"].(string); ok {
+			ctx = userservice.ContextWithActor(ctx, uid)
+			ctx = userservice.ContextWithCaller(ctx, userservice.Caller{ID: uid, Roles: claimRoles(claims)})
+			info := requestInfoFromContext(ctx)
+			info.set(&info.user, uid)
+		}
+	}
+	if ip := clientIP(r); ip != "" {
+		ctx = userservice.ContextWithSourceIP(ctx, ip)
+	}
+	return ctx
+}
+
+// claimRoles returns the roles listed in the "roles" claim, which token
+// providers decode as []interface{}.
+func claimRoles(claims map[string]interface{}) []string {
+	var roles []string
+	switch v := claims["roles"].(type) {
+	case []string:
+		roles = v
+	case []interface{}:
+		for _, r := range v {
+			if role, ok := r.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// withRoles returns a copy of claims whose "roles" claim lists roles.
+func withRoles(claims map[string]interface{}, roles []string) map[string]interface{} {
+	c := make(map[string]interface{}, len(claims)+1)
+	for k, v := range claims {
+		c[k] = v
+	}
+	c["roles"] = roles
+	return c
+}
+
+// callerID identifies the authenticated caller of r, or returns "" if there is
+// none.
+func callerID(r *http.Request) string {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		return ""
+	}
+	uid, _ := claims["user-id"].(string)
+	return uid
+}
+
+// rateLimitClient identifies the client of r for rate limiting by its API key,
+// its user ID or, failing both, its address. API keys are identified by the
+// ID verified from them, so each key of a user has a bucket of its own.
+func rateLimitClient(r *http.Request) string {
+	if claims, err := ClaimsFromContext(r.Context()); err == nil {
+		if id, ok := claims["api-key-id"].(string); ok && id != "" {
+			return "apikey:" + id
+		}
+	}
+	if uid := callerID(r); uid != "" {
+		return "user:" + uid
+	}
+	return "ip:" + clientIP(r)
+}
+
+// rateLimitAddress identifies the client of r by its address alone, for the
+// limit checked before authentication. Its buckets are kept apart from those of
+// rateLimitClient.
+func rateLimitAddress(r *http.Request) string {
+	return "addr:" + clientIP(r)
+}
+
+// clientIP returns the address of the end client of r as accessLog found it,
+// or the peer address if r wasn't served through accessLog.
+func clientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoContextKey{}).(*requestInfo); ok {
+		return info.client
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address r came from.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustedProxies are the networks of the load balancers in front of the
+// gateway.
+type trustedProxies []*net.IPNet
+
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the end client of r. X-Forwarded-For is only
+// honoured when r comes from a trusted proxy, and is read from the right: each
+// proxy appends the address it was called from, so the client is the first
+// address not of a trusted proxy. Anything left of it may have been made up
+// by the client.
+func (p trustedProxies) clientIP(r *http.Request) string {
+	client := peerIP(r)
+	if !p.contains(client) {
+		return client
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// Malformed or missing; the last proxy is all that is known.
+			return client
+		}
+		client = hop
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return client
+}
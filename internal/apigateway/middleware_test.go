@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// staticTokenProvider verifies the tokens it was given the claims of.
+type staticTokenProvider map[string]map[string]interface{}
+
+func (p staticTokenProvider) GenerateToken(map[string]interface{}) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (p staticTokenProvider) VerifyToken(token string) (map[string]interface{}, error) {
+	claims, ok := p[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func (p staticTokenProvider) Name() string { return "static" }
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"forwarded header from an untrusted peer", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed entries left of the client", "10.0.0.2:5000", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"203.0.113.7, 10.0.0.9"}, "203.0.113.7"},
+		{"header split over lines", "10.0.0.2:5000", []string{"198.51.100.1", "203.0.113.7, 10.0.0.9"}, "203.0.113.7"},
+		{"IPv6 proxy", "[fd00::1]:5000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"trusted proxy without the header", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"malformed entry", "10.0.0.2:5000", []string{"203.0.113.7, unknown"}, "10.0.0.2"},
+		{"only trusted proxies", "10.0.0.2:5000", []string{"10.0.0.3"}, "10.0.0.3"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := proxies.clientIP(r); got != tc.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"10.0.0.1"}); err == nil {
+		t.Error("parsed an address without a prefix length")
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+		header string
+		want   string
+	}{
+		{"user", map[string]interface{}{"user-id": "u1"}, "", "user:u1"},
+		{"API key", map[string]interface{}{"user-id": "u1", "api-key-id": "k1"}, "", "apikey:k1"},
+		{"unauthenticated", nil, "", "ip:192.0.2.1"},
+		// The header isn't verified; only the claims count.
+		{"unverified API key header", nil, "secret", "ip:192.0.2.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "claims", tc.claims))
+		}
+		if tc.header != "" {
+			r.Header.Set("X-API-Key", tc.header)
+		}
+		if got := rateLimitClient(r); got != tc.want {
+			t.Errorf("%s: rateLimitClient() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAccessLogClientIP(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.0/8"})
+	var got string
+	h := accessLog(log.NewNopLogger(), testGatewayMetrics(), proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := userCallContext(r.Context(), r)
+		got, _ = userservice.SourceIPFromContext(ctx)
+	}))
+	for _, tc := range []struct {
+		remote string
+		want   string
+	}{
+		{"10.0.0.2:5000", "203.0.113.7"},
+		{"198.51.100.1:5000", "198.51.100.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != tc.want {
+			t.Errorf("from %s: source IP %q, want %q", tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	accounts := newAccountStatusCache(func(_ context.Context, uid string) (model.AccountStatus, error) {
+		switch uid {
+		case "banned":
+			return model.AccountStatus{Suspension: &model.Suspension{Reason: "spam"}}, nil
+		case "admin":
+			return model.AccountStatus{Roles: []string{model.RoleAdmin}}, nil
+		}
+		return model.AccountStatus{}, nil
+	}, config.Accounts{CacheTTL: time.Minute, ErrorTTL: time.Second, CacheSize: 10}, log.NewNopLogger())
+	a := &AuthenticationMiddleware{
+		TokenProviders: []tokenprovider.TokenProvider{
+			staticTokenProvider{
+				"t1":     {"user-id": "u1"},
+				"t2":     {"user-id": "banned"},
+				"t3":     {"user-id": "admin"},
+				"forged": {"user-id": "u1", "roles": []interface{}{model.RoleAdmin}},
+				"key":    {"user-id": "admin", "api-key-id": "k1"},
+			},
+		},
+		Accounts: accounts,
+	}
+	var (
+		user  string
+		roles []string
+	)
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		user, roles = callerID(r), claimRoles(claims)
+	}))
+	for _, tc := range []struct {
+		name      string
+		token     string
+		want      int
+		wantUser  string
+		wantRoles []string
+	}{
+		{"user", "t1", http.StatusOK, "u1", nil},
+		{"suspended user", "t2", http.StatusForbidden, "", nil},
+		{"admin in usersvc", "t3", http.StatusOK, "admin", []string{model.RoleAdmin}},
+		{"role claimed by the token only", "forged", http.StatusOK, "u1", nil},
+		{"API key of an admin", "key", http.StatusOK, "admin", nil},
+		{"unknown token", "unknown", http.StatusUnauthorized, "", nil},
+		{"no token", "", http.StatusUnauthorized, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, roles = "", nil
+			r := httptest.NewRequest(http.MethodGet, "/user/u1", nil)
+			r.Header.Set("Authorization", tc.token)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.want || user != tc.wantUser {
+				t.Errorf("status %d as %q, want %d as %q", rec.Code, user, tc.want, tc.wantUser)
+			}
+			if !slices.Equal(roles, tc.wantRoles) {
+				t.Errorf("roles %v, want %v", roles, tc.wantRoles)
+			}
+		})
+	}
+}
+
+func TestAuthenticationMiddlewareWithoutAccounts(t *testing.T) {
+	a := &AuthenticationMiddleware{
+		TokenProviders: []tokenprovider.TokenProvider{staticTokenProvider{"t1": {"user-id": "u1", "roles": []interface{}{model.RoleModerator}}}},
+	}
+	var roles []string
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		roles = claimRoles(claims)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/user/u1", nil)
+	r.Header.Set("Authorization", "t1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if len(roles) != 0 {
+		t.Errorf("roles %v taken from the token, want none", roles)
+	}
+}
@@ -0,0 +1,60 @@
+// Package ratelimit limits the request rate of gateway clients with token
+// buckets, kept locally or in a store shared by gateway replicas.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket holding up to Burst tokens and refilled at Rate
+// tokens per second. Each request takes a token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// RetryAfter is how long until a token is available, when none is.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Limiter takes a token from the bucket identified by key.
+type Limiter interface {
+	Allow(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// take applies a request at now to a bucket holding tokens, last refilled at
+// last, and returns the bucket's new level along with the result.
+func take(l Limit, tokens float64, last, now time.Time) (float64, Result) {
+	tokens = refill(l, tokens, last, now)
+	r := Result{}
+	if tokens >= 1 {
+		tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((1 - tokens) / l.Rate)
+	}
+	r.Remaining = int(tokens)
+	r.Reset = seconds((float64(l.Burst) - tokens) / l.Rate)
+	return tokens, r
+}
+
+// refill returns the level at now of a bucket holding tokens at last.
+func refill(l Limit, tokens float64, last, now time.Time) float64 {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(l.Burst), tokens+elapsed*l.Rate)
+	}
+	return tokens
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Local is a Limiter whose buckets live in the gateway process, so each
+// replica enforces the limits on its own.
+type Local struct {
+	now func() time.Time
+
+	mtx       sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+func NewLocal() *Local {
+	return &Local{now: time.Now, buckets: map[string]*bucket{}}
+}
+
+func (l *Local) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	var r Result
+	b.tokens, r = take(limit, b.tokens, b.last, now)
+	b.last, b.limit = now, limit
+	return r, nil
+}
+
+// sweep drops the buckets that have refilled, which behave like new ones, at
+// most once a minute.
+func (l *Local) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(time.Minute)
+	for key, b := range l.buckets {
+		if refill(b.limit, b.tokens, b.last, now) >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
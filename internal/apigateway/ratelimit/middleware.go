@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware limits each client's requests to the routes it wraps, which must
+// be gorilla/mux routes. Every response carries RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers; requests over the limit are
+// rejected with 429 and Retry-After.
+type Middleware struct {
+	Limiter Limiter
+	Default Limit
+	// Routes overrides Default per route, keyed by "METHOD /path/template".
+	Routes map[string]Limit
+	// Client identifies the client making a request, e.g. as "user:<id>".
+	Client func(*http.Request) string
+	Logger log.Logger
+}
+
+func (m *Middleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = r.Method + " " + tmpl
+			}
+		}
+		limit, ok := m.Routes[route]
+		if !ok {
+			limit, route = m.Default, "default"
+		}
+
+		// Routes with their own limit get their own buckets.
+		result, err := m.Limiter.Allow(r.Context(), route+"\x00"+m.Client(r), limit)
+		if err != nil {
+			// Serving the request beats failing it because the store is down.
+			m.Logger.Log("ratelimit", "allow", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			h.Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "rate limit exceeded"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
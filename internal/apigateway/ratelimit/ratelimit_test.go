@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	l := Limit{Rate: 2, Burst: 4}
+	start := time.Unix(1000, 0)
+	for _, tc := range []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       Result
+	}{
+		{"full bucket", 4, 0, 3, Result{Allowed: true, Remaining: 3, Reset: 500 * time.Millisecond}},
+		{"last token", 1, 0, 0, Result{Allowed: true, Remaining: 0, Reset: 2 * time.Second}},
+		{"empty bucket", 0.5, 0, 0.5, Result{Allowed: false, RetryAfter: 250 * time.Millisecond, Reset: 1750 * time.Millisecond}},
+		{"refilled meanwhile", 0, time.Second, 1, Result{Allowed: true, Remaining: 1, Reset: 1500 * time.Millisecond}},
+		{"refill capped at the burst", 0, time.Hour, 3, Result{Allowed: true, Remaining: 3, Reset: 500 * time.Millisecond}},
+	} {
+		tokens, r := take(l, tc.tokens, start, start.Add(tc.elapsed))
+		if tokens != tc.wantTokens || r != tc.want {
+			t.Errorf("%s: take() = %v, %+v, want %v, %+v", tc.name, tokens, r, tc.wantTokens, tc.want)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLocal()
+	l.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 2}
+	allow := func(key string) bool {
+		r, _ := l.Allow(context.Background(), key, limit)
+		return r.Allowed
+	}
+
+	if !allow("a") || !allow("a") || allow("a") {
+		t.Error("bucket didn't hold exactly Burst requests")
+	}
+	if !allow("b") {
+		t.Error("buckets are shared between keys")
+	}
+	now = now.Add(time.Second)
+	if !allow("a") || allow("a") {
+		t.Error("bucket didn't refill at Rate")
+	}
+
+	// Buckets that have refilled are dropped.
+	now = now.Add(time.Minute)
+	allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("refilled bucket kept")
+	}
+}
+
+// failingLimiter fails every call.
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+func TestMiddleware(t *testing.T) {
+	newRouter := func(limiter Limiter) *mux.Router {
+		m := &Middleware{
+			Limiter: limiter,
+			Default: Limit{Rate: 1, Burst: 2},
+			Routes:  map[string]Limit{"POST /user": {Rate: 1, Burst: 1}},
+			Client:  func(r *http.Request) string { return r.Header.Get("Client") },
+			Logger:  log.NewNopLogger(),
+		}
+		r := mux.NewRouter()
+		r.Use(m.Middleware)
+		ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		r.Handle("/user", ok).Methods(http.MethodPost)
+		r.Handle("/user/{uid}", ok).Methods(http.MethodGet)
+		return r
+	}
+	type call struct {
+		method, path, client string
+		want                 int
+	}
+	for _, tc := range []struct {
+		name    string
+		limiter Limiter
+		calls   []call
+	}{
+		{
+			name:    "default limit per client",
+			limiter: NewLocal(),
+			calls: []call{
+				{http.MethodGet, "/user/a", "c1", http.StatusOK},
+				{http.MethodGet, "/user/b", "c1", http.StatusOK},
+				{http.MethodGet, "/user/c", "c1", http.StatusTooManyRequests},
+				{http.MethodGet, "/user/a", "c2", http.StatusOK},
+			},
+		},
+		{
+			name:    "route limits have their own buckets",
+			limiter: NewLocal(),
+			calls: []call{
+				{http.MethodPost, "/user", "c1", http.StatusOK},
+				{http.MethodPost, "/user", "c1", http.StatusTooManyRequests},
+				{http.MethodGet, "/user/a", "c1", http.StatusOK},
+			},
+		},
+		{
+			name:    "store down",
+			limiter: failingLimiter{},
+			calls: []call{
+				{http.MethodPost, "/user", "c1", http.StatusOK},
+				{http.MethodPost, "/user", "c1", http.StatusOK},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRouter(tc.limiter)
+			for i, c := range tc.calls {
+				req := httptest.NewRequest(c.method, c.path, nil)
+				req.Header.Set("Client", c.client)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				if rec.Code != c.want {
+					t.Fatalf("call %d: status %d, want %d", i, rec.Code, c.want)
+				}
+				if _, failing := tc.limiter.(failingLimiter); failing {
+					continue
+				}
+				if rec.Header().Get("RateLimit-Remaining") == "" || rec.Header().Get("RateLimit-Reset") == "" {
+					t.Errorf("call %d: headers %v lack the rate limit", i, rec.Header())
+				}
+				if c.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+					t.Errorf("call %d: 429 without Retry-After", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// Redis is a Limiter whose buckets are shared by gateway replicas through any
+// server that speaks the Redis protocol. The bucket is updated by a script,
+// atomically and on the server's clock.
+type Redis struct {
+	client    redis.Scripter
+	keyPrefix string
+}
+
+func NewRedis(client redis.Scripter, keyPrefix string) *Redis {
+	return &Redis{client: client, keyPrefix: keyPrefix}
+}
+
+// takeScript mirrors take. It returns whether the request is allowed, the
+// remaining tokens, and RetryAfter and Reset in microseconds. A bucket expires
+// once it would have refilled.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1e6
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+if now > last then
+  tokens = math.min(burst, tokens + (now - last) * rate)
+end
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = (1 - tokens) / rate
+end
+local reset = (burst - tokens) / rate
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(reset * 1000) + 1000)
+return {allowed, math.floor(tokens), math.ceil(retry * 1e6), math.ceil(reset * 1e6)}
+`)
+
+func (s *Redis) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	args := []interface{}{strconv.FormatFloat(l.Rate, 'g', -1, 64), l.Burst}
+	v, err := takeScript.Run(ctx, s.client, []string{s.keyPrefix + key}, args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    v[0] == 1,
+		Remaining:  int(v[1]),
+		RetryAfter: time.Duration(v[2]) * time.Microsecond,
+		Reset:      time.Duration(v[3]) * time.Microsecond,
+	}, nil
+}
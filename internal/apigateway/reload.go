@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadingHandler serves requests with the current gateway and swaps in a new
+// one on reload. A replaced gateway is closed once the requests it is still
+// serving have finished, so a reload never cuts off a request.
+type reloadingHandler struct {
+	mtx     sync.RWMutex
+	current *generation
+	// draining is set once the gateway is shutting down.
+	draining atomic.Bool
+}
+
+// generation counts the requests being served by one gateway.
+type generation struct {
+	gateway *gateway
+
+	mtx     sync.Mutex
+	active  int
+	retired bool
+}
+
+func newReloadingHandler(g *gateway) *reloadingHandler {
+	return &reloadingHandler{current: &generation{gateway: g}}
+}
+
+func (h *reloadingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Acquiring under the read lock guarantees that swap can't retire, and
+	// close, the generation between loading and acquiring it.
+	h.mtx.RLock()
+	gen := h.current
+	gen.acquire()
+	h.mtx.RUnlock()
+	defer gen.release()
+
+	gen.gateway.handler.ServeHTTP(w, r)
+}
+
+// swap makes g the current gateway and retires the previous one.
+func (h *reloadingHandler) swap(g *gateway) {
+	h.mtx.Lock()
+	old := h.current
+	h.current = &generation{gateway: g}
+	h.mtx.Unlock()
+	old.retire()
+}
+
+// gateway returns the current gateway.
+func (h *reloadingHandler) gateway() *gateway {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return h.current.gateway
+}
+
+// startDraining makes /readyz fail from now on.
+func (h *reloadingHandler) startDraining() {
+	h.draining.Store(true)
+}
+
+// Close retires the current gateway.
+func (h *reloadingHandler) Close() {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	h.current.retire()
+}
+
+func (gen *generation) acquire() {
+	gen.mtx.Lock()
+	defer gen.mtx.Unlock()
+	gen.active++
+}
+
+func (gen *generation) release() {
+	gen.mtx.Lock()
+	defer gen.mtx.Unlock()
+	gen.active--
+	if gen.retired && gen.active == 0 {
+		go gen.gateway.Close()
+	}
+}
+
+func (gen *generation) retire() {
+	gen.mtx.Lock()
+	defer gen.mtx.Unlock()
+	if gen.retired {
+		return
+	}
+	gen.retired = true
+	if gen.active == 0 {
+		go gen.gateway.Close()
+	}
+}
+
+// reloader rebuilds the gateway from the config file. A config that fails to
+// load, validate or build is logged and ignored, leaving the previous one in use.
+type reloader struct {
+	path        string
+	handler     *reloadingHandler
+	userClients *clientPool[userendpoint.Set]
+	authClients *clientPool[authendpoint.Set]
+	metrics     *gatewayMetrics
+	logger      log.Logger
+
+	mtx    sync.Mutex
+	config config.Config
+}
+
+func (rl *reloader) reload() {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	cfg, err := config.Load(rl.path, os.Environ())
+	if err != nil {
+		rl.logger.Log("reload", "rejected", "err", err)
+		return
+	}
+	changes := config.Diff(rl.config, cfg)
+	if len(changes) == 0 {
+		rl.logger.Log("reload", "unchanged")
+		return
+	}
+	g, err := newGateway(cfg, rl.userClients, rl.authClients, rl.metrics, rl.logger)
+	if err != nil {
+		rl.logger.Log("reload", "rejected", "err", err)
+		return
+	}
+	for _, c := range changes {
+		rl.logger.Log("reload", "changed", "setting", c.Path, "old", c.Old, "new", c.New)
+	}
+	if !reflect.DeepEqual(rl.config.Listeners, cfg.Listeners) {
+		// Listeners are bound once, so keep describing the ones in use.
+		rl.logger.Log("reload", "partial", "msg", "listener changes take effect after a restart")
+		cfg.Listeners = rl.config.Listeners
+	}
+	for _, name := range sortedKeys(cfg.Upstreams) {
+		u, old := cfg.Upstreams[name], rl.config.Upstreams[name]
+		if u.TLS != old.TLS {
+			// The client pools are set up with the credentials once.
+			rl.logger.Log("reload", "partial", "msg", "TLS changes of upstream "+name+" take effect after a restart")
+			u.TLS = old.TLS
+			cfg.Upstreams[name] = u
+		}
+		if u.Identity != old.Identity {
+			// The clients are set up with the signing key once.
+			rl.logger.Log("reload", "partial", "msg", "identity changes of upstream "+name+" take effect after a restart")
+			u.Identity = old.Identity
+			cfg.Upstreams[name] = u
+		}
+	}
+	if rl.config.Tracing != cfg.Tracing {
+		rl.logger.Log("reload", "partial", "msg", "tracing changes take effect after a restart")
+		cfg.Tracing = rl.config.Tracing
+	}
+	rl.handler.swap(g)
+	rl.config = cfg
+	rl.logger.Log("reload", "applied", "changes", len(changes))
+}
+
+// watch calls reload whenever the content of the config file changes, checking
+// every interval. Comparing content rather than modification times also catches
+// files replaced through a symlink swap, as Kubernetes does for ConfigMaps.
+func (rl *reloader) watch(ctx context.Context, interval time.Duration) error {
+	last, _ := fileHash(rl.path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		sum, err := fileHash(rl.path)
+		if err != nil || bytes.Equal(sum, last) {
+			continue
+		}
+		last = sum
+		rl.logger.Log("reload", "file changed", "path", rl.path)
+		rl.reload()
+	}
+}
+
+func fileHash(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
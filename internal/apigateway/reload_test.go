@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/go-kit/kit/metrics/discard"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testGateway returns a gateway answering with status, whose requests block
+// until release is closed if it isn't nil, and that closes closed on Close.
+func testGateway(status int, release chan struct{}) (g *gateway, closed chan struct{}) {
+	closed = make(chan struct{})
+	g = &gateway{
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if release != nil {
+				<-release
+			}
+			w.WriteHeader(status)
+		}),
+		stops: []func(){func() { close(closed) }},
+	}
+	return g, closed
+}
+
+// testGatewayMetrics returns gatewayMetrics discarding everything, as the
+// Prometheus ones can only be registered once.
+func testGatewayMetrics() *gatewayMetrics {
+	return &gatewayMetrics{
+		requests:         discard.NewCounter(),
+		duration:         discard.NewHistogram(),
+		upstreamRequests: discard.NewCounter(),
+		upstreamDuration: discard.NewHistogram(),
+		retries:          discard.NewCounter(),
+		rejected:         discard.NewCounter(),
+	}
+}
+
+func isClosed(c chan struct{}, wait time.Duration) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func TestReloadingHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		inFlight bool
+	}{
+		{"idle gateway closed on swap", false},
+		{"gateway closed after its requests", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var release chan struct{}
+			if tc.inFlight {
+				release = make(chan struct{})
+			}
+			old, oldClosed := testGateway(http.StatusOK, release)
+			h := newReloadingHandler(old)
+
+			done := make(chan int)
+			if tc.inFlight {
+				go func() {
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+					done <- rec.Code
+				}()
+				// Wait for the request to be counted against old.
+				for {
+					h.mtx.RLock()
+					gen := h.current
+					h.mtx.RUnlock()
+					gen.mtx.Lock()
+					active := gen.active
+					gen.mtx.Unlock()
+					if active == 1 {
+						break
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			next, nextClosed := testGateway(http.StatusAccepted, nil)
+			h.swap(next)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("request after swap answered %d, want %d", rec.Code, http.StatusAccepted)
+			}
+
+			if tc.inFlight {
+				if isClosed(oldClosed, 10*time.Millisecond) {
+					t.Fatal("old gateway closed with a request in flight")
+				}
+				close(release)
+				if code := <-done; code != http.StatusOK {
+					t.Errorf("request in flight answered %d, want %d", code, http.StatusOK)
+				}
+			}
+			if !isClosed(oldClosed, time.Second) {
+				t.Error("old gateway not closed")
+			}
+			if isClosed(nextClosed, 0) {
+				t.Error("current gateway closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+var tracer = otel.Tracer("github.com/yuisofull/gommunigate/internal/apigateway")
+
+// retryPolicy says whether the calls of a route may be repeated.
+type retryPolicy struct {
+	// idempotent routes are retried freely; others only when the client sent
+	// an Idempotency-Key, which makes repeating them safe.
+	idempotent bool
+}
+
+// userRetryPolicies holds the retry policy of each usersvc route, keyed by
+// "METHOD /path/template" like the rate limits.
+var userRetryPolicies = map[string]retryPolicy{
+	"GET /user/{uid}": {idempotent: true},
+	"POST /user":      {idempotent: false},
+	"PUT /user":       {idempotent: true},
+	"DELETE /user":    {idempotent: true},
+	// Looked up by AuthenticationMiddleware.
+	"GetAccountStatus": {idempotent: true},
+	"VerifyAPIKey":     {idempotent: true},
+	"CheckSession":     {idempotent: true},
+	// Used by stepUpMiddleware. A code is used up once accepted.
+	"VerifyTOTP": {idempotent: false},
+
+	"GET /user/me/apikeys":         {idempotent: true},
+	"POST /user/me/apikeys":        {idempotent: false},
+	"DELETE /user/me/apikeys/{id}": {idempotent: true},
+
+	"GET /user/me/sessions":         {idempotent: true},
+	"DELETE /user/me/sessions":      {idempotent: true},
+	"DELETE /user/me/sessions/{id}": {idempotent: true},
+
+	"GET /user/me/2fa": {idempotent: true},
+	// Enrolling again replaces a secret the client never got.
+	"POST /user/me/2fa/totp":         {idempotent: true},
+	"POST /user/me/2fa/totp/confirm": {idempotent: false},
+	"DELETE /user/me/2fa/totp":       {idempotent: false},
+	// Repeating a refresh that went through reuses its refresh token, which
+	// revokes the session.
+	"POST /auth/token":   {idempotent: false},
+	"POST /auth/refresh": {idempotent: false},
+
+	"GET /admin/users":                  {idempotent: true},
+	"GET /admin/users/{uid}":            {idempotent: true},
+	"PUT /admin/users/{uid}":            {idempotent: true},
+	"POST /admin/users/{uid}/suspend":   {idempotent: false},
+	"POST /admin/users/{uid}/unsuspend": {idempotent: false},
+	"DELETE /admin/users/{uid}":         {idempotent: true},
+}
+
+// authRetryPolicies holds the retry policy of each authsvc route. None is
+// repeated: a repeated login counts as another attempt towards the lockout, a
+// repeated reset request sends another email, and a repeated registration or
+// password change fails where the first one went through.
+var authRetryPolicies = map[string]retryPolicy{
+	"POST /auth/register":               {idempotent: false},
+	"POST /auth/login":                  {idempotent: false},
+	"POST /auth/password":               {idempotent: false},
+	"POST /auth/password/reset":         {idempotent: false},
+	"POST /auth/password/reset/confirm": {idempotent: false},
+}
+
+func (p retryPolicy) allows(ctx context.Context) bool {
+	_, ok := idempotencyKeyFromContext(ctx)
+	return p.idempotent || ok
+}
+
+// retry calls an endpoint picked by b until a call succeeds or fails for good,
+// at most cfg.Max times, backing off exponentially with jitter between
+// attempts. Only calls that never reached usersvc, or that it couldn't serve,
+// are retried, and only if policy allows it. Every attempt shares the
+// cfg.Timeout budget, whose remaining time the gRPC client sends upstream as
+// the call's deadline. Each repeated call is counted in retries, and every
+// attempt is traced in a span of its own.
+func retry(cfg config.Retry, policy retryPolicy, b lb.Balancer, retries metrics.Counter) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+
+		for attempt := 1; ; attempt++ {
+			response, err = tracedAttempt(ctx, attempt, b, request)
+			if attempt >= cfg.Max || !retryable(response, err) || !policy.allows(ctx) {
+				return response, err
+			}
+			wait := backoff(cfg, attempt)
+			if deadline, _ := ctx.Deadline(); time.Until(deadline) < wait {
+				return response, err
+			}
+			select {
+			case <-time.After(wait):
+				retries.Add(1)
+			case <-ctx.Done():
+				return response, err
+			}
+		}
+	}
+}
+
+// tracedAttempt makes one attempt of a call in a span recording its number
+// and outcome.
+func tracedAttempt(ctx context.Context, attempt int, b lb.Balancer, request interface{}) (response interface{}, err error) {
+	ctx, span := tracer.Start(ctx, "attempt", trace.WithAttributes(attribute.Int("retry.attempt", attempt)))
+	defer func() {
+		failure := err
+		if f, ok := response.(endpoint.Failer); ok && failure == nil {
+			failure = f.Failed()
+		}
+		if failure != nil {
+			span.RecordError(failure)
+			span.SetStatus(otelcodes.Error, failure.Error())
+		}
+		span.End()
+	}()
+
+	e, err := b.Endpoint()
+	if err != nil {
+		return nil, err
+	}
+	return e(ctx, request)
+}
+
+// retryable reports whether a call failed because usersvc was unavailable, as
+// opposed to answering with an error, which a retry would only repeat.
+func retryable(response interface{}, err error) bool {
+	if err == nil {
+		f, ok := response.(endpoint.Failer)
+		if !ok || f.Failed() == nil {
+			return false
+		}
+		err = f.Failed()
+	}
+	return status.Code(err) == codes.Unavailable || errors.Is(err, lb.ErrNoEndpoints)
+}
+
+// backoff returns a random wait before the attempt following attempt, up to an
+// exponentially growing bound ("full jitter").
+func backoff(cfg config.Retry, attempt int) time.Duration {
+	bound := cfg.InitialBackoff << (attempt - 1)
+	if bound > cfg.MaxBackoff || bound <= 0 {
+		bound = cfg.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(bound) + 1))
+}
+
+type idempotencyKeyContextKey struct{}
+
+// idempotencyKeyToContext is a transport/http.RequestFunc that records the
+// request's Idempotency-Key header.
+func idempotencyKeyToContext(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		ctx = context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+	}
+	return ctx
+}
+
+func idempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok
+}
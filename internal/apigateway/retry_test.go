@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testRetryConfig = config.Retry{
+	Max:            3,
+	Timeout:        time.Second,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     2 * time.Millisecond,
+}
+
+// scriptedBalancer hands out an endpoint answering each call with the next of
+// its outcomes, repeating the last one.
+type scriptedBalancer struct {
+	outcomes []error
+	calls    int
+}
+
+func (b *scriptedBalancer) Endpoint() (endpoint.Endpoint, error) {
+	return func(context.Context, interface{}) (interface{}, error) {
+		err := b.outcomes[min(b.calls, len(b.outcomes)-1)]
+		b.calls++
+		if err != nil {
+			return nil, err
+		}
+		return "served", nil
+	}, nil
+}
+
+var _ lb.Balancer = (*scriptedBalancer)(nil)
+
+func TestRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	notFound := status.Error(codes.NotFound, "user not found")
+	withKey := func(ctx context.Context) context.Context {
+		r := httptest.NewRequest(http.MethodPost, "/user", nil)
+		r.Header.Set("Idempotency-Key", "k1")
+		return idempotencyKeyToContext(ctx, r)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		idempotent  bool
+		withKey     bool
+		outcomes    []error
+		wantCalls   int
+		wantRetries float64
+		wantErr     error
+	}{
+		{"success", true, false, []error{nil}, 1, 0, nil},
+		{"unavailable then success", true, false, []error{unavailable, nil}, 2, 1, nil},
+		{"gives up after max attempts", true, false, []error{unavailable}, 3, 2, unavailable},
+		{"service errors aren't retried", true, false, []error{notFound, nil}, 1, 0, notFound},
+		{"not idempotent", false, false, []error{unavailable, nil}, 1, 0, unavailable},
+		{"not idempotent with an Idempotency-Key", false, true, []error{unavailable, nil}, 2, 1, nil},
+		{"no endpoints", true, false, []error{lb.ErrNoEndpoints, nil}, 2, 1, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &scriptedBalancer{outcomes: tc.outcomes}
+			retries := generic.NewCounter("retries")
+			ctx := context.Background()
+			if tc.withKey {
+				ctx = withKey(ctx)
+			}
+			_, err := retry(testRetryConfig, retryPolicy{idempotent: tc.idempotent}, b, retries)(ctx, nil)
+			if err != tc.wantErr {
+				t.Errorf("err = %v, want %v", err, tc.wantErr)
+			}
+			if b.calls != tc.wantCalls {
+				t.Errorf("%d calls, want %d", b.calls, tc.wantCalls)
+			}
+			if got := retries.Value(); got != tc.wantRetries {
+				t.Errorf("%v retries counted, want %v", got, tc.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetryStopsAtTheTimeout(t *testing.T) {
+	cfg := testRetryConfig
+	cfg.Max = 100
+	cfg.Timeout = 20 * time.Millisecond
+	cfg.InitialBackoff, cfg.MaxBackoff = 5*time.Millisecond, 5*time.Millisecond
+	b := &scriptedBalancer{outcomes: []error{status.Error(codes.Unavailable, "down")}}
+
+	start := time.Now()
+	retry(cfg, retryPolicy{idempotent: true}, b, generic.NewCounter("retries"))(context.Background(), nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retried for %v past a %v timeout", elapsed, cfg.Timeout)
+	}
+	if b.calls >= cfg.Max {
+		t.Errorf("%d calls within the timeout", b.calls)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response interface{}
+		err      error
+		want     bool
+	}{
+		{"success", failer{}, nil, false},
+		{"response without Failed", "served", nil, false},
+		{"unavailable", nil, status.Error(codes.Unavailable, "down"), true},
+		{"unavailable in the response", failer{status.Error(codes.Unavailable, "down")}, nil, true},
+		{"no endpoints", nil, lb.ErrNoEndpoints, true},
+		{"deadline exceeded", nil, status.Error(codes.DeadlineExceeded, "slow"), false},
+		{"service error", failer{errors.New("user not found")}, nil, false},
+	} {
+		if got := retryable(tc.response, tc.err); got != tc.want {
+			t.Errorf("%s: retryable() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := config.Retry{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for _, tc := range []struct {
+		attempt int
+		bound   time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{70, time.Second}, // the shift overflows
+	} {
+		for range 100 {
+			if d := backoff(cfg, tc.attempt); d < 0 || d > tc.bound {
+				t.Fatalf("backoff(%d) = %v, want at most %v", tc.attempt, d, tc.bound)
+			}
+		}
+	}
+}
+
+func TestRetryPolicies(t *testing.T) {
+	for route, policy := range authRetryPolicies {
+		if policy.idempotent {
+			t.Errorf("authsvc route %s is retried", route)
+		}
+	}
+	for _, route := range []string{"VerifyTOTP", "POST /auth/refresh", "POST /user"} {
+		if userRetryPolicies[route].idempotent {
+			t.Errorf("%s is retried without an Idempotency-Key", route)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// securityHeaders sets headers on every response of next. Those are set before
+// next runs, so next can still override them.
+func securityHeaders(headers map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			if value != "" {
+				w.Header().Set(name, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the size of request bodies read by next. Reading past the
+// limit fails with an *http.MaxBytesError, which encodeError reports as 413.
+func limitBody(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			encodeError(r.Context(), &http.MaxBytesError{Limit: max}, w)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// badRequestError is a client error in a request, reported as 400.
+type badRequestError struct {
+	err error
+}
+
+func (e badRequestError) Error() string { return e.err.Error() }
+
+func (e badRequestError) Unwrap() error { return e.err }
+
+var errTrailingData = errors.New("unexpected data after the JSON body")
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	cors := config.Default().CORS
+	cors.AllowedOrigins = []string{"https://app.example.com"}
+	cors.AllowCredentials = true
+	cors.MaxAge = 10 * time.Minute
+	h := corsHandler(cors, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		wantHeader map[string]string
+	}{
+		{
+			name:       "same origin",
+			method:     http.MethodGet,
+			wantStatus: http.StatusTeapot,
+			wantHeader: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:       "allowed origin",
+			method:     http.MethodGet,
+			header:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusTeapot,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:       "other origin",
+			method:     http.MethodGet,
+			header:     map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusTeapot,
+			wantHeader: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			header: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodPut,
+				"Access-Control-Request-Headers": "authorization, content-type, idempotency-key",
+			},
+			wantStatus: http.StatusNoContent,
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Headers": "authorization, content-type, idempotency-key",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "preflight for a disallowed method",
+			method: http.MethodOptions,
+			header: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": http.MethodPatch,
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			// API keys are for servers, not browsers.
+			name:   "preflight for X-API-Key",
+			method: http.MethodOptions,
+			header: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodGet,
+				"Access-Control-Request-Headers": "x-api-key",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "preflight from another origin",
+			method: http.MethodOptions,
+			header: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": http.MethodGet,
+			},
+			wantStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/user/u1", nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tc.wantStatus)
+			}
+			for k, want := range tc.wantHeader {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	h := corsHandler(config.CORS{}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q without allowed origins", got)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := securityHeaders(map[string]string{"X-Frame-Options": "DENY", "Cache-Control": ""},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for k, want := range map[string]string{
+		"X-Frame-Options":         "DENY",
+		"Cache-Control":           "",
+		"Content-Security-Policy": "default-src 'self'",
+	} {
+		if got := rec.Header().Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	h := limitBody(8, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			encodeError(r.Context(), err, w)
+		}
+	}))
+	for _, tc := range []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{"within the limit", "12345678", 8, http.StatusOK},
+		{"declared too large", "123456789", 9, http.StatusRequestEntityTooLarge},
+		{"chunked and too large", "123456789", -1, http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(tc.body))
+		r.ContentLength = tc.contentLength
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
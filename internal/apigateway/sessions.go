@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/yuisofull/gommunigate/internal/apigateway/tokenprovider"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+	"time"
+)
+
+// accessTokenIssuer is the TokenProvider issuing the access tokens of usersvc
+// sessions.
+type accessTokenIssuer interface {
+	tokenprovider.TokenProvider
+	TokenTTL() time.Duration
+}
+
+// routeAuth serves the /auth routes on r: /auth/token starts a session for a
+// caller that signIn authenticates, and /auth/refresh exchanges a refresh
+// token for a new one. Both return an access token issued by tokens.
+func routeAuth(r *mux.Router, set userendpoint.Set, tokens accessTokenIssuer, signIn mux.MiddlewareFunc, options []httptransport.ServerOption) {
+	r.Path("/token").
+		Handler(signIn(requireSignIn(httptransport.NewServer(issueAccessToken(set.CreateSessionEndpoint, tokens), decodeCreateSessionRequest, encodeResponse, options...)))).
+		Methods(http.MethodPost)
+	r.Path("/refresh").
+		Handler(httptransport.NewServer(issueAccessToken(set.RefreshSessionEndpoint, tokens), decodeRefreshSessionRequest, encodeResponse, options...)).
+		Methods(http.MethodPost)
+}
+
+// routeSessions serves the /user/me/sessions routes on r, which list and
+// revoke the caller's sessions. They can't be used with an API key.
+func routeSessions(r *mux.Router, set userendpoint.Set, options []httptransport.ServerOption) {
+	r.Path("/me/sessions").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.ListSessionsEndpoint, decodeListSessionsRequest, encodeResponse, options...))).
+		Methods(http.MethodGet)
+	r.Path("/me/sessions").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.RevokeSessionsEndpoint, decodeRevokeSessionsRequest, encodeResponse, options...))).
+		Methods(http.MethodDelete)
+	r.Path("/me/sessions/{id}").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.RevokeSessionEndpoint, decodeRevokeSessionRequest, encodeResponse, options...))).
+		Methods(http.MethodDelete)
+}
+
+// requireSignIn rejects requests authenticated with an API key or the access
+// token of a session, so that a session is only started by signing in.
+func requireSignIn(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := ClaimsFromContext(r.Context())
+		if err != nil {
+			encodeError(r.Context(), userservice.ErrUnauthenticated, w)
+			return
+		}
+		if _, ok := claims["session-id"]; ok || isAPIKey(claims) {
+			encodeError(r.Context(), userservice.ErrPermissionDenied, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenResponse carries the tokens of a session, named as in OAuth 2.0.
+type tokenResponse struct {
+	AccessToken  string        `json:"access_token"`
+	TokenType    string        `json:"token_type"`
+	ExpiresIn    int           `json:"expires_in"`
+	RefreshToken string        `json:"refresh_token"`
+	Session      model.Session `json:"session"`
+}
+
+// issueAccessToken wraps an endpoint returning a session and its refresh
+// token, the CreateSession or RefreshSession endpoint, to add an access token
+// for the session issued by tokens.
+func issueAccessToken(next endpoint.Endpoint, tokens accessTokenIssuer) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			session      model.Session
+			refreshToken string
+		)
+		switch resp := response.(type) {
+		case userendpoint.CreateSessionResponse:
+			if resp.Err != nil {
+				return resp, nil
+			}
+			session, refreshToken = resp.Session, resp.RefreshToken
+		case userendpoint.RefreshSessionResponse:
+			if resp.Err != nil {
+				return resp, nil
+			}
+			session, refreshToken = resp.Session, resp.RefreshToken
+		}
+		accessToken, err := tokens.GenerateToken(map[string]interface{}{
+			"user-id":    session.UserID,
+			"session-id": session.ID,
+			"roles":      session.Roles,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return tokenResponse{
+			AccessToken:  accessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(tokens.TokenTTL().Seconds()),
+			RefreshToken: refreshToken,
+			Session:      session,
+		}, nil
+	}
+}
+
+// decodeCreateSessionRequest reads the optional name of the device signing
+// in, which defaults to its User-Agent.
+func decodeCreateSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Device string `json:"device"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.CreateSessionRequest{Device: device(r, body.Device)}, nil
+}
+
+func decodeRefreshSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+		Device       string `json:"device"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.RefreshSessionRequest{RefreshToken: body.RefreshToken, Device: device(r, body.Device)}, nil
+}
+
+func decodeListSessionsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return userendpoint.ListSessionsRequest{}, nil
+}
+
+func decodeRevokeSessionsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return userendpoint.RevokeSessionsRequest{}, nil
+}
+
+func decodeRevokeSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return userendpoint.RevokeSessionRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+// device returns the device named by the client, or else its User-Agent.
+func device(r *http.Request, named string) string {
+	if named != "" {
+		return named
+	}
+	return r.UserAgent()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// newHTTPServer returns the server of listener l, whose requests learn through
+// draining when it shuts down.
+func newHTTPServer(l config.Listener, handler http.Handler) *http.Server {
+	var (
+		notice = make(chan struct{})
+		once   sync.Once
+	)
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		ReadTimeout:       l.ReadTimeout,
+		WriteTimeout:      l.WriteTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), drainNoticeContextKey{}, (<-chan struct{})(notice))
+		},
+	}
+	server.RegisterOnShutdown(func() { once.Do(func() { close(notice) }) })
+	return server
+}
+
+type drainNoticeContextKey struct{}
+
+// draining returns a channel that is closed when the server of the request
+// with ctx shuts down. Shutdown neither waits for nor closes hijacked
+// connections, and only waits for streams up to the shutdown timeout, so
+// handlers keeping a connection open must end it when notified, with their
+// protocol's close message, e.g. a websocket close frame with status 1001
+// (going away).
+func draining(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(drainNoticeContextKey{}).(<-chan struct{})
+	return ch
+}
+
+// shutdown drains server: it fails /readyz, stops keeping connections alive,
+// keeps serving for l.DrainDelay so that load balancers stop sending
+// requests, and then gives the requests in progress up to l.ShutdownTimeout
+// to finish before closing their connections. Open streams and hijacked
+// connections are notified through draining once the delay is over.
+func shutdown(server *http.Server, h *reloadingHandler, l config.Listener, logger log.Logger) {
+	h.startDraining()
+	server.SetKeepAlivesEnabled(false)
+	logger.Log("shutdown", "draining", "delay", l.DrainDelay)
+	time.Sleep(l.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Log("shutdown", "forced", "err", err)
+		_ = server.Close()
+		return
+	}
+	logger.Log("shutdown", "drained")
+}
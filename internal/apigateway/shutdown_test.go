@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/apigateway/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	g, closed := testGateway(http.StatusOK, release)
+	h := newReloadingHandler(g)
+	l := config.Listener{DrainDelay: 20 * time.Millisecond, ShutdownTimeout: 5 * time.Second}
+	server := newHTTPServer(l, withProbes(h))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- server.Serve(lis) }()
+	url := "http://" + lis.Addr().String()
+
+	// A request in progress when the shutdown starts.
+	inFlight := make(chan int)
+	go func() {
+		resp, err := http.Get(url + "/user/u1")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	for {
+		h.mtx.RLock()
+		gen := h.current
+		h.mtx.RUnlock()
+		gen.mtx.Lock()
+		active := gen.active
+		gen.mtx.Unlock()
+		if active == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(server, h, l, log.NewNopLogger())
+		close(done)
+	}()
+	// Still serving during the drain delay, but no longer ready.
+	for !h.draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz answered %d while draining", resp.StatusCode)
+	}
+
+	close(release)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Errorf("request in progress answered %d", code)
+	}
+	<-done
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve() = %v", err)
+	}
+	h.Close()
+	if !isClosed(closed, time.Second) {
+		t.Error("gateway not closed")
+	}
+}
+
+func TestDrainNotice(t *testing.T) {
+	// A handler holding on to a hijacked connection until the server shuts
+	// down, as a websocket would.
+	hijacked := make(chan struct{})
+	handler := accessLog(log.NewNopLogger(), testGatewayMetrics(), nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() = %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		buf.Flush()
+		close(hijacked)
+		<-draining(r.Context())
+		buf.WriteString("going away\n")
+		buf.Flush()
+	}))
+	server := newHTTPServer(config.Listener{}, handler)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: gateway\r\n\r\n")
+	<-hijacked
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(got), "going away\n") {
+		t.Errorf("hijacked connection got %q, want the close notice", got)
+	}
+}
+
+func TestStatusWriterUnwrap(t *testing.T) {
+	h := accessLog(log.NewNopLogger(), testGatewayMetrics(), nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() = %v", err)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !rec.Flushed {
+		t.Error("response not flushed")
+	}
+}
@@ -0,0 +1,139 @@
+// Package apikey implements a TokenProvider for the API keys of bots and
+// server-side integrations, sent as "Authorization: ApiKey <key>".
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheme prefixes API keys in the Authorization header.
+const scheme = "ApiKey "
+
+// maxCached bounds the number of keys remembered at once.
+const maxCached = 10000
+
+var (
+	ErrNotAPIKey  = errors.New("not an api key")
+	ErrInvalidKey = errors.New("invalid api key")
+)
+
+// Verifier looks up an active API key, returning
+// userservice.ErrInvalidAPIKey if there is none. usersvc is one.
+type Verifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (model.APIKey, error)
+}
+
+type tokenProvider struct {
+	verifier Verifier
+	ttl      time.Duration
+
+	mtx   sync.Mutex
+	cache map[[sha256.Size]byte]entry
+}
+
+// entry remembers a verified key, or a zero key for an invalid one.
+type entry struct {
+	key     model.APIKey
+	expires time.Time
+}
+
+// NewTokenProvider returns a TokenProvider verifying API keys with v. Verified
+// and invalid keys are remembered for cacheTTL, so revoking a key takes up to
+// that long to take effect; 0 verifies every request.
+func NewTokenProvider(v Verifier, cacheTTL time.Duration) *tokenProvider {
+	return &tokenProvider{
+		verifier: v,
+		ttl:      cacheTTL,
+		cache:    map[[sha256.Size]byte]entry{},
+	}
+}
+
+// GenerateToken is not supported: keys are created through usersvc.
+func (t *tokenProvider) GenerateToken(data map[string]interface{}) (string, error) {
+	return "", errors.New("api keys are created through usersvc")
+}
+
+// VerifyToken returns the claims of the key in an "ApiKey" Authorization
+// header: the "user-id" it acts as, its "api-key-id" and its "scopes". API
+// keys carry no roles.
+func (t *tokenProvider) VerifyToken(header string) (map[string]interface{}, error) {
+	key, ok := strings.CutPrefix(header, scheme)
+	if !ok {
+		return nil, ErrNotAPIKey
+	}
+	k, err := t.verify(strings.TrimSpace(key))
+	if err != nil {
+		return nil, err
+	}
+	scopes := make([]interface{}, 0, len(k.Scopes))
+	for _, s := range k.Scopes {
+		scopes = append(scopes, s)
+	}
+	return map[string]interface{}{
+		"user-id":    k.Subject,
+		"api-key-id": k.ID,
+		"scopes":     scopes,
+	}, nil
+}
+
+func (t *tokenProvider) Name() string {
+	return "apikey"
+}
+
+func (t *tokenProvider) verify(key string) (model.APIKey, error) {
+	now := time.Now()
+	id := sha256.Sum256([]byte(key))
+	t.mtx.Lock()
+	e, ok := t.cache[id]
+	t.mtx.Unlock()
+	if ok && now.Before(e.expires) {
+		if e.key.ID == "" {
+			return model.APIKey{}, ErrInvalidKey
+		}
+		return e.key, nil
+	}
+
+	k, err := t.verifier.VerifyAPIKey(context.Background(), key)
+	switch {
+	case errors.Is(err, userservice.ErrInvalidAPIKey):
+		t.remember(id, entry{expires: now.Add(t.ttl)}, now)
+		return model.APIKey{}, ErrInvalidKey
+	case err != nil:
+		return model.APIKey{}, err
+	}
+	e = entry{key: k, expires: now.Add(t.ttl)}
+	if !k.ExpiresAt.IsZero() && k.ExpiresAt.Before(e.expires) {
+		e.expires = k.ExpiresAt
+	}
+	t.remember(id, e, now)
+	return k, nil
+}
+
+func (t *tokenProvider) remember(id [sha256.Size]byte, e entry, now time.Time) {
+	if t.ttl <= 0 {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if _, ok := t.cache[id]; !ok && len(t.cache) >= maxCached {
+		for id, e := range t.cache {
+			if !now.Before(e.expires) {
+				delete(t.cache, id)
+			}
+		}
+		if len(t.cache) >= maxCached {
+			for id := range t.cache {
+				delete(t.cache, id)
+				break
+			}
+		}
+	}
+	t.cache[id] = e
+}
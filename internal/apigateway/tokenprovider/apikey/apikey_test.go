@@ -0,0 +1,120 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubVerifier verifies the keys it was given, counting the verifications.
+type stubVerifier struct {
+	keys  map[string]model.APIKey
+	err   error
+	calls int
+}
+
+func (v *stubVerifier) VerifyAPIKey(_ context.Context, key string) (model.APIKey, error) {
+	v.calls++
+	if v.err != nil {
+		return model.APIKey{}, v.err
+	}
+	k, ok := v.keys[key]
+	if !ok {
+		return model.APIKey{}, userservice.ErrInvalidAPIKey
+	}
+	return k, nil
+}
+
+func TestVerifyToken(t *testing.T) {
+	v := &stubVerifier{keys: map[string]model.APIKey{
+		"gmk_bot": {ID: "k1", Subject: "u1", Scopes: []string{model.ScopeRead, model.ScopeWrite}},
+	}}
+	tp := NewTokenProvider(v, 0)
+	for _, tc := range []struct {
+		name       string
+		header     string
+		wantClaims map[string]interface{}
+		wantErr    error
+	}{
+		{
+			name:   "valid key",
+			header: "ApiKey gmk_bot",
+			wantClaims: map[string]interface{}{
+				"user-id":    "u1",
+				"api-key-id": "k1",
+				"scopes":     []interface{}{model.ScopeRead, model.ScopeWrite},
+			},
+		},
+		{name: "surrounding spaces", header: "ApiKey  gmk_bot ", wantClaims: map[string]interface{}{
+			"user-id":    "u1",
+			"api-key-id": "k1",
+			"scopes":     []interface{}{model.ScopeRead, model.ScopeWrite},
+		}},
+		{name: "unknown key", header: "ApiKey gmk_other", wantErr: ErrInvalidKey},
+		{name: "bearer token", header: "Bearer gmk_bot", wantErr: ErrNotAPIKey},
+		{name: "no scheme", header: "gmk_bot", wantErr: ErrNotAPIKey},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := tp.VerifyToken(tc.header)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("VerifyToken() = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(claims, tc.wantClaims) {
+				t.Errorf("claims = %v, want %v", claims, tc.wantClaims)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenCache(t *testing.T) {
+	v := &stubVerifier{keys: map[string]model.APIKey{
+		"gmk_bot":      {ID: "k1", Subject: "u1", Scopes: []string{model.ScopeRead}},
+		"gmk_expiring": {ID: "k2", Subject: "u1", Scopes: []string{model.ScopeRead}, ExpiresAt: time.Now().Add(20 * time.Millisecond)},
+	}}
+	tp := NewTokenProvider(v, time.Hour)
+	verify := func(key string) error {
+		_, err := tp.VerifyToken("ApiKey " + key)
+		return err
+	}
+
+	verify("gmk_bot")
+	verify("gmk_bot")
+	verify("gmk_unknown")
+	verify("gmk_unknown")
+	if v.calls != 2 {
+		t.Errorf("%d verifications, want valid and invalid keys verified once", v.calls)
+	}
+
+	// A key is only cached until it expires.
+	verify("gmk_expiring")
+	time.Sleep(20 * time.Millisecond)
+	delete(v.keys, "gmk_expiring")
+	if err := verify("gmk_expiring"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expired key verified from the cache: %v", err)
+	}
+
+	// Failing to verify a key isn't remembered.
+	v.calls, v.err = 0, errors.New("usersvc unreachable")
+	if err := verify("gmk_new"); !errors.Is(err, v.err) {
+		t.Errorf("VerifyToken() = %v, want %v", err, v.err)
+	}
+	v.err = nil
+	verify("gmk_new")
+	if v.calls != 2 {
+		t.Errorf("%d verifications, want the failed one retried", v.calls)
+	}
+}
+
+func TestVerifyTokenWithoutCache(t *testing.T) {
+	v := &stubVerifier{keys: map[string]model.APIKey{"gmk_bot": {ID: "k1", Subject: "u1"}}}
+	tp := NewTokenProvider(v, 0)
+	tp.VerifyToken("ApiKey gmk_bot")
+	delete(v.keys, "gmk_bot")
+	if _, err := tp.VerifyToken("ApiKey gmk_bot"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("revoked key verified: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Package authsvc implements a TokenProvider for the ID tokens authsvc issues
+// when users log in with their email and password: JWTs signed with Ed25519,
+// sent as "Authorization: Bearer <token>", that the gateway verifies with the
+// public key alone. Without asking authsvc, it can't tell a token issued
+// before a password change or reset, which stays valid until it expires.
+package authsvc
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"os"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid ID token")
+
+// LoadPublicKey reads a PEM-encoded Ed25519 public key from path, such as one
+// made by "openssl pkey -pubout" from authsvc's signing key.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseEdPublicKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key.(ed25519.PublicKey), nil
+}
+
+type tokenProvider struct {
+	key ed25519.PublicKey
+}
+
+// NewTokenProvider returns a TokenProvider verifying ID tokens signed with
+// the private key of key.
+func NewTokenProvider(key ed25519.PublicKey) *tokenProvider {
+	return &tokenProvider{key: key}
+}
+
+// GenerateToken is not supported: only authsvc holds the signing key.
+func (t *tokenProvider) GenerateToken(map[string]interface{}) (string, error) {
+	return "", errors.New("authsvc tokens are issued by authsvc")
+}
+
+// VerifyToken returns the claims of the ID token in a "Bearer" Authorization
+// header: the "user-id" and "email" of its account.
+func (t *tokenProvider) VerifyToken(header string) (map[string]interface{}, error) {
+	var c authservice.Claims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), &c, func(*jwt.Token) (interface{}, error) {
+		return t.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil || c.Issuer != authservice.Issuer || c.Subject == "" || c.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+	return map[string]interface{}{
+		"user-id": c.Subject,
+		"email":   c.Email,
+	}, nil
+}
+
+func (t *tokenProvider) Name() string {
+	return "authsvc"
+}
@@ -0,0 +1,114 @@
+package authsvc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, c authservice.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyToken(t *testing.T) {
+	public, private := newKey(t)
+	_, other := newKey(t)
+	tp := NewTokenProvider(public)
+	valid, _, err := authservice.NewTokenSigner(private, time.Minute).Sign(model.Account{ID: "a1", Email: "ada@example.com"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(issuer, subject string, expiresIn time.Duration) authservice.Claims {
+		return authservice.Claims{Email: "ada@example.com", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		}}
+	}
+	noExpiry := claims(authservice.Issuer, "a1", 0)
+	noExpiry.ExpiresAt = nil
+
+	for _, tc := range []struct {
+		name       string
+		header     string
+		wantClaims map[string]interface{}
+	}{
+		{name: "valid", header: "Bearer " + valid, wantClaims: map[string]interface{}{"user-id": "a1", "email": "ada@example.com"}},
+		{name: "expired", header: "Bearer " + sign(t, jwt.SigningMethodEdDSA, private, claims(authservice.Issuer, "a1", -time.Second))},
+		{name: "without expiry", header: "Bearer " + sign(t, jwt.SigningMethodEdDSA, private, noExpiry)},
+		{name: "another issuer", header: "Bearer " + sign(t, jwt.SigningMethodEdDSA, private, claims("gommunigate-gateway", "a1", time.Minute))},
+		{name: "without subject", header: "Bearer " + sign(t, jwt.SigningMethodEdDSA, private, claims(authservice.Issuer, "", time.Minute))},
+		{name: "signed with another key", header: "Bearer " + sign(t, jwt.SigningMethodEdDSA, other, claims(authservice.Issuer, "a1", time.Minute))},
+		// Anyone holding the public key could sign a token with it as an
+		// HMAC key, if HMAC were accepted.
+		{name: "HMAC with the public key", header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte(public), claims(authservice.Issuer, "a1", time.Minute))},
+		{name: "unsigned", header: "Bearer " + sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(authservice.Issuer, "a1", time.Minute))},
+		{name: "garbage", header: "Bearer garbage"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tp.VerifyToken(tc.header)
+			if tc.wantClaims == nil {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("VerifyToken() = %v, %v, want %v", got, err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyToken() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.wantClaims) {
+				t.Errorf("claims = %v, want %v", got, tc.wantClaims)
+			}
+		})
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	public, _ := newKey(t)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "authsvc.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadPublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(public) {
+		t.Error("loaded another key")
+	}
+
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPublicKey(path); err == nil {
+		t.Error("LoadPublicKey() of garbage = nil")
+	}
+}
@@ -4,11 +4,19 @@ import (
 	"context"
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
+	"fmt"
 	"google.golang.org/api/option"
+	"net/http"
+	"sync/atomic"
 )
 
+// idTokenCertsURL serves the public keys Firebase ID tokens are signed with.
+const idTokenCertsURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
 type tokenProvider struct {
 	auth *auth.Client
+	// keysLoaded is set once the signing keys could be fetched.
+	keysLoaded *atomic.Bool
 }
 
 // NewTokenProvider returns a new Firebase TokenProvider,
@@ -24,7 +32,7 @@ func NewTokenProvider(configFilePath string) (*tokenProvider, error) {
 		return nil, err
 	}
 
-	return &tokenProvider{auth: a}, nil
+	return &tokenProvider{auth: a, keysLoaded: &atomic.Bool{}}, nil
 }
 
 func MustNewTokenProvider(configFilePath string) *tokenProvider {
@@ -50,3 +58,26 @@ func (t tokenProvider) VerifyToken(idToken string) (map[string]interface{}, erro
 func (t tokenProvider) Name() string {
 	return "firebase"
 }
+
+// Ready implements tokenprovider.ReadinessChecker by fetching the keys ID tokens
+// are signed with. The auth client fetches them itself on first use and keeps
+// them fresh, so once they could be fetched the provider stays ready.
+func (t tokenProvider) Ready(ctx context.Context) error {
+	if t.keysLoaded.Load() {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, idTokenCertsURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch signing keys: %s", resp.Status)
+	}
+	t.keysLoaded.Store(true)
+	return nil
+}
@@ -0,0 +1,192 @@
+// Package session implements a TokenProvider for the access tokens the gateway
+// issues for usersvc sessions: short-lived JWTs signed with HMAC-SHA256, sent
+// as "Authorization: Bearer <token>", that are no longer accepted once their
+// session is revoked.
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// issuer tells the gateway's tokens from others signed with HS256.
+	issuer = "gommunigate-gateway"
+	// minKeyLen is the shortest signing key accepted, in bytes.
+	minKeyLen = 32
+	// maxCached bounds the number of sessions remembered at once.
+	maxCached = 10000
+)
+
+var (
+	ErrInvalidToken   = errors.New("invalid session token")
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+// Checker returns userservice.ErrSessionRevoked for a session that is no
+// longer active. usersvc is one.
+type Checker interface {
+	CheckSession(ctx context.Context, id string) error
+}
+
+// claims is the payload of an access token. The subject is the user ID.
+type claims struct {
+	SessionID string   `json:"sid"`
+	Roles     []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// LoadKey reads the key tokens are signed with from path. Surrounding
+// whitespace is ignored.
+func LoadKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) < minKeyLen {
+		return nil, fmt.Errorf("%s: key must be at least %d bytes, got %d", path, minKeyLen, len(key))
+	}
+	return key, nil
+}
+
+type tokenProvider struct {
+	key      []byte
+	tokenTTL time.Duration
+	checker  Checker
+	cacheTTL time.Duration
+
+	mtx   sync.Mutex
+	cache map[string]entry
+}
+
+// entry remembers whether a session was active.
+type entry struct {
+	revoked bool
+	expires time.Time
+}
+
+// NewTokenProvider returns a TokenProvider issuing access tokens signed with
+// key that are valid for tokenTTL, and checking with c that their session is
+// still active. The outcome is remembered for cacheTTL, so revoking a session
+// takes up to that long to take effect; 0 checks every request.
+func NewTokenProvider(key []byte, tokenTTL time.Duration, c Checker, cacheTTL time.Duration) *tokenProvider {
+	return &tokenProvider{
+		key:      key,
+		tokenTTL: tokenTTL,
+		checker:  c,
+		cacheTTL: cacheTTL,
+		cache:    map[string]entry{},
+	}
+}
+
+// TokenTTL returns how long the tokens it issues are valid for.
+func (t *tokenProvider) TokenTTL() time.Duration {
+	return t.tokenTTL
+}
+
+// GenerateToken issues an access token for the session with the "session-id"
+// in data, acting as "user-id" with "roles", a []string.
+func (t *tokenProvider) GenerateToken(data map[string]interface{}) (string, error) {
+	uid, _ := data["user-id"].(string)
+	sid, _ := data["session-id"].(string)
+	if uid == "" || sid == "" {
+		return "", errors.New("user-id and session-id are required")
+	}
+	roles, _ := data["roles"].([]string)
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		SessionID: sid,
+		Roles:     roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   uid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.tokenTTL)),
+		},
+	})
+	return token.SignedString(t.key)
+}
+
+// VerifyToken returns the claims of the access token in a "Bearer"
+// Authorization header: the "user-id" and "roles" it acts with and its
+// "session-id".
+func (t *tokenProvider) VerifyToken(header string) (map[string]interface{}, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), &c, func(*jwt.Token) (interface{}, error) {
+		return t.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || c.Issuer != issuer || c.Subject == "" || c.SessionID == "" || c.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+	if err := t.check(c.SessionID); err != nil {
+		return nil, err
+	}
+	roles := make([]interface{}, 0, len(c.Roles))
+	for _, r := range c.Roles {
+		roles = append(roles, r)
+	}
+	return map[string]interface{}{
+		"user-id":    c.Subject,
+		"session-id": c.SessionID,
+		"roles":      roles,
+	}, nil
+}
+
+func (t *tokenProvider) Name() string {
+	return "session"
+}
+
+func (t *tokenProvider) check(sid string) error {
+	now := time.Now()
+	t.mtx.Lock()
+	e, ok := t.cache[sid]
+	t.mtx.Unlock()
+	if ok && now.Before(e.expires) {
+		if e.revoked {
+			return ErrSessionRevoked
+		}
+		return nil
+	}
+
+	err := t.checker.CheckSession(context.Background(), sid)
+	switch {
+	case errors.Is(err, userservice.ErrSessionRevoked):
+		t.remember(sid, entry{revoked: true, expires: now.Add(t.cacheTTL)}, now)
+		return ErrSessionRevoked
+	case err != nil:
+		return err
+	}
+	t.remember(sid, entry{expires: now.Add(t.cacheTTL)}, now)
+	return nil
+}
+
+func (t *tokenProvider) remember(sid string, e entry, now time.Time) {
+	if t.cacheTTL <= 0 {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if _, ok := t.cache[sid]; !ok && len(t.cache) >= maxCached {
+		for sid, e := range t.cache {
+			if !now.Before(e.expires) {
+				delete(t.cache, sid)
+			}
+		}
+		if len(t.cache) >= maxCached {
+			for sid := range t.cache {
+				delete(t.cache, sid)
+				break
+			}
+		}
+	}
+	t.cache[sid] = e
+}
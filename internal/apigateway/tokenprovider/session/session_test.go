@@ -0,0 +1,114 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// stubChecker reports the sessions in revoked as revoked, counting the checks.
+type stubChecker struct {
+	revoked map[string]bool
+	calls   int
+}
+
+func (c *stubChecker) CheckSession(_ context.Context, id string) error {
+	c.calls++
+	if c.revoked[id] {
+		return userservice.ErrSessionRevoked
+	}
+	return nil
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, c claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyToken(t *testing.T) {
+	tp := NewTokenProvider(testKey, time.Minute, &stubChecker{revoked: map[string]bool{"s2": true}}, 0)
+	valid, err := tp.GenerateToken(map[string]interface{}{"user-id": "u1", "session-id": "s1", "roles": []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := tp.GenerateToken(map[string]interface{}{"user-id": "u1", "session-id": "s2"})
+	registered := func(issuer string, expiresIn time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{Issuer: issuer, Subject: "u1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn))}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		header     string
+		wantClaims map[string]interface{}
+		wantErr    error
+	}{
+		{
+			name:       "valid",
+			header:     "Bearer " + valid,
+			wantClaims: map[string]interface{}{"user-id": "u1", "session-id": "s1", "roles": []interface{}{"admin"}},
+		},
+		{name: "revoked session", header: "Bearer " + revoked, wantErr: ErrSessionRevoked},
+		{name: "expired", header: "Bearer " + sign(t, jwt.SigningMethodHS256, testKey, claims{SessionID: "s1", RegisteredClaims: registered(issuer, -time.Second)}), wantErr: ErrInvalidToken},
+		{name: "without expiry", header: "Bearer " + sign(t, jwt.SigningMethodHS256, testKey, claims{SessionID: "s1", RegisteredClaims: jwt.RegisteredClaims{Issuer: issuer, Subject: "u1"}}), wantErr: ErrInvalidToken},
+		{name: "another issuer", header: "Bearer " + sign(t, jwt.SigningMethodHS256, testKey, claims{SessionID: "s1", RegisteredClaims: registered("authsvc", time.Minute)}), wantErr: ErrInvalidToken},
+		{name: "without session", header: "Bearer " + sign(t, jwt.SigningMethodHS256, testKey, claims{RegisteredClaims: registered(issuer, time.Minute)}), wantErr: ErrInvalidToken},
+		{name: "signed with another key", header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("another key of at least 32 bytes!"), claims{SessionID: "s1", RegisteredClaims: registered(issuer, time.Minute)}), wantErr: ErrInvalidToken},
+		{name: "another algorithm", header: "Bearer " + sign(t, jwt.SigningMethodHS512, testKey, claims{SessionID: "s1", RegisteredClaims: registered(issuer, time.Minute)}), wantErr: ErrInvalidToken},
+		{name: "unsigned", header: "Bearer " + sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims{SessionID: "s1", RegisteredClaims: registered(issuer, time.Minute)}), wantErr: ErrInvalidToken},
+		{name: "garbage", header: "Bearer garbage", wantErr: ErrInvalidToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tp.VerifyToken(tc.header)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("VerifyToken() = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.wantClaims) {
+				t.Errorf("claims = %v, want %v", got, tc.wantClaims)
+			}
+		})
+	}
+}
+
+func TestGenerateTokenRequiresSession(t *testing.T) {
+	tp := NewTokenProvider(testKey, time.Minute, &stubChecker{}, 0)
+	for _, data := range []map[string]interface{}{
+		{"user-id": "u1"},
+		{"session-id": "s1"},
+	} {
+		if _, err := tp.GenerateToken(data); err == nil {
+			t.Errorf("GenerateToken(%v) issued a token", data)
+		}
+	}
+}
+
+func TestVerifyTokenCache(t *testing.T) {
+	checker := &stubChecker{revoked: map[string]bool{}}
+	tp := NewTokenProvider(testKey, time.Minute, checker, 20*time.Millisecond)
+	token, _ := tp.GenerateToken(map[string]interface{}{"user-id": "u1", "session-id": "s1"})
+	header := "Bearer " + token
+
+	tp.VerifyToken(header)
+	tp.VerifyToken(header)
+	if checker.calls != 1 {
+		t.Errorf("session checked %d times within the cache TTL", checker.calls)
+	}
+	// Revoking the session takes effect once the cached check expires.
+	checker.revoked["s1"] = true
+	if _, err := tp.VerifyToken(header); err != nil {
+		t.Errorf("VerifyToken() = %v before the cached check expired", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := tp.VerifyToken(header); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("VerifyToken() = %v after revocation, want %v", err, ErrSessionRevoked)
+	}
+}
@@ -1,7 +1,16 @@
 package tokenprovider
 
+import "context"
+
 type TokenProvider interface {
 	GenerateToken(data map[string]interface{}) (string, error)
 	VerifyToken(token string) (map[string]interface{}, error)
 	Name() string
 }
+
+// ReadinessChecker is implemented by TokenProviders that depend on something
+// being loaded, such as the keys tokens are signed with, before they can verify
+// tokens. Ready reports whether it is.
+type ReadinessChecker interface {
+	Ready(ctx context.Context) error
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestRetryTracesAttempts(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	defer provider.Shutdown(context.Background())
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	b := &scriptedBalancer{outcomes: []error{status.Error(codes.Unavailable, "down"), nil}}
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	retry(testRetryConfig, retryPolicy{idempotent: true}, b, testGatewayMetrics().retries)(ctx, nil)
+	parent.End()
+
+	var attempts []sdktrace.ReadOnlySpan
+	for _, s := range spans.Ended() {
+		if s.Name() == "attempt" {
+			attempts = append(attempts, s)
+		}
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("%d attempt spans, want 2", len(attempts))
+	}
+	for i, s := range attempts {
+		if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("attempt %d isn't a child of the request span", i+1)
+		}
+		var number int64
+		for _, a := range s.Attributes() {
+			if a.Key == "retry.attempt" {
+				number = a.Value.AsInt64()
+			}
+		}
+		if number != int64(i+1) {
+			t.Errorf("attempt %d recorded as attempt %d", i+1, number)
+		}
+	}
+	if got := attempts[0].Status().Code; got != otelcodes.Error {
+		t.Errorf("failed attempt has status %v", got)
+	}
+	if got := attempts[1].Status().Code; got != otelcodes.Unset {
+		t.Errorf("successful attempt has status %v", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"net/http"
+)
+
+// totpHeader carries the second factor of a request: a TOTP code or a
+// recovery code.
+const totpHeader = "X-TOTP-Code"
+
+// routeTwoFactor serves the /user/me/2fa routes on r, which enroll the caller
+// in TOTP and disable it given one of their codes in the X-TOTP-Code header.
+// They can't be used with an API key.
+func routeTwoFactor(r *mux.Router, set userendpoint.Set, options []httptransport.ServerOption) {
+	r.Path("/me/2fa").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.GetTwoFactorEndpoint, decodeGetTwoFactorRequest, encodeResponse, options...))).
+		Methods(http.MethodGet)
+	r.Path("/me/2fa/totp").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.EnrollTOTPEndpoint, decodeEnrollTOTPRequest, encodeResponse, options...))).
+		Methods(http.MethodPost)
+	r.Path("/me/2fa/totp/confirm").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.ConfirmTOTPEndpoint, decodeConfirmTOTPRequest, encodeResponse, options...))).
+		Methods(http.MethodPost)
+	r.Path("/me/2fa/totp").
+		Handler(rejectAPIKeys(httptransport.NewServer(set.DisableTOTPEndpoint, decodeDisableTOTPRequest, encodeResponse, options...))).
+		Methods(http.MethodDelete)
+}
+
+// stepUpMiddleware makes Routes, keyed by "METHOD /path/template", require
+// the second factor of callers who enabled one on top of their token, in the
+// X-TOTP-Code header. Verify checks and uses it up, so a code is only good
+// for one request. Callers without a second factor are let through.
+type stepUpMiddleware struct {
+	Routes map[string]bool
+	Verify func(ctx context.Context, code string) error
+}
+
+func (s *stepUpMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil || !s.Routes[r.Method+" "+tmpl] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := userCallContext(r.Context(), r)
+		err = s.Verify(ctx, r.Header.Get(totpHeader))
+		if err != nil && !errors.Is(err, userservice.ErrTwoFactorNotEnabled) {
+			encodeError(ctx, err, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeGetTwoFactorRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return userendpoint.GetTwoFactorRequest{}, nil
+}
+
+func decodeEnrollTOTPRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return userendpoint.EnrollTOTPRequest{}, nil
+}
+
+func decodeConfirmTOTPRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, err
+	}
+	return userendpoint.ConfirmTOTPRequest{Code: body.Code}, nil
+}
+
+func decodeDisableTOTPRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return userendpoint.DisableTOTPRequest{Code: r.Header.Get(totpHeader)}, nil
+}
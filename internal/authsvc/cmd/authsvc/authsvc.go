@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	"github.com/hashicorp/consul/api"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	authpb "github.com/yuisofull/gommunigate/internal/authsvc/pb"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/infrastructure"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	authtransport "github.com/yuisofull/gommunigate/internal/authsvc/pkg/transport"
+	"github.com/yuisofull/gommunigate/internal/pkg/certs"
+	"github.com/yuisofull/gommunigate/internal/pkg/instance"
+	"github.com/yuisofull/gommunigate/internal/pkg/tracing"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	fs := flag.NewFlagSet("authsvc", flag.ExitOnError)
+	var (
+		grpcAddr   = fs.String("grpc-addr", ":8083", "gRPC listen address")
+		debugAddr  = fs.String("debug-addr", ":8084", "Debug and metrics listen address")
+		mongodbURI = fs.String("mongodb-uri", "mongodb://localhost:27017", "MongoDB URI")
+		mongodbDB  = fs.String("mongodb-db", "authsvc", "MongoDB database")
+		mongodbCol = fs.String("mongodb-col", "accounts", "MongoDB collection")
+
+		signingKeyFile  = fs.String("signing-key-file", "", "PEM Ed25519 private key ID tokens are signed with; the gateway verifies them with its public key")
+		tokenTTL        = fs.Duration("token-ttl", 15*time.Minute, "How long an ID token is valid for, including after its account's password changes")
+		lockoutAttempts = fs.Int("lockout-attempts", 5, "Failed logins in a row that lock an account, 0 to never lock accounts")
+		lockoutDuration = fs.Duration("lockout-duration", 15*time.Minute, "How long an account stays locked")
+		resetTTL        = fs.Duration("reset-ttl", time.Hour, "How long a password reset token is valid for")
+
+		mailer           = fs.String("mailer", "log", "How to send emails: log, for development only, or smtp")
+		smtpAddr         = fs.String("smtp-addr", "localhost:587", "SMTP server address")
+		smtpUsername     = fs.String("smtp-username", "", "SMTP username; no authentication when empty")
+		smtpPasswordFile = fs.String("smtp-password-file", "", "File holding the SMTP password")
+		smtpFrom         = fs.String("smtp-from", "", "Sender address of emails")
+		resetURL         = fs.String("reset-url", "", "Page linked from password reset emails, which is given the token in its \"token\" query parameter")
+
+		consulAddr    = fs.String("consul-addr", "", "Optional Consul agent to register this instance with")
+		consulService = fs.String("consul-service", "authsvc", "Service name to register in Consul")
+		consulTTL     = fs.Duration("consul-ttl", 10*time.Second, "TTL of the Consul health check, reported a few times per TTL")
+		advertiseAddr = fs.String("advertise-addr", "", "Address other services reach the gRPC server at, by default the hostname and the port of grpc-addr")
+
+		tlsCert           = fs.String("tls-cert", "", "Certificate file of the gRPC server; TLS is enabled when set")
+		tlsKey            = fs.String("tls-key", "", "Key file of the gRPC server certificate")
+		tlsClientCA       = fs.String("tls-client-ca", "", "CA bundle that client certificates must be signed by; requires clients to authenticate (mTLS)")
+		tlsAllowedSANs    = fs.String("tls-allowed-sans", "", "Comma-separated DNS or URI SANs of the client certificates allowed to call, e.g. gateway.internal; any verified client when empty")
+		tlsReloadInterval = fs.Duration("tls-reload-interval", 10*time.Second, "How often to check the certificate files for changes, 0 to never reload them")
+
+		healthInterval = fs.Duration("health-interval", 5*time.Second, "How often the dependencies are checked for the gRPC health service and /readyz")
+		healthTimeout  = fs.Duration("health-timeout", 2*time.Second, "Timeout of each dependency check")
+
+		traceExporter    = fs.String("trace-exporter", "none", "Where to export traces: none, stdout or otlp")
+		otlpEndpoint     = fs.String("otlp-endpoint", "localhost:4317", "OTLP/gRPC collector address for the otlp trace exporter")
+		otlpInsecure     = fs.Bool("otlp-insecure", true, "Connect to the OTLP collector without TLS")
+		traceSampleRatio = fs.Float64("trace-sample-ratio", 1, "Fraction of traces started here to record; calls from a traced caller follow its decision")
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	// Create a single logger, which we'll use and give to other components.
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	{
+		shutdown, err := tracing.Setup(ctx, "authsvc", tracing.Config{
+			Exporter:    *traceExporter,
+			Endpoint:    *otlpEndpoint,
+			Insecure:    *otlpInsecure,
+			SampleRatio: *traceSampleRatio,
+		})
+		if err != nil {
+			logger.Log("tracing", *traceExporter, "err", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdown(ctx)
+		}()
+		logger.Log("tracing", *traceExporter, "sampleRatio", *traceSampleRatio)
+	}
+
+	var signer *authservice.TokenSigner
+	{
+		if *signingKeyFile == "" {
+			logger.Log("err", "-signing-key-file is required")
+			os.Exit(1)
+		}
+		key, err := authservice.LoadSigningKey(*signingKeyFile)
+		if err != nil {
+			logger.Log("signing-key-file", *signingKeyFile, "err", err)
+			os.Exit(1)
+		}
+		signer = authservice.NewTokenSigner(key, *tokenTTL)
+	}
+
+	var mail authservice.Mailer
+	switch *mailer {
+	case "log":
+		logger.Log("mailer", "log", "msg", "password reset tokens are logged, not emailed")
+		mail = infrastructure.NewLogMailer(log.With(logger, "component", "mailer"))
+	case "smtp":
+		var password string
+		if *smtpPasswordFile != "" {
+			b, err := os.ReadFile(*smtpPasswordFile)
+			if err != nil {
+				logger.Log("smtp-password-file", *smtpPasswordFile, "err", err)
+				os.Exit(1)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		if *smtpFrom == "" || *resetURL == "" {
+			logger.Log("err", "-smtp-from and -reset-url are required with the smtp mailer")
+			os.Exit(1)
+		}
+		m, err := infrastructure.NewSMTPMailer(*smtpAddr, *smtpUsername, password, *smtpFrom, *resetURL)
+		if err != nil {
+			logger.Log("mailer", "smtp", "err", err)
+			os.Exit(1)
+		}
+		logger.Log("mailer", "smtp", "addr", *smtpAddr, "from", *smtpFrom)
+		mail = m
+	default:
+		logger.Log("mailer", *mailer, "err", "unknown mailer")
+		os.Exit(1)
+	}
+
+	var (
+		repo authservice.Repository
+		ping func(context.Context) error
+	)
+	{
+		client, err := mongo.Connect(options.Client().ApplyURI(*mongodbURI))
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+
+		if err = client.Ping(ctx, readpref.Primary()); err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		logger.Log("repository", "MongoDB", "uri", *mongodbURI, "db", *mongodbDB, "collection", *mongodbCol)
+
+		defer client.Disconnect(ctx)
+		ping = func(ctx context.Context) error { return client.Ping(ctx, readpref.Primary()) }
+		accounts := infrastructure.NewMongoRepository(client, *mongodbDB, *mongodbCol)
+		if err := accounts.EnsureIndexes(ctx); err != nil {
+			logger.Log("accounts", *mongodbCol, "during", "EnsureIndexes", "err", err)
+			os.Exit(1)
+		}
+		repo = accounts
+	}
+
+	var endpointMetrics authendpoint.Metrics
+	{
+		endpointMetrics.Requests = kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "authsvc",
+			Subsystem: "endpoint",
+			Name:      "requests_total",
+			Help:      "Endpoint calls by method and outcome code.",
+		}, []string{"method", "code"})
+		endpointMetrics.Duration = kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: "authsvc",
+			Subsystem: "endpoint",
+			Name:      "request_duration_seconds",
+			Help:      "Endpoint call latency in seconds, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"})
+	}
+
+	var (
+		healthServer  = health.NewServer()
+		healthMonitor = instance.NewHealthMonitor(healthServer, []string{authpb.Auth_ServiceDesc.ServiceName}, *healthInterval, *healthTimeout,
+			log.With(logger, "component", "health"),
+			instance.HealthCheck{Name: "mongodb", Check: ping},
+		)
+	)
+
+	var (
+		lockout    = authservice.Lockout{MaxAttempts: *lockoutAttempts, Duration: *lockoutDuration}
+		service    = authservice.NewService(repo, signer, mail, lockout, *resetTTL)
+		endpoints  = authendpoint.New(service, logger, endpointMetrics)
+		grpcServer = authtransport.NewGRPCServer(endpoints, logger)
+	)
+
+	var (
+		serverOptions []grpc.ServerOption
+		unary         []grpc.UnaryServerInterceptor
+		stream        []grpc.StreamServerInterceptor
+		certReloader  *certs.Reloader
+	)
+	{
+		switch {
+		case (*tlsCert == "") != (*tlsKey == ""):
+			logger.Log("err", "-tls-cert and -tls-key must be set together")
+			os.Exit(1)
+		case *tlsCert == "" && *tlsClientCA != "":
+			logger.Log("err", "-tls-client-ca requires -tls-cert")
+			os.Exit(1)
+		case *tlsClientCA == "" && *tlsAllowedSANs != "":
+			logger.Log("err", "-tls-allowed-sans requires -tls-client-ca")
+			os.Exit(1)
+		}
+		if *tlsCert != "" {
+			var err error
+			certReloader, err = certs.NewReloader(*tlsCert, *tlsKey, *tlsClientCA, log.With(logger, "component", "tls"))
+			if err != nil {
+				logger.Log("transport", "gRPC", "during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(certReloader.ServerConfig())))
+			logger.Log("transport", "gRPC", "tls", true, "mtls", *tlsClientCA != "", "allowed", *tlsAllowedSANs)
+		}
+		if *tlsAllowedSANs != "" {
+			authorizer := certs.NewPeerAuthorizer(strings.Split(*tlsAllowedSANs, ","))
+			unary = append(unary, authorizer.UnaryInterceptor)
+			stream = append(stream, authorizer.StreamInterceptor)
+		}
+		unary = append(unary, kitgrpc.Interceptor)
+	}
+
+	var g group.Group
+	{
+		grpcListener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			logger.Log("transport", "gRPC", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+
+		baseServer := grpc.NewServer(append(serverOptions,
+			grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+			grpc.ChainUnaryInterceptor(unary...),
+			grpc.ChainStreamInterceptor(stream...),
+			// Allow the keepalive pings of the gateway's pooled connections.
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             10 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		)...)
+		authpb.RegisterAuthServer(baseServer, grpcServer)
+		healthpb.RegisterHealthServer(baseServer, healthServer)
+
+		g.Add(func() error {
+			logger.Log("transport", "gRPC", "addr", *grpcAddr)
+			return baseServer.Serve(grpcListener)
+		}, func(error) {
+			baseServer.GracefulStop()
+			_ = grpcListener.Close()
+		})
+	}
+
+	{
+		debugListener, err := net.Listen("tcp", *debugAddr)
+		if err != nil {
+			logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+
+		m := http.NewServeMux()
+		m.Handle("/metrics", promhttp.Handler())
+		m.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		m.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+			if err := healthMonitor.Ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		})
+
+		g.Add(func() error {
+			logger.Log("transport", "debug/HTTP", "addr", *debugAddr)
+			return http.Serve(debugListener, m)
+		}, func(error) {
+			_ = debugListener.Close()
+		})
+	}
+
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return healthMonitor.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if certReloader != nil && *tlsReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return certReloader.Run(ctx, *tlsReloadInterval)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if *consulAddr != "" {
+		client, err := api.NewClient(&api.Config{Address: *consulAddr})
+		if err != nil {
+			logger.Log("consul", *consulAddr, "err", err)
+			os.Exit(1)
+		}
+		host, port, err := instance.AdvertisedHostPort(*advertiseAddr, *grpcAddr)
+		if err != nil {
+			logger.Log("advertise-addr", *advertiseAddr, "err", err)
+			os.Exit(1)
+		}
+		var (
+			id        = fmt.Sprintf("%s-%s-%d", *consulService, host, port)
+			registrar = instance.NewConsulRegistrar(client, *consulService, id, host, port, *consulTTL, healthMonitor.Check, logger)
+		)
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return registrar.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	{
+		g.Add(func() error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, func(error) {
+			cancel()
+		})
+	}
+	logger.Log("exit", g.Run())
+}
@@ -0,0 +1,56 @@
+package authendpoint
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"time"
+)
+
+// Metrics instruments the endpoints of a Set. Both are labelled by "method";
+// Requests is also labelled by "code", the outcome reported by ErrorCode.
+type Metrics struct {
+	Requests metrics.Counter
+	Duration metrics.Histogram
+}
+
+// InstrumentingMiddleware records the outcome and duration of each call to an
+// endpoint. Service errors returned inside a Failer response count too.
+func InstrumentingMiddleware(method string, m Metrics) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				failure := err
+				if f, ok := response.(endpoint.Failer); ok && failure == nil {
+					failure = f.Failed()
+				}
+				m.Requests.With("method", method, "code", ErrorCode(failure)).Add(1)
+				m.Duration.With("method", method).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// ErrorCode classifies err for metrics: "ok", "invalid", "denied", "locked",
+// "canceled" or "error". A rise of "denied" or "locked" logins hints at
+// password guessing.
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, authservice.ErrInvalidEmail), errors.Is(err, authservice.ErrWeakPassword),
+		errors.Is(err, authservice.ErrEmailTaken):
+		return "invalid"
+	case errors.Is(err, authservice.ErrInvalidCredentials), errors.Is(err, authservice.ErrInvalidResetToken):
+		return "denied"
+	case errors.Is(err, authservice.ErrAccountLocked):
+		return "locked"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,87 @@
+package authendpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countsByLabels is a metrics.Counter adding up what is counted under each set
+// of label values, e.g. "method=Login,code=ok".
+type countsByLabels struct {
+	counts map[string]float64
+	labels []string
+}
+
+func newCountsByLabels() *countsByLabels {
+	return &countsByLabels{counts: map[string]float64{}}
+}
+
+func (c *countsByLabels) With(labelValues ...string) metrics.Counter {
+	return &countsByLabels{counts: c.counts, labels: append(append([]string(nil), c.labels...), labelValues...)}
+}
+
+func (c *countsByLabels) Add(delta float64) {
+	var pairs []string
+	for i := 0; i+1 < len(c.labels); i += 2 {
+		pairs = append(pairs, c.labels[i]+"="+c.labels[i+1])
+	}
+	c.counts[strings.Join(pairs, ",")] += delta
+}
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	requests := newCountsByLabels()
+	m := Metrics{Requests: requests, Duration: discard.NewHistogram()}
+	for _, outcome := range []struct {
+		response interface{}
+		err      error
+	}{
+		{LoginResponse{Token: "t"}, nil},
+		{LoginResponse{Err: authservice.ErrInvalidCredentials}, nil},
+		{LoginResponse{Err: authservice.ErrInvalidCredentials}, nil},
+		{LoginResponse{Err: fmt.Errorf("wrapped: %w", authservice.ErrAccountLocked)}, nil},
+		{nil, errors.New("connection refused")},
+	} {
+		e := InstrumentingMiddleware("Login", m)(func(context.Context, interface{}) (interface{}, error) {
+			return outcome.response, outcome.err
+		})
+		e(context.Background(), nil)
+	}
+	want := map[string]float64{
+		"method=Login,code=ok":     1,
+		"method=Login,code=denied": 2,
+		"method=Login,code=locked": 1,
+		"method=Login,code=error":  1,
+	}
+	if !reflect.DeepEqual(requests.counts, want) {
+		t.Errorf("counted %v, want %v", requests.counts, want)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{authservice.ErrInvalidEmail, "invalid"},
+		{authservice.ErrWeakPassword, "invalid"},
+		{authservice.ErrEmailTaken, "invalid"},
+		{authservice.ErrInvalidCredentials, "denied"},
+		{authservice.ErrInvalidResetToken, "denied"},
+		{authservice.ErrAccountLocked, "locked"},
+		{context.Canceled, "canceled"},
+		{context.DeadlineExceeded, "canceled"},
+		{errors.New("mongo: no reachable servers"), "error"},
+	} {
+		if got := ErrorCode(tc.err); got != tc.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
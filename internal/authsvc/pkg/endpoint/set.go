@@ -0,0 +1,192 @@
+package authendpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"time"
+)
+
+type Set struct {
+	RegisterEndpoint             endpoint.Endpoint
+	LoginEndpoint                endpoint.Endpoint
+	ChangePasswordEndpoint       endpoint.Endpoint
+	RequestPasswordResetEndpoint endpoint.Endpoint
+	ResetPasswordEndpoint        endpoint.Endpoint
+}
+
+// New returns a Set of the endpoints of s. They take no caller: the gateway
+// only calls ChangePassword for the account its token was issued to.
+func New(s authservice.Service, logger log.Logger, m Metrics) Set {
+	return Set{
+		RegisterEndpoint:             InstrumentingMiddleware("Register", m)(MakeRegisterEndpoint(s)),
+		LoginEndpoint:                InstrumentingMiddleware("Login", m)(MakeLoginEndpoint(s)),
+		ChangePasswordEndpoint:       InstrumentingMiddleware("ChangePassword", m)(MakeChangePasswordEndpoint(s)),
+		RequestPasswordResetEndpoint: InstrumentingMiddleware("RequestPasswordReset", m)(MakeRequestPasswordResetEndpoint(s)),
+		ResetPasswordEndpoint:        InstrumentingMiddleware("ResetPassword", m)(MakeResetPasswordEndpoint(s)),
+	}
+}
+
+// Register implements Service. Primarily useful in a client.
+func (s Set) Register(ctx context.Context, email, password string) (model.Account, error) {
+	response, err := s.RegisterEndpoint(ctx, RegisterRequest{Email: email, Password: password})
+	if err != nil {
+		return model.Account{}, err
+	}
+	resp := response.(RegisterResponse)
+	return resp.Account, resp.Err
+}
+
+func (s Set) Login(ctx context.Context, email, password string) (string, time.Time, error) {
+	response, err := s.LoginEndpoint(ctx, LoginRequest{Email: email, Password: password})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp := response.(LoginResponse)
+	return resp.Token, resp.ExpiresAt, resp.Err
+}
+
+func (s Set) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	response, err := s.ChangePasswordEndpoint(ctx, ChangePasswordRequest{ID: id, CurrentPassword: currentPassword, NewPassword: newPassword})
+	if err != nil {
+		return err
+	}
+	return response.(ChangePasswordResponse).Err
+}
+
+func (s Set) RequestPasswordReset(ctx context.Context, email string) error {
+	response, err := s.RequestPasswordResetEndpoint(ctx, RequestPasswordResetRequest{Email: email})
+	if err != nil {
+		return err
+	}
+	return response.(RequestPasswordResetResponse).Err
+}
+
+func (s Set) ResetPassword(ctx context.Context, token, newPassword string) error {
+	response, err := s.ResetPasswordEndpoint(ctx, ResetPasswordRequest{Token: token, NewPassword: newPassword})
+	if err != nil {
+		return err
+	}
+	return response.(ResetPasswordResponse).Err
+}
+
+func MakeRegisterEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RegisterRequest)
+		account, err := s.Register(ctx, req.Email, req.Password)
+		return RegisterResponse{Account: account, Err: err}, nil
+	}
+}
+
+func MakeLoginEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(LoginRequest)
+		token, expiresAt, err := s.Login(ctx, req.Email, req.Password)
+		return LoginResponse{Token: token, ExpiresAt: expiresAt, Err: err}, nil
+	}
+}
+
+func MakeChangePasswordEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ChangePasswordRequest)
+		return ChangePasswordResponse{Err: s.ChangePassword(ctx, req.ID, req.CurrentPassword, req.NewPassword)}, nil
+	}
+}
+
+func MakeRequestPasswordResetEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RequestPasswordResetRequest)
+		return RequestPasswordResetResponse{Err: s.RequestPasswordReset(ctx, req.Email)}, nil
+	}
+}
+
+func MakeResetPasswordEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ResetPasswordRequest)
+		return ResetPasswordResponse{Err: s.ResetPassword(ctx, req.Token, req.NewPassword)}, nil
+	}
+}
+
+// compile time assertions for our response types implementing endpoint.Failer.
+var (
+	_ endpoint.Failer = RegisterResponse{}
+	_ endpoint.Failer = LoginResponse{}
+	_ endpoint.Failer = ChangePasswordResponse{}
+	_ endpoint.Failer = RequestPasswordResetResponse{}
+	_ endpoint.Failer = ResetPasswordResponse{}
+)
+
+// RegisterRequest collects the request parameters for the Register method.
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RegisterResponse collects the response values for the Register method.
+type RegisterResponse struct {
+	Account model.Account `json:"account"`
+	Err     error         `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RegisterResponse) Failed() error { return r.Err }
+
+// LoginRequest collects the request parameters for the Login method.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoginResponse collects the response values for the Login method.
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Err       error     `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r LoginResponse) Failed() error { return r.Err }
+
+// ChangePasswordRequest collects the request parameters for the ChangePassword method.
+type ChangePasswordRequest struct {
+	ID              string `json:"id"`
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// ChangePasswordResponse collects the response values for the ChangePassword method.
+type ChangePasswordResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ChangePasswordResponse) Failed() error { return r.Err }
+
+// RequestPasswordResetRequest collects the request parameters for the RequestPasswordReset method.
+type RequestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// RequestPasswordResetResponse collects the response values for the RequestPasswordReset method.
+type RequestPasswordResetResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RequestPasswordResetResponse) Failed() error { return r.Err }
+
+// ResetPasswordRequest collects the request parameters for the ResetPassword method.
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// ResetPasswordResponse collects the response values for the ResetPassword method.
+type ResetPasswordResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ResetPasswordResponse) Failed() error { return r.Err }
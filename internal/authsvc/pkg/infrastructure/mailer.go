@@ -0,0 +1,75 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+)
+
+// logMailer logs the emails it is asked to send instead of sending them. It
+// is meant for development: anyone reading the logs can reset passwords.
+type logMailer struct {
+	logger log.Logger
+}
+
+func NewLogMailer(logger log.Logger) *logMailer {
+	return &logMailer{logger: logger}
+}
+
+func (m *logMailer) SendPasswordReset(_ context.Context, to, token string) error {
+	return m.logger.Log("mail", "password reset", "to", to, "token", token)
+}
+
+// smtpMailer sends emails through an SMTP server, authenticating with PLAIN
+// when it has a username. net/smtp upgrades the connection with STARTTLS when
+// the server offers it, and refuses to send credentials without TLS unless
+// the server is on localhost.
+type smtpMailer struct {
+	addr     string
+	auth     smtp.Auth
+	from     string
+	resetURL string
+}
+
+// NewSMTPMailer returns a mailer sending from the address from through the
+// server at addr, a host:port. Password reset emails link to resetURL with the
+// token in its "token" query parameter.
+func NewSMTPMailer(addr, username, password, from, resetURL string) (*smtpMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := url.Parse(resetURL); err != nil {
+		return nil, err
+	}
+	m := &smtpMailer{addr: addr, from: from, resetURL: resetURL}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *smtpMailer) SendPasswordReset(_ context.Context, to, token string) error {
+	link, _ := url.Parse(m.resetURL)
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	body := fmt.Sprintf("Someone asked to reset the password of your account.\r\n\r\n"+
+		"To choose a new password, open this link. It works once and expires soon:\r\n\r\n%s\r\n\r\n"+
+		"If it wasn't you, ignore this email; your password stays the same.\r\n", link)
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: Reset your password",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
@@ -0,0 +1,201 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// mongoRepository stores accounts in a collection where emails are unique.
+type mongoRepository struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoRepository(client *mongo.Client, db, collection string) *mongoRepository {
+	return &mongoRepository{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the unique index on emails and the index used to
+// look accounts up by reset token.
+func (m *mongoRepository) EnsureIndexes(ctx context.Context) error {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "resetTokenHash", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+func (m *mongoRepository) CreateAccount(ctx context.Context, a model.Account) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "insert")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err = collection.InsertOne(ctx, accountDocument{
+		ID:                a.ID,
+		Email:             a.Email,
+		PasswordHash:      a.PasswordHash,
+		CreatedAt:         a.CreatedAt,
+		PasswordChangedAt: a.PasswordChangedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return authservice.ErrEmailTaken
+	}
+	return err
+}
+
+func (m *mongoRepository) GetAccount(ctx context.Context, id string) (model.Account, error) {
+	return m.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (m *mongoRepository) FindAccount(ctx context.Context, email string) (model.Account, error) {
+	return m.findOne(ctx, bson.D{{Key: "email", Value: email}})
+}
+
+func (m *mongoRepository) findOne(ctx context.Context, filter bson.D) (_ model.Account, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "findOne")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	var d accountDocument
+	err = collection.FindOne(ctx, filter).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.Account{}, authservice.ErrAccountNotFound
+	}
+	if err != nil {
+		return model.Account{}, err
+	}
+	return d.toModel(), nil
+}
+
+func (m *mongoRepository) SetPassword(ctx context.Context, id, hash string, at time.Time) error {
+	return m.update(ctx, id, setPassword(hash, at))
+}
+
+func (m *mongoRepository) RecordFailedLogin(ctx context.Context, id string) (_ int, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "findOneAndUpdate")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	var d accountDocument
+	err = collection.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "failedLogins", Value: 1}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, authservice.ErrAccountNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return d.FailedLogins, nil
+}
+
+func (m *mongoRepository) ResetFailedLogins(ctx context.Context, id string) error {
+	return m.update(ctx, id, bson.D{{Key: "$set", Value: bson.D{{Key: "failedLogins", Value: 0}}}})
+}
+
+func (m *mongoRepository) LockAccount(ctx context.Context, id string, until time.Time) error {
+	return m.update(ctx, id, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "failedLogins", Value: 0},
+		{Key: "lockedUntil", Value: until},
+	}}})
+}
+
+func (m *mongoRepository) SetResetToken(ctx context.Context, id, tokenHash string, expiresAt time.Time) error {
+	return m.update(ctx, id, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "resetTokenHash", Value: tokenHash},
+		{Key: "resetExpiresAt", Value: expiresAt},
+	}}})
+}
+
+func (m *mongoRepository) ResetPassword(ctx context.Context, tokenHash, passwordHash string, at time.Time) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{
+		{Key: "resetTokenHash", Value: tokenHash},
+		{Key: "resetExpiresAt", Value: bson.D{{Key: "$gt", Value: at}}},
+	}
+	result, err := collection.UpdateOne(ctx, filter, setPassword(passwordHash, at))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return authservice.ErrInvalidResetToken
+	}
+	return nil
+}
+
+// update applies update to account id.
+func (m *mongoRepository) update(ctx context.Context, id string, update bson.D) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	result, err := collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return authservice.ErrAccountNotFound
+	}
+	return nil
+}
+
+// setPassword is the update replacing the password hash of an account at at,
+// which also drops its reset token and clears its lockout.
+func setPassword(hash string, at time.Time) bson.D {
+	return bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "passwordHash", Value: hash},
+			{Key: "passwordChangedAt", Value: at},
+			{Key: "failedLogins", Value: 0},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "lockedUntil", Value: ""},
+			{Key: "resetTokenHash", Value: ""},
+			{Key: "resetExpiresAt", Value: ""},
+		}},
+	}
+}
+
+type accountDocument struct {
+	ID                string     `bson:"_id"`
+	Email             string     `bson:"email"`
+	PasswordHash      string     `bson:"passwordHash"`
+	CreatedAt         time.Time  `bson:"createdAt"`
+	PasswordChangedAt time.Time  `bson:"passwordChangedAt"`
+	FailedLogins      int        `bson:"failedLogins"`
+	LockedUntil       *time.Time `bson:"lockedUntil,omitempty"`
+	ResetTokenHash    string     `bson:"resetTokenHash,omitempty"`
+	ResetExpiresAt    *time.Time `bson:"resetExpiresAt,omitempty"`
+}
+
+func (d accountDocument) toModel() model.Account {
+	a := model.Account{
+		ID:                d.ID,
+		Email:             d.Email,
+		PasswordHash:      d.PasswordHash,
+		CreatedAt:         d.CreatedAt.UTC(),
+		PasswordChangedAt: d.PasswordChangedAt.UTC(),
+		FailedLogins:      d.FailedLogins,
+	}
+	if d.LockedUntil != nil {
+		a.LockedUntil = d.LockedUntil.UTC()
+	}
+	return a
+}
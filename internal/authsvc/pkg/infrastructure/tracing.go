@@ -0,0 +1,36 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/yuisofull/gommunigate/internal/authsvc/pkg/infrastructure")
+
+// startSpan starts the span of a MongoDB operation on a collection.
+func startSpan(ctx context.Context, db, collection, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+db+"."+collection,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNamespace(db),
+			semconv.DBCollectionName(collection),
+			semconv.DBOperationName(operation),
+		),
+	)
+}
+
+// endSpan ends span, marking it failed if err is an error other than an
+// unknown account or a taken email, which are expected outcomes.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, authservice.ErrAccountNotFound) && !errors.Is(err, authservice.ErrEmailTaken) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
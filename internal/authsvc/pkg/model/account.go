@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Account holds the email and password a user signs in with. Its ID is the
+// user ID in the tokens authsvc issues, and so the ID of the user's profile in
+// usersvc. Email is stored lowercased. LockedUntil is zero unless too many
+// failed logins locked the account.
+type Account struct {
+	ID                string    `json:"id"`
+	Email             string    `json:"email"`
+	CreatedAt         time.Time `json:"createdAt"`
+	PasswordChangedAt time.Time `json:"passwordChangedAt"`
+	// PasswordHash is the argon2id hash of the password in PHC string
+	// format. It never leaves authsvc.
+	PasswordHash string `json:"-"`
+	// FailedLogins counts the failed logins since the last successful one
+	// or the last lockout.
+	FailedLogins int       `json:"-"`
+	LockedUntil  time.Time `json:"-"`
+}
+
+// Locked reports whether a is locked at t.
+func (a Account) Locked(t time.Time) bool {
+	return t.Before(a.LockedUntil)
+}
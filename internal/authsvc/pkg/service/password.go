@@ -0,0 +1,122 @@
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// minPasswordLen and maxPasswordLen bound the length of passwords, in
+	// characters.
+	minPasswordLen = 10
+	maxPasswordLen = 128
+)
+
+// argon2Params are the argon2id parameters of new password hashes, the second
+// recommended option of RFC 9106. Hashes made with other parameters keep
+// verifying, as the parameters are stored with them.
+var argon2Params = struct {
+	memory  uint32 // in KiB
+	time    uint32
+	threads uint8
+	saltLen int
+	keyLen  uint32
+}{memory: 64 * 1024, time: 3, threads: 4, saltLen: 16, keyLen: 32}
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// hashing holds a slot per password hash being computed. Each takes
+// argon2Params.memory and a core for a few hundred milliseconds, so a burst of
+// logins queues for a slot instead of exhausting memory.
+var hashing = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// acquireHashing waits for a hashing slot, returned by calling release, or
+// returns the error of ctx.
+func acquireHashing(ctx context.Context) (release func(), err error) {
+	select {
+	case hashing <- struct{}{}:
+		return func() { <-hashing }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dummyHash returns the hash verified against when logging in to an unknown
+// account, so that it takes as long as for a known one.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := hashPassword(context.Background(), "not the password of any account")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// validatePassword returns ErrWeakPassword for a password that is too short
+// or too long.
+func validatePassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < minPasswordLen || n > maxPasswordLen {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// hashPassword returns the argon2id hash of password in PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>.
+func hashPassword(ctx context.Context, password string) (string, error) {
+	release, err := acquireHashing(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	p := argon2Params
+	salt := make([]byte, p.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword reports whether password matches hash, made by hashPassword.
+func verifyPassword(ctx context.Context, hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errMalformedHash
+	}
+	var (
+		version      int
+		memory, time uint32
+		threads      uint8
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, errMalformedHash
+	}
+	release, err := acquireHashing(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -0,0 +1,132 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cheapHashes makes hashPassword fast for the duration of the test, where the
+// cost of argon2id is beside the point.
+func cheapHashes(t *testing.T) {
+	t.Helper()
+	saved := argon2Params
+	argon2Params.memory, argon2Params.time, argon2Params.threads = 64, 1, 1
+	t.Cleanup(func() { argon2Params = saved })
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword(context.Background(), "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("hash %q doesn't use the RFC 9106 parameters", hash)
+	}
+	for _, tc := range []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse battery staple", true},
+		{"correct horse battery stapl", false},
+		{"Correct horse battery staple", false},
+		{"", false},
+	} {
+		if ok, err := verifyPassword(context.Background(), hash, tc.password); ok != tc.want || err != nil {
+			t.Errorf("verifyPassword(%q) = %v, %v, want %v", tc.password, ok, err, tc.want)
+		}
+	}
+
+	// The salt is random, so the same password hashes differently.
+	other, _ := hashPassword(context.Background(), "correct horse battery staple")
+	if other == hash {
+		t.Error("same hash for two accounts with the same password")
+	}
+}
+
+func TestVerifyPasswordWithOtherParameters(t *testing.T) {
+	cheapHashes(t)
+	hash, err := hashPassword(context.Background(), "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hash, "$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q not made with the test parameters", hash)
+	}
+	argon2Params.memory, argon2Params.time = 128, 2
+	if ok, err := verifyPassword(context.Background(), hash, "correct horse battery staple"); !ok || err != nil {
+		t.Errorf("hash with earlier parameters: %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	const valid = "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5"
+	if _, err := verifyPassword(context.Background(), valid, "password"); err != nil {
+		t.Fatalf("verifyPassword() of a well-formed hash = %v", err)
+	}
+	for _, hash := range []string{
+		"",
+		"password",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA",
+	} {
+		if ok, err := verifyPassword(context.Background(), hash, "password"); ok || err != errMalformedHash {
+			t.Errorf("verifyPassword(%q) = %v, %v, want %v", hash, ok, err, errMalformedHash)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{"too short", "short", ErrWeakPassword},
+		{"shortest", strings.Repeat("a", minPasswordLen), nil},
+		{"longest", strings.Repeat("a", maxPasswordLen), nil},
+		{"too long", strings.Repeat("a", maxPasswordLen+1), ErrWeakPassword},
+		// Characters are counted, not bytes.
+		{"multibyte", strings.Repeat("é", minPasswordLen), nil},
+		{"multibyte too short", strings.Repeat("é", minPasswordLen-1), ErrWeakPassword},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validatePassword(tc.password); err != tc.wantErr {
+				t.Errorf("validatePassword() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHashingSlots(t *testing.T) {
+	cheapHashes(t)
+	// Take every slot, as a burst of logins would.
+	for range cap(hashing) {
+		hashing <- struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := hashPassword(ctx, "correct horse battery staple"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("hashPassword() without a free slot = %v, want %v", err, context.DeadlineExceeded)
+	}
+	<-hashing
+	done := make(chan error)
+	go func() {
+		_, err := hashPassword(context.Background(), "correct horse battery staple")
+		done <- err
+	}()
+	if err := <-done; err != nil {
+		t.Errorf("hashPassword() with a free slot = %v", err)
+	}
+	for range cap(hashing) - 1 {
+		<-hashing
+	}
+}
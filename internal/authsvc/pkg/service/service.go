@@ -0,0 +1,285 @@
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountNotFound is returned by repositories for an unknown account.
+	// Service methods return ErrInvalidCredentials instead, so as not to
+	// reveal which emails have an account.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrEmailTaken is returned by Register for an email that already has an
+	// account.
+	ErrEmailTaken   = errors.New("email already registered")
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrWeakPassword is returned for a new password that is too short or
+	// too long.
+	ErrWeakPassword = errors.New("password must be 10 to 128 characters")
+	// ErrInvalidCredentials is returned for a wrong email or password.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrAccountLocked is returned for an account locked after too many
+	// failed logins, whatever the password.
+	ErrAccountLocked = errors.New("account temporarily locked")
+	// ErrInvalidResetToken is returned by ResetPassword for a reset token
+	// that is unknown, used or expired.
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+const (
+	// resetTokenPrefix starts every password reset token.
+	resetTokenPrefix = "gmp_"
+	// maxEmailLen bounds the length of emails, as in RFC 5321.
+	maxEmailLen = 254
+)
+
+// Service manages the email and password accounts users sign in with.
+type Service interface {
+	// Register creates an account for email with password.
+	Register(ctx context.Context, email, password string) (model.Account, error)
+	// Login returns a signed ID token for the account of email if password
+	// is its password, and when the token expires.
+	Login(ctx context.Context, email, password string) (string, time.Time, error)
+	// ChangePassword replaces the password of account id, if
+	// currentPassword is still its password. ID tokens issued before stay
+	// valid until they expire.
+	ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error
+	// RequestPasswordReset emails a reset token to email if it has an
+	// account. It succeeds either way.
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword replaces the password of the account a reset token was
+	// emailed for, and unlocks it. The token can only be used once. As with
+	// ChangePassword, ID tokens issued before stay valid until they expire.
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+// Repository stores accounts along with the SHA-256 hash of their pending
+// password reset token. Methods taking an account return ErrAccountNotFound
+// for an unknown one.
+type Repository interface {
+	// CreateAccount returns ErrEmailTaken if the email has an account.
+	CreateAccount(ctx context.Context, a model.Account) error
+	GetAccount(ctx context.Context, id string) (model.Account, error)
+	FindAccount(ctx context.Context, email string) (model.Account, error)
+	// SetPassword replaces the password hash of account id, resets its
+	// failed logins and drops its reset token.
+	SetPassword(ctx context.Context, id, hash string, at time.Time) error
+	// RecordFailedLogin counts a failed login of account id and returns the
+	// number of failed logins since the last reset.
+	RecordFailedLogin(ctx context.Context, id string) (int, error)
+	// ResetFailedLogins resets the failed logins of account id.
+	ResetFailedLogins(ctx context.Context, id string) error
+	// LockAccount locks account id until the given time and resets its
+	// failed logins.
+	LockAccount(ctx context.Context, id string, until time.Time) error
+	// SetResetToken saves the hash of a reset token for account id, valid
+	// until expiresAt, replacing any previous one.
+	SetResetToken(ctx context.Context, id, tokenHash string, expiresAt time.Time) error
+	// ResetPassword replaces the password hash of the account whose reset
+	// token hashes to tokenHash and is valid at at, drops the token, resets
+	// its failed logins and unlocks it. Otherwise it returns
+	// ErrInvalidResetToken.
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string, at time.Time) error
+}
+
+// Mailer delivers emails to account holders.
+type Mailer interface {
+	// SendPasswordReset emails token to to, for them to reset their
+	// password with.
+	SendPasswordReset(ctx context.Context, to, token string) error
+}
+
+// Lockout locks an account for Duration once MaxAttempts logins in a row
+// failed, which slows down guessing its password.
+type Lockout struct {
+	MaxAttempts int
+	Duration    time.Duration
+}
+
+// NewService returns a Service storing accounts in r, signing ID tokens with
+// signer, and emailing reset tokens valid for resetTTL through m.
+func NewService(r Repository, signer *TokenSigner, m Mailer, l Lockout, resetTTL time.Duration) Service {
+	if r == nil {
+		panic("invalid repository")
+	}
+	return service{repo: r, signer: signer, mailer: m, lockout: l, resetTTL: resetTTL}
+}
+
+type service struct {
+	repo     Repository
+	signer   *TokenSigner
+	mailer   Mailer
+	lockout  Lockout
+	resetTTL time.Duration
+}
+
+func (s service) Register(ctx context.Context, email, password string) (model.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if err := validatePassword(password); err != nil {
+		return model.Account{}, err
+	}
+	hash, err := hashPassword(ctx, password)
+	if err != nil {
+		return model.Account{}, err
+	}
+	now := time.Now().UTC()
+	account := model.Account{
+		ID:                uuid.NewString(),
+		Email:             email,
+		CreatedAt:         now,
+		PasswordChangedAt: now,
+		PasswordHash:      hash,
+	}
+	if err := s.repo.CreateAccount(ctx, account); err != nil {
+		return model.Account{}, err
+	}
+	return account, nil
+}
+
+func (s service) Login(ctx context.Context, email, password string) (string, time.Time, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	account, err := s.repo.FindAccount(ctx, email)
+	if errors.Is(err, ErrAccountNotFound) {
+		_, _ = verifyPassword(ctx, dummyHash(), password)
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	if err := s.checkPassword(ctx, account, password, now); err != nil {
+		return "", time.Time{}, err
+	}
+	return s.signer.Sign(account, now)
+}
+
+func (s service) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	account, err := s.repo.GetAccount(ctx, id)
+	if errors.Is(err, ErrAccountNotFound) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if err := s.checkPassword(ctx, account, currentPassword, now); err != nil {
+		return err
+	}
+	hash, err := hashPassword(ctx, newPassword)
+	if err != nil {
+		return err
+	}
+	return s.repo.SetPassword(ctx, account.ID, hash, now)
+}
+
+func (s service) RequestPasswordReset(ctx context.Context, email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	account, err := s.repo.FindAccount(ctx, email)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := newResetToken()
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetResetToken(ctx, account.ID, hashToken(token), time.Now().UTC().Add(s.resetTTL)); err != nil {
+		return err
+	}
+	return s.mailer.SendPasswordReset(ctx, account.Email, token)
+}
+
+func (s service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if !strings.HasPrefix(token, resetTokenPrefix) {
+		return ErrInvalidResetToken
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	hash, err := hashPassword(ctx, newPassword)
+	if err != nil {
+		return err
+	}
+	return s.repo.ResetPassword(ctx, hashToken(token), hash, time.Now().UTC())
+}
+
+// checkPassword returns nil if password is the password of a at now. Failed
+// attempts are counted towards locking a.
+func (s service) checkPassword(ctx context.Context, a model.Account, password string, now time.Time) error {
+	if a.Locked(now) {
+		return ErrAccountLocked
+	}
+	ok, err := verifyPassword(ctx, a.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if a.FailedLogins > 0 {
+			return s.repo.ResetFailedLogins(ctx, a.ID)
+		}
+		return nil
+	}
+	failures, err := s.repo.RecordFailedLogin(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	if s.lockout.MaxAttempts > 0 && failures >= s.lockout.MaxAttempts {
+		if err := s.repo.LockAccount(ctx, a.ID, now.Add(s.lockout.Duration)); err != nil {
+			return err
+		}
+	}
+	return ErrInvalidCredentials
+}
+
+// normalizeEmail lowercases a bare address such as "jane@example.com", or
+// returns ErrInvalidEmail.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > maxEmailLen {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return resetTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a reset token for storage. Tokens are random enough that a
+// fast hash can't be brute-forced, and it lets them be looked up by their hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
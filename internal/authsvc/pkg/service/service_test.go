@@ -0,0 +1,329 @@
+package authservice
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memRepository is a Repository keeping accounts in memory, for tests.
+type memRepository struct {
+	mtx      sync.Mutex
+	accounts map[string]model.Account
+	resets   map[string]resetToken // token hash to reset
+}
+
+type resetToken struct {
+	id        string
+	expiresAt time.Time
+}
+
+func newMemRepository() *memRepository {
+	return &memRepository{accounts: map[string]model.Account{}, resets: map[string]resetToken{}}
+}
+
+func (r *memRepository) CreateAccount(_ context.Context, a model.Account) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, other := range r.accounts {
+		if other.Email == a.Email {
+			return ErrEmailTaken
+		}
+	}
+	r.accounts[a.ID] = a
+	return nil
+}
+
+func (r *memRepository) GetAccount(_ context.Context, id string) (model.Account, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	a, ok := r.accounts[id]
+	if !ok {
+		return model.Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+func (r *memRepository) FindAccount(_ context.Context, email string) (model.Account, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, a := range r.accounts {
+		if a.Email == email {
+			return a, nil
+		}
+	}
+	return model.Account{}, ErrAccountNotFound
+}
+
+func (r *memRepository) update(id string, f func(*model.Account)) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	a, ok := r.accounts[id]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	f(&a)
+	r.accounts[id] = a
+	return nil
+}
+
+func (r *memRepository) SetPassword(_ context.Context, id, hash string, at time.Time) error {
+	return r.update(id, func(a *model.Account) {
+		a.PasswordHash, a.PasswordChangedAt, a.FailedLogins = hash, at, 0
+		for h, reset := range r.resets {
+			if reset.id == id {
+				delete(r.resets, h)
+			}
+		}
+	})
+}
+
+func (r *memRepository) RecordFailedLogin(_ context.Context, id string) (int, error) {
+	var failures int
+	err := r.update(id, func(a *model.Account) {
+		a.FailedLogins++
+		failures = a.FailedLogins
+	})
+	return failures, err
+}
+
+func (r *memRepository) ResetFailedLogins(_ context.Context, id string) error {
+	return r.update(id, func(a *model.Account) { a.FailedLogins = 0 })
+}
+
+func (r *memRepository) LockAccount(_ context.Context, id string, until time.Time) error {
+	return r.update(id, func(a *model.Account) { a.LockedUntil, a.FailedLogins = until, 0 })
+}
+
+func (r *memRepository) SetResetToken(_ context.Context, id, tokenHash string, expiresAt time.Time) error {
+	r.mtx.Lock()
+	for h, reset := range r.resets {
+		if reset.id == id {
+			delete(r.resets, h)
+		}
+	}
+	r.resets[tokenHash] = resetToken{id: id, expiresAt: expiresAt}
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *memRepository) ResetPassword(_ context.Context, tokenHash, passwordHash string, at time.Time) error {
+	r.mtx.Lock()
+	reset, ok := r.resets[tokenHash]
+	delete(r.resets, tokenHash)
+	r.mtx.Unlock()
+	if !ok || !at.Before(reset.expiresAt) {
+		return ErrInvalidResetToken
+	}
+	return r.update(reset.id, func(a *model.Account) {
+		a.PasswordHash, a.PasswordChangedAt, a.FailedLogins, a.LockedUntil = passwordHash, at, 0, time.Time{}
+	})
+}
+
+// memMailer records the reset tokens sent, by recipient.
+type memMailer struct{ sent map[string]string }
+
+func (m *memMailer) SendPasswordReset(_ context.Context, to, token string) error {
+	m.sent[to] = token
+	return nil
+}
+
+const testPassword = "correct horse battery staple"
+
+// testService returns a service locking accounts after 3 failed logins, with
+// the account of jane@example.com.
+func testService(t *testing.T) (Service, *memRepository, *memMailer, ed25519.PublicKey) {
+	t.Helper()
+	cheapHashes(t)
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, mailer := newMemRepository(), &memMailer{sent: map[string]string{}}
+	s := NewService(repo, NewTokenSigner(private, time.Hour), mailer, Lockout{MaxAttempts: 3, Duration: time.Minute}, time.Hour)
+	if _, err := s.Register(context.Background(), "jane@example.com", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	return s, repo, mailer, public
+}
+
+func TestRegister(t *testing.T) {
+	s, repo, _, _ := testService(t)
+	for _, tc := range []struct {
+		name      string
+		email     string
+		password  string
+		wantEmail string
+		wantErr   error
+	}{
+		{"normalized email", "  John@Example.COM ", testPassword, "john@example.com", nil},
+		{"taken email", "JANE@example.com", testPassword, "", ErrEmailTaken},
+		{"weak password", "joe@example.com", "short", "", ErrWeakPassword},
+		{"display name", "Joe <joe@example.com>", testPassword, "", ErrInvalidEmail},
+		{"not an email", "joe", testPassword, "", ErrInvalidEmail},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := s.Register(context.Background(), tc.email, tc.password)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Register() = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && (a.Email != tc.wantEmail || a.PasswordHash == tc.password) {
+				t.Errorf("registered %+v", a)
+			}
+		})
+	}
+	for _, a := range repo.accounts {
+		if ok, _ := verifyPassword(context.Background(), a.PasswordHash, testPassword); !ok {
+			t.Errorf("password of %s not stored hashed", a.Email)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, _, _, public := testService(t)
+	ctx := context.Background()
+
+	token, expiresAt, err := s.Login(ctx, "Jane@example.com", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Claims
+	if _, err := jwt.ParseWithClaims(token, &c, func(*jwt.Token) (interface{}, error) { return public, nil }); err != nil {
+		t.Fatalf("token doesn't verify: %v", err)
+	}
+	if c.Issuer != Issuer || c.Email != "jane@example.com" || c.Subject == "" || !c.ExpiresAt.Time.Equal(expiresAt.Truncate(time.Second)) {
+		t.Errorf("claims = %+v", c)
+	}
+
+	for _, tc := range []struct {
+		email, password string
+	}{
+		{"jane@example.com", "wrong password"},
+		{"nobody@example.com", testPassword},
+		{"not an email", testPassword},
+	} {
+		if _, _, err := s.Login(ctx, tc.email, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q, %q) = %v, want %v", tc.email, tc.password, err, ErrInvalidCredentials)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	s, repo, _, _ := testService(t)
+	ctx := context.Background()
+	account, _ := repo.FindAccount(ctx, "jane@example.com")
+	login := func(password string) error {
+		_, _, err := s.Login(ctx, "jane@example.com", password)
+		return err
+	}
+
+	// A successful login resets the count.
+	login("wrong password")
+	login("wrong password")
+	if err := login(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := repo.GetAccount(ctx, account.ID); a.FailedLogins != 0 {
+		t.Errorf("%d failed logins after a successful one", a.FailedLogins)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := login("wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failed login %d = %v", i, err)
+		}
+	}
+	// Locked, whatever the password, including for changing it.
+	if err := login(testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Login() of a locked account = %v, want %v", err, ErrAccountLocked)
+	}
+	if err := s.ChangePassword(ctx, account.ID, testPassword, "another good password"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("ChangePassword() of a locked account = %v, want %v", err, ErrAccountLocked)
+	}
+
+	// The lock expires.
+	repo.update(account.ID, func(a *model.Account) { a.LockedUntil = time.Now().Add(-time.Second) })
+	if err := login(testPassword); err != nil {
+		t.Errorf("Login() after the lock expired = %v", err)
+	}
+
+	// Wrong current passwords count towards the lockout too.
+	for range 3 {
+		s.ChangePassword(ctx, account.ID, "wrong password", "another good password")
+	}
+	if err := login(testPassword); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Login() after failed password changes = %v, want %v", err, ErrAccountLocked)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s, repo, _, _ := testService(t)
+	ctx := context.Background()
+	account, _ := repo.FindAccount(ctx, "jane@example.com")
+
+	if err := s.ChangePassword(ctx, account.ID, testPassword, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("ChangePassword() to a weak password = %v", err)
+	}
+	if err := s.ChangePassword(ctx, "unknown", testPassword, "another good password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ChangePassword() of an unknown account = %v", err)
+	}
+	if err := s.ChangePassword(ctx, account.ID, testPassword, "another good password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Login(ctx, "jane@example.com", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("old password still works: %v", err)
+	}
+	if _, _, err := s.Login(ctx, "jane@example.com", "another good password"); err != nil {
+		t.Errorf("new password doesn't work: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	s, repo, mailer, _ := testService(t)
+	ctx := context.Background()
+	account, _ := repo.FindAccount(ctx, "jane@example.com")
+
+	if err := s.RequestPasswordReset(ctx, "nobody@example.com"); err != nil || len(mailer.sent) != 0 {
+		t.Errorf("reset of an unknown account = %v, sent %v", err, mailer.sent)
+	}
+	if err := s.RequestPasswordReset(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first := mailer.sent["jane@example.com"]
+	if err := s.RequestPasswordReset(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.sent["jane@example.com"]
+	if _, ok := repo.resets[token]; ok {
+		t.Error("reset token stored in the clear")
+	}
+	repo.LockAccount(ctx, account.ID, time.Now().Add(time.Hour))
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		password string
+		wantErr  error
+	}{
+		{"replaced token", first, "another good password", ErrInvalidResetToken},
+		{"unknown token", resetTokenPrefix + "unknown", "another good password", ErrInvalidResetToken},
+		{"without the prefix", token[len(resetTokenPrefix):], "another good password", ErrInvalidResetToken},
+		{"weak password", token, "short", ErrWeakPassword},
+		{"valid", token, "another good password", nil},
+		{"used token", token, "yet another password", ErrInvalidResetToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.ResetPassword(ctx, tc.token, tc.password); !errors.Is(err, tc.wantErr) {
+				t.Errorf("ResetPassword() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+	// Resetting the password unlocks the account.
+	if _, _, err := s.Login(ctx, "jane@example.com", "another good password"); err != nil {
+		t.Errorf("Login() after reset = %v", err)
+	}
+}
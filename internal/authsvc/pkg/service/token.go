@@ -0,0 +1,65 @@
+package authservice
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	"os"
+	"time"
+)
+
+// Issuer is the issuer of the ID tokens authsvc signs.
+const Issuer = "gommunigate-authsvc"
+
+// Claims is the payload of an ID token. The subject is the account ID.
+type Claims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// TokenSigner signs the ID tokens of accounts that log in: JWTs signed with
+// Ed25519, so that the gateway verifies them with the public key only. Nothing
+// revokes them, not even a password change, so keep their TTL short.
+type TokenSigner struct {
+	key ed25519.PrivateKey
+	ttl time.Duration
+}
+
+// NewTokenSigner returns a TokenSigner signing with key tokens valid for ttl.
+func NewTokenSigner(key ed25519.PrivateKey, ttl time.Duration) *TokenSigner {
+	return &TokenSigner{key: key, ttl: ttl}
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key from path,
+// such as one made by "openssl genpkey -algorithm ed25519".
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key.(ed25519.PrivateKey), nil
+}
+
+// Sign returns an ID token for a issued at now, and when it expires.
+func (s *TokenSigner) Sign(a model.Account, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(s.ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{
+		Email: a.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   a.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
@@ -0,0 +1,384 @@
+package authtransport
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pb"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"google.golang.org/grpc"
+	"time"
+)
+
+type grpcServer struct {
+	register             grpctransport.Handler
+	login                grpctransport.Handler
+	changePassword       grpctransport.Handler
+	requestPasswordReset grpctransport.Handler
+	resetPassword        grpctransport.Handler
+	pb.UnimplementedAuthServer
+}
+
+// NewGRPCServer makes a set of endpoints available as a gRPC AuthServer.
+func NewGRPCServer(endpoints authendpoint.Set, logger log.Logger, extra ...grpctransport.ServerOption) pb.AuthServer {
+	options := append([]grpctransport.ServerOption{
+		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}, extra...)
+	return &grpcServer{
+		register: grpctransport.NewServer(
+			endpoints.RegisterEndpoint,
+			decodeGRPCRegisterRequest,
+			encodeGRPCRegisterResponse,
+			options...,
+		),
+		login: grpctransport.NewServer(
+			endpoints.LoginEndpoint,
+			decodeGRPCLoginRequest,
+			encodeGRPCLoginResponse,
+			options...,
+		),
+		changePassword: grpctransport.NewServer(
+			endpoints.ChangePasswordEndpoint,
+			decodeGRPCChangePasswordRequest,
+			encodeGRPCChangePasswordResponse,
+			options...,
+		),
+		requestPasswordReset: grpctransport.NewServer(
+			endpoints.RequestPasswordResetEndpoint,
+			decodeGRPCRequestPasswordResetRequest,
+			encodeGRPCRequestPasswordResetResponse,
+			options...,
+		),
+		resetPassword: grpctransport.NewServer(
+			endpoints.ResetPasswordEndpoint,
+			decodeGRPCResetPasswordRequest,
+			encodeGRPCResetPasswordResponse,
+			options...,
+		),
+	}
+}
+
+func (g *grpcServer) Register(ctx context.Context, request *pb.RegisterRequest) (*pb.RegisterReply, error) {
+	_, rep, err := g.register.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RegisterReply), nil
+}
+
+func (g *grpcServer) Login(ctx context.Context, request *pb.LoginRequest) (*pb.LoginReply, error) {
+	_, rep, err := g.login.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.LoginReply), nil
+}
+
+func (g *grpcServer) ChangePassword(ctx context.Context, request *pb.ChangePasswordRequest) (*pb.ChangePasswordReply, error) {
+	_, rep, err := g.changePassword.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ChangePasswordReply), nil
+}
+
+func (g *grpcServer) RequestPasswordReset(ctx context.Context, request *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetReply, error) {
+	_, rep, err := g.requestPasswordReset.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RequestPasswordResetReply), nil
+}
+
+func (g *grpcServer) ResetPassword(ctx context.Context, request *pb.ResetPasswordRequest) (*pb.ResetPasswordReply, error) {
+	_, rep, err := g.resetPassword.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ResetPasswordReply), nil
+}
+
+// NewGRPCClient returns a Set calling authsvc over conn, which implements
+// Service.
+func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger, extra ...grpctransport.ClientOption) authendpoint.Set {
+	var registerEndpoint endpoint.Endpoint
+	{
+		registerEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Auth",
+			"Register",
+			encodeGRPCRegisterRequest,
+			decodeGRPCRegisterResponse,
+			pb.RegisterReply{},
+			extra...,
+		).Endpoint()
+	}
+	var loginEndpoint endpoint.Endpoint
+	{
+		loginEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Auth",
+			"Login",
+			encodeGRPCLoginRequest,
+			decodeGRPCLoginResponse,
+			pb.LoginReply{},
+			extra...,
+		).Endpoint()
+	}
+	var changePasswordEndpoint endpoint.Endpoint
+	{
+		changePasswordEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Auth",
+			"ChangePassword",
+			encodeGRPCChangePasswordRequest,
+			decodeGRPCChangePasswordResponse,
+			pb.ChangePasswordReply{},
+			extra...,
+		).Endpoint()
+	}
+	var requestPasswordResetEndpoint endpoint.Endpoint
+	{
+		requestPasswordResetEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Auth",
+			"RequestPasswordReset",
+			encodeGRPCRequestPasswordResetRequest,
+			decodeGRPCRequestPasswordResetResponse,
+			pb.RequestPasswordResetReply{},
+			extra...,
+		).Endpoint()
+	}
+	var resetPasswordEndpoint endpoint.Endpoint
+	{
+		resetPasswordEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Auth",
+			"ResetPassword",
+			encodeGRPCResetPasswordRequest,
+			decodeGRPCResetPasswordResponse,
+			pb.ResetPasswordReply{},
+			extra...,
+		).Endpoint()
+	}
+	return authendpoint.Set{
+		RegisterEndpoint:             registerEndpoint,
+		LoginEndpoint:                loginEndpoint,
+		ChangePasswordEndpoint:       changePasswordEndpoint,
+		RequestPasswordResetEndpoint: requestPasswordResetEndpoint,
+		ResetPasswordEndpoint:        resetPasswordEndpoint,
+	}
+}
+
+// decodeGRPCRegisterRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC register request to an auth-domain request. Primarily useful in a server.
+func decodeGRPCRegisterRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RegisterRequest)
+	return authendpoint.RegisterRequest{Email: req.Email, Password: req.Password}, nil
+}
+
+// encodeGRPCRegisterResponse is a transport/grpc.EncodeResponseFunc that converts an
+// auth-domain response to a gRPC register reply. Primarily useful in a server.
+func encodeGRPCRegisterResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RegisterResponse)
+	reply := &pb.RegisterReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.Account = accountToPB(resp.Account)
+	}
+	return reply, nil
+}
+
+// encodeGRPCRegisterRequest is a transport/grpc.EncodeRequestFunc that converts an
+// auth-domain request to a gRPC register request. Primarily useful in a client.
+func encodeGRPCRegisterRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RegisterRequest)
+	return &pb.RegisterRequest{Email: req.Email, Password: req.Password}, nil
+}
+
+// decodeGRPCRegisterResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC register reply to an auth-domain response. Primarily useful in a client.
+func decodeGRPCRegisterResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RegisterReply)
+	return authendpoint.RegisterResponse{Account: pbToAccount(reply.Account), Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCLoginRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC login request to an auth-domain request. Primarily useful in a server.
+func decodeGRPCLoginRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.LoginRequest)
+	return authendpoint.LoginRequest{Email: req.Email, Password: req.Password}, nil
+}
+
+// encodeGRPCLoginResponse is a transport/grpc.EncodeResponseFunc that converts an
+// auth-domain response to a gRPC login reply. Primarily useful in a server.
+func encodeGRPCLoginResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.LoginResponse)
+	return &pb.LoginReply{Token: resp.Token, ExpiresAt: unixNano(resp.ExpiresAt), Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCLoginRequest is a transport/grpc.EncodeRequestFunc that converts an
+// auth-domain request to a gRPC login request. Primarily useful in a client.
+func encodeGRPCLoginRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.LoginRequest)
+	return &pb.LoginRequest{Email: req.Email, Password: req.Password}, nil
+}
+
+// decodeGRPCLoginResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC login reply to an auth-domain response. Primarily useful in a client.
+func decodeGRPCLoginResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.LoginReply)
+	return authendpoint.LoginResponse{Token: reply.Token, ExpiresAt: timeFromUnixNano(reply.ExpiresAt), Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCChangePasswordRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC change password request to an auth-domain request. Primarily useful in a server.
+func decodeGRPCChangePasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ChangePasswordRequest)
+	return authendpoint.ChangePasswordRequest{ID: req.Id, CurrentPassword: req.CurrentPassword, NewPassword: req.NewPassword}, nil
+}
+
+// encodeGRPCChangePasswordResponse is a transport/grpc.EncodeResponseFunc that converts an
+// auth-domain response to a gRPC change password reply. Primarily useful in a server.
+func encodeGRPCChangePasswordResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ChangePasswordResponse)
+	return &pb.ChangePasswordReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCChangePasswordRequest is a transport/grpc.EncodeRequestFunc that converts an
+// auth-domain request to a gRPC change password request. Primarily useful in a client.
+func encodeGRPCChangePasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ChangePasswordRequest)
+	return &pb.ChangePasswordRequest{Id: req.ID, CurrentPassword: req.CurrentPassword, NewPassword: req.NewPassword}, nil
+}
+
+// decodeGRPCChangePasswordResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC change password reply to an auth-domain response. Primarily useful in a client.
+func decodeGRPCChangePasswordResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ChangePasswordReply)
+	return authendpoint.ChangePasswordResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCRequestPasswordResetRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC request password reset request to an auth-domain request. Primarily useful in a server.
+func decodeGRPCRequestPasswordResetRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RequestPasswordResetRequest)
+	return authendpoint.RequestPasswordResetRequest{Email: req.Email}, nil
+}
+
+// encodeGRPCRequestPasswordResetResponse is a transport/grpc.EncodeResponseFunc that converts an
+// auth-domain response to a gRPC request password reset reply. Primarily useful in a server.
+func encodeGRPCRequestPasswordResetResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.RequestPasswordResetResponse)
+	return &pb.RequestPasswordResetReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCRequestPasswordResetRequest is a transport/grpc.EncodeRequestFunc that converts an
+// auth-domain request to a gRPC request password reset request. Primarily useful in a client.
+func encodeGRPCRequestPasswordResetRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.RequestPasswordResetRequest)
+	return &pb.RequestPasswordResetRequest{Email: req.Email}, nil
+}
+
+// decodeGRPCRequestPasswordResetResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC request password reset reply to an auth-domain response. Primarily useful in a client.
+func decodeGRPCRequestPasswordResetResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RequestPasswordResetReply)
+	return authendpoint.RequestPasswordResetResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCResetPasswordRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC reset password request to an auth-domain request. Primarily useful in a server.
+func decodeGRPCResetPasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ResetPasswordRequest)
+	return authendpoint.ResetPasswordRequest{Token: req.Token, NewPassword: req.NewPassword}, nil
+}
+
+// encodeGRPCResetPasswordResponse is a transport/grpc.EncodeResponseFunc that converts an
+// auth-domain response to a gRPC reset password reply. Primarily useful in a server.
+func encodeGRPCResetPasswordResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(authendpoint.ResetPasswordResponse)
+	return &pb.ResetPasswordReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCResetPasswordRequest is a transport/grpc.EncodeRequestFunc that converts an
+// auth-domain request to a gRPC reset password request. Primarily useful in a client.
+func encodeGRPCResetPasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(authendpoint.ResetPasswordRequest)
+	return &pb.ResetPasswordRequest{Token: req.Token, NewPassword: req.NewPassword}, nil
+}
+
+// decodeGRPCResetPasswordResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reset password reply to an auth-domain response. Primarily useful in a client.
+func decodeGRPCResetPasswordResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ResetPasswordReply)
+	return authendpoint.ResetPasswordResponse{Err: str2err(reply.Err)}, nil
+}
+
+func accountToPB(a model.Account) *pb.Account {
+	return &pb.Account{
+		Id:                a.ID,
+		Email:             a.Email,
+		CreatedAt:         unixNano(a.CreatedAt),
+		PasswordChangedAt: unixNano(a.PasswordChangedAt),
+	}
+}
+
+func pbToAccount(a *pb.Account) model.Account {
+	if a == nil {
+		return model.Account{}
+	}
+	return model.Account{
+		ID:                a.Id,
+		Email:             a.Email,
+		CreatedAt:         timeFromUnixNano(a.CreatedAt),
+		PasswordChangedAt: timeFromUnixNano(a.PasswordChangedAt),
+	}
+}
+
+// unixNano converts t for a proto field, where 0 stands for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// timeFromUnixNano is the inverse of unixNano.
+func timeFromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+func str2err(s string) error {
+	switch s {
+	case "":
+		return nil
+	case authservice.ErrEmailTaken.Error():
+		return authservice.ErrEmailTaken
+	case authservice.ErrInvalidEmail.Error():
+		return authservice.ErrInvalidEmail
+	case authservice.ErrWeakPassword.Error():
+		return authservice.ErrWeakPassword
+	case authservice.ErrInvalidCredentials.Error():
+		return authservice.ErrInvalidCredentials
+	case authservice.ErrAccountLocked.Error():
+		return authservice.ErrAccountLocked
+	case authservice.ErrInvalidResetToken.Error():
+		return authservice.ErrInvalidResetToken
+	}
+	return errors.New(s)
+}
+
+func err2str(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,130 @@
+package authtransport
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pb"
+	authendpoint "github.com/yuisofull/gommunigate/internal/authsvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/authsvc/pkg/model"
+	authservice "github.com/yuisofull/gommunigate/internal/authsvc/pkg/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stubService records the arguments of its calls and fails them with err.
+type stubService struct {
+	err  error
+	args []string
+}
+
+var (
+	testCreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	testExpiresAt = time.Date(2026, 1, 2, 4, 4, 5, 0, time.UTC)
+)
+
+func (s *stubService) Register(_ context.Context, email, password string) (model.Account, error) {
+	s.args = []string{email, password}
+	return model.Account{ID: "a1", Email: email, CreatedAt: testCreatedAt, PasswordChangedAt: testCreatedAt, PasswordHash: "hash"}, s.err
+}
+
+func (s *stubService) Login(_ context.Context, email, password string) (string, time.Time, error) {
+	s.args = []string{email, password}
+	return "token", testExpiresAt, s.err
+}
+
+func (s *stubService) ChangePassword(_ context.Context, id, currentPassword, newPassword string) error {
+	s.args = []string{id, currentPassword, newPassword}
+	return s.err
+}
+
+func (s *stubService) RequestPasswordReset(_ context.Context, email string) error {
+	s.args = []string{email}
+	return s.err
+}
+
+func (s *stubService) ResetPassword(_ context.Context, token, newPassword string) error {
+	s.args = []string{token, newPassword}
+	return s.err
+}
+
+// testClient serves s over gRPC and returns a client of it.
+func testClient(t *testing.T, s authservice.Service) authendpoint.Set {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := authendpoint.Metrics{Requests: discard.NewCounter(), Duration: discard.NewHistogram()}
+	server := grpc.NewServer()
+	pb.RegisterAuthServer(server, NewGRPCServer(authendpoint.New(s, log.NewNopLogger(), m), log.NewNopLogger()))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewGRPCClient(conn, log.NewNopLogger())
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	s := &stubService{}
+	client := testClient(t, s)
+	ctx := context.Background()
+
+	a, err := client.Register(ctx, "ada@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.Account{ID: "a1", Email: "ada@example.com", CreatedAt: testCreatedAt, PasswordChangedAt: testCreatedAt}
+	if a != want {
+		t.Errorf("Register() = %+v, want %+v without the password hash", a, want)
+	}
+	token, expiresAt, err := client.Login(ctx, "ada@example.com", "correct horse")
+	if err != nil || token != "token" || !expiresAt.Equal(testExpiresAt) {
+		t.Errorf("Login() = %q, %v, %v", token, expiresAt, err)
+	}
+	for name, call := range map[string]struct {
+		call func() error
+		args []string
+	}{
+		"ChangePassword":       {func() error { return client.ChangePassword(ctx, "a1", "old", "new") }, []string{"a1", "old", "new"}},
+		"RequestPasswordReset": {func() error { return client.RequestPasswordReset(ctx, "ada@example.com") }, []string{"ada@example.com"}},
+		"ResetPassword":        {func() error { return client.ResetPassword(ctx, "gmp_token", "new") }, []string{"gmp_token", "new"}},
+	} {
+		if err := call.call(); err != nil {
+			t.Errorf("%s() = %v", name, err)
+		}
+		if !slices.Equal(s.args, call.args) {
+			t.Errorf("%s() got %q, want %q", name, s.args, call.args)
+		}
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	s := &stubService{}
+	client := testClient(t, s)
+	for _, err := range []error{
+		authservice.ErrEmailTaken,
+		authservice.ErrInvalidEmail,
+		authservice.ErrWeakPassword,
+		authservice.ErrInvalidCredentials,
+		authservice.ErrAccountLocked,
+		authservice.ErrInvalidResetToken,
+	} {
+		s.err = err
+		if _, _, got := client.Login(context.Background(), "ada@example.com", "password"); !errors.Is(got, err) {
+			t.Errorf("Login() = %v, want %v", got, err)
+		}
+	}
+	s.err = errors.New("mongo: no reachable servers")
+	if got := client.ChangePassword(context.Background(), "a1", "old", "new"); got == nil || got.Error() != s.err.Error() {
+		t.Errorf("ChangePassword() = %v, want %v", got, s.err)
+	}
+}
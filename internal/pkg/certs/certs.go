@@ -0,0 +1,216 @@
+// Package certs builds TLS configurations from certificate, key and CA files,
+// reloading the files when their content changes so that certificates can be
+// rotated without a restart.
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds a certificate and key pair and, optionally, a bundle of CA
+// certificates that peers must be signed by.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	logger                    log.Logger
+
+	mtx  sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+	sum  []byte
+}
+
+// NewReloader loads the files. certFile and keyFile may be empty for a client
+// that doesn't authenticate itself; caFile may be empty for a server that
+// doesn't verify its clients.
+func NewReloader(certFile, keyFile, caFile string, logger log.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, logger: logger}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run reloads the files whenever their content changes, checking every
+// interval, until ctx is done. Files that fail to load are logged and ignored,
+// leaving the previous ones in use.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		changed, err := r.reload()
+		switch {
+		case err != nil:
+			r.logger.Log("certs", "rejected", "err", err)
+		case changed:
+			r.logger.Log("certs", "reloaded", "cert", r.certFile, "ca", r.caFile)
+		}
+	}
+}
+
+// reload loads the files if their content changed since the last load.
+func (r *Reloader) reload() (changed bool, err error) {
+	var (
+		contents [3][]byte
+		h        = sha256.New()
+	)
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		if contents[i], err = os.ReadFile(path); err != nil {
+			return false, err
+		}
+		h.Write(contents[i])
+	}
+	sum := h.Sum(nil)
+	r.mtx.RLock()
+	unchanged := bytes.Equal(sum, r.sum)
+	r.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+	if r.certFile != "" || r.keyFile != "" {
+		c, err := tls.X509KeyPair(contents[0], contents[1])
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", r.certFile, err)
+		}
+		cert = &c
+	}
+	if r.caFile != "" {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("%s: no CA certificates found", r.caFile)
+		}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.cert, r.pool, r.sum = cert, pool, sum
+	return true, nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the configuration of a server presenting the current
+// certificate. With a CA bundle, clients must present a certificate signed by
+// one of its CAs.
+func (r *Reloader) ServerConfig() *tls.Config {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	if r.caFile != "" {
+		// The CAs may change, so they are checked by verifyClient rather than
+		// through a fixed ClientCAs pool.
+		c.ClientAuth = tls.RequireAnyClientCert
+		c.VerifyPeerCertificate = r.verifyClient
+	}
+	return c
+}
+
+// ClientConfig returns the configuration of a client that verifies servers
+// against the current CA bundle, expecting serverName in their certificate if
+// it is set and the host dialed otherwise, and that presents the current
+// certificate if there is one.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := r.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		// The CAs may change, so the server is verified by VerifyConnection
+		// rather than through a fixed RootCAs pool.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return r.verifyServer(cs, serverName)
+		},
+	}
+}
+
+func (r *Reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	_, pool := r.current()
+	certs, err := parseCertificates(rawCerts)
+	if err != nil {
+		return err
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates(certs),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func (r *Reloader) verifyServer(cs tls.ConnectionState, serverName string) error {
+	_, pool := r.current()
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	if serverName == "" {
+		serverName = cs.ServerName
+	}
+	if serverName == "" {
+		// Servers dialed by IP address send no SNI to take the name from.
+		return errors.New("server name unknown, it must be set for servers dialed by IP address")
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates(cs.PeerCertificates),
+		DNSName:       serverName,
+	})
+	return err
+}
+
+func parseCertificates(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs[i] = c
+	}
+	return certs, nil
+}
+
+func intermediates(chain []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		pool.AddCert(c)
+	}
+	return pool
+}
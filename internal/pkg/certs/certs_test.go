@@ -0,0 +1,224 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf for usage, with the DNS
+// and URI SANs given.
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage, dnsNames []string, uris ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte) string {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serveHealth serves the gRPC health service with TLS from r, behind a
+// PeerAuthorizer allowing allowedSANs, and returns its address.
+func serveHealth(t *testing.T, r *Reloader, allowedSANs ...string) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := NewPeerAuthorizer(allowedSANs)
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(r.ServerConfig())),
+		grpc.UnaryInterceptor(authorizer.UnaryInterceptor),
+		grpc.StreamInterceptor(authorizer.StreamInterceptor),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// check calls the health service at addr with TLS from r.
+func check(t *testing.T, addr string, r *Reloader) error {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(r.ClientConfig("usersvc.internal"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestPeerAuthorizer(t *testing.T) {
+	dir := t.TempDir()
+	ca, otherCA := newTestCA(t, "test CA"), newTestCA(t, "other CA")
+	var files int
+	reloader := func(cert, key, caPEM []byte) *Reloader {
+		paths := make([]string, 3)
+		for i, content := range [][]byte{cert, key, caPEM} {
+			if content != nil {
+				files++
+				paths[i] = writeFile(t, filepath.Join(dir, strconv.Itoa(files)+".pem"), content)
+			}
+		}
+		r, err := NewReloader(paths[0], paths[1], paths[2], log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	client := func(ca *testCA, dnsNames []string, uris ...string) *Reloader {
+		cert, key := ca.issue(t, "client", x509.ExtKeyUsageClientAuth, dnsNames, uris...)
+		return reloader(cert, key, ca.pem)
+	}
+
+	serverCert, serverKey := ca.issue(t, "usersvc", x509.ExtKeyUsageServerAuth, []string{"usersvc.internal"})
+	mTLS := serveHealth(t, reloader(serverCert, serverKey, ca.pem), "gateway.internal", "spiffe://example.org/apigateway")
+	// Without a CA bundle the server doesn't ask for client certificates.
+	tlsOnly := serveHealth(t, reloader(serverCert, serverKey, nil), "gateway.internal")
+
+	for _, tc := range []struct {
+		name   string
+		addr   string
+		client *Reloader
+		want   codes.Code
+	}{
+		{"allowed DNS SAN", mTLS, client(ca, []string{"gateway.internal"}), codes.OK},
+		{"allowed URI SAN", mTLS, client(ca, nil, "spiffe://example.org/apigateway"), codes.OK},
+		{"unknown SAN", mTLS, client(ca, []string{"intruder.internal"}, "spiffe://example.org/intruder"), codes.PermissionDenied},
+		// The handshake fails, so the call never reaches the authorizer.
+		{"no client certificate", mTLS, reloader(nil, nil, ca.pem), codes.Unavailable},
+		{"certificate of another CA", mTLS, client(otherCA, []string{"gateway.internal"}), codes.Unavailable},
+		{"no client certificate asked for", tlsOnly, client(ca, []string{"gateway.internal"}), codes.PermissionDenied},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status.Code(check(t, tc.addr, tc.client)); got != tc.want {
+				t.Errorf("call answered %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReloaderReload(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	cert1, key1 := ca.issue(t, "first", x509.ExtKeyUsageServerAuth, []string{"usersvc.internal"})
+	cert2, key2 := ca.issue(t, "second", x509.ExtKeyUsageServerAuth, []string{"usersvc.internal"})
+	dir := t.TempDir()
+	certFile := writeFile(t, filepath.Join(dir, "cert.pem"), cert1)
+	keyFile := writeFile(t, filepath.Join(dir, "key.pem"), key1)
+	r, err := NewReloader(certFile, keyFile, "", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commonName := func() string {
+		cert, _ := r.current()
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+
+	if changed, err := r.reload(); changed || err != nil {
+		t.Errorf("reload() of unchanged files = %v, %v", changed, err)
+	}
+	writeFile(t, certFile, cert2)
+	if _, err := r.reload(); err == nil {
+		t.Error("mismatched certificate and key loaded")
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("serving %q after a failed reload, want the previous certificate", got)
+	}
+	writeFile(t, keyFile, key2)
+	if changed, err := r.reload(); !changed || err != nil {
+		t.Errorf("reload() of rotated files = %v, %v", changed, err)
+	}
+	if got := commonName(); got != "second" {
+		t.Errorf("serving %q after rotation", got)
+	}
+}
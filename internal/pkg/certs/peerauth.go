@@ -0,0 +1,65 @@
+package certs
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// PeerAuthorizer admits calls from peers whose client certificate, verified
+// during the mTLS handshake, has one of the allowed DNS or URI SANs, e.g.
+// "gateway.internal" or "spiffe://example.org/apigateway".
+type PeerAuthorizer struct {
+	allowed map[string]bool
+}
+
+func NewPeerAuthorizer(allowedSANs []string) *PeerAuthorizer {
+	a := &PeerAuthorizer{allowed: map[string]bool{}}
+	for _, san := range allowedSANs {
+		a.allowed[san] = true
+	}
+	return a
+}
+
+// UnaryInterceptor rejects unary calls from other peers with PermissionDenied.
+func (a *PeerAuthorizer) UnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects streams from other peers with PermissionDenied.
+func (a *PeerAuthorizer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (a *PeerAuthorizer) authorize(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unknown peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return status.Error(codes.PermissionDenied, "no client certificate")
+	}
+	// The server's tls.Config has verified the certificate against the CAs.
+	leaf := info.State.PeerCertificates[0]
+	for _, name := range leaf.DNSNames {
+		if a.allowed[name] {
+			return nil
+		}
+	}
+	for _, uri := range leaf.URIs {
+		if a.allowed[uri.String()] {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "client certificate %q is not allowed", leaf.Subject.CommonName)
+}
@@ -0,0 +1,121 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/consul/api"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ConsulRegistrar registers an instance with the local Consul agent under a TTL
+// health check, which it keeps passing for as long as check succeeds. An
+// instance that stops reporting, e.g. because it crashed, turns critical and is
+// removed by Consul after deregisterAfter.
+type ConsulRegistrar struct {
+	agent  *api.Agent
+	reg    *api.AgentServiceRegistration
+	ttl    time.Duration
+	check  func(context.Context) error
+	logger log.Logger
+}
+
+const deregisterAfter = time.Minute
+
+// NewConsulRegistrar describes the instance id of service name, reachable at
+// host and port.
+func NewConsulRegistrar(client *api.Client, name, id, host string, port int, ttl time.Duration, check func(context.Context) error, logger log.Logger) *ConsulRegistrar {
+	return &ConsulRegistrar{
+		agent: client.Agent(),
+		reg: &api.AgentServiceRegistration{
+			ID:      id,
+			Name:    name,
+			Address: host,
+			Port:    port,
+			Check: &api.AgentServiceCheck{
+				CheckID:                        checkID(id),
+				TTL:                            ttl.String(),
+				DeregisterCriticalServiceAfter: deregisterAfter.String(),
+			},
+		},
+		ttl:    ttl,
+		check:  check,
+		logger: logger,
+	}
+}
+
+// Run registers the instance, reports its health a few times per TTL until ctx
+// is done and deregisters it before returning.
+func (r *ConsulRegistrar) Run(ctx context.Context) error {
+	if err := r.agent.ServiceRegister(r.reg); err != nil {
+		return fmt.Errorf("register with consul: %w", err)
+	}
+	r.logger.Log("consul", "registered", "service", r.reg.Name, "id", r.reg.ID, "address", r.reg.Address, "port", r.reg.Port)
+	defer func() {
+		if err := r.agent.ServiceDeregister(r.reg.ID); err != nil {
+			r.logger.Log("consul", "deregister", "err", err)
+			return
+		}
+		r.logger.Log("consul", "deregistered", "id", r.reg.ID)
+	}()
+
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		r.heartbeat(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *ConsulRegistrar) heartbeat(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, r.ttl/3)
+	defer cancel()
+
+	status, output := api.HealthPassing, ""
+	if err := r.check(ctx); err != nil {
+		if ctx.Err() == context.Canceled {
+			// Shutting down; the deregistration follows.
+			return
+		}
+		status, output = api.HealthCritical, err.Error()
+	}
+	if err := r.agent.UpdateTTL(checkID(r.reg.ID), output, status); err != nil {
+		r.logger.Log("consul", "heartbeat", "err", err)
+	}
+}
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID + ":ttl"
+}
+
+// AdvertisedHostPort splits advertise, or the hostname and the port of listen
+// when advertise is empty.
+func AdvertisedHostPort(advertise, listen string) (string, int, error) {
+	if advertise == "" {
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return "", 0, err
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", 0, err
+		}
+		advertise = net.JoinHostPort(hostname, port)
+	}
+	host, port, err := net.SplitHostPort(advertise)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, p, nil
+}
@@ -0,0 +1,123 @@
+// Package instance keeps the instances of a service known to their clients:
+// it reports their health through the gRPC health checking protocol and
+// registers them with Consul.
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"sync"
+	"time"
+)
+
+// HealthCheck checks that a dependency of the instance, such as its database,
+// is usable.
+type HealthCheck struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+// HealthMonitor runs the health checks of an instance periodically and reports
+// the outcome through the gRPC health checking protocol: the instance and all
+// of its services are SERVING while every check passes, and NOT_SERVING
+// otherwise.
+type HealthMonitor struct {
+	server   *health.Server
+	services []string
+	checks   []HealthCheck
+	interval time.Duration
+	timeout  time.Duration
+	logger   log.Logger
+
+	mtx  sync.Mutex
+	last error
+}
+
+var errNotChecked = errors.New("not checked yet")
+
+// NewHealthMonitor reports the health of services through server, running
+// checks every interval, each bounded by timeout.
+func NewHealthMonitor(server *health.Server, services []string, interval, timeout time.Duration, logger log.Logger, checks ...HealthCheck) *HealthMonitor {
+	m := &HealthMonitor{
+		server:   server,
+		services: services,
+		checks:   checks,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logger,
+		last:     errNotChecked,
+	}
+	m.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return m
+}
+
+// Run checks the instance at once and then every interval until ctx is done.
+// Before returning it reports NOT_SERVING for good, so clients move away from
+// the instance while it drains.
+func (m *HealthMonitor) Run(ctx context.Context) error {
+	defer m.server.Shutdown()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.update(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs every check and reports those that failed.
+func (m *HealthMonitor) Check(ctx context.Context) error {
+	var errs []error
+	for _, c := range m.checks {
+		ctx, cancel := context.WithTimeout(ctx, m.timeout)
+		err := c.Check(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ready returns the outcome of the last round of checks.
+func (m *HealthMonitor) Ready() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.last
+}
+
+func (m *HealthMonitor) update(ctx context.Context) {
+	err := m.Check(ctx)
+	if ctx.Err() != nil {
+		// Shutting down; Run reports NOT_SERVING anyway.
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	switch {
+	case err == nil && m.last != nil:
+		m.logger.Log("health", "SERVING")
+		m.setStatus(healthpb.HealthCheckResponse_SERVING)
+	case err != nil && (m.last == nil || m.last.Error() != err.Error()):
+		m.logger.Log("health", "NOT_SERVING", "err", err)
+		m.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	m.last = err
+}
+
+func (m *HealthMonitor) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	// The empty service name stands for the instance as a whole.
+	m.server.SetServingStatus("", status)
+	for _, s := range m.services {
+		m.server.SetServingStatus(s, status)
+	}
+}
@@ -0,0 +1,83 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+)
+
+func servingStatus(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GetStatus()
+}
+
+func TestHealthMonitor(t *testing.T) {
+	var dbErr error
+	server := health.NewServer()
+	m := NewHealthMonitor(server, []string{"pb.User"}, time.Hour, time.Second, log.NewNopLogger(), HealthCheck{
+		Name:  "mongodb",
+		Check: func(context.Context) error { return dbErr },
+	})
+	if got := servingStatus(t, server, "pb.User"); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("unchecked instance is %s", got)
+	}
+	if m.Ready() == nil {
+		t.Error("unchecked instance is ready")
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"failing check", errors.New("not connected"), healthpb.HealthCheckResponse_NOT_SERVING},
+		{"passing check", nil, healthpb.HealthCheckResponse_SERVING},
+		{"failing again", errors.New("timeout"), healthpb.HealthCheckResponse_NOT_SERVING},
+	} {
+		dbErr = tc.err
+		m.update(context.Background())
+		for _, service := range []string{"", "pb.User"} {
+			if got := servingStatus(t, server, service); got != tc.want {
+				t.Errorf("%s: service %q is %s, want %s", tc.name, service, got, tc.want)
+			}
+		}
+		if err := m.Ready(); !errors.Is(err, tc.err) {
+			t.Errorf("%s: Ready() = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestHealthMonitorRun(t *testing.T) {
+	server := health.NewServer()
+	m := NewHealthMonitor(server, []string{"pb.User"}, 5*time.Millisecond, time.Second, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("instance never checked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := servingStatus(t, server, "pb.User"); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("healthy instance is %s", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if got := servingStatus(t, server, "pb.User"); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("stopped instance is %s", got)
+	}
+}
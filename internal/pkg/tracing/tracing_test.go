@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	for _, tc := range []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{"", false},
+		{"none", false},
+		{"stdout", false},
+		{"zipkin", true},
+	} {
+		shutdown, err := Setup(context.Background(), "test", Config{Exporter: tc.exporter, SampleRatio: 1})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("exporter %q: err = %v, want error %v", tc.exporter, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("exporter %q: shutdown: %v", tc.exporter, err)
+		}
+		if fields := otel.GetTextMapPropagator().Fields(); len(fields) == 0 {
+			t.Errorf("exporter %q: no propagator installed", tc.exporter)
+		}
+	}
+}
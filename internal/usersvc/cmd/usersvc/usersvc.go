@@ -3,21 +3,42 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	"github.com/hashicorp/consul/api"
+	"github.com/nats-io/nats.go"
 	"github.com/oklog/oklog/pkg/group"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/yuisofull/gommunigate/internal/pkg/certs"
+	"github.com/yuisofull/gommunigate/internal/pkg/instance"
+	"github.com/yuisofull/gommunigate/internal/pkg/tracing"
 	userpb "github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	usercache "github.com/yuisofull/gommunigate/internal/usersvc/pkg/cache"
 	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	userevents "github.com/yuisofull/gommunigate/internal/usersvc/pkg/events"
 	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/infrastructure"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
 	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
 	usertransport "github.com/yuisofull/gommunigate/internal/usersvc/pkg/transport"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -26,9 +47,54 @@ func main() {
 	fs := flag.NewFlagSet("usersvc", flag.ExitOnError)
 	var (
 		grpcAddr   = fs.String("grpc-addr", ":8081", "gRPC listen address")
+		debugAddr  = fs.String("debug-addr", ":8082", "Debug and metrics listen address")
 		mongodbURI = fs.String("mongodb-uri", "mongodb://localhost:27017", "MongoDB URI")
 		mongodbDB  = fs.String("mongodb-db", "usersvc", "MongoDB database")
 		mongodbCol = fs.String("mongodb-col", "users", "MongoDB collection")
+		auditCol   = fs.String("mongodb-audit-col", "audit", "MongoDB collection for the profile audit log")
+		adminCol   = fs.String("mongodb-admin-actions-col", "admin_actions", "MongoDB collection recording the actions of moderators and admins")
+		apiKeysCol = fs.String("mongodb-apikeys-col", "apikeys", "MongoDB collection for API keys")
+		sessionCol = fs.String("mongodb-sessions-col", "sessions", "MongoDB collection for sign-in sessions")
+		sessionTTL = fs.Duration("session-ttl", 30*24*time.Hour, "How long a session lasts after its refresh token was last used")
+		totpIssuer = fs.String("totp-issuer", "gommunigate", "Issuer authenticator apps show TOTP second factors under")
+
+		totpLockoutAttempts = fs.Int("totp-lockout-attempts", 5, "Invalid two-factor codes in a row that lock a second factor, 0 to never lock them")
+		totpLockoutDuration = fs.Duration("totp-lockout-duration", 15*time.Minute, "How long a second factor stays locked")
+
+		eventsPublisher   = fs.String("events-publisher", "none", "Where to publish user lifecycle events: none, inproc or nats")
+		outboxCol         = fs.String("mongodb-outbox-col", "outbox", "MongoDB collection for the event outbox")
+		outboxRetention   = fs.Duration("outbox-retention", 7*24*time.Hour, "How long published events are kept in the outbox")
+		outboxInterval    = fs.Duration("outbox-poll-interval", time.Second, "How often the relay polls the outbox")
+		natsURL           = fs.String("nats-url", nats.DefaultURL, "NATS server URL")
+		natsStream        = fs.String("nats-stream", "USERS", "NATS JetStream stream for user events")
+		natsSubjectPrefix = fs.String("nats-subject-prefix", "users.events", "NATS subject prefix for user events")
+
+		cacheSize        = fs.Int("cache-size", 10000, "Number of profiles kept in the in-process cache, 0 to disable it")
+		cacheTTL         = fs.Duration("cache-ttl", 30*time.Second, "How long a cached profile is served")
+		cacheNegativeTTL = fs.Duration("cache-negative-ttl", 5*time.Second, "How long an unknown user is remembered as such")
+		redisAddr        = fs.String("redis-addr", "", "Optional Redis-protocol server for a cache shared between instances")
+		redisKeyPrefix   = fs.String("redis-key-prefix", "usersvc:profile:", "Key prefix for profiles cached in Redis")
+
+		consulAddr    = fs.String("consul-addr", "", "Optional Consul agent to register this instance with")
+		consulService = fs.String("consul-service", "usersvc", "Service name to register in Consul")
+		consulTTL     = fs.Duration("consul-ttl", 10*time.Second, "TTL of the Consul health check, reported a few times per TTL")
+		advertiseAddr = fs.String("advertise-addr", "", "Address other services reach the gRPC server at, by default the hostname and the port of grpc-addr")
+
+		tlsCert           = fs.String("tls-cert", "", "Certificate file of the gRPC server; TLS is enabled when set")
+		tlsKey            = fs.String("tls-key", "", "Key file of the gRPC server certificate")
+		tlsClientCA       = fs.String("tls-client-ca", "", "CA bundle that client certificates must be signed by; requires clients to authenticate (mTLS)")
+		tlsAllowedSANs    = fs.String("tls-allowed-sans", "", "Comma-separated DNS or URI SANs of the client certificates allowed to call, e.g. gateway.internal; any verified client when empty")
+		tlsReloadInterval = fs.Duration("tls-reload-interval", 10*time.Second, "How often to check the certificate files for changes, 0 to never reload them")
+
+		identityKeyFile = fs.String("identity-key-file", "", "HMAC key shared with the gateway to verify the callers it forwards; without it every change is denied")
+
+		healthInterval = fs.Duration("health-interval", 5*time.Second, "How often the dependencies are checked for the gRPC health service and /readyz")
+		healthTimeout  = fs.Duration("health-timeout", 2*time.Second, "Timeout of each dependency check")
+
+		traceExporter    = fs.String("trace-exporter", "none", "Where to export traces: none, stdout or otlp")
+		otlpEndpoint     = fs.String("otlp-endpoint", "localhost:4317", "OTLP/gRPC collector address for the otlp trace exporter")
+		otlpInsecure     = fs.Bool("otlp-insecure", true, "Connect to the OTLP collector without TLS")
+		traceSampleRatio = fs.Float64("trace-sample-ratio", 1, "Fraction of traces started here to record; calls from a traced caller follow its decision")
 	)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -46,7 +112,35 @@ func main() {
 		os.Exit(1)
 	}
 
-	var repo userservice.Repository
+	{
+		shutdown, err := tracing.Setup(ctx, "usersvc", tracing.Config{
+			Exporter:    *traceExporter,
+			Endpoint:    *otlpEndpoint,
+			Insecure:    *otlpInsecure,
+			SampleRatio: *traceSampleRatio,
+		})
+		if err != nil {
+			logger.Log("tracing", *traceExporter, "err", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdown(ctx)
+		}()
+		logger.Log("tracing", *traceExporter, "sampleRatio", *traceSampleRatio)
+	}
+
+	var (
+		repo      userservice.Repository
+		auditRepo userservice.AuditRepository
+		adminRepo userservice.AdminActionRepository
+		keysRepo  userservice.APIKeyRepository
+		sessRepo  userservice.SessionRepository
+		tfRepo    userservice.TwoFactorRepository
+		outbox    userevents.Outbox
+		ping      func(context.Context) error
+	)
 	{
 		client, err := mongo.Connect(options.Client().ApplyURI(*mongodbURI))
 		if err != nil {
@@ -63,15 +157,201 @@ func main() {
 		logger.Log("repository", "MongoDB", "uri", *mongodbURI, "db", *mongodbDB, "collection", *mongodbCol)
 
 		defer client.Disconnect(ctx)
-		repo = infrastructure.NewMongoRepository(client, *mongodbDB, *mongodbCol)
+		ping = func(ctx context.Context) error { return client.Ping(ctx, readpref.Primary()) }
+		users := infrastructure.NewMongoRepository(client, *mongodbDB, *mongodbCol)
+		repo = users
+		tfRepo = users
+		auditRepo = infrastructure.NewMongoAuditRepository(client, *mongodbDB, *auditCol)
+		adminRepo = infrastructure.NewMongoAdminActionRepository(client, *mongodbDB, *adminCol)
+		keys := infrastructure.NewMongoAPIKeyRepository(client, *mongodbDB, *apiKeysCol)
+		if err := keys.EnsureIndexes(ctx); err != nil {
+			logger.Log("apikeys", *apiKeysCol, "during", "EnsureIndexes", "err", err)
+			os.Exit(1)
+		}
+		keysRepo = keys
+		sessions := infrastructure.NewMongoSessionRepository(client, *mongodbDB, *sessionCol)
+		if err := sessions.EnsureIndexes(ctx); err != nil {
+			logger.Log("sessions", *sessionCol, "during", "EnsureIndexes", "err", err)
+			os.Exit(1)
+		}
+		sessRepo = sessions
+
+		if *eventsPublisher != "none" {
+			o := infrastructure.NewMongoOutbox(client, *mongodbDB, *outboxCol)
+			if err := o.EnsureIndexes(ctx, *outboxRetention); err != nil {
+				logger.Log("outbox", *outboxCol, "during", "EnsureIndexes", "err", err)
+				os.Exit(1)
+			}
+			logger.Log("outbox", *outboxCol, "publisher", *eventsPublisher)
+			repo = infrastructure.NewMongoOutboxRepository(users, o)
+			outbox = o
+		}
+	}
+
+	{
+		var (
+			cacheMetrics = usercache.Metrics{
+				Hits: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+					Namespace: "usersvc",
+					Subsystem: "profile_cache",
+					Name:      "hits_total",
+					Help:      "Profile lookups served by a cache tier.",
+				}, []string{"tier"}),
+				Misses: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+					Namespace: "usersvc",
+					Subsystem: "profile_cache",
+					Name:      "misses_total",
+					Help:      "Profile lookups not found in a cache tier.",
+				}, []string{"tier"}),
+				Evictions: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+					Namespace: "usersvc",
+					Subsystem: "profile_cache",
+					Name:      "evictions_total",
+					Help:      "Profiles evicted from a cache tier to make room.",
+				}, []string{"tier"}),
+			}
+			tiers []usercache.Tier
+		)
+		if *cacheSize > 0 {
+			tiers = append(tiers, usercache.NewLRU(*cacheSize, cacheMetrics.Evictions))
+		}
+		if *redisAddr != "" {
+			rdb := redis.NewClient(&redis.Options{Addr: *redisAddr})
+			defer rdb.Close()
+			tiers = append(tiers, usercache.NewRedis(rdb, *redisKeyPrefix))
+		}
+		if len(tiers) > 0 {
+			logger.Log("cache", "profile", "size", *cacheSize, "ttl", *cacheTTL, "redis", *redisAddr)
+			repo = usercache.NewRepository(repo, *cacheTTL, *cacheNegativeTTL, cacheMetrics, log.With(logger, "component", "cache"), tiers...)
+		}
+	}
+
+	var publisher userevents.Publisher
+	switch *eventsPublisher {
+	case "none":
+	case "inproc":
+		p := userevents.NewInProcPublisher()
+		p.Subscribe(func(_ context.Context, e model.Event) error {
+			return logger.Log("event", e.Type, "id", e.ID, "user", e.UserID, "sequence", e.Sequence)
+		})
+		publisher = p
+	case "nats":
+		nc, err := nats.Connect(*natsURL, nats.Name("usersvc"))
+		if err != nil {
+			logger.Log("publisher", "NATS", "url", *natsURL, "err", err)
+			os.Exit(1)
+		}
+		defer nc.Close()
+		p, err := userevents.NewNATSPublisher(ctx, nc, *natsStream, *natsSubjectPrefix)
+		if err != nil {
+			logger.Log("publisher", "NATS", "stream", *natsStream, "err", err)
+			os.Exit(1)
+		}
+		logger.Log("publisher", "NATS", "url", *natsURL, "stream", *natsStream, "subjects", *natsSubjectPrefix+".>")
+		publisher = p
+	default:
+		logger.Log("events-publisher", *eventsPublisher, "err", "unknown publisher")
+		os.Exit(1)
+	}
+
+	var service userservice.Service
+	{
+		service = userservice.NewService(repo)
+		service = userservice.AuditMiddleware(auditRepo, logger)(service)
+	}
+
+	var adminService userservice.AdminService
+	{
+		adminService = userservice.NewAdminService(repo)
+		adminService = userservice.AdminActionMiddleware(adminRepo, logger)(adminService)
+	}
+
+	var endpointMetrics userendpoint.Metrics
+	{
+		// Recording rules can derive error ratios from requests_total by code
+		// and latency quantiles from the duration histogram, per method.
+		endpointMetrics.Requests = kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "usersvc",
+			Subsystem: "endpoint",
+			Name:      "requests_total",
+			Help:      "Endpoint calls by method and outcome code.",
+		}, []string{"method", "code"})
+		endpointMetrics.Duration = kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: "usersvc",
+			Subsystem: "endpoint",
+			Name:      "request_duration_seconds",
+			Help:      "Endpoint call latency in seconds, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"})
 	}
 
 	var (
-		service    = userservice.NewService(repo)
-		endpoints  = userendpoint.New(service, logger)
-		grpcServer = usertransport.NewGRPCServer(endpoints, logger)
+		healthServer  = health.NewServer()
+		healthMonitor = instance.NewHealthMonitor(healthServer, []string{userpb.User_ServiceDesc.ServiceName}, *healthInterval, *healthTimeout,
+			log.With(logger, "component", "health"),
+			instance.HealthCheck{Name: "mongodb", Check: ping},
+		)
 	)
 
+	var grpcServerOptions []kitgrpc.ServerOption
+	if *identityKeyFile != "" {
+		key, err := usertransport.LoadIdentityKey(*identityKeyFile)
+		if err != nil {
+			logger.Log("identity-key-file", *identityKeyFile, "err", err)
+			os.Exit(1)
+		}
+		verifier := usertransport.NewIdentityVerifier(key, log.With(logger, "component", "identity"))
+		grpcServerOptions = append(grpcServerOptions, kitgrpc.ServerBefore(verifier.VerifyCaller))
+	} else {
+		logger.Log("identity", "unverified", "msg", "no -identity-key-file, so profile changes are denied")
+	}
+
+	var (
+		auditLog   = userservice.NewAuditLog(auditRepo)
+		apiKeys    = userservice.NewAPIKeyService(keysRepo)
+		sessions   = userservice.NewSessionService(sessRepo, repo, *sessionTTL)
+		tfLockout  = userservice.TwoFactorLockout{MaxAttempts: *totpLockoutAttempts, Duration: *totpLockoutDuration}
+		twoFactor  = userservice.NewTwoFactorService(tfRepo, repo, *totpIssuer, tfLockout)
+		endpoints  = userendpoint.New(service, auditLog, adminService, apiKeys, sessions, twoFactor, logger, endpointMetrics)
+		grpcServer = usertransport.NewGRPCServer(endpoints, logger, grpcServerOptions...)
+	)
+
+	var (
+		serverOptions []grpc.ServerOption
+		unary         = []grpc.UnaryServerInterceptor{usertransport.AccessLogInterceptor(log.With(logger, "component", "access"))}
+		stream        []grpc.StreamServerInterceptor
+		certReloader  *certs.Reloader
+	)
+	{
+		switch {
+		case (*tlsCert == "") != (*tlsKey == ""):
+			logger.Log("err", "-tls-cert and -tls-key must be set together")
+			os.Exit(1)
+		case *tlsCert == "" && *tlsClientCA != "":
+			logger.Log("err", "-tls-client-ca requires -tls-cert")
+			os.Exit(1)
+		case *tlsClientCA == "" && *tlsAllowedSANs != "":
+			logger.Log("err", "-tls-allowed-sans requires -tls-client-ca")
+			os.Exit(1)
+		}
+		if *tlsCert != "" {
+			var err error
+			certReloader, err = certs.NewReloader(*tlsCert, *tlsKey, *tlsClientCA, log.With(logger, "component", "tls"))
+			if err != nil {
+				logger.Log("transport", "gRPC", "during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(certReloader.ServerConfig())))
+			logger.Log("transport", "gRPC", "tls", true, "mtls", *tlsClientCA != "", "allowed", *tlsAllowedSANs)
+		}
+		if *tlsAllowedSANs != "" {
+			authorizer := certs.NewPeerAuthorizer(strings.Split(*tlsAllowedSANs, ","))
+			unary = append(unary, authorizer.UnaryInterceptor)
+			stream = append(stream, authorizer.StreamInterceptor)
+		}
+		unary = append(unary, kitgrpc.Interceptor)
+	}
+
 	var g group.Group
 	{
 		grpcListener, err := net.Listen("tcp", *grpcAddr)
@@ -80,8 +360,19 @@ func main() {
 			os.Exit(1)
 		}
 
-		baseServer := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+		baseServer := grpc.NewServer(append(serverOptions,
+			// Continue the trace of the caller, e.g. the gateway.
+			grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+			grpc.ChainUnaryInterceptor(unary...),
+			grpc.ChainStreamInterceptor(stream...),
+			// Allow the keepalive pings of the gateway's pooled connections.
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             10 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		)...)
 		userpb.RegisterUserServer(baseServer, grpcServer)
+		healthpb.RegisterHealthServer(baseServer, healthServer)
 
 		g.Add(func() error {
 			logger.Log("transport", "gRPC", "addr", *grpcAddr)
@@ -92,13 +383,94 @@ func main() {
 		})
 	}
 
+	{
+		debugListener, err := net.Listen("tcp", *debugAddr)
+		if err != nil {
+			logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
+			os.Exit(1)
+		}
+
+		m := http.NewServeMux()
+		m.Handle("/metrics", promhttp.Handler())
+		m.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		m.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+			if err := healthMonitor.Ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		})
+
+		g.Add(func() error {
+			logger.Log("transport", "debug/HTTP", "addr", *debugAddr)
+			return http.Serve(debugListener, m)
+		}, func(error) {
+			_ = debugListener.Close()
+		})
+	}
+
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return healthMonitor.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if certReloader != nil && *tlsReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return certReloader.Run(ctx, *tlsReloadInterval)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if publisher != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		relay := userevents.NewRelay(outbox, publisher, *outboxInterval, log.With(logger, "component", "relay"))
+		g.Add(func() error {
+			return relay.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if *consulAddr != "" {
+		client, err := api.NewClient(&api.Config{Address: *consulAddr})
+		if err != nil {
+			logger.Log("consul", *consulAddr, "err", err)
+			os.Exit(1)
+		}
+		host, port, err := instance.AdvertisedHostPort(*advertiseAddr, *grpcAddr)
+		if err != nil {
+			logger.Log("advertise-addr", *advertiseAddr, "err", err)
+			os.Exit(1)
+		}
+		var (
+			id        = fmt.Sprintf("%s-%s-%d", *consulService, host, port)
+			registrar = instance.NewConsulRegistrar(client, *consulService, id, host, port, *consulTTL, healthMonitor.Check, logger)
+		)
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return registrar.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	{
 		g.Add(func() error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
 			}
-		}, nil)
+		}, func(error) {
+			cancel()
+		})
 	}
 	logger.Log("exit", g.Run())
 
@@ -0,0 +1,88 @@
+package usercache
+
+import (
+	"container/list"
+	"context"
+	"github.com/go-kit/kit/metrics"
+	"sync"
+	"time"
+)
+
+type lruItem struct {
+	uid       string
+	entry     Entry
+	expiresAt time.Time
+}
+
+// LRU is an in-process Tier holding at most size entries. When full, the least
+// recently used entry is evicted; expired entries are dropped when looked up.
+type LRU struct {
+	mtx       sync.Mutex
+	size      int
+	items     map[string]*list.Element
+	order     *list.List // front is most recently used
+	evictions metrics.Counter
+}
+
+// NewLRU returns an LRU tier that counts capacity evictions on evictions.
+func NewLRU(size int, evictions metrics.Counter) *LRU {
+	if size <= 0 {
+		panic("invalid LRU size")
+	}
+	return &LRU{
+		size:      size,
+		items:     make(map[string]*list.Element, size),
+		order:     list.New(),
+		evictions: evictions.With("tier", "lru"),
+	}
+}
+
+func (c *LRU) Name() string { return "lru" }
+
+func (c *LRU) Get(_ context.Context, uid string) (Entry, bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	el, ok := c.items[uid]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.expiresAt) {
+		c.remove(el)
+		return Entry{}, false, nil
+	}
+	c.order.MoveToFront(el)
+	return item.entry, true, nil
+}
+
+func (c *LRU) Set(_ context.Context, uid string, e Entry, ttl time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	expiresAt := time.Now().Add(ttl)
+	if el, ok := c.items[uid]; ok {
+		item := el.Value.(*lruItem)
+		item.entry, item.expiresAt = e, expiresAt
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.items[uid] = c.order.PushFront(&lruItem{uid: uid, entry: e, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+	return nil
+}
+
+func (c *LRU) Delete(_ context.Context, uid string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if el, ok := c.items[uid]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruItem).uid)
+}
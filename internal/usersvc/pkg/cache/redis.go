@@ -0,0 +1,49 @@
+package usercache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Redis is a Tier shared by all usersvc instances, backed by any server that
+// speaks the Redis protocol.
+type Redis struct {
+	client    redis.Cmdable
+	keyPrefix string
+}
+
+func NewRedis(client redis.Cmdable, keyPrefix string) *Redis {
+	return &Redis{client: client, keyPrefix: keyPrefix}
+}
+
+func (c *Redis) Name() string { return "redis" }
+
+func (c *Redis) Get(ctx context.Context, uid string) (Entry, bool, error) {
+	b, err := c.client.Get(ctx, c.keyPrefix+uid).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, uid string, e Entry, ttl time.Duration) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.keyPrefix+uid, b, ttl).Err()
+}
+
+func (c *Redis) Delete(ctx context.Context, uid string) error {
+	return c.client.Del(ctx, c.keyPrefix+uid).Err()
+}
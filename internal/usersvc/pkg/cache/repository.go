@@ -0,0 +1,200 @@
+package usercache
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"golang.org/x/sync/singleflight"
+	"sync/atomic"
+	"time"
+)
+
+// loadTimeout bounds a load from the next Repository. Loads are shared by the
+// callers missing the same user, so they don't end with the first caller's
+// context.
+const loadTimeout = 10 * time.Second
+
+// generationStripes is the number of invalidation counters users are spread
+// over, so that invalidating a user only drops the concurrent loads of users
+// sharing its counter.
+const generationStripes = 256
+
+// Entry is a cached lookup result. NotFound entries cache the absence of a user.
+type Entry struct {
+	User     model.User `json:"user"`
+	NotFound bool       `json:"notFound,omitempty"`
+}
+
+// Tier is one level of the cache, checked in order from fastest to slowest.
+type Tier interface {
+	Name() string
+	Get(ctx context.Context, uid string) (Entry, bool, error)
+	Set(ctx context.Context, uid string, e Entry, ttl time.Duration) error
+	Delete(ctx context.Context, uid string) error
+}
+
+// Metrics counts cache outcomes, labelled by "tier".
+type Metrics struct {
+	Hits      metrics.Counter
+	Misses    metrics.Counter
+	Evictions metrics.Counter
+}
+
+type repository struct {
+	next        userservice.Repository
+	tiers       []Tier
+	ttl         time.Duration
+	negativeTTL time.Duration
+	metrics     Metrics
+	logger      log.Logger
+	loads       singleflight.Group
+
+	// generations are bumped by the invalidations of the users of their
+	// stripe. A load that overlaps one may have read the old value, so its
+	// result is returned but not cached.
+	generations [generationStripes]atomic.Uint64
+}
+
+// NewRepository returns a read-through cache in front of next. Concurrent misses
+// for the same user share a single load, which a caller giving up doesn't
+// cancel for the others, unknown users are cached for
+// negativeTTL, and any write through the returned Repository invalidates the
+// user in every tier. Tier failures are logged and treated as misses, so the
+// cache never fails a call that next would have served.
+//
+// Writes that bypass this Repository, including those made by other usersvc
+// instances to their own in-process tier, become visible once the entry expires.
+func NewRepository(next userservice.Repository, ttl, negativeTTL time.Duration, m Metrics, logger log.Logger, tiers ...Tier) userservice.Repository {
+	return &repository{
+		next:        next,
+		tiers:       tiers,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		metrics:     m,
+		logger:      logger,
+	}
+}
+
+func (r *repository) GetUser(ctx context.Context, uid string) (model.User, error) {
+	for i, tier := range r.tiers {
+		e, ok, err := tier.Get(ctx, uid)
+		if err != nil {
+			r.logger.Log("tier", tier.Name(), "during", "Get", "err", err)
+		}
+		if !ok {
+			r.metrics.Misses.With("tier", tier.Name()).Add(1)
+			continue
+		}
+		r.metrics.Hits.With("tier", tier.Name()).Add(1)
+		r.fill(ctx, r.tiers[:i], uid, e)
+		return e.result()
+	}
+
+	loaded := r.loads.DoChan(uid, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		generation := r.generation(uid).Load()
+		var e Entry
+		u, err := r.next.GetUser(ctx, uid)
+		switch {
+		case errors.Is(err, userservice.ErrUserNotFound):
+			e = Entry{NotFound: true}
+		case err != nil:
+			return nil, err
+		default:
+			e = Entry{User: u}
+		}
+		if r.generation(uid).Load() == generation {
+			r.fill(ctx, r.tiers, uid, e)
+		}
+		return e, nil
+	})
+	select {
+	case <-ctx.Done():
+		return model.User{}, ctx.Err()
+	case res := <-loaded:
+		if res.Err != nil {
+			return model.User{}, res.Err
+		}
+		return res.Val.(Entry).result()
+	}
+}
+
+func (r *repository) CreateUser(ctx context.Context, u model.User) error {
+	// Creating a user must drop a cached "not found".
+	defer r.invalidate(ctx, u.UUID)
+	return r.next.CreateUser(ctx, u)
+}
+
+func (r *repository) UpdateUser(ctx context.Context, u model.User) error {
+	defer r.invalidate(ctx, u.UUID)
+	return r.next.UpdateUser(ctx, u)
+}
+
+func (r *repository) DeleteUser(ctx context.Context, uid string) error {
+	defer r.invalidate(ctx, &uid)
+	return r.next.DeleteUser(ctx, uid)
+}
+
+// ListUsers is not cached.
+func (r *repository) ListUsers(ctx context.Context, q model.UserQuery) ([]model.User, string, error) {
+	return r.next.ListUsers(ctx, q)
+}
+
+func (r *repository) SetSuspension(ctx context.Context, uid string, s *model.Suspension) error {
+	defer r.invalidate(ctx, &uid)
+	return r.next.SetSuspension(ctx, uid, s)
+}
+
+// fill stores e in the given tiers, slowest first, so that a concurrent reader
+// never finds a fresher entry in a slower tier than in a faster one.
+func (r *repository) fill(ctx context.Context, tiers []Tier, uid string, e Entry) {
+	ttl := r.ttl
+	if e.NotFound {
+		ttl = r.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if err := tiers[i].Set(ctx, uid, e, ttl); err != nil {
+			r.logger.Log("tier", tiers[i].Name(), "during", "Set", "err", err)
+		}
+	}
+}
+
+// invalidate drops uid from every tier, slowest first, so that a faster tier
+// can't be refilled from a stale slower one.
+func (r *repository) invalidate(ctx context.Context, uid *string) {
+	if uid == nil || *uid == "" {
+		return
+	}
+	r.generation(*uid).Add(1)
+	r.loads.Forget(*uid)
+	for i := len(r.tiers) - 1; i >= 0; i-- {
+		if err := r.tiers[i].Delete(ctx, *uid); err != nil {
+			r.logger.Log("tier", r.tiers[i].Name(), "during", "Delete", "err", err)
+		}
+	}
+}
+
+// generation returns the invalidation counter of uid, picked by its FNV-1a
+// hash.
+func (r *repository) generation(uid string) *atomic.Uint64 {
+	h := uint32(2166136261)
+	for i := 0; i < len(uid); i++ {
+		h ^= uint32(uid[i])
+		h *= 16777619
+	}
+	return &r.generations[h%generationStripes]
+}
+
+func (e Entry) result() (model.User, error) {
+	if e.NotFound {
+		return model.User{}, userservice.ErrUserNotFound
+	}
+	return e.User, nil
+}
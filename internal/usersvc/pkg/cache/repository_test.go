@@ -0,0 +1,218 @@
+package usercache
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepository serves users from a map and counts the GetUser calls.
+// If release is set, GetUser waits for it to be closed, or for its context to
+// be done.
+type countingRepository struct {
+	userservice.Repository
+	mtx     sync.Mutex
+	users   map[string]model.User
+	gets    atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *countingRepository) GetUser(ctx context.Context, uid string) (model.User, error) {
+	r.gets.Add(1)
+	if r.release != nil {
+		r.started <- struct{}{}
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return model.User{}, ctx.Err()
+		}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	u, ok := r.users[uid]
+	if !ok {
+		return model.User{}, userservice.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (r *countingRepository) UpdateUser(_ context.Context, u model.User) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.users[*u.UUID] = u
+	return nil
+}
+
+func testMetrics() Metrics {
+	return Metrics{Hits: discard.NewCounter(), Misses: discard.NewCounter(), Evictions: discard.NewCounter()}
+}
+
+func newTestCache(next userservice.Repository) userservice.Repository {
+	return NewRepository(next, time.Minute, time.Minute, testMetrics(), log.NewNopLogger(), NewLRU(10, discard.NewCounter()))
+}
+
+func TestRepository(t *testing.T) {
+	alice := "alice"
+	for _, tc := range []struct {
+		name     string
+		calls    func(ctx context.Context, r userservice.Repository) (model.User, error)
+		wantErr  error
+		wantName string
+		wantGets int32
+	}{
+		{
+			name: "hit after miss",
+			calls: func(ctx context.Context, r userservice.Repository) (model.User, error) {
+				r.GetUser(ctx, "u1")
+				return r.GetUser(ctx, "u1")
+			},
+			wantName: "alice",
+			wantGets: 1,
+		},
+		{
+			name: "unknown users are cached",
+			calls: func(ctx context.Context, r userservice.Repository) (model.User, error) {
+				r.GetUser(ctx, "u2")
+				return r.GetUser(ctx, "u2")
+			},
+			wantErr:  userservice.ErrUserNotFound,
+			wantGets: 1,
+		},
+		{
+			name: "writes invalidate",
+			calls: func(ctx context.Context, r userservice.Repository) (model.User, error) {
+				r.GetUser(ctx, "u1")
+				name := "alicia"
+				r.UpdateUser(ctx, model.User{UUID: ptr("u1"), UserName: &name})
+				return r.GetUser(ctx, "u1")
+			},
+			wantName: "alicia",
+			wantGets: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &countingRepository{users: map[string]model.User{"u1": {UUID: ptr("u1"), UserName: &alice}}}
+			u, err := tc.calls(context.Background(), newTestCache(next))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && *u.UserName != tc.wantName {
+				t.Errorf("user name = %q, want %q", *u.UserName, tc.wantName)
+			}
+			if got := next.gets.Load(); got != tc.wantGets {
+				t.Errorf("loaded %d times, want %d", got, tc.wantGets)
+			}
+		})
+	}
+}
+
+func TestRepositorySharedLoadOutlivesCanceledCaller(t *testing.T) {
+	alice := "alice"
+	next := &countingRepository{
+		users:   map[string]model.User{"u1": {UUID: ptr("u1"), UserName: &alice}},
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	r := newTestCache(next)
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := r.GetUser(first, "u1")
+		firstErr <- err
+	}()
+	<-next.started
+
+	secondErr := make(chan error, 1)
+	go func() {
+		_, err := r.GetUser(context.Background(), "u1")
+		secondErr <- err
+	}()
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller got %v, want %v", err, context.Canceled)
+	}
+	close(next.release)
+	if err := <-secondErr; err != nil {
+		t.Errorf("waiting caller got %v, want nil", err)
+	}
+	if got := next.gets.Load(); got != 1 {
+		t.Errorf("loaded %d times, want 1", got)
+	}
+}
+
+func TestRepositoryInvalidationDuringLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		invalidated string
+		wantGets    int32
+	}{
+		{"of the loaded user", "u1", 2},
+		{"of another user", "u2", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice := "alice"
+			next := &countingRepository{
+				users:   map[string]model.User{"u1": {UUID: ptr("u1"), UserName: &alice}},
+				started: make(chan struct{}, 2),
+				release: make(chan struct{}),
+			}
+			r := newTestCache(next)
+			if rr := r.(*repository); tc.invalidated != "u1" && rr.generation(tc.invalidated) == rr.generation("u1") {
+				t.Fatalf("%s and u1 share an invalidation counter", tc.invalidated)
+			}
+
+			loaded := make(chan error, 1)
+			go func() {
+				_, err := r.GetUser(context.Background(), "u1")
+				loaded <- err
+			}()
+			<-next.started
+			r.UpdateUser(context.Background(), model.User{UUID: ptr(tc.invalidated)})
+			close(next.release)
+			if err := <-loaded; err != nil {
+				t.Fatal(err)
+			}
+
+			// A load overlapping an invalidation of the user may have read
+			// the old profile, so it mustn't be cached.
+			r.GetUser(context.Background(), "u1")
+			if got := next.gets.Load(); got != tc.wantGets {
+				t.Errorf("loaded %d times, want %d", got, tc.wantGets)
+			}
+		})
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2, discard.NewCounter())
+	c.Set(ctx, "a", Entry{NotFound: true}, time.Minute)
+	c.Set(ctx, "b", Entry{NotFound: true}, time.Minute)
+	c.Get(ctx, "a") // b is now the least recently used
+	c.Set(ctx, "c", Entry{NotFound: true}, time.Minute)
+	c.Set(ctx, "a", Entry{NotFound: true}, -time.Second)
+
+	for _, tc := range []struct {
+		uid    string
+		wantOK bool
+	}{
+		{"a", false}, // expired
+		{"b", false}, // evicted
+		{"c", true},
+	} {
+		if _, ok, _ := c.Get(ctx, tc.uid); ok != tc.wantOK {
+			t.Errorf("Get(%q) found %v, want %v", tc.uid, ok, tc.wantOK)
+		}
+	}
+}
+
+func ptr(s string) *string { return &s }
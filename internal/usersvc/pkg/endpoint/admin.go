@@ -0,0 +1,241 @@
+package userendpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"time"
+)
+
+// ListUsers implements AdminService. Primarily useful in a client.
+func (s Set) ListUsers(ctx context.Context, q model.UserQuery) ([]model.User, string, error) {
+	request := ListUsersRequest{
+		Role:          q.Role,
+		SuspendedOnly: q.SuspendedOnly,
+		PageToken:     q.PageToken,
+		PageSize:      q.PageSize,
+	}
+	response, err := s.ListUsersEndpoint(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	resp := response.(ListUsersResponse)
+	return resp.Users, resp.NextPageToken, resp.Err
+}
+
+func (s Set) GetUser(ctx context.Context, uid string) (model.User, error) {
+	response, err := s.GetUserEndpoint(ctx, GetUserRequest{UUID: uid})
+	if err != nil {
+		return model.User{}, err
+	}
+	resp := response.(GetUserResponse)
+	return resp.User, resp.Err
+}
+
+func (s Set) UpdateUser(ctx context.Context, u model.User, reason string) error {
+	request := UpdateUserRequest{
+		UUID:           u.UUID,
+		Email:          u.Email,
+		PhoneNumber:    u.PhoneNumber,
+		UserName:       u.UserName,
+		ProfilePicture: u.ProfilePicture,
+		Bio:            u.Bio,
+		Roles:          u.Roles,
+		Reason:         reason,
+	}
+	response, err := s.UpdateUserEndpoint(ctx, request)
+	if err != nil {
+		return err
+	}
+	return response.(UpdateUserResponse).Err
+}
+
+func (s Set) Suspend(ctx context.Context, uid, reason string, until time.Time) error {
+	response, err := s.SuspendEndpoint(ctx, SuspendRequest{UUID: uid, Reason: reason, Until: until})
+	if err != nil {
+		return err
+	}
+	return response.(SuspendResponse).Err
+}
+
+func (s Set) Unsuspend(ctx context.Context, uid, reason string) error {
+	response, err := s.UnsuspendEndpoint(ctx, UnsuspendRequest{UUID: uid, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return response.(UnsuspendResponse).Err
+}
+
+func (s Set) ForceDelete(ctx context.Context, uid, reason string) error {
+	response, err := s.ForceDeleteEndpoint(ctx, ForceDeleteRequest{UUID: uid, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return response.(ForceDeleteResponse).Err
+}
+
+func MakeListUsersEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListUsersRequest)
+		users, next, err := a.ListUsers(ctx, model.UserQuery{
+			Role:          req.Role,
+			SuspendedOnly: req.SuspendedOnly,
+			PageToken:     req.PageToken,
+			PageSize:      req.PageSize,
+		})
+		return ListUsersResponse{Users: users, NextPageToken: next, Err: err}, nil
+	}
+}
+
+func MakeGetUserEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(GetUserRequest)
+		u, err := a.GetUser(ctx, req.UUID)
+		return GetUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeUpdateUserEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(UpdateUserRequest)
+		err = a.UpdateUser(ctx, model.User{
+			UUID:           req.UUID,
+			Email:          req.Email,
+			PhoneNumber:    req.PhoneNumber,
+			UserName:       req.UserName,
+			ProfilePicture: req.ProfilePicture,
+			Bio:            req.Bio,
+			Roles:          req.Roles,
+		}, req.Reason)
+		return UpdateUserResponse{Err: err}, nil
+	}
+}
+
+func MakeSuspendEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(SuspendRequest)
+		return SuspendResponse{Err: a.Suspend(ctx, req.UUID, req.Reason, req.Until)}, nil
+	}
+}
+
+func MakeUnsuspendEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(UnsuspendRequest)
+		return UnsuspendResponse{Err: a.Unsuspend(ctx, req.UUID, req.Reason)}, nil
+	}
+}
+
+func MakeForceDeleteEndpoint(a userservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ForceDeleteRequest)
+		return ForceDeleteResponse{Err: a.ForceDelete(ctx, req.UUID, req.Reason)}, nil
+	}
+}
+
+// compile time assertions for our response types implementing endpoint.Failer.
+var (
+	_ endpoint.Failer = ListUsersResponse{}
+	_ endpoint.Failer = GetUserResponse{}
+	_ endpoint.Failer = UpdateUserResponse{}
+	_ endpoint.Failer = SuspendResponse{}
+	_ endpoint.Failer = UnsuspendResponse{}
+	_ endpoint.Failer = ForceDeleteResponse{}
+)
+
+// ListUsersRequest collects the request parameters for the ListUsers method.
+type ListUsersRequest struct {
+	Role          string `json:"role,omitempty"`
+	SuspendedOnly bool   `json:"suspendedOnly,omitempty"`
+	PageToken     string `json:"pageToken,omitempty"`
+	PageSize      int    `json:"pageSize,omitempty"`
+}
+
+// ListUsersResponse collects the response values for the ListUsers method.
+type ListUsersResponse struct {
+	Users         []model.User `json:"users"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+	Err           error        `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ListUsersResponse) Failed() error { return r.Err }
+
+// GetUserRequest collects the request parameters for the GetUser method.
+type GetUserRequest struct {
+	UUID string `json:"uid"`
+}
+
+// GetUserResponse collects the response values for the GetUser method.
+type GetUserResponse struct {
+	User model.User `json:"user"`
+	Err  error      `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r GetUserResponse) Failed() error { return r.Err }
+
+// UpdateUserRequest collects the request parameters for the UpdateUser method.
+// A nil Roles leaves the roles unchanged.
+type UpdateUserRequest struct {
+	UUID           *string  `json:"uid"`
+	Email          *string  `json:"email,omitempty"`
+	PhoneNumber    *string  `json:"phoneNumber,omitempty"`
+	UserName       *string  `json:"userName,omitempty"`
+	ProfilePicture *string  `json:"profilePicture,omitempty"`
+	Bio            *string  `json:"bio,omitempty"`
+	Roles          []string `json:"roles,omitempty"`
+	Reason         string   `json:"reason"`
+}
+
+// UpdateUserResponse collects the response values for the UpdateUser method.
+type UpdateUserResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r UpdateUserResponse) Failed() error { return r.Err }
+
+// SuspendRequest collects the request parameters for the Suspend method.
+// A zero Until suspends the user indefinitely.
+type SuspendRequest struct {
+	UUID   string    `json:"uid"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until,omitempty"`
+}
+
+// SuspendResponse collects the response values for the Suspend method.
+type SuspendResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r SuspendResponse) Failed() error { return r.Err }
+
+// UnsuspendRequest collects the request parameters for the Unsuspend method.
+type UnsuspendRequest struct {
+	UUID   string `json:"uid"`
+	Reason string `json:"reason"`
+}
+
+// UnsuspendResponse collects the response values for the Unsuspend method.
+type UnsuspendResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r UnsuspendResponse) Failed() error { return r.Err }
+
+// ForceDeleteRequest collects the request parameters for the ForceDelete method.
+type ForceDeleteRequest struct {
+	UUID   string `json:"uid"`
+	Reason string `json:"reason"`
+}
+
+// ForceDeleteResponse collects the response values for the ForceDelete method.
+type ForceDeleteResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ForceDeleteResponse) Failed() error { return r.Err }
@@ -0,0 +1,152 @@
+package userendpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"time"
+)
+
+// CreateAPIKey implements APIKeyService. Primarily useful in a client.
+func (s Set) CreateAPIKey(ctx context.Context, k model.APIKey) (model.APIKey, string, error) {
+	request := CreateAPIKeyRequest{
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		ExpiresAt: k.ExpiresAt,
+		Subject:   k.Subject,
+	}
+	response, err := s.CreateAPIKeyEndpoint(ctx, request)
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	resp := response.(CreateAPIKeyResponse)
+	return resp.APIKey, resp.Key, resp.Err
+}
+
+func (s Set) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	response, err := s.ListAPIKeysEndpoint(ctx, ListAPIKeysRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(ListAPIKeysResponse)
+	return resp.APIKeys, resp.Err
+}
+
+func (s Set) RevokeAPIKey(ctx context.Context, id string) error {
+	response, err := s.RevokeAPIKeyEndpoint(ctx, RevokeAPIKeyRequest{ID: id})
+	if err != nil {
+		return err
+	}
+	return response.(RevokeAPIKeyResponse).Err
+}
+
+func (s Set) VerifyAPIKey(ctx context.Context, key string) (model.APIKey, error) {
+	response, err := s.VerifyAPIKeyEndpoint(ctx, VerifyAPIKeyRequest{Key: key})
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	resp := response.(VerifyAPIKeyResponse)
+	return resp.APIKey, resp.Err
+}
+
+func MakeCreateAPIKeyEndpoint(k userservice.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(CreateAPIKeyRequest)
+		created, key, err := k.CreateAPIKey(ctx, model.APIKey{
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+			Subject:   req.Subject,
+		})
+		return CreateAPIKeyResponse{APIKey: created, Key: key, Err: err}, nil
+	}
+}
+
+func MakeListAPIKeysEndpoint(k userservice.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		keys, err := k.ListAPIKeys(ctx)
+		return ListAPIKeysResponse{APIKeys: keys, Err: err}, nil
+	}
+}
+
+func MakeRevokeAPIKeyEndpoint(k userservice.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RevokeAPIKeyRequest)
+		return RevokeAPIKeyResponse{Err: k.RevokeAPIKey(ctx, req.ID)}, nil
+	}
+}
+
+func MakeVerifyAPIKeyEndpoint(k userservice.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(VerifyAPIKeyRequest)
+		key, err := k.VerifyAPIKey(ctx, req.Key)
+		return VerifyAPIKeyResponse{APIKey: key, Err: err}, nil
+	}
+}
+
+// compile time assertions for our response types implementing endpoint.Failer.
+var (
+	_ endpoint.Failer = CreateAPIKeyResponse{}
+	_ endpoint.Failer = ListAPIKeysResponse{}
+	_ endpoint.Failer = RevokeAPIKeyResponse{}
+	_ endpoint.Failer = VerifyAPIKeyResponse{}
+)
+
+// CreateAPIKeyRequest collects the request parameters for the CreateAPIKey method.
+// An empty Subject binds the key to the caller.
+type CreateAPIKeyRequest struct {
+	Name      string    `json:"name,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+}
+
+// CreateAPIKeyResponse collects the response values for the CreateAPIKey method.
+type CreateAPIKeyResponse struct {
+	APIKey model.APIKey `json:"apiKey"`
+	Key    string       `json:"key"`
+	Err    error        `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r CreateAPIKeyResponse) Failed() error { return r.Err }
+
+// ListAPIKeysRequest collects the request parameters for the ListAPIKeys method.
+type ListAPIKeysRequest struct{}
+
+// ListAPIKeysResponse collects the response values for the ListAPIKeys method.
+type ListAPIKeysResponse struct {
+	APIKeys []model.APIKey `json:"apiKeys"`
+	Err     error          `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ListAPIKeysResponse) Failed() error { return r.Err }
+
+// RevokeAPIKeyRequest collects the request parameters for the RevokeAPIKey method.
+type RevokeAPIKeyRequest struct {
+	ID string `json:"id"`
+}
+
+// RevokeAPIKeyResponse collects the response values for the RevokeAPIKey method.
+type RevokeAPIKeyResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RevokeAPIKeyResponse) Failed() error { return r.Err }
+
+// VerifyAPIKeyRequest collects the request parameters for the VerifyAPIKey method.
+type VerifyAPIKeyRequest struct {
+	Key string `json:"key"`
+}
+
+// VerifyAPIKeyResponse collects the response values for the VerifyAPIKey method.
+type VerifyAPIKeyResponse struct {
+	APIKey model.APIKey `json:"apiKey"`
+	Err    error        `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r VerifyAPIKeyResponse) Failed() error { return r.Err }
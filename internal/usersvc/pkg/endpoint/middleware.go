@@ -0,0 +1,104 @@
+package userendpoint
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"time"
+)
+
+// Metrics instruments the endpoints of a Set. Both are labelled by "method";
+// Requests is also labelled by "code", the outcome reported by ErrorCode.
+type Metrics struct {
+	Requests metrics.Counter
+	Duration metrics.Histogram
+}
+
+// InstrumentingMiddleware records the outcome and duration of each call to an
+// endpoint. Service errors returned inside a Failer response count too.
+func InstrumentingMiddleware(method string, m Metrics) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				failure := err
+				if f, ok := response.(endpoint.Failer); ok && failure == nil {
+					failure = f.Failed()
+				}
+				m.Requests.With("method", method, "code", ErrorCode(failure)).Add(1)
+				m.Duration.With("method", method).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// ErrorCode classifies err for metrics: "ok", "not_found", "denied",
+// "locked", "canceled" or "error".
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, userservice.ErrUserNotFound), errors.Is(err, userservice.ErrAPIKeyNotFound),
+		errors.Is(err, userservice.ErrSessionNotFound):
+		return "not_found"
+	case errors.Is(err, userservice.ErrUnauthenticated), errors.Is(err, userservice.ErrPermissionDenied),
+		errors.Is(err, userservice.ErrInvalidAPIKey), errors.Is(err, userservice.ErrSessionRevoked),
+		errors.Is(err, userservice.ErrInvalidRefreshToken), errors.Is(err, userservice.ErrRefreshTokenReused),
+		errors.Is(err, userservice.ErrTwoFactorRequired), errors.Is(err, userservice.ErrInvalidTwoFactorCode):
+		return "denied"
+	case errors.Is(err, userservice.ErrTwoFactorLocked):
+		return "locked"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// AuthorizingMiddleware lets a call through if the verified caller is allowed
+// to act on the users it concerns, the ones returned by subjects: the caller
+// must be one of them or hold the admin role. Denied calls return the response
+// made by deny, carrying ErrUnauthenticated or ErrPermissionDenied, rather
+// than an error, so that they don't count against the instance.
+func AuthorizingMiddleware(subjects func(request interface{}) []string, deny func(error) interface{}) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			caller, ok := userservice.CallerFromContext(ctx)
+			if !ok {
+				return deny(userservice.ErrUnauthenticated), nil
+			}
+			if caller.HasRole(model.RoleAdmin) {
+				return next(ctx, request)
+			}
+			for _, uid := range subjects(request) {
+				if uid == caller.ID {
+					return next(ctx, request)
+				}
+			}
+			return deny(userservice.ErrPermissionDenied), nil
+		}
+	}
+}
+
+// RoleMiddleware lets a call through if the verified caller holds one of
+// roles. Like AuthorizingMiddleware, it returns denied calls in the response
+// made by deny.
+func RoleMiddleware(deny func(error) interface{}, roles ...string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			caller, ok := userservice.CallerFromContext(ctx)
+			if !ok {
+				return deny(userservice.ErrUnauthenticated), nil
+			}
+			for _, role := range roles {
+				if caller.HasRole(role) {
+					return next(ctx, request)
+				}
+			}
+			return deny(userservice.ErrPermissionDenied), nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package userendpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countsByLabels is a metrics.Counter adding up what is counted under each set
+// of label values, e.g. "method=GetProfile,code=ok".
+type countsByLabels struct {
+	counts map[string]float64
+	labels []string
+}
+
+func newCountsByLabels() *countsByLabels {
+	return &countsByLabels{counts: map[string]float64{}}
+}
+
+func (c *countsByLabels) With(labelValues ...string) metrics.Counter {
+	return &countsByLabels{counts: c.counts, labels: append(append([]string(nil), c.labels...), labelValues...)}
+}
+
+func (c *countsByLabels) Add(delta float64) {
+	var pairs []string
+	for i := 0; i+1 < len(c.labels); i += 2 {
+		pairs = append(pairs, c.labels[i]+"="+c.labels[i+1])
+	}
+	c.counts[strings.Join(pairs, ",")] += delta
+}
+
+// failure is a Failer response.
+type failure struct{ err error }
+
+func (f failure) Failed() error { return f.err }
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	requests := newCountsByLabels()
+	m := Metrics{Requests: requests, Duration: discard.NewHistogram()}
+	for _, outcome := range []struct {
+		response interface{}
+		err      error
+	}{
+		{failure{}, nil},
+		{failure{}, nil},
+		{failure{userservice.ErrUserNotFound}, nil},
+		{nil, errors.New("connection refused")},
+		{failure{fmt.Errorf("wrapped: %w", userservice.ErrPermissionDenied)}, nil},
+	} {
+		e := InstrumentingMiddleware("GetProfile", m)(func(context.Context, interface{}) (interface{}, error) {
+			return outcome.response, outcome.err
+		})
+		e(context.Background(), nil)
+	}
+	want := map[string]float64{
+		"method=GetProfile,code=ok":        2,
+		"method=GetProfile,code=not_found": 1,
+		"method=GetProfile,code=error":     1,
+		"method=GetProfile,code=denied":    1,
+	}
+	if !reflect.DeepEqual(requests.counts, want) {
+		t.Errorf("counted %v, want %v", requests.counts, want)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{userservice.ErrUserNotFound, "not_found"},
+		{userservice.ErrSessionNotFound, "not_found"},
+		{userservice.ErrUnauthenticated, "denied"},
+		{userservice.ErrRefreshTokenReused, "denied"},
+		{userservice.ErrInvalidTwoFactorCode, "denied"},
+		{userservice.ErrTwoFactorLocked, "locked"},
+		{context.Canceled, "canceled"},
+		{context.DeadlineExceeded, "canceled"},
+		{userservice.ErrReasonRequired, "error"},
+	} {
+		if got := ErrorCode(tc.err); got != tc.want {
+			t.Errorf("ErrorCode(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package userendpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+)
+
+// CreateSession implements SessionService. Primarily useful in a client.
+func (s Set) CreateSession(ctx context.Context, device string) (model.Session, string, error) {
+	response, err := s.CreateSessionEndpoint(ctx, CreateSessionRequest{Device: device})
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	resp := response.(CreateSessionResponse)
+	return resp.Session, resp.RefreshToken, resp.Err
+}
+
+func (s Set) RefreshSession(ctx context.Context, refreshToken, device string) (model.Session, string, error) {
+	response, err := s.RefreshSessionEndpoint(ctx, RefreshSessionRequest{RefreshToken: refreshToken, Device: device})
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	resp := response.(RefreshSessionResponse)
+	return resp.Session, resp.RefreshToken, resp.Err
+}
+
+func (s Set) ListSessions(ctx context.Context) ([]model.Session, error) {
+	response, err := s.ListSessionsEndpoint(ctx, ListSessionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(ListSessionsResponse)
+	return resp.Sessions, resp.Err
+}
+
+func (s Set) RevokeSession(ctx context.Context, id string) error {
+	response, err := s.RevokeSessionEndpoint(ctx, RevokeSessionRequest{ID: id})
+	if err != nil {
+		return err
+	}
+	return response.(RevokeSessionResponse).Err
+}
+
+func (s Set) RevokeSessions(ctx context.Context) error {
+	response, err := s.RevokeSessionsEndpoint(ctx, RevokeSessionsRequest{})
+	if err != nil {
+		return err
+	}
+	return response.(RevokeSessionsResponse).Err
+}
+
+func (s Set) CheckSession(ctx context.Context, id string) error {
+	response, err := s.CheckSessionEndpoint(ctx, CheckSessionRequest{ID: id})
+	if err != nil {
+		return err
+	}
+	return response.(CheckSessionResponse).Err
+}
+
+func MakeCreateSessionEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(CreateSessionRequest)
+		session, token, err := s.CreateSession(ctx, req.Device)
+		return CreateSessionResponse{Session: session, RefreshToken: token, Err: err}, nil
+	}
+}
+
+func MakeRefreshSessionEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RefreshSessionRequest)
+		session, token, err := s.RefreshSession(ctx, req.RefreshToken, req.Device)
+		return RefreshSessionResponse{Session: session, RefreshToken: token, Err: err}, nil
+	}
+}
+
+func MakeListSessionsEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		sessions, err := s.ListSessions(ctx)
+		return ListSessionsResponse{Sessions: sessions, Err: err}, nil
+	}
+}
+
+func MakeRevokeSessionEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(RevokeSessionRequest)
+		return RevokeSessionResponse{Err: s.RevokeSession(ctx, req.ID)}, nil
+	}
+}
+
+func MakeRevokeSessionsEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		return RevokeSessionsResponse{Err: s.RevokeSessions(ctx)}, nil
+	}
+}
+
+func MakeCheckSessionEndpoint(s userservice.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(CheckSessionRequest)
+		return CheckSessionResponse{Err: s.CheckSession(ctx, req.ID)}, nil
+	}
+}
+
+// compile time assertions for our response types implementing endpoint.Failer.
+var (
+	_ endpoint.Failer = CreateSessionResponse{}
+	_ endpoint.Failer = RefreshSessionResponse{}
+	_ endpoint.Failer = ListSessionsResponse{}
+	_ endpoint.Failer = RevokeSessionResponse{}
+	_ endpoint.Failer = RevokeSessionsResponse{}
+	_ endpoint.Failer = CheckSessionResponse{}
+)
+
+// CreateSessionRequest collects the request parameters for the CreateSession method.
+type CreateSessionRequest struct {
+	Device string `json:"device,omitempty"`
+}
+
+// CreateSessionResponse collects the response values for the CreateSession method.
+type CreateSessionResponse struct {
+	Session      model.Session `json:"session"`
+	RefreshToken string        `json:"refreshToken"`
+	Err          error         `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r CreateSessionResponse) Failed() error { return r.Err }
+
+// RefreshSessionRequest collects the request parameters for the RefreshSession method.
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refreshToken"`
+	Device       string `json:"device,omitempty"`
+}
+
+// RefreshSessionResponse collects the response values for the RefreshSession method.
+type RefreshSessionResponse struct {
+	Session      model.Session `json:"session"`
+	RefreshToken string        `json:"refreshToken"`
+	Err          error         `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RefreshSessionResponse) Failed() error { return r.Err }
+
+// ListSessionsRequest collects the request parameters for the ListSessions method.
+type ListSessionsRequest struct{}
+
+// ListSessionsResponse collects the response values for the ListSessions method.
+type ListSessionsResponse struct {
+	Sessions []model.Session `json:"sessions"`
+	Err      error           `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ListSessionsResponse) Failed() error { return r.Err }
+
+// RevokeSessionRequest collects the request parameters for the RevokeSession method.
+type RevokeSessionRequest struct {
+	ID string `json:"id"`
+}
+
+// RevokeSessionResponse collects the response values for the RevokeSession method.
+type RevokeSessionResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RevokeSessionResponse) Failed() error { return r.Err }
+
+// RevokeSessionsRequest collects the request parameters for the RevokeSessions method.
+type RevokeSessionsRequest struct{}
+
+// RevokeSessionsResponse collects the response values for the RevokeSessions method.
+type RevokeSessionsResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r RevokeSessionsResponse) Failed() error { return r.Err }
+
+// CheckSessionRequest collects the request parameters for the CheckSession method.
+type CheckSessionRequest struct {
+	ID string `json:"id"`
+}
+
+// CheckSessionResponse collects the response values for the CheckSession method.
+type CheckSessionResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r CheckSessionResponse) Failed() error { return r.Err }
@@ -13,14 +13,114 @@ type Set struct {
 	GetProfileEndpoint    endpoint.Endpoint
 	UpdateProfileEndpoint endpoint.Endpoint
 	DeleteProfileEndpoint endpoint.Endpoint
+	GetSuspensionEndpoint endpoint.Endpoint
+
+	GetAccountStatusEndpoint endpoint.Endpoint
+
+	ListAuditEntriesEndpoint endpoint.Endpoint
+
+	ListUsersEndpoint   endpoint.Endpoint
+	GetUserEndpoint     endpoint.Endpoint
+	UpdateUserEndpoint  endpoint.Endpoint
+	SuspendEndpoint     endpoint.Endpoint
+	UnsuspendEndpoint   endpoint.Endpoint
+	ForceDeleteEndpoint endpoint.Endpoint
+
+	CreateAPIKeyEndpoint endpoint.Endpoint
+	ListAPIKeysEndpoint  endpoint.Endpoint
+	RevokeAPIKeyEndpoint endpoint.Endpoint
+	VerifyAPIKeyEndpoint endpoint.Endpoint
+
+	CreateSessionEndpoint  endpoint.Endpoint
+	RefreshSessionEndpoint endpoint.Endpoint
+	ListSessionsEndpoint   endpoint.Endpoint
+	RevokeSessionEndpoint  endpoint.Endpoint
+	RevokeSessionsEndpoint endpoint.Endpoint
+	CheckSessionEndpoint   endpoint.Endpoint
+
+	GetTwoFactorEndpoint endpoint.Endpoint
+	EnrollTOTPEndpoint   endpoint.Endpoint
+	ConfirmTOTPEndpoint  endpoint.Endpoint
+	VerifyTOTPEndpoint   endpoint.Endpoint
+	DisableTOTPEndpoint  endpoint.Endpoint
 }
 
-func New(s userservice.Service, logger log.Logger) Set {
+// New returns a Set of the endpoints of s, a, admin, keys, sessions and
+// twoFactor. The caller may only change their own profile and read their own
+// suspension, account status and the audit entries about themselves, unless they are an
+// admin; anyone may read profiles. Moderators may also list, read, suspend and
+// unsuspend any user, while only admins may update their profile and roles or
+// delete them. keys, sessions and twoFactor authorize their callers
+// themselves.
+func New(s userservice.Service, a userservice.AuditLog, admin userservice.AdminService, keys userservice.APIKeyService, sessions userservice.SessionService, twoFactor userservice.TwoFactorService, logger log.Logger, m Metrics) Set {
+	var (
+		moderators = []string{model.RoleModerator, model.RoleAdmin}
+		admins     = []string{model.RoleAdmin}
+	)
 	return Set{
-		CreateProfileEndpoint: MakeCreateProfileEndpoint(s),
-		GetProfileEndpoint:    MakeGetProfileEndpoint(s),
-		UpdateProfileEndpoint: MakeUpdateProfileEndpoint(s),
-		DeleteProfileEndpoint: MakeDeleteProfileEndpoint(s),
+		CreateProfileEndpoint: InstrumentingMiddleware("CreateProfile", m)(AuthorizingMiddleware(
+			func(request interface{}) []string { return []string{deref(request.(CreateProfileRequest).UUID)} },
+			func(err error) interface{} { return CreateProfileResponse{Err: err} },
+		)(MakeCreateProfileEndpoint(s))),
+		GetProfileEndpoint: InstrumentingMiddleware("GetProfile", m)(MakeGetProfileEndpoint(s)),
+		UpdateProfileEndpoint: InstrumentingMiddleware("UpdateProfile", m)(AuthorizingMiddleware(
+			func(request interface{}) []string { return []string{deref(request.(UpdateProfileRequest).UUID)} },
+			func(err error) interface{} { return UpdateProfileResponse{Err: err} },
+		)(MakeUpdateProfileEndpoint(s))),
+		DeleteProfileEndpoint: InstrumentingMiddleware("DeleteProfile", m)(AuthorizingMiddleware(
+			func(request interface{}) []string { return []string{request.(DeleteProfileRequest).UUID} },
+			func(err error) interface{} { return DeleteProfileResponse{Err: err} },
+		)(MakeDeleteProfileEndpoint(s))),
+		GetSuspensionEndpoint: InstrumentingMiddleware("GetSuspension", m)(AuthorizingMiddleware(
+			func(request interface{}) []string { return []string{request.(GetSuspensionRequest).UUID} },
+			func(err error) interface{} { return GetSuspensionResponse{Err: err} },
+		)(MakeGetSuspensionEndpoint(s))),
+		GetAccountStatusEndpoint: InstrumentingMiddleware("GetAccountStatus", m)(AuthorizingMiddleware(
+			func(request interface{}) []string { return []string{request.(GetAccountStatusRequest).UUID} },
+			func(err error) interface{} { return GetAccountStatusResponse{Err: err} },
+		)(MakeGetAccountStatusEndpoint(s))),
+		ListAuditEntriesEndpoint: InstrumentingMiddleware("ListAuditEntries", m)(AuthorizingMiddleware(
+			func(request interface{}) []string {
+				req := request.(ListAuditEntriesRequest)
+				return []string{req.Actor, req.Target}
+			},
+			func(err error) interface{} { return ListAuditEntriesResponse{Err: err} },
+		)(MakeListAuditEntriesEndpoint(a))),
+		ListUsersEndpoint: InstrumentingMiddleware("ListUsers", m)(RoleMiddleware(
+			func(err error) interface{} { return ListUsersResponse{Err: err} }, moderators...,
+		)(MakeListUsersEndpoint(admin))),
+		GetUserEndpoint: InstrumentingMiddleware("GetUser", m)(RoleMiddleware(
+			func(err error) interface{} { return GetUserResponse{Err: err} }, moderators...,
+		)(MakeGetUserEndpoint(admin))),
+		UpdateUserEndpoint: InstrumentingMiddleware("UpdateUser", m)(RoleMiddleware(
+			func(err error) interface{} { return UpdateUserResponse{Err: err} }, admins...,
+		)(MakeUpdateUserEndpoint(admin))),
+		SuspendEndpoint: InstrumentingMiddleware("Suspend", m)(RoleMiddleware(
+			func(err error) interface{} { return SuspendResponse{Err: err} }, moderators...,
+		)(MakeSuspendEndpoint(admin))),
+		UnsuspendEndpoint: InstrumentingMiddleware("Unsuspend", m)(RoleMiddleware(
+			func(err error) interface{} { return UnsuspendResponse{Err: err} }, moderators...,
+		)(MakeUnsuspendEndpoint(admin))),
+		ForceDeleteEndpoint: InstrumentingMiddleware("ForceDelete", m)(RoleMiddleware(
+			func(err error) interface{} { return ForceDeleteResponse{Err: err} }, admins...,
+		)(MakeForceDeleteEndpoint(admin))),
+		CreateAPIKeyEndpoint: InstrumentingMiddleware("CreateAPIKey", m)(MakeCreateAPIKeyEndpoint(keys)),
+		ListAPIKeysEndpoint:  InstrumentingMiddleware("ListAPIKeys", m)(MakeListAPIKeysEndpoint(keys)),
+		RevokeAPIKeyEndpoint: InstrumentingMiddleware("RevokeAPIKey", m)(MakeRevokeAPIKeyEndpoint(keys)),
+		VerifyAPIKeyEndpoint: InstrumentingMiddleware("VerifyAPIKey", m)(MakeVerifyAPIKeyEndpoint(keys)),
+
+		CreateSessionEndpoint:  InstrumentingMiddleware("CreateSession", m)(MakeCreateSessionEndpoint(sessions)),
+		RefreshSessionEndpoint: InstrumentingMiddleware("RefreshSession", m)(MakeRefreshSessionEndpoint(sessions)),
+		ListSessionsEndpoint:   InstrumentingMiddleware("ListSessions", m)(MakeListSessionsEndpoint(sessions)),
+		RevokeSessionEndpoint:  InstrumentingMiddleware("RevokeSession", m)(MakeRevokeSessionEndpoint(sessions)),
+		RevokeSessionsEndpoint: InstrumentingMiddleware("RevokeSessions", m)(MakeRevokeSessionsEndpoint(sessions)),
+		CheckSessionEndpoint:   InstrumentingMiddleware("CheckSession", m)(MakeCheckSessionEndpoint(sessions)),
+
+		GetTwoFactorEndpoint: InstrumentingMiddleware("GetTwoFactor", m)(MakeGetTwoFactorEndpoint(twoFactor)),
+		EnrollTOTPEndpoint:   InstrumentingMiddleware("EnrollTOTP", m)(MakeEnrollTOTPEndpoint(twoFactor)),
+		ConfirmTOTPEndpoint:  InstrumentingMiddleware("ConfirmTOTP", m)(MakeConfirmTOTPEndpoint(twoFactor)),
+		VerifyTOTPEndpoint:   InstrumentingMiddleware("VerifyTOTP", m)(MakeVerifyTOTPEndpoint(twoFactor)),
+		DisableTOTPEndpoint:  InstrumentingMiddleware("DisableTOTP", m)(MakeDisableTOTPEndpoint(twoFactor)),
 	}
 }
 
@@ -87,6 +187,40 @@ func (s Set) DeleteProfile(ctx context.Context, uid string) error {
 	return resp.Err
 }
 
+func (s Set) GetSuspension(ctx context.Context, uid string) (*model.Suspension, error) {
+	response, err := s.GetSuspensionEndpoint(ctx, GetSuspensionRequest{UUID: uid})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(GetSuspensionResponse)
+	return resp.Suspension, resp.Err
+}
+
+func (s Set) GetAccountStatus(ctx context.Context, uid string) (model.AccountStatus, error) {
+	response, err := s.GetAccountStatusEndpoint(ctx, GetAccountStatusRequest{UUID: uid})
+	if err != nil {
+		return model.AccountStatus{}, err
+	}
+	resp := response.(GetAccountStatusResponse)
+	return resp.Status, resp.Err
+}
+
+// ListAuditEntries implements AuditLog. Primarily useful in a client.
+func (s Set) ListAuditEntries(ctx context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error) {
+	request := ListAuditEntriesRequest{
+		Actor:     q.Actor,
+		Target:    q.Target,
+		PageToken: q.PageToken,
+		PageSize:  q.PageSize,
+	}
+	response, err := s.ListAuditEntriesEndpoint(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	resp := response.(ListAuditEntriesResponse)
+	return resp.Entries, resp.NextPageToken, resp.Err
+}
+
 func MakeCreateProfileEndpoint(s userservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(CreateProfileRequest)
@@ -143,12 +277,44 @@ func MakeDeleteProfileEndpoint(s userservice.Service) endpoint.Endpoint {
 
 }
 
+func MakeGetSuspensionEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(GetSuspensionRequest)
+		suspension, err := s.GetSuspension(ctx, req.UUID)
+		return GetSuspensionResponse{Suspension: suspension, Err: err}, nil
+	}
+}
+
+func MakeGetAccountStatusEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(GetAccountStatusRequest)
+		status, err := s.GetAccountStatus(ctx, req.UUID)
+		return GetAccountStatusResponse{Status: status, Err: err}, nil
+	}
+}
+
+func MakeListAuditEntriesEndpoint(a userservice.AuditLog) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListAuditEntriesRequest)
+		entries, next, err := a.ListAuditEntries(ctx, model.AuditQuery{
+			Actor:     req.Actor,
+			Target:    req.Target,
+			PageToken: req.PageToken,
+			PageSize:  req.PageSize,
+		})
+		return ListAuditEntriesResponse{Entries: entries, NextPageToken: next, Err: err}, nil
+	}
+}
+
 // compile time assertions for our response types implementing endpoint.Failer.
 var (
 	_ endpoint.Failer = CreateProfileResponse{}
 	_ endpoint.Failer = GetProfileResponse{}
 	_ endpoint.Failer = UpdateProfileResponse{}
 	_ endpoint.Failer = DeleteProfileResponse{}
+	_ endpoint.Failer = GetSuspensionResponse{}
+	_ endpoint.Failer = GetAccountStatusResponse{}
+	_ endpoint.Failer = ListAuditEntriesResponse{}
 )
 
 // CreateProfileRequest collects the request parameters for the CreateProfile method.
@@ -220,3 +386,57 @@ type DeleteProfileResponse struct {
 
 // Failed implements endpoint.Failer.
 func (r DeleteProfileResponse) Failed() error { return r.Err }
+
+// GetSuspensionRequest collects the request parameters for the GetSuspension method.
+type GetSuspensionRequest struct {
+	UUID string `json:"uid"`
+}
+
+// GetSuspensionResponse collects the response values for the GetSuspension method.
+// Suspension is nil unless the user is suspended.
+type GetSuspensionResponse struct {
+	Suspension *model.Suspension `json:"suspension,omitempty"`
+	Err        error             `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r GetSuspensionResponse) Failed() error { return r.Err }
+
+// GetAccountStatusRequest collects the request parameters for the GetAccountStatus method.
+type GetAccountStatusRequest struct {
+	UUID string `json:"uid"`
+}
+
+// GetAccountStatusResponse collects the response values for the GetAccountStatus method.
+type GetAccountStatusResponse struct {
+	Status model.AccountStatus `json:"status"`
+	Err    error               `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r GetAccountStatusResponse) Failed() error { return r.Err }
+
+// ListAuditEntriesRequest collects the request parameters for the ListAuditEntries method.
+type ListAuditEntriesRequest struct {
+	Actor     string `json:"actor,omitempty"`
+	Target    string `json:"target,omitempty"`
+	PageToken string `json:"pageToken,omitempty"`
+	PageSize  int    `json:"pageSize,omitempty"`
+}
+
+// ListAuditEntriesResponse collects the response values for the ListAuditEntries method.
+type ListAuditEntriesResponse struct {
+	Entries       []model.AuditEntry `json:"entries"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+	Err           error              `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ListAuditEntriesResponse) Failed() error { return r.Err }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,151 @@
+package userendpoint
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"testing"
+)
+
+// stubAuditLog returns one entry for any query.
+type stubAuditLog struct{}
+
+func (stubAuditLog) ListAuditEntries(_ context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error) {
+	return []model.AuditEntry{{Actor: q.Actor, Target: q.Target}}, "", nil
+}
+
+func testMetrics() Metrics {
+	return Metrics{Requests: discard.NewCounter(), Duration: discard.NewHistogram()}
+}
+
+func TestListAuditEntriesAuthorization(t *testing.T) {
+	set := New(nil, stubAuditLog{}, nil, nil, nil, nil, log.NewNopLogger(), testMetrics())
+	for _, tc := range []struct {
+		name    string
+		caller  *userservice.Caller
+		q       model.AuditQuery
+		wantErr error
+	}{
+		{"anonymous", nil, model.AuditQuery{Target: "u1"}, userservice.ErrUnauthenticated},
+		{"entries about another user", &userservice.Caller{ID: "u2"}, model.AuditQuery{Target: "u1"}, userservice.ErrPermissionDenied},
+		{"all entries", &userservice.Caller{ID: "u2"}, model.AuditQuery{}, userservice.ErrPermissionDenied},
+		{"moderator", &userservice.Caller{ID: "u2", Roles: []string{model.RoleModerator}}, model.AuditQuery{Target: "u1"}, userservice.ErrPermissionDenied},
+		{"entries about themselves", &userservice.Caller{ID: "u1"}, model.AuditQuery{Target: "u1"}, nil},
+		{"entries by themselves", &userservice.Caller{ID: "u1"}, model.AuditQuery{Actor: "u1"}, nil},
+		{"admin", &userservice.Caller{ID: "u2", Roles: []string{model.RoleAdmin}}, model.AuditQuery{}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.caller != nil {
+				ctx = userservice.ContextWithCaller(ctx, *tc.caller)
+			}
+			entries, _, err := set.ListAuditEntries(ctx, tc.q)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && len(entries) != 1 {
+				t.Errorf("got %d entries, want 1", len(entries))
+			}
+		})
+	}
+}
+
+// recordingService records the users whose profiles are changed.
+type recordingService struct {
+	userservice.Service
+	changed []string
+}
+
+func (s *recordingService) UpdateProfile(_ context.Context, u model.User) error {
+	s.changed = append(s.changed, *u.UUID)
+	return nil
+}
+
+func (s *recordingService) DeleteProfile(_ context.Context, uid string) error {
+	s.changed = append(s.changed, uid)
+	return nil
+}
+
+func TestProfileAuthorization(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		caller  *userservice.Caller
+		wantErr error
+	}{
+		{"anonymous", nil, userservice.ErrUnauthenticated},
+		{"another user", &userservice.Caller{ID: "u2"}, userservice.ErrPermissionDenied},
+		{"moderator", &userservice.Caller{ID: "u2", Roles: []string{model.RoleModerator}}, userservice.ErrPermissionDenied},
+		{"the user", &userservice.Caller{ID: "u1"}, nil},
+		{"admin", &userservice.Caller{ID: "u2", Roles: []string{model.RoleAdmin}}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &recordingService{}
+			set := New(s, nil, nil, nil, nil, nil, log.NewNopLogger(), testMetrics())
+			ctx := context.Background()
+			if tc.caller != nil {
+				ctx = userservice.ContextWithCaller(ctx, *tc.caller)
+			}
+			uid := "u1"
+			if err := set.UpdateProfile(ctx, model.User{UUID: &uid}); !errors.Is(err, tc.wantErr) {
+				t.Errorf("UpdateProfile() = %v, want %v", err, tc.wantErr)
+			}
+			if err := set.DeleteProfile(ctx, uid); !errors.Is(err, tc.wantErr) {
+				t.Errorf("DeleteProfile() = %v, want %v", err, tc.wantErr)
+			}
+			wantChanged := 2
+			if tc.wantErr != nil {
+				wantChanged = 0
+			}
+			if len(s.changed) != wantChanged {
+				t.Errorf("profile changed %d times, want %d", len(s.changed), wantChanged)
+			}
+		})
+	}
+}
+
+// stubAdmin answers every call of an admin.
+type stubAdmin struct{ userservice.AdminService }
+
+func (stubAdmin) GetUser(_ context.Context, uid string) (model.User, error) {
+	return model.User{UUID: &uid}, nil
+}
+
+func (stubAdmin) UpdateUser(context.Context, model.User, string) error { return nil }
+
+func (stubAdmin) ForceDelete(context.Context, string, string) error { return nil }
+
+func TestAdminAuthorization(t *testing.T) {
+	set := New(nil, nil, stubAdmin{}, nil, nil, nil, log.NewNopLogger(), testMetrics())
+	uid := "u1"
+	for _, tc := range []struct {
+		name        string
+		caller      *userservice.Caller
+		wantRead    error
+		wantUpdate  error
+		wantDeleted error
+	}{
+		{"anonymous", nil, userservice.ErrUnauthenticated, userservice.ErrUnauthenticated, userservice.ErrUnauthenticated},
+		{"user", &userservice.Caller{ID: "u1"}, userservice.ErrPermissionDenied, userservice.ErrPermissionDenied, userservice.ErrPermissionDenied},
+		{"moderator", &userservice.Caller{ID: "u2", Roles: []string{model.RoleModerator}}, nil, userservice.ErrPermissionDenied, userservice.ErrPermissionDenied},
+		{"admin", &userservice.Caller{ID: "u2", Roles: []string{model.RoleAdmin}}, nil, nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.caller != nil {
+				ctx = userservice.ContextWithCaller(ctx, *tc.caller)
+			}
+			if _, err := set.GetUser(ctx, uid); !errors.Is(err, tc.wantRead) {
+				t.Errorf("GetUser() = %v, want %v", err, tc.wantRead)
+			}
+			if err := set.UpdateUser(ctx, model.User{UUID: &uid}, "support ticket"); !errors.Is(err, tc.wantUpdate) {
+				t.Errorf("UpdateUser() = %v, want %v", err, tc.wantUpdate)
+			}
+			if err := set.ForceDelete(ctx, uid, "support ticket"); !errors.Is(err, tc.wantDeleted) {
+				t.Errorf("ForceDelete() = %v, want %v", err, tc.wantDeleted)
+			}
+		})
+	}
+}
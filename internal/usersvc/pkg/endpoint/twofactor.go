@@ -0,0 +1,162 @@
+package userendpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+)
+
+// GetTwoFactor implements TwoFactorService. Primarily useful in a client.
+func (s Set) GetTwoFactor(ctx context.Context) (model.TwoFactor, error) {
+	response, err := s.GetTwoFactorEndpoint(ctx, GetTwoFactorRequest{})
+	if err != nil {
+		return model.TwoFactor{}, err
+	}
+	resp := response.(GetTwoFactorResponse)
+	return resp.TwoFactor, resp.Err
+}
+
+func (s Set) EnrollTOTP(ctx context.Context) (string, string, error) {
+	response, err := s.EnrollTOTPEndpoint(ctx, EnrollTOTPRequest{})
+	if err != nil {
+		return "", "", err
+	}
+	resp := response.(EnrollTOTPResponse)
+	return resp.Secret, resp.URI, resp.Err
+}
+
+func (s Set) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	response, err := s.ConfirmTOTPEndpoint(ctx, ConfirmTOTPRequest{Code: code})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(ConfirmTOTPResponse)
+	return resp.RecoveryCodes, resp.Err
+}
+
+func (s Set) VerifyTOTP(ctx context.Context, code string) error {
+	response, err := s.VerifyTOTPEndpoint(ctx, VerifyTOTPRequest{Code: code})
+	if err != nil {
+		return err
+	}
+	return response.(VerifyTOTPResponse).Err
+}
+
+func (s Set) DisableTOTP(ctx context.Context, code string) error {
+	response, err := s.DisableTOTPEndpoint(ctx, DisableTOTPRequest{Code: code})
+	if err != nil {
+		return err
+	}
+	return response.(DisableTOTPResponse).Err
+}
+
+func MakeGetTwoFactorEndpoint(s userservice.TwoFactorService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		tf, err := s.GetTwoFactor(ctx)
+		return GetTwoFactorResponse{TwoFactor: tf, Err: err}, nil
+	}
+}
+
+func MakeEnrollTOTPEndpoint(s userservice.TwoFactorService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		secret, uri, err := s.EnrollTOTP(ctx)
+		return EnrollTOTPResponse{Secret: secret, URI: uri, Err: err}, nil
+	}
+}
+
+func MakeConfirmTOTPEndpoint(s userservice.TwoFactorService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ConfirmTOTPRequest)
+		codes, err := s.ConfirmTOTP(ctx, req.Code)
+		return ConfirmTOTPResponse{RecoveryCodes: codes, Err: err}, nil
+	}
+}
+
+func MakeVerifyTOTPEndpoint(s userservice.TwoFactorService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(VerifyTOTPRequest)
+		return VerifyTOTPResponse{Err: s.VerifyTOTP(ctx, req.Code)}, nil
+	}
+}
+
+func MakeDisableTOTPEndpoint(s userservice.TwoFactorService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(DisableTOTPRequest)
+		return DisableTOTPResponse{Err: s.DisableTOTP(ctx, req.Code)}, nil
+	}
+}
+
+// compile time assertions for our response types implementing endpoint.Failer.
+var (
+	_ endpoint.Failer = GetTwoFactorResponse{}
+	_ endpoint.Failer = EnrollTOTPResponse{}
+	_ endpoint.Failer = ConfirmTOTPResponse{}
+	_ endpoint.Failer = VerifyTOTPResponse{}
+	_ endpoint.Failer = DisableTOTPResponse{}
+)
+
+// GetTwoFactorRequest collects the request parameters for the GetTwoFactor method.
+type GetTwoFactorRequest struct{}
+
+// GetTwoFactorResponse collects the response values for the GetTwoFactor method.
+type GetTwoFactorResponse struct {
+	TwoFactor model.TwoFactor `json:"twoFactor"`
+	Err       error           `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r GetTwoFactorResponse) Failed() error { return r.Err }
+
+// EnrollTOTPRequest collects the request parameters for the EnrollTOTP method.
+type EnrollTOTPRequest struct{}
+
+// EnrollTOTPResponse collects the response values for the EnrollTOTP method.
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	Err    error  `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r EnrollTOTPResponse) Failed() error { return r.Err }
+
+// ConfirmTOTPRequest collects the request parameters for the ConfirmTOTP method.
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// ConfirmTOTPResponse collects the response values for the ConfirmTOTP method.
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+	Err           error    `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r ConfirmTOTPResponse) Failed() error { return r.Err }
+
+// VerifyTOTPRequest collects the request parameters for the VerifyTOTP method.
+type VerifyTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// VerifyTOTPResponse collects the response values for the VerifyTOTP method.
+type VerifyTOTPResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r VerifyTOTPResponse) Failed() error { return r.Err }
+
+// DisableTOTPRequest collects the request parameters for the DisableTOTP method.
+type DisableTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPResponse collects the response values for the DisableTOTP method.
+type DisableTOTPResponse struct {
+	Err error `json:"-"`
+}
+
+// Failed implements endpoint.Failer.
+func (r DisableTOTPResponse) Failed() error { return r.Err }
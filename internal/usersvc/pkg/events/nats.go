@@ -0,0 +1,63 @@
+package userevents
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"strconv"
+	"time"
+)
+
+// duplicateWindow is how long JetStream remembers message IDs to drop duplicates.
+// It should comfortably exceed the time the relay needs to retry a batch.
+const duplicateWindow = 10 * time.Minute
+
+var subjectTokens = map[model.EventType]string{
+	model.EventUserCreated:     "created",
+	model.EventUserUpdated:     "updated",
+	model.EventUserDeleted:     "deleted",
+	model.EventUserSuspended:   "suspended",
+	model.EventUserUnsuspended: "unsuspended",
+}
+
+type natsPublisher struct {
+	js            jetstream.JetStream
+	subjectPrefix string
+}
+
+// NewNATSPublisher returns a Publisher that publishes events as JSON to a NATS
+// JetStream stream, creating the stream if needed. Events are published on
+// <subjectPrefix>.created, .updated, .deleted, .suspended and .unsuspended,
+// with the event ID as the JetStream message ID so that redelivered events are
+// dropped by the server.
+func NewNATSPublisher(ctx context.Context, nc *nats.Conn, stream, subjectPrefix string) (*natsPublisher, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       stream,
+		Subjects:   []string{subjectPrefix + ".>"},
+		Duplicates: duplicateWindow,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{js: js, subjectPrefix: subjectPrefix}, nil
+}
+
+// Publish waits for the stream to acknowledge the event.
+func (p *natsPublisher) Publish(ctx context.Context, e model.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(p.subjectPrefix + "." + subjectTokens[e.Type])
+	msg.Data = data
+	msg.Header.Set("User-Id", e.UserID)
+	msg.Header.Set("Sequence", strconv.FormatInt(e.Sequence, 10))
+	_, err = p.js.PublishMsg(ctx, msg, jetstream.WithMsgID(e.ID))
+	return err
+}
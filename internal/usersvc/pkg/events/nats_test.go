@@ -0,0 +1,243 @@
+package userevents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"io"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJetStream is a NATS server speaking just enough of the client protocol
+// and the JetStream API for a publisher: it creates streams, stores the
+// messages published on their subjects, dropping those whose Nats-Msg-Id it
+// saw, and acknowledges them. With ack set to "error", it answers publishes
+// with an API error instead, and with "none", not at all.
+type fakeJetStream struct {
+	ln net.Listener
+
+	mtx     sync.Mutex
+	streams map[string]*fakeStream
+	ack     string
+}
+
+type fakeStream struct {
+	config   jetstream.StreamConfig
+	messages []*nats.Msg
+	ids      map[string]int // sequence by message ID
+}
+
+func newFakeJetStream(t *testing.T) *fakeJetStream {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeJetStream{ln: ln, streams: map[string]*fakeStream{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeJetStream) url() string { return "nats://" + s.ln.Addr().String() }
+
+// stream returns the config of stream name and the messages stored in it.
+func (s *fakeJetStream) stream(name string) (jetstream.StreamConfig, []*nats.Msg, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	stream, ok := s.streams[name]
+	if !ok {
+		return jetstream.StreamConfig{}, nil, false
+	}
+	return stream.config, slices.Clone(stream.messages), true
+}
+
+func (s *fakeJetStream) setAck(ack string) {
+	s.mtx.Lock()
+	s.ack = ack
+	s.mtx.Unlock()
+}
+
+func (s *fakeJetStream) serve(conn net.Conn) {
+	defer conn.Close()
+	subs := map[string]string{} // subject by subscription ID
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		fields := strings.Fields(args)
+		switch strings.ToUpper(op) {
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			subs[fields[len(fields)-1]] = fields[0]
+		case "UNSUB":
+			delete(subs, fields[0])
+		case "PUB", "HPUB":
+			var subject, reply string
+			subject, fields = fields[0], fields[1:]
+			hdrLen := 0
+			if op == "HPUB" {
+				hdrLen, _ = strconv.Atoi(fields[len(fields)-2])
+			}
+			if len(fields) == 3 || (op == "PUB" && len(fields) == 2) {
+				reply = fields[0]
+			}
+			total, _ := strconv.Atoi(fields[len(fields)-1])
+			buf := make([]byte, total+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			msg := &nats.Msg{Subject: subject, Header: nats.Header{}, Data: buf[hdrLen:total]}
+			if hdrLen > 0 {
+				tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(buf[:hdrLen])))
+				tr.ReadLine() // NATS/1.0
+				h, _ := tr.ReadMIMEHeader()
+				msg.Header = nats.Header(h)
+			}
+			if resp := s.handle(msg); resp != nil && reply != "" {
+				for sid, pattern := range subs {
+					if subjectMatches(pattern, reply) {
+						fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", reply, sid, len(resp), resp)
+					}
+				}
+			}
+		}
+	}
+}
+
+// handle serves a JetStream API request or stores a message, and returns the
+// response to send, if any.
+func (s *fakeJetStream) handle(msg *nats.Msg) []byte {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if name, ok := strings.CutPrefix(msg.Subject, "$JS.API.STREAM.UPDATE."); ok {
+		if _, ok := s.streams[name]; !ok {
+			return []byte(`{"error":{"code":404,"err_code":10059,"description":"stream not found"}}`)
+		}
+	}
+	if name, ok := strings.CutPrefix(msg.Subject, "$JS.API.STREAM.CREATE."); ok {
+		var cfg jetstream.StreamConfig
+		json.Unmarshal(msg.Data, &cfg)
+		s.streams[name] = &fakeStream{config: cfg, ids: map[string]int{}}
+		resp, _ := json.Marshal(jetstream.StreamInfo{Config: cfg, Created: time.Now()})
+		return resp
+	}
+	for name, stream := range s.streams {
+		if !slices.ContainsFunc(stream.config.Subjects, func(p string) bool { return subjectMatches(p, msg.Subject) }) {
+			continue
+		}
+		switch s.ack {
+		case "none":
+			return nil
+		case "error":
+			return []byte(`{"error":{"code":503,"err_code":10077,"description":"insufficient resources"}}`)
+		}
+		id := msg.Header.Get(jetstream.MsgIDHeader)
+		if seq, ok := stream.ids[id]; ok && id != "" {
+			return []byte(fmt.Sprintf(`{"stream":%q,"seq":%d,"duplicate":true}`, name, seq))
+		}
+		stream.messages = append(stream.messages, msg)
+		stream.ids[id] = len(stream.messages)
+		return []byte(fmt.Sprintf(`{"stream":%q,"seq":%d}`, name, len(stream.messages)))
+	}
+	return nil
+}
+
+// subjectMatches reports whether subject matches pattern, which may hold the
+// * and > wildcards.
+func subjectMatches(pattern, subject string) bool {
+	p, s := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, token := range p {
+		switch {
+		case token == ">":
+			return len(s) > i
+		case i >= len(s), token != "*" && token != s[i]:
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	server := newFakeJetStream(t)
+	nc, err := nats.Connect(server.url(), nats.NoReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	ctx := context.Background()
+	p, err := NewNATSPublisher(ctx, nc, "USERS", "users.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, ok := server.stream("USERS")
+	if !ok {
+		t.Fatal("stream not created")
+	}
+	if len(cfg.Subjects) != 1 || cfg.Subjects[0] != "users.events.>" || cfg.Duplicates != duplicateWindow {
+		t.Errorf("stream config = %+v", cfg)
+	}
+
+	e := model.Event{ID: "e1", Type: model.EventUserSuspended, UserID: "u1", Sequence: 3}
+	if err := p.Publish(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	// The relay publishes the event again after failing to mark it published.
+	if err := p.Publish(ctx, e); err != nil {
+		t.Fatalf("Publish() of a duplicate = %v", err)
+	}
+	_, messages, _ := server.stream("USERS")
+	if len(messages) != 1 {
+		t.Fatalf("stream holds %d messages, want the duplicate dropped", len(messages))
+	}
+	msg := messages[0]
+	if msg.Subject != "users.events.suspended" {
+		t.Errorf("published on %q", msg.Subject)
+	}
+	for k, want := range map[string]string{jetstream.MsgIDHeader: "e1", "User-Id": "u1", "Sequence": "3"} {
+		if got := msg.Header.Get(k); got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	var got model.Event
+	if err := json.Unmarshal(msg.Data, &got); err != nil || got.ID != e.ID || got.UserID != e.UserID {
+		t.Errorf("published %s, %v", msg.Data, err)
+	}
+
+	// Events are only published once the stream acknowledged them.
+	server.setAck("error")
+	if err := p.Publish(ctx, model.Event{ID: "e2", Type: model.EventUserCreated, UserID: "u2"}); err == nil {
+		t.Error("Publish() = nil on an error from the stream")
+	}
+	server.setAck("none")
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := p.Publish(timeout, model.Event{ID: "e3", Type: model.EventUserCreated, UserID: "u3"}); err == nil {
+		t.Error("Publish() = nil without an acknowledgement")
+	}
+}
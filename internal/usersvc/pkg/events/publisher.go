@@ -0,0 +1,50 @@
+package userevents
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"sync"
+)
+
+// Publisher delivers domain events to interested parties. Publish must only
+// return nil once the event has been accepted, since the relay marks the event
+// as published afterwards and never retries it.
+type Publisher interface {
+	Publish(ctx context.Context, e model.Event) error
+}
+
+// Handler consumes events delivered by an InProcPublisher.
+type Handler func(ctx context.Context, e model.Event) error
+
+// InProcPublisher delivers events synchronously to handlers in the same process.
+type InProcPublisher struct {
+	mtx      sync.RWMutex
+	handlers []Handler
+}
+
+func NewInProcPublisher() *InProcPublisher {
+	return &InProcPublisher{}
+}
+
+// Subscribe registers h to receive every event published from now on.
+func (p *InProcPublisher) Subscribe(h Handler) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.handlers = append(p.handlers, h)
+}
+
+// Publish calls every handler in turn and fails on the first handler error,
+// in which case the event is published again later, including to the handlers
+// that already succeeded.
+func (p *InProcPublisher) Publish(ctx context.Context, e model.Event) error {
+	p.mtx.RLock()
+	handlers := p.handlers
+	p.mtx.RUnlock()
+
+	for _, h := range handlers {
+		if err := h(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package userevents
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"sort"
+	"time"
+)
+
+const (
+	relayBatchSize = 100
+	// relayPublishTimeout bounds each Publish, so that the relay can renew its
+	// lease before it runs out in the middle of one.
+	relayPublishTimeout = 5 * time.Second
+)
+
+// errLeaseLost is returned by a relay whose lease was taken over while it
+// was publishing, which it stops doing.
+var errLeaseLost = errors.New("outbox lease lost")
+
+// Outbox is the store of events written alongside the changes they describe.
+type Outbox interface {
+	// Pending returns up to limit events that have not been published yet,
+	// oldest first.
+	Pending(ctx context.Context, limit int) ([]model.Event, error)
+	// MarkPublished records that the given events have been published.
+	MarkPublished(ctx context.Context, ids []string) error
+	// Lease grants holder exclusive use of the outbox for ttl, or extends a
+	// lease it already holds. It reports false if another holder has it.
+	Lease(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+}
+
+// Relay moves events from an Outbox to a Publisher.
+//
+// Delivery is at least once: an event is marked as published only after Publish
+// succeeds, so a crash in between publishes it again. Events of the same user
+// are published in sequence order, and a failure holds back that user's later
+// events until the next round. Only the relay holding the outbox lease
+// publishes, so running one relay per usersvc instance is safe: the lease is
+// renewed before any publish it could run out during, however long a batch
+// takes.
+type Relay struct {
+	outbox         Outbox
+	publisher      Publisher
+	interval       time.Duration
+	publishTimeout time.Duration
+	leaseTTL       time.Duration
+	leasedUntil    time.Time
+	holder         string
+	logger         log.Logger
+}
+
+func NewRelay(outbox Outbox, publisher Publisher, interval time.Duration, logger log.Logger) *Relay {
+	return &Relay{
+		outbox:         outbox,
+		publisher:      publisher,
+		interval:       interval,
+		publishTimeout: relayPublishTimeout,
+		leaseTTL:       3*interval + relayPublishTimeout,
+		holder:         uuid.NewString(),
+		logger:         logger,
+	}
+}
+
+// Run polls the outbox every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.relay(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Log("component", "relay", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes pending events in batches while it holds the lease.
+func (r *Relay) relay(ctx context.Context) error {
+	for {
+		if err := r.lease(ctx); err != nil {
+			if errors.Is(err, errLeaseLost) {
+				return nil
+			}
+			return err
+		}
+		events, err := r.outbox.Pending(ctx, relayBatchSize)
+		if err != nil {
+			return err
+		}
+		published, err := r.publish(ctx, events)
+		if len(published) > 0 {
+			if err := r.outbox.MarkPublished(ctx, published); err != nil {
+				return err
+			}
+		}
+		if err != nil || len(events) < relayBatchSize {
+			return err
+		}
+	}
+}
+
+// lease takes or renews the outbox lease, or returns errLeaseLost if another
+// relay holds it.
+func (r *Relay) lease(ctx context.Context) error {
+	now := time.Now()
+	ok, err := r.outbox.Lease(ctx, r.holder, r.leaseTTL)
+	if err != nil || !ok {
+		r.leasedUntil = time.Time{}
+		if err == nil {
+			err = errLeaseLost
+		}
+		return err
+	}
+	r.leasedUntil = now.Add(r.leaseTTL)
+	return nil
+}
+
+// publish publishes events user by user, in sequence order, and returns the IDs
+// of the events that were published. It stops publishing a user's events at the
+// first failure and returns the last error after trying every user. It stops
+// altogether if it loses the lease.
+func (r *Relay) publish(ctx context.Context, events []model.Event) ([]string, error) {
+	var (
+		users  []string
+		byUser = map[string][]model.Event{}
+	)
+	for _, e := range events {
+		if _, ok := byUser[e.UserID]; !ok {
+			users = append(users, e.UserID)
+		}
+		byUser[e.UserID] = append(byUser[e.UserID], e)
+	}
+
+	var (
+		published []string
+		lastErr   error
+	)
+	for _, uid := range users {
+		userEvents := byUser[uid]
+		sort.Slice(userEvents, func(i, j int) bool { return userEvents[i].Sequence < userEvents[j].Sequence })
+		for _, e := range userEvents {
+			if time.Until(r.leasedUntil) < r.publishTimeout {
+				if err := r.lease(ctx); err != nil {
+					return published, err
+				}
+			}
+			pctx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+			err := r.publisher.Publish(pctx, e)
+			cancel()
+			if err != nil {
+				r.logger.Log("component", "relay", "event", e.ID, "user", e.UserID, "err", err)
+				lastErr = err
+				break
+			}
+			published = append(published, e.ID)
+		}
+	}
+	return published, lastErr
+}
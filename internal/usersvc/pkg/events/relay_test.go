@@ -0,0 +1,214 @@
+package userevents
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memOutbox is an Outbox in memory whose lease is held by leaseHolder, or by
+// whoever asks first if it is empty. It counts the leases taken or renewed.
+type memOutbox struct {
+	events      []model.Event
+	published   map[string]bool
+	leaseHolder string
+	leases      int
+}
+
+func (o *memOutbox) Pending(_ context.Context, limit int) ([]model.Event, error) {
+	var pending []model.Event
+	for _, e := range o.events {
+		if !o.published[e.ID] && len(pending) < limit {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (o *memOutbox) MarkPublished(_ context.Context, ids []string) error {
+	for _, id := range ids {
+		o.published[id] = true
+	}
+	return nil
+}
+
+func (o *memOutbox) Lease(_ context.Context, holder string, _ time.Duration) (bool, error) {
+	if o.leaseHolder == "" {
+		o.leaseHolder = holder
+	}
+	if o.leaseHolder != holder {
+		return false, nil
+	}
+	o.leases++
+	return true, nil
+}
+
+// recordingPublisher records the IDs of the events published and fails those
+// listed in fail. It calls onPublish, if set, after each event.
+type recordingPublisher struct {
+	published []string
+	fail      map[string]bool
+	onPublish func(e model.Event)
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, e model.Event) error {
+	if p.fail[e.ID] {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, e.ID)
+	if p.onPublish != nil {
+		p.onPublish(e)
+	}
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	events := []model.Event{
+		{ID: "a2", UserID: "a", Sequence: 2},
+		{ID: "b1", UserID: "b", Sequence: 1},
+		{ID: "a1", UserID: "a", Sequence: 1},
+		{ID: "a3", UserID: "a", Sequence: 3},
+	}
+	for _, tc := range []struct {
+		name          string
+		leaseHolder   string
+		fail          []string
+		wantPublished []string
+		wantMarked    []string
+		wantErr       bool
+	}{
+		{
+			name:          "in sequence order per user",
+			wantPublished: []string{"a1", "a2", "a3", "b1"},
+			wantMarked:    []string{"a1", "a2", "a3", "b1"},
+		},
+		{
+			name:          "failure holds back the user's later events",
+			fail:          []string{"a2"},
+			wantPublished: []string{"a1", "b1"},
+			wantMarked:    []string{"a1", "b1"},
+			wantErr:       true,
+		},
+		{
+			name:        "lease held by another relay",
+			leaseHolder: "other",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outbox := &memOutbox{events: events, published: map[string]bool{}, leaseHolder: tc.leaseHolder}
+			publisher := &recordingPublisher{fail: map[string]bool{}}
+			for _, id := range tc.fail {
+				publisher.fail[id] = true
+			}
+			r := NewRelay(outbox, publisher, time.Second, log.NewNopLogger())
+
+			err := r.relay(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("relay() = %v, want error %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(publisher.published, tc.wantPublished) {
+				t.Errorf("published %v, want %v", publisher.published, tc.wantPublished)
+			}
+			var marked []string
+			for id := range outbox.published {
+				marked = append(marked, id)
+			}
+			sort.Strings(marked)
+			if !reflect.DeepEqual(marked, tc.wantMarked) {
+				t.Errorf("marked %v as published, want %v", marked, tc.wantMarked)
+			}
+		})
+	}
+}
+
+func TestRelayBatches(t *testing.T) {
+	outbox := &memOutbox{published: map[string]bool{}}
+	for i := range relayBatchSize + 1 {
+		outbox.events = append(outbox.events, model.Event{ID: strconv.Itoa(i), UserID: "u", Sequence: int64(i)})
+	}
+	publisher := &recordingPublisher{}
+	if err := NewRelay(outbox, publisher, time.Second, log.NewNopLogger()).relay(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.published) != relayBatchSize+1 {
+		t.Errorf("published %d events, want %d", len(publisher.published), relayBatchSize+1)
+	}
+}
+
+func TestRelayLease(t *testing.T) {
+	events := []model.Event{
+		{ID: "a1", UserID: "a", Sequence: 1},
+		{ID: "a2", UserID: "a", Sequence: 2},
+		{ID: "b1", UserID: "b", Sequence: 1},
+		{ID: "c1", UserID: "c", Sequence: 1},
+	}
+	for _, tc := range []struct {
+		name          string
+		takenAfter    string // event after which another relay takes the lease
+		wantPublished []string
+		wantErr       error
+	}{
+		{"renewed during the batch", "", []string{"a1", "a2", "b1", "c1"}, nil},
+		{"taken over during the batch", "a2", []string{"a1", "a2"}, errLeaseLost},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outbox := &memOutbox{events: events, published: map[string]bool{}}
+			publisher := &recordingPublisher{onPublish: func(e model.Event) {
+				// Each publish takes as long as it may, so the lease must be
+				// renewed before the next one.
+				time.Sleep(10 * time.Millisecond)
+				if e.ID == tc.takenAfter {
+					outbox.leaseHolder = "other"
+				}
+			}}
+			r := NewRelay(outbox, publisher, time.Second, log.NewNopLogger())
+			r.publishTimeout, r.leaseTTL = 10*time.Millisecond, 15*time.Millisecond
+
+			if err := r.relay(context.Background()); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("relay() = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(publisher.published, tc.wantPublished) {
+				t.Errorf("published %v, want %v", publisher.published, tc.wantPublished)
+			}
+			if len(outbox.published) != len(tc.wantPublished) {
+				t.Errorf("marked %d events as published, want %d", len(outbox.published), len(tc.wantPublished))
+			}
+			if outbox.leases < len(tc.wantPublished) {
+				t.Errorf("lease taken or renewed %d times for %d events", outbox.leases, len(tc.wantPublished))
+			}
+		})
+	}
+}
+
+func TestInProcPublisher(t *testing.T) {
+	p := NewInProcPublisher()
+	var got []string
+	p.Subscribe(func(_ context.Context, e model.Event) error {
+		got = append(got, "first:"+e.ID)
+		return nil
+	})
+	p.Subscribe(func(_ context.Context, e model.Event) error {
+		if e.ID == "bad" {
+			return errors.New("rejected")
+		}
+		got = append(got, "second:"+e.ID)
+		return nil
+	})
+
+	if err := p.Publish(context.Background(), model.Event{ID: "e1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(context.Background(), model.Event{ID: "bad"}); err == nil {
+		t.Error("Publish() = nil, want the handler's error")
+	}
+	want := []string{"first:e1", "second:e1", "first:bad"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
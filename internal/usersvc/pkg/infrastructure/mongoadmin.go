@@ -0,0 +1,57 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+// mongoAdminActionRepository stores admin actions in their own collection.
+// Actions are only ever inserted.
+type mongoAdminActionRepository struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoAdminActionRepository(client *mongo.Client, db, collection string) *mongoAdminActionRepository {
+	return &mongoAdminActionRepository{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+func (m *mongoAdminActionRepository) AppendAdminAction(ctx context.Context, a model.AdminAction) error {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	changes := make([]auditFieldChange, 0, len(a.Changes))
+	for _, c := range a.Changes {
+		changes = append(changes, auditFieldChange{Field: c.Field, Old: c.Old, New: c.New})
+	}
+	_, err := collection.InsertOne(ctx, adminActionDocument{
+		ID:        bson.NewObjectID(),
+		Action:    string(a.Action),
+		Actor:     a.Actor,
+		Target:    a.Target,
+		Reason:    a.Reason,
+		Changes:   changes,
+		Timestamp: a.Timestamp,
+		RequestID: a.RequestID,
+		SourceIP:  a.SourceIP,
+	})
+	return err
+}
+
+type adminActionDocument struct {
+	ID        bson.ObjectID      `bson:"_id"`
+	Action    string             `bson:"action"`
+	Actor     string             `bson:"actor"`
+	Target    string             `bson:"target"`
+	Reason    string             `bson:"reason"`
+	Changes   []auditFieldChange `bson:"changes,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+	RequestID string             `bson:"requestId,omitempty"`
+	SourceIP  string             `bson:"sourceIp,omitempty"`
+}
@@ -0,0 +1,167 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// mongoAPIKeyRepository stores API keys in their own collection, looked up by
+// the hash of the key. Revoked keys are kept so their owners can still see them.
+type mongoAPIKeyRepository struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoAPIKeyRepository(client *mongo.Client, db, collection string) *mongoAPIKeyRepository {
+	return &mongoAPIKeyRepository{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the indexes used to look keys up by hash and by
+// creator.
+func (m *mongoAPIKeyRepository) EnsureIndexes(ctx context.Context) error {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "createdBy", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	return err
+}
+
+func (m *mongoAPIKeyRepository) CreateAPIKey(ctx context.Context, k model.APIKey, hash string) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "insert")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err = collection.InsertOne(ctx, apiKeyDocument{
+		ID:        k.ID,
+		Hash:      hash,
+		Prefix:    k.Prefix,
+		Name:      k.Name,
+		Subject:   k.Subject,
+		CreatedBy: k.CreatedBy,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: timePtrOrNil(k.ExpiresAt),
+	})
+	return err
+}
+
+// ListAPIKeys returns the keys created by createdBy, newest first.
+func (m *mongoAPIKeyRepository) ListAPIKeys(ctx context.Context, createdBy string) (_ []model.APIKey, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "find")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.D{{Key: "createdBy", Value: createdBy}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []apiKeyDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	keys := make([]model.APIKey, 0, len(docs))
+	for _, d := range docs {
+		keys = append(keys, d.toModel())
+	}
+	return keys, nil
+}
+
+func (m *mongoAPIKeyRepository) GetAPIKey(ctx context.Context, id string) (model.APIKey, error) {
+	return m.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (m *mongoAPIKeyRepository) FindAPIKey(ctx context.Context, hash string) (model.APIKey, error) {
+	return m.findOne(ctx, bson.D{{Key: "hash", Value: hash}})
+}
+
+func (m *mongoAPIKeyRepository) findOne(ctx context.Context, filter bson.D) (_ model.APIKey, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "findOne")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	var d apiKeyDocument
+	err = collection.FindOne(ctx, filter).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.APIKey{}, userservice.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	return d.toModel(), nil
+}
+
+func (m *mongoAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	return m.set(ctx, id, "revokedAt", at)
+}
+
+func (m *mongoAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return m.set(ctx, id, "lastUsedAt", at)
+}
+
+func (m *mongoAPIKeyRepository) set(ctx context.Context, id, field string, at time.Time) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err = collection.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: at}}}},
+	)
+	return err
+}
+
+type apiKeyDocument struct {
+	ID         string     `bson:"_id"`
+	Hash       string     `bson:"hash"`
+	Prefix     string     `bson:"prefix"`
+	Name       string     `bson:"name,omitempty"`
+	Subject    string     `bson:"subject"`
+	CreatedBy  string     `bson:"createdBy"`
+	Scopes     []string   `bson:"scopes"`
+	CreatedAt  time.Time  `bson:"createdAt"`
+	ExpiresAt  *time.Time `bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty"`
+}
+
+func (d apiKeyDocument) toModel() model.APIKey {
+	return model.APIKey{
+		ID:         d.ID,
+		Prefix:     d.Prefix,
+		Name:       d.Name,
+		Subject:    d.Subject,
+		CreatedBy:  d.CreatedBy,
+		Scopes:     d.Scopes,
+		CreatedAt:  d.CreatedAt,
+		ExpiresAt:  timeValue(d.ExpiresAt),
+		LastUsedAt: timeValue(d.LastUsedAt),
+		RevokedAt:  timeValue(d.RevokedAt),
+	}
+}
+
+func timePtrOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
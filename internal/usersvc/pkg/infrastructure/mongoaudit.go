@@ -0,0 +1,124 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// mongoAuditRepository stores audit entries in their own collection. Entries are
+// only ever inserted; ObjectIDs give a stable, time-ordered key to paginate on.
+type mongoAuditRepository struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoAuditRepository(client *mongo.Client, db, collection string) *mongoAuditRepository {
+	return &mongoAuditRepository{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+func (m *mongoAuditRepository) AppendAuditEntry(ctx context.Context, e model.AuditEntry) error {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	changes := make([]auditFieldChange, 0, len(e.Changes))
+	for _, c := range e.Changes {
+		changes = append(changes, auditFieldChange{Field: c.Field, Old: c.Old, New: c.New})
+	}
+	_, err := collection.InsertOne(ctx, auditEntryDocument{
+		ID:        bson.NewObjectID(),
+		Action:    string(e.Action),
+		Actor:     e.Actor,
+		Target:    e.Target,
+		Changes:   changes,
+		Timestamp: e.Timestamp,
+		RequestID: e.RequestID,
+		SourceIP:  e.SourceIP,
+	})
+	return err
+}
+
+// ListAuditEntries returns entries newest first. The page token is the ID of the
+// last entry of the previous page.
+func (m *mongoAuditRepository) ListAuditEntries(ctx context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error) {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{}
+	if q.Actor != "" {
+		filter = append(filter, bson.E{Key: "actor", Value: q.Actor})
+	}
+	if q.Target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: q.Target})
+	}
+	if q.PageToken != "" {
+		after, err := bson.ObjectIDFromHex(q.PageToken)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: after}}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(q.PageSize))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	var docs []auditEntryDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, "", err
+	}
+
+	entries := make([]model.AuditEntry, 0, len(docs))
+	for _, d := range docs {
+		entries = append(entries, d.toModel())
+	}
+	var next string
+	if len(docs) == q.PageSize && len(docs) > 0 {
+		next = docs[len(docs)-1].ID.Hex()
+	}
+	return entries, next, nil
+}
+
+type auditEntryDocument struct {
+	ID        bson.ObjectID      `bson:"_id"`
+	Action    string             `bson:"action"`
+	Actor     string             `bson:"actor,omitempty"`
+	Target    string             `bson:"target"`
+	Changes   []auditFieldChange `bson:"changes,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+	RequestID string             `bson:"requestId,omitempty"`
+	SourceIP  string             `bson:"sourceIp,omitempty"`
+}
+
+type auditFieldChange struct {
+	Field string `bson:"field"`
+	Old   string `bson:"old,omitempty"`
+	New   string `bson:"new,omitempty"`
+}
+
+func (d auditEntryDocument) toModel() model.AuditEntry {
+	var changes []model.FieldChange
+	for _, c := range d.Changes {
+		changes = append(changes, model.FieldChange{Field: c.Field, Old: c.Old, New: c.New})
+	}
+	return model.AuditEntry{
+		ID:        d.ID.Hex(),
+		Action:    model.AuditAction(d.Action),
+		Actor:     d.Actor,
+		Target:    d.Target,
+		Changes:   changes,
+		Timestamp: d.Timestamp,
+		RequestID: d.RequestID,
+		SourceIP:  d.SourceIP,
+	}
+}
@@ -0,0 +1,258 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	uuid "github.com/google/uuid"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+const relayLeaseID = "relay"
+
+// mongoOutbox stores domain events in a collection next to the users collection.
+// Per-user sequence numbers live in <collection>_sequences and the relay lease in
+// <collection>_leases.
+type mongoOutbox struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoOutbox(client *mongo.Client, db, collection string) *mongoOutbox {
+	return &mongoOutbox{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the indexes used to find pending events and to expire
+// published events after retention.
+func (o *mongoOutbox) EnsureIndexes(ctx context.Context, retention time.Duration) error {
+	collection := o.client.Database(o.db).Collection(o.collection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "publishedAt", Value: 1}, {Key: "_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "publishedAt", Value: 1}},
+			Options: options.Index().SetName("publishedAt_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+	return err
+}
+
+// append writes an event for the given user. It must run inside the transaction
+// of the change it describes, so the sequence bump and the insert commit or
+// abort together with it.
+func (o *mongoOutbox) append(ctx context.Context, t model.EventType, uid string, u model.User) error {
+	db := o.client.Database(o.db)
+	var seq struct {
+		Value int64 `bson:"seq"`
+	}
+	err := db.Collection(o.collection+"_sequences").FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: uid}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&seq)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(o.collection).InsertOne(ctx, outboxEventDocument{
+		ID:         bson.NewObjectID(),
+		Type:       string(t),
+		UserID:     uid,
+		Sequence:   seq.Value,
+		OccurredAt: time.Now().UTC(),
+		User: outboxUserDocument{
+			Email:          u.Email,
+			PhoneNumber:    u.PhoneNumber,
+			UserName:       u.UserName,
+			ProfilePicture: u.ProfilePicture,
+			Bio:            u.Bio,
+			AuthProvider:   u.AuthProvider,
+			Roles:          u.Roles,
+			Suspension:     newSuspensionDocument(u.Suspension),
+		},
+	})
+	return err
+}
+
+func (o *mongoOutbox) Pending(ctx context.Context, limit int) ([]model.Event, error) {
+	collection := o.client.Database(o.db).Collection(o.collection)
+	filter := bson.D{{Key: "publishedAt", Value: nil}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []outboxEventDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	events := make([]model.Event, 0, len(docs))
+	for _, d := range docs {
+		events = append(events, d.toModel())
+	}
+	return events, nil
+}
+
+func (o *mongoOutbox) MarkPublished(ctx context.Context, ids []string) error {
+	collection := o.client.Database(o.db).Collection(o.collection)
+	oids := make([]bson.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		oid, err := bson.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		oids = append(oids, oid)
+	}
+	_, err := collection.UpdateMany(ctx,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: oids}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "publishedAt", Value: time.Now().UTC()}}}},
+	)
+	return err
+}
+
+func (o *mongoOutbox) Lease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	collection := o.client.Database(o.db).Collection(o.collection + "_leases")
+	now := time.Now().UTC()
+	filter := bson.D{
+		{Key: "_id", Value: relayLeaseID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "holder", Value: holder}},
+			bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "holder", Value: holder},
+		{Key: "expiresAt", Value: now.Add(ttl)},
+	}}}
+	_, err := collection.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and is held by someone else.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type outboxEventDocument struct {
+	ID          bson.ObjectID      `bson:"_id"`
+	Type        string             `bson:"type"`
+	UserID      string             `bson:"userId"`
+	Sequence    int64              `bson:"sequence"`
+	OccurredAt  time.Time          `bson:"occurredAt"`
+	User        outboxUserDocument `bson:"user"`
+	PublishedAt *time.Time         `bson:"publishedAt,omitempty"`
+}
+
+type outboxUserDocument struct {
+	Email          *string             `bson:"email,omitempty"`
+	PhoneNumber    *string             `bson:"phoneNumber,omitempty"`
+	UserName       *string             `bson:"userName,omitempty"`
+	ProfilePicture *string             `bson:"profilePicture,omitempty"`
+	Bio            *string             `bson:"bio,omitempty"`
+	AuthProvider   *string             `bson:"authProvider,omitempty"`
+	Roles          []string            `bson:"roles,omitempty"`
+	Suspension     *suspensionDocument `bson:"suspension,omitempty"`
+}
+
+func (d outboxEventDocument) toModel() model.Event {
+	uid := d.UserID
+	return model.Event{
+		ID:         d.ID.Hex(),
+		Type:       model.EventType(d.Type),
+		UserID:     d.UserID,
+		Sequence:   d.Sequence,
+		OccurredAt: d.OccurredAt,
+		User: model.User{
+			UUID:           &uid,
+			Email:          d.User.Email,
+			PhoneNumber:    d.User.PhoneNumber,
+			UserName:       d.User.UserName,
+			ProfilePicture: d.User.ProfilePicture,
+			Bio:            d.User.Bio,
+			AuthProvider:   d.User.AuthProvider,
+			Roles:          d.User.Roles,
+			Suspension:     d.User.Suspension.toModel(),
+		},
+	}
+}
+
+// mongoOutboxRepository decorates mongoRepository so that every mutation and its
+// domain event are written in a single transaction. Transactions require MongoDB
+// to run as a replica set.
+type mongoOutboxRepository struct {
+	*mongoRepository
+	outbox *mongoOutbox
+}
+
+func NewMongoOutboxRepository(users *mongoRepository, outbox *mongoOutbox) *mongoOutboxRepository {
+	return &mongoOutboxRepository{
+		mongoRepository: users,
+		outbox:          outbox,
+	}
+}
+
+func (m *mongoOutboxRepository) CreateUser(ctx context.Context, u model.User) error {
+	if u.UUID == nil || *u.UUID == "" {
+		// The event needs the ID, so it can't be left to oidFromUUID.
+		id := uuid.NewString()
+		u.UUID = &id
+	}
+	return m.inTransaction(ctx, func(ctx context.Context) error {
+		if err := m.mongoRepository.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		return m.outbox.append(ctx, model.EventUserCreated, *u.UUID, u)
+	})
+}
+
+func (m *mongoOutboxRepository) UpdateUser(ctx context.Context, u model.User) error {
+	if u.UUID == nil {
+		return errors.New("missing user ID")
+	}
+	return m.inTransaction(ctx, func(ctx context.Context) error {
+		if err := m.mongoRepository.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		return m.outbox.append(ctx, model.EventUserUpdated, *u.UUID, u)
+	})
+}
+
+func (m *mongoOutboxRepository) DeleteUser(ctx context.Context, uid string) error {
+	return m.inTransaction(ctx, func(ctx context.Context) error {
+		if err := m.mongoRepository.DeleteUser(ctx, uid); err != nil {
+			return err
+		}
+		return m.outbox.append(ctx, model.EventUserDeleted, uid, model.User{})
+	})
+}
+
+func (m *mongoOutboxRepository) SetSuspension(ctx context.Context, uid string, s *model.Suspension) error {
+	t := model.EventUserUnsuspended
+	if s != nil {
+		t = model.EventUserSuspended
+	}
+	return m.inTransaction(ctx, func(ctx context.Context) error {
+		if err := m.mongoRepository.SetSuspension(ctx, uid, s); err != nil {
+			return err
+		}
+		return m.outbox.append(ctx, t, uid, model.User{Suspension: s})
+	})
+}
+
+func (m *mongoOutboxRepository) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
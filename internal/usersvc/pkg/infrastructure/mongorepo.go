@@ -2,10 +2,13 @@ package infrastructure
 
 import (
 	"context"
+	"errors"
 	uuid "github.com/google/uuid"
 	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"time"
 )
 
@@ -23,10 +26,13 @@ func NewMongoRepository(client *mongo.Client, db, collection string) *mongoRepos
 	}
 }
 
-func (m *mongoRepository) CreateUser(ctx context.Context, u model.User) error {
+func (m *mongoRepository) CreateUser(ctx context.Context, u model.User) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "insert")
+	defer func() { endSpan(span, err) }()
+
 	collection := m.client.Database(m.db).Collection(m.collection)
 	id := oidFromUUID(*u.UUID)
-	_, err := collection.InsertOne(ctx, createUserQuery{
+	_, err = collection.InsertOne(ctx, createUserQuery{
 		UUID:           id,
 		Email:          u.Email,
 		PhoneNumber:    u.PhoneNumber,
@@ -38,44 +44,113 @@ func (m *mongoRepository) CreateUser(ctx context.Context, u model.User) error {
 	return err
 }
 
-func (m *mongoRepository) GetUser(ctx context.Context, uid string) (model.User, error) {
+func (m *mongoRepository) GetUser(ctx context.Context, uid string) (_ model.User, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "find")
+	defer func() { endSpan(span, err) }()
+
 	collection := m.client.Database(m.db).Collection(m.collection)
 	var resp getUserResponse
 	q := getUserQuery{UUID: oidFromUUID(uid)}
-	err := collection.FindOne(ctx, q).Decode(&resp)
+	err = collection.FindOne(ctx, q).Decode(&resp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.User{}, userservice.ErrUserNotFound
+	}
 	if err != nil {
 		return model.User{}, err
 	}
 
-	id := uuidFromOID(resp.UUID)
-	return model.User{
-		UUID:           &id,
-		Email:          resp.Email,
-		PhoneNumber:    resp.PhoneNumber,
-		UserName:       resp.UserName,
-		ProfilePicture: resp.ProfilePicture,
-		Bio:            resp.Bio,
-	}, nil
+	return resp.toModel(), nil
 }
 
-func (m *mongoRepository) UpdateUser(ctx context.Context, u model.User) error {
+func (m *mongoRepository) UpdateUser(ctx context.Context, u model.User) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
 	collection := m.client.Database(m.db).Collection(m.collection)
 	id := oidFromUUID(*u.UUID)
 	filter := updateUserQuery{UUID: id}
-	query := bson.D{{"$set", updateUserQuery{
+	query := bson.D{{Key: "$set", Value: updateUserQuery{
 		Email:          u.Email,
 		PhoneNumber:    u.PhoneNumber,
 		UserName:       u.UserName,
 		ProfilePicture: u.ProfilePicture,
 		Bio:            u.Bio,
+		Roles:          u.Roles,
 	}}}
-	_, err := collection.UpdateOne(ctx, filter, query)
+	_, err = collection.UpdateOne(ctx, filter, query)
 	return err
 }
 
-func (m *mongoRepository) DeleteUser(ctx context.Context, uid string) error {
+// ListUsers returns users in the order of their IDs. The page token is the ID
+// of the last user of the previous page.
+func (m *mongoRepository) ListUsers(ctx context.Context, q model.UserQuery) (_ []model.User, _ string, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "find")
+	defer func() { endSpan(span, err) }()
+
 	collection := m.client.Database(m.db).Collection(m.collection)
-	_, err := collection.DeleteOne(ctx, deleteUserQuery{UUID: oidFromUUID(uid)})
+	filter := bson.D{}
+	if q.Role != "" {
+		filter = append(filter, bson.E{Key: "roles", Value: q.Role})
+	}
+	if q.SuspendedOnly {
+		filter = append(filter,
+			bson.E{Key: "suspension", Value: bson.D{{Key: "$exists", Value: true}}},
+			bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "suspension.until", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "suspension.until", Value: bson.D{{Key: "$gt", Value: time.Now()}}}},
+			}},
+		)
+	}
+	if q.PageToken != "" {
+		after, err := uuid.Parse(q.PageToken)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: after[:]}}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(q.PageSize))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	var docs []getUserResponse
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, "", err
+	}
+
+	users := make([]model.User, 0, len(docs))
+	for _, d := range docs {
+		users = append(users, d.toModel())
+	}
+	var next string
+	if len(docs) == q.PageSize && len(docs) > 0 {
+		next = uuidFromOID(docs[len(docs)-1].UUID)
+	}
+	return users, next, nil
+}
+
+func (m *mongoRepository) SetSuspension(ctx context.Context, uid string, s *model.Suspension) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "suspension", Value: ""}}}}
+	if s != nil {
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "suspension", Value: newSuspensionDocument(s)}}}}
+	}
+	_, err = collection.UpdateOne(ctx, getUserQuery{UUID: oidFromUUID(uid)}, update)
+	return err
+}
+
+func (m *mongoRepository) DeleteUser(ctx context.Context, uid string) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "delete")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err = collection.DeleteOne(ctx, deleteUserQuery{UUID: oidFromUUID(uid)})
 	return err
 }
 
@@ -100,22 +175,73 @@ type getUserQuery struct {
 }
 
 type getUserResponse struct {
-	UUID           []byte  `bson:"_id,omitempty"`
-	Email          *string `bson:"email,omitempty"`
-	PhoneNumber    *string `bson:"phoneNumber,omitempty"`
-	UserName       *string `bson:"userName,omitempty"`
-	ProfilePicture *string `bson:"profilePicture,omitempty"`
-	Bio            *string `bson:"bio,omitempty"`
-	AuthProvider   *string `bson:"authProvider,omitempty"`
+	UUID           []byte              `bson:"_id,omitempty"`
+	Email          *string             `bson:"email,omitempty"`
+	PhoneNumber    *string             `bson:"phoneNumber,omitempty"`
+	UserName       *string             `bson:"userName,omitempty"`
+	ProfilePicture *string             `bson:"profilePicture,omitempty"`
+	Bio            *string             `bson:"bio,omitempty"`
+	AuthProvider   *string             `bson:"authProvider,omitempty"`
+	Roles          []string            `bson:"roles,omitempty"`
+	Suspension     *suspensionDocument `bson:"suspension,omitempty"`
+}
+
+func (r getUserResponse) toModel() model.User {
+	id := uuidFromOID(r.UUID)
+	u := model.User{
+		UUID:           &id,
+		Email:          r.Email,
+		PhoneNumber:    r.PhoneNumber,
+		UserName:       r.UserName,
+		ProfilePicture: r.ProfilePicture,
+		Bio:            r.Bio,
+		AuthProvider:   r.AuthProvider,
+		Roles:          r.Roles,
+	}
+	u.Suspension = r.Suspension.toModel()
+	return u
+}
+
+// suspensionDocument stores a model.Suspension. Until is left out of
+// indefinite suspensions.
+type suspensionDocument struct {
+	Reason string     `bson:"reason"`
+	Actor  string     `bson:"actor,omitempty"`
+	Since  time.Time  `bson:"since"`
+	Until  *time.Time `bson:"until,omitempty"`
+}
+
+func newSuspensionDocument(s *model.Suspension) *suspensionDocument {
+	if s == nil {
+		return nil
+	}
+	d := &suspensionDocument{Reason: s.Reason, Actor: s.Actor, Since: s.Since}
+	if !s.Until.IsZero() {
+		until := s.Until
+		d.Until = &until
+	}
+	return d
+}
+
+func (d *suspensionDocument) toModel() *model.Suspension {
+	if d == nil {
+		return nil
+	}
+	s := &model.Suspension{Reason: d.Reason, Actor: d.Actor, Since: d.Since}
+	if d.Until != nil {
+		s.Until = *d.Until
+	}
+	return s
 }
 
 type updateUserQuery struct {
-	UUID           []byte  `bson:"_id,omitempty"`
-	Email          *string `bson:"email,omitempty"`
-	PhoneNumber    *string `bson:"phoneNumber,omitempty"`
-	UserName       *string `bson:"userName,omitempty"`
-	ProfilePicture *string `bson:"profilePicture,omitempty"`
-	Bio            *string `bson:"bio,omitempty"`
+	UUID           []byte   `bson:"_id,omitempty"`
+	Email          *string  `bson:"email,omitempty"`
+	PhoneNumber    *string  `bson:"phoneNumber,omitempty"`
+	UserName       *string  `bson:"userName,omitempty"`
+	ProfilePicture *string  `bson:"profilePicture,omitempty"`
+	Bio            *string  `bson:"bio,omitempty"`
+	Roles          []string `bson:"roles,omitempty"`
 }
 
 type deleteUserQuery struct {
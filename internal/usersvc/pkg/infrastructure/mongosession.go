@@ -0,0 +1,195 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// maxUsedRefreshTokens bounds the number of replaced refresh tokens kept per
+// session to detect their reuse. Only the most recent ones are kept.
+const maxUsedRefreshTokens = 50
+
+// mongoSessionRepository stores sessions in their own collection. Revoked
+// sessions are kept until they would have expired, so that their refresh
+// tokens are still recognized, and MongoDB deletes them afterwards.
+type mongoSessionRepository struct {
+	client     *mongo.Client
+	db         string
+	collection string
+}
+
+func NewMongoSessionRepository(client *mongo.Client, db, collection string) *mongoSessionRepository {
+	return &mongoSessionRepository{
+		client:     client,
+		db:         db,
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the index used to list the sessions of a user and
+// the TTL index deleting expired sessions.
+func (m *mongoSessionRepository) EnsureIndexes(ctx context.Context) error {
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastUsedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (m *mongoSessionRepository) CreateSession(ctx context.Context, s model.Session, hash string) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "insert")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	_, err = collection.InsertOne(ctx, sessionDocument{
+		ID:         s.ID,
+		UserID:     s.UserID,
+		Hash:       hash,
+		Device:     s.Device,
+		IP:         s.IP,
+		Roles:      s.Roles,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+	})
+	return err
+}
+
+func (m *mongoSessionRepository) GetSession(ctx context.Context, id string) (_ model.Session, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "findOne")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	var d sessionDocument
+	err = collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.Session{}, userservice.ErrSessionNotFound
+	}
+	if err != nil {
+		return model.Session{}, err
+	}
+	return d.toModel(), nil
+}
+
+func (m *mongoSessionRepository) ListSessions(ctx context.Context, userID string, t time.Time) (_ []model.Session, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "find")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{
+		{Key: "userId", Value: userID},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: t}}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []sessionDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	sessions := make([]model.Session, 0, len(docs))
+	for _, d := range docs {
+		sessions = append(sessions, d.toModel())
+	}
+	return sessions, nil
+}
+
+func (m *mongoSessionRepository) RotateSession(ctx context.Context, s model.Session, oldHash, newHash string) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{
+		{Key: "_id", Value: s.ID},
+		{Key: "hash", Value: oldHash},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "hash", Value: newHash},
+			{Key: "device", Value: s.Device},
+			{Key: "ip", Value: s.IP},
+			{Key: "roles", Value: s.Roles},
+			{Key: "lastUsedAt", Value: s.LastUsedAt},
+			{Key: "expiresAt", Value: s.ExpiresAt},
+		}},
+		{Key: "$push", Value: bson.D{{Key: "usedHashes", Value: bson.D{
+			{Key: "$each", Value: bson.A{oldHash}},
+			{Key: "$slice", Value: -maxUsedRefreshTokens},
+		}}}},
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return userservice.ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+func (m *mongoSessionRepository) RefreshTokenUsed(ctx context.Context, id, hash string) (_ bool, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "count")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	n, err := collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, {Key: "usedHashes", Value: hash}})
+	return n > 0, err
+}
+
+func (m *mongoSessionRepository) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	return m.revoke(ctx, bson.D{{Key: "_id", Value: id}}, at)
+}
+
+func (m *mongoSessionRepository) RevokeSessions(ctx context.Context, userID string, at time.Time) error {
+	return m.revoke(ctx, bson.D{{Key: "userId", Value: userID}}, at)
+}
+
+// revoke revokes the sessions matching filter that aren't revoked yet.
+func (m *mongoSessionRepository) revoke(ctx context.Context, filter bson.D, at time.Time) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter = append(filter, bson.E{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}})
+	_, err = collection.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: at}}}})
+	return err
+}
+
+type sessionDocument struct {
+	ID         string     `bson:"_id"`
+	UserID     string     `bson:"userId"`
+	Hash       string     `bson:"hash"`
+	UsedHashes []string   `bson:"usedHashes,omitempty"`
+	Device     string     `bson:"device,omitempty"`
+	IP         string     `bson:"ip,omitempty"`
+	Roles      []string   `bson:"roles,omitempty"`
+	CreatedAt  time.Time  `bson:"createdAt"`
+	LastUsedAt time.Time  `bson:"lastUsedAt"`
+	ExpiresAt  time.Time  `bson:"expiresAt"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty"`
+}
+
+func (d sessionDocument) toModel() model.Session {
+	return model.Session{
+		ID:         d.ID,
+		UserID:     d.UserID,
+		Device:     d.Device,
+		IP:         d.IP,
+		Roles:      d.Roles,
+		CreatedAt:  d.CreatedAt.UTC(),
+		LastUsedAt: d.LastUsedAt.UTC(),
+		ExpiresAt:  d.ExpiresAt.UTC(),
+		RevokedAt:  timeValue(d.RevokedAt),
+	}
+}
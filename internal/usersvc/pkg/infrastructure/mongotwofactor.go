@@ -0,0 +1,193 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// The second factor of a user is kept in the twoFactor field of their profile
+// document, and goes away with it. Each change is conditional on the state it
+// was checked against, so that concurrent calls can't both use a code.
+
+func (m *mongoRepository) GetTwoFactor(ctx context.Context, uid string) (_ model.TwoFactor, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "find")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	var resp struct {
+		TwoFactor *twoFactorDocument `bson:"twoFactor,omitempty"`
+	}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "twoFactor", Value: 1}})
+	err = collection.FindOne(ctx, getUserQuery{UUID: oidFromUUID(uid)}, opts).Decode(&resp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.TwoFactor{}, userservice.ErrUserNotFound
+	}
+	if err != nil {
+		return model.TwoFactor{}, err
+	}
+	return resp.TwoFactor.toModel(), nil
+}
+
+func (m *mongoRepository) SetTOTPSecret(ctx context.Context, uid, secret string) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{
+		{Key: "_id", Value: oidFromUUID(uid)},
+		{Key: "twoFactor.enabled", Value: bson.D{{Key: "$ne", Value: true}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "twoFactor", Value: twoFactorDocument{Secret: secret}}}}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	n, err := collection.CountDocuments(ctx, getUserQuery{UUID: oidFromUUID(uid)})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return userservice.ErrUserNotFound
+	}
+	return userservice.ErrTwoFactorEnabled
+}
+
+func (m *mongoRepository) EnableTwoFactor(ctx context.Context, uid, secret string, step int64, recoveryHashes []string, at time.Time) error {
+	filter := bson.D{
+		{Key: "_id", Value: oidFromUUID(uid)},
+		{Key: "twoFactor.secret", Value: secret},
+		{Key: "twoFactor.enabled", Value: bson.D{{Key: "$ne", Value: true}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "twoFactor.enabled", Value: true},
+		{Key: "twoFactor.enabledAt", Value: at},
+		{Key: "twoFactor.lastStep", Value: step},
+		{Key: "twoFactor.recoveryCodes", Value: recoveryHashes},
+	}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrInvalidTwoFactorCode)
+}
+
+func (m *mongoRepository) UseTOTPStep(ctx context.Context, uid string, step int64) error {
+	filter := bson.D{
+		{Key: "_id", Value: oidFromUUID(uid)},
+		{Key: "twoFactor.enabled", Value: true},
+		{Key: "twoFactor.lastStep", Value: bson.D{{Key: "$lt", Value: step}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "twoFactor.lastStep", Value: step}}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrInvalidTwoFactorCode)
+}
+
+func (m *mongoRepository) UseRecoveryCode(ctx context.Context, uid, hash string) error {
+	filter := bson.D{
+		{Key: "_id", Value: oidFromUUID(uid)},
+		{Key: "twoFactor.enabled", Value: true},
+		{Key: "twoFactor.recoveryCodes", Value: hash},
+	}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "twoFactor.recoveryCodes", Value: hash}}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrInvalidTwoFactorCode)
+}
+
+func (m *mongoRepository) RecordTwoFactorFailure(ctx context.Context, uid string) (_ int, err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "findOneAndUpdate")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	filter := bson.D{
+		{Key: "_id", Value: oidFromUUID(uid)},
+		{Key: "twoFactor.enabled", Value: true},
+	}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "twoFactor.failedAttempts", Value: 1}}}}
+	opts := options.FindOneAndUpdate().
+		SetProjection(bson.D{{Key: "twoFactor", Value: 1}}).
+		SetReturnDocument(options.After)
+	var resp struct {
+		TwoFactor *twoFactorDocument `bson:"twoFactor,omitempty"`
+	}
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&resp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, userservice.ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return 0, err
+	}
+	return resp.TwoFactor.toModel().FailedAttempts, nil
+}
+
+func (m *mongoRepository) ResetTwoFactorFailures(ctx context.Context, uid string) error {
+	filter := bson.D{{Key: "_id", Value: oidFromUUID(uid)}, {Key: "twoFactor.enabled", Value: true}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "twoFactor.failedAttempts", Value: 0}}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrTwoFactorNotEnabled)
+}
+
+func (m *mongoRepository) LockTwoFactor(ctx context.Context, uid string, until time.Time) error {
+	filter := bson.D{{Key: "_id", Value: oidFromUUID(uid)}, {Key: "twoFactor.enabled", Value: true}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "twoFactor.failedAttempts", Value: 0},
+		{Key: "twoFactor.lockedUntil", Value: until},
+	}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrTwoFactorNotEnabled)
+}
+
+func (m *mongoRepository) DisableTwoFactor(ctx context.Context, uid string) error {
+	filter := bson.D{{Key: "_id", Value: oidFromUUID(uid)}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "twoFactor", Value: ""}}}}
+	return m.updateTwoFactor(ctx, filter, update, userservice.ErrUserNotFound)
+}
+
+// updateTwoFactor applies update to the profile matching filter, or returns
+// unmatched if there is none.
+func (m *mongoRepository) updateTwoFactor(ctx context.Context, filter, update bson.D, unmatched error) (err error) {
+	ctx, span := startSpan(ctx, m.db, m.collection, "update")
+	defer func() { endSpan(span, err) }()
+
+	collection := m.client.Database(m.db).Collection(m.collection)
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return unmatched
+	}
+	return nil
+}
+
+// twoFactorDocument stores a model.TwoFactor with the hashes of its recovery
+// codes.
+type twoFactorDocument struct {
+	Secret         string     `bson:"secret"`
+	Enabled        bool       `bson:"enabled,omitempty"`
+	EnabledAt      *time.Time `bson:"enabledAt,omitempty"`
+	LastStep       int64      `bson:"lastStep,omitempty"`
+	RecoveryCodes  []string   `bson:"recoveryCodes,omitempty"`
+	FailedAttempts int        `bson:"failedAttempts,omitempty"`
+	LockedUntil    *time.Time `bson:"lockedUntil,omitempty"`
+}
+
+func (d *twoFactorDocument) toModel() model.TwoFactor {
+	if d == nil {
+		return model.TwoFactor{}
+	}
+	t := model.TwoFactor{
+		Enabled:        d.Enabled,
+		RecoveryCodes:  len(d.RecoveryCodes),
+		Secret:         d.Secret,
+		LastStep:       d.LastStep,
+		FailedAttempts: d.FailedAttempts,
+	}
+	if d.EnabledAt != nil {
+		t.EnabledAt = d.EnabledAt.UTC()
+	}
+	if d.LockedUntil != nil {
+		t.LockedUntil = d.LockedUntil.UTC()
+	}
+	return t
+}
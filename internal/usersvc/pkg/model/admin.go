@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type AdminActionType string
+
+const (
+	AdminActionUpdate      AdminActionType = "update"
+	AdminActionSuspend     AdminActionType = "suspend"
+	AdminActionUnsuspend   AdminActionType = "unsuspend"
+	AdminActionForceDelete AdminActionType = "forceDelete"
+)
+
+// AdminAction is a single, immutable record of a change a moderator or admin
+// made to another user's account, and why.
+type AdminAction struct {
+	ID        string          `json:"id"`
+	Action    AdminActionType `json:"action"`
+	Actor     string          `json:"actor"`
+	Target    string          `json:"target"`
+	Reason    string          `json:"reason"`
+	Changes   []FieldChange   `json:"changes,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	RequestID string          `json:"requestId,omitempty"`
+	SourceIP  string          `json:"sourceIp,omitempty"`
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be granted. Read allows safe requests such as GET;
+// write allows the rest.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// ValidScope reports whether scope is one of the known scopes.
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// ServiceAccountPrefix prefixes the subject of keys bound to a service account
+// rather than a user, e.g. "service:ci-bot".
+const ServiceAccountPrefix = "service:"
+
+// IsServiceAccount reports whether subject names a service account.
+func IsServiceAccount(subject string) bool {
+	return strings.HasPrefix(subject, ServiceAccountPrefix)
+}
+
+// APIKey describes an API key. The key itself is only known to whoever
+// created it; Prefix, its first characters, tells keys apart. The key acts as
+// Subject, a user ID or a service account, and is managed by its creator.
+// Zero times are unset: a key without ExpiresAt never expires.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Prefix     string    `json:"prefix"`
+	Name       string    `json:"name,omitempty"`
+	Subject    string    `json:"subject"`
+	CreatedBy  string    `json:"createdBy"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether k can be used at t.
+func (k APIKey) Active(t time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || t.Before(k.ExpiresAt))
+}
+
+// HasScope reports whether k was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+type AuditAction string
+
+const (
+	AuditActionCreate AuditAction = "create"
+	AuditActionUpdate AuditAction = "update"
+	AuditActionDelete AuditAction = "delete"
+)
+
+// AuditEntry is a single, immutable record of a profile mutation.
+type AuditEntry struct {
+	ID        string        `json:"id"`
+	Action    AuditAction   `json:"action"`
+	Actor     string        `json:"actor"`
+	Target    string        `json:"target"`
+	Changes   []FieldChange `json:"changes,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	RequestID string        `json:"requestId,omitempty"`
+	SourceIP  string        `json:"sourceIp,omitempty"`
+}
+
+// FieldChange describes the value of a single profile field before and after a mutation.
+// Sensitive values are redacted before the change is stored.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// AuditQuery selects a page of audit entries. Empty Actor and Target match any value.
+type AuditQuery struct {
+	Actor     string
+	Target    string
+	PageToken string
+	PageSize  int
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+type EventType string
+
+const (
+	EventUserCreated EventType = "UserCreated"
+	EventUserUpdated EventType = "UserUpdated"
+	EventUserDeleted EventType = "UserDeleted"
+	// EventUserSuspended and EventUserUnsuspended carry the suspension, if
+	// any, in User.Suspension.
+	EventUserSuspended   EventType = "UserSuspended"
+	EventUserUnsuspended EventType = "UserUnsuspended"
+)
+
+// Event is a domain event describing a change to a user's profile.
+//
+// ID is unique per event and stays the same across redeliveries, so consumers can
+// use it to drop duplicates. Sequence increases strictly for each user, so
+// consumers can also detect events they have already applied.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       EventType `json:"type"`
+	UserID     string    `json:"userId"`
+	Sequence   int64     `json:"sequence"`
+	OccurredAt time.Time `json:"occurredAt"`
+	// User holds the full profile for UserCreated, only the changed fields for
+	// UserUpdated, the suspension for UserSuspended and nothing for
+	// UserDeleted and UserUnsuspended.
+	User User `json:"user"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Session is a sign-in of a user on a device, kept alive by exchanging its
+// refresh token for a new one. The gateway issues short-lived access tokens
+// for a session, which stop being accepted once it is revoked or expires.
+// Device and IP are those of the client that last refreshed it, and Roles
+// the roles the user held then. Zero times are unset.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Device     string    `json:"device,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Roles      []string  `json:"roles,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	RevokedAt  time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether s can be used at t.
+func (s Session) Active(t time.Time) bool {
+	return s.RevokedAt.IsZero() && t.Before(s.ExpiresAt)
+}
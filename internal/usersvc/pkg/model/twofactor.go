@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// TwoFactor is the state of a user's TOTP second factor, kept on their
+// profile. Secret is set when they start enrolling, but the second factor is
+// only Enabled once they verified a code generated from it. RecoveryCodes is
+// the number of recovery codes they have left; the codes themselves are only
+// stored hashed. LastStep is the time step of the last TOTP code accepted,
+// which can't be used again. FailedAttempts counts the invalid codes given in
+// a row, and too many of them lock the second factor until LockedUntil.
+type TwoFactor struct {
+	Enabled        bool      `json:"enabled"`
+	EnabledAt      time.Time `json:"enabledAt,omitempty"`
+	RecoveryCodes  int       `json:"recoveryCodes"`
+	Secret         string    `json:"-"`
+	LastStep       int64     `json:"-"`
+	FailedAttempts int       `json:"-"`
+	LockedUntil    time.Time `json:"-"`
+}
+
+// Pending reports whether t was enrolled but not enabled yet.
+func (t TwoFactor) Pending() bool {
+	return !t.Enabled && t.Secret != ""
+}
+
+// Locked reports whether t is locked at now.
+func (t TwoFactor) Locked(now time.Time) bool {
+	return now.Before(t.LockedUntil)
+}
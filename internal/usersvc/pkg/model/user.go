@@ -1,5 +1,23 @@
 package model
 
+import "time"
+
+// Roles a user can hold. Users without a role are plain users.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID             *string `json:"id,omitempty"`
 	UUID           *string `json:"uuid,omitempty"`
@@ -9,4 +27,39 @@ type User struct {
 	ProfilePicture *string `json:"profilePicture,omitempty"`
 	Bio            *string `json:"bio,omitempty"`
 	AuthProvider   *string `json:"authProvider,omitempty"`
+	// Roles are only set by admins. A nil Roles leaves them unchanged on
+	// update.
+	Roles      []string    `json:"roles,omitempty"`
+	Suspension *Suspension `json:"suspension,omitempty"`
+}
+
+// Suspension records why, by whom and since when a user is suspended. A zero
+// Until suspends them until they are unsuspended.
+type Suspension struct {
+	Reason string    `json:"reason"`
+	Actor  string    `json:"actor,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"`
+}
+
+// AccountStatus is what authorizing a request of a user takes: the roles they
+// hold and the suspension in effect for them, if any.
+type AccountStatus struct {
+	Roles      []string    `json:"roles,omitempty"`
+	Suspension *Suspension `json:"suspension,omitempty"`
+}
+
+// Active reports whether s is in effect at t. A nil Suspension never is.
+func (s *Suspension) Active(t time.Time) bool {
+	return s != nil && (s.Until.IsZero() || t.Before(s.Until))
+}
+
+// UserQuery selects a page of users. An empty Role matches any user;
+// SuspendedOnly leaves out users who aren't suspended, including those whose
+// suspension has expired.
+type UserQuery struct {
+	Role          string
+	SuspendedOnly bool
+	PageToken     string
+	PageSize      int
 }
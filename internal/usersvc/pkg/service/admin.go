@@ -0,0 +1,238 @@
+package userservice
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+)
+
+// AdminService lets moderators and admins manage any user's account. Every
+// change takes a reason, which AdminActionMiddleware records along with the
+// actor.
+type AdminService interface {
+	ListUsers(ctx context.Context, q model.UserQuery) (users []model.User, nextPageToken string, err error)
+	GetUser(ctx context.Context, uid string) (model.User, error)
+	UpdateUser(ctx context.Context, u model.User, reason string) error
+	// Suspend suspends uid until the given time, or indefinitely if it is
+	// zero.
+	Suspend(ctx context.Context, uid, reason string, until time.Time) error
+	Unsuspend(ctx context.Context, uid, reason string) error
+	ForceDelete(ctx context.Context, uid, reason string) error
+}
+
+// AdminActionRepository is an append-only store of admin actions.
+type AdminActionRepository interface {
+	AppendAdminAction(ctx context.Context, a model.AdminAction) error
+}
+
+// AdminMiddleware describes an AdminService middleware.
+type AdminMiddleware func(AdminService) AdminService
+
+func NewAdminService(r Repository) AdminService {
+	if r == nil {
+		panic("invalid repository")
+	}
+	return adminService{repo: r}
+}
+
+type adminService struct {
+	repo Repository
+}
+
+func (s adminService) ListUsers(ctx context.Context, q model.UserQuery) ([]model.User, string, error) {
+	if q.Role != "" && !model.ValidRole(q.Role) {
+		return nil, "", ErrInvalidRole
+	}
+	switch {
+	case q.PageSize <= 0:
+		q.PageSize = defaultUserPageSize
+	case q.PageSize > maxUserPageSize:
+		q.PageSize = maxUserPageSize
+	}
+	return s.repo.ListUsers(ctx, q)
+}
+
+func (s adminService) GetUser(ctx context.Context, uid string) (model.User, error) {
+	return s.repo.GetUser(ctx, uid)
+}
+
+// UpdateUser changes the non-nil fields of u. Roles are deduplicated, and an
+// empty list makes the user a plain user.
+func (s adminService) UpdateUser(ctx context.Context, u model.User, reason string) error {
+	if err := s.validate(ctx, stringValue(u.UUID), reason); err != nil {
+		return err
+	}
+	if u.Roles != nil {
+		roles, err := normalizeRoles(u.Roles)
+		if err != nil {
+			return err
+		}
+		u.Roles = roles
+	}
+	return s.repo.UpdateUser(ctx, u)
+}
+
+func (s adminService) Suspend(ctx context.Context, uid, reason string, until time.Time) error {
+	now := time.Now().UTC()
+	if !until.IsZero() && !until.After(now) {
+		return ErrInvalidExpiry
+	}
+	if err := s.validate(ctx, uid, reason); err != nil {
+		return err
+	}
+	actor, _ := ActorFromContext(ctx)
+	return s.repo.SetSuspension(ctx, uid, &model.Suspension{
+		Reason: reason,
+		Actor:  actor,
+		Since:  now,
+		Until:  until.UTC(),
+	})
+}
+
+func (s adminService) Unsuspend(ctx context.Context, uid, reason string) error {
+	if err := s.validate(ctx, uid, reason); err != nil {
+		return err
+	}
+	return s.repo.SetSuspension(ctx, uid, nil)
+}
+
+func (s adminService) ForceDelete(ctx context.Context, uid, reason string) error {
+	if err := s.validate(ctx, uid, reason); err != nil {
+		return err
+	}
+	return s.repo.DeleteUser(ctx, uid)
+}
+
+// validate checks that a reason is given and that uid exists, since the
+// repository ignores changes to unknown users.
+func (s adminService) validate(ctx context.Context, uid, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return ErrReasonRequired
+	}
+	_, err := s.repo.GetUser(ctx, uid)
+	return err
+}
+
+func normalizeRoles(roles []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, role := range roles {
+		if !model.ValidRole(role) {
+			return nil, ErrInvalidRole
+		}
+		if !seen[role] {
+			seen[role] = true
+			normalized = append(normalized, role)
+		}
+	}
+	if len(normalized) == 0 {
+		normalized = append(normalized, model.RoleUser)
+	}
+	return normalized, nil
+}
+
+// AdminActionMiddleware returns an AdminMiddleware that appends an admin action
+// to r for every successful UpdateUser, Suspend, Unsuspend and ForceDelete.
+// The actor, request ID and source IP are taken from the context. As with
+// AuditMiddleware, failing to record an action is logged but does not fail the
+// call.
+func AdminActionMiddleware(r AdminActionRepository, logger log.Logger) AdminMiddleware {
+	return func(next AdminService) AdminService {
+		return adminActionMiddleware{
+			next:   next,
+			repo:   r,
+			logger: logger,
+		}
+	}
+}
+
+type adminActionMiddleware struct {
+	next   AdminService
+	repo   AdminActionRepository
+	logger log.Logger
+}
+
+func (mw adminActionMiddleware) ListUsers(ctx context.Context, q model.UserQuery) ([]model.User, string, error) {
+	return mw.next.ListUsers(ctx, q)
+}
+
+func (mw adminActionMiddleware) GetUser(ctx context.Context, uid string) (model.User, error) {
+	return mw.next.GetUser(ctx, uid)
+}
+
+func (mw adminActionMiddleware) UpdateUser(ctx context.Context, u model.User, reason string) error {
+	uid := stringValue(u.UUID)
+	old, err := mw.next.GetUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err := mw.next.UpdateUser(ctx, u, reason); err != nil {
+		return err
+	}
+	if u.Roles != nil {
+		// Record the roles as stored.
+		if updated, err := mw.next.GetUser(ctx, uid); err == nil {
+			u.Roles = updated.Roles
+		}
+	}
+	mw.record(ctx, model.AdminActionUpdate, uid, reason, diffUsers(old, u))
+	return nil
+}
+
+func (mw adminActionMiddleware) Suspend(ctx context.Context, uid, reason string, until time.Time) error {
+	if err := mw.next.Suspend(ctx, uid, reason, until); err != nil {
+		return err
+	}
+	var changes []model.FieldChange
+	if !until.IsZero() {
+		changes = []model.FieldChange{{Field: "suspension.until", New: until.UTC().Format(time.RFC3339)}}
+	}
+	mw.record(ctx, model.AdminActionSuspend, uid, reason, changes)
+	return nil
+}
+
+func (mw adminActionMiddleware) Unsuspend(ctx context.Context, uid, reason string) error {
+	if err := mw.next.Unsuspend(ctx, uid, reason); err != nil {
+		return err
+	}
+	mw.record(ctx, model.AdminActionUnsuspend, uid, reason, nil)
+	return nil
+}
+
+func (mw adminActionMiddleware) ForceDelete(ctx context.Context, uid, reason string) error {
+	old, err := mw.next.GetUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if err := mw.next.ForceDelete(ctx, uid, reason); err != nil {
+		return err
+	}
+	mw.record(ctx, model.AdminActionForceDelete, uid, reason, diffUsers(old, model.User{}))
+	return nil
+}
+
+func (mw adminActionMiddleware) record(ctx context.Context, action model.AdminActionType, target, reason string, changes []model.FieldChange) {
+	actor, _ := ActorFromContext(ctx)
+	requestID, _ := RequestIDFromContext(ctx)
+	sourceIP, _ := SourceIPFromContext(ctx)
+	a := model.AdminAction{
+		Action:    action,
+		Actor:     actor,
+		Target:    target,
+		Reason:    reason,
+		Changes:   changes,
+		Timestamp: time.Now().UTC(),
+		RequestID: requestID,
+		SourceIP:  sourceIP,
+	}
+	if err := mw.repo.AppendAdminAction(ctx, a); err != nil {
+		mw.logger.Log("adminAction", action, "target", target, "err", err)
+	}
+}
@@ -0,0 +1,135 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memAdminActionRepository records the admin actions appended to it.
+type memAdminActionRepository struct{ actions []model.AdminAction }
+
+func (r *memAdminActionRepository) AppendAdminAction(_ context.Context, a model.AdminAction) error {
+	r.actions = append(r.actions, a)
+	return nil
+}
+
+func TestUpdateUserRoles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		uid     string
+		roles   []string
+		reason  string
+		want    []string
+		wantErr error
+	}{
+		{"granted", "u1", []string{model.RoleModerator}, "promotion", []string{model.RoleModerator}, nil},
+		{"duplicates", "u1", []string{model.RoleAdmin, model.RoleAdmin, model.RoleModerator}, "promotion", []string{model.RoleAdmin, model.RoleModerator}, nil},
+		{"all revoked", "u1", []string{}, "demotion", []string{model.RoleUser}, nil},
+		{"unchanged", "u1", nil, "new bio", []string{model.RoleAdmin}, nil},
+		{"unknown role", "u1", []string{"root"}, "promotion", []string{model.RoleAdmin}, ErrInvalidRole},
+		{"no reason", "u1", []string{model.RoleModerator}, " ", []string{model.RoleAdmin}, ErrReasonRequired},
+		{"unknown user", "u2", []string{model.RoleModerator}, "promotion", nil, ErrUserNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMemRepository(model.User{UUID: ptr("u1"), Roles: []string{model.RoleAdmin}})
+			s := NewAdminService(repo)
+			err := s.UpdateUser(context.Background(), model.User{UUID: ptr(tc.uid), Roles: tc.roles}, tc.reason)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("UpdateUser() = %v, want %v", err, tc.wantErr)
+			}
+			status, _ := NewService(repo).GetAccountStatus(context.Background(), tc.uid)
+			if !reflect.DeepEqual(status.Roles, tc.want) {
+				t.Errorf("roles = %v, want %v", status.Roles, tc.want)
+			}
+		})
+	}
+}
+
+func TestSuspension(t *testing.T) {
+	ctx := ContextWithActor(context.Background(), "mod")
+	repo := newMemRepository(model.User{UUID: ptr("u1"), Roles: []string{model.RoleModerator}})
+	admin, s := NewAdminService(repo), NewService(repo)
+
+	if err := admin.Suspend(ctx, "u1", "spam", time.Now().Add(-time.Minute)); !errors.Is(err, ErrInvalidExpiry) {
+		t.Errorf("Suspend() until the past = %v, want %v", err, ErrInvalidExpiry)
+	}
+	if err := admin.Suspend(ctx, "u1", "", time.Time{}); !errors.Is(err, ErrReasonRequired) {
+		t.Errorf("Suspend() without a reason = %v, want %v", err, ErrReasonRequired)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := admin.Suspend(ctx, "u1", "spam", until); err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.GetAccountStatus(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sus := status.Suspension; sus == nil || sus.Reason != "spam" || sus.Actor != "mod" || !sus.Until.Equal(until.UTC()) {
+		t.Errorf("suspension = %+v", sus)
+	}
+	if !reflect.DeepEqual(status.Roles, []string{model.RoleModerator}) {
+		t.Errorf("roles of the suspended user = %v", status.Roles)
+	}
+
+	// An expired suspension is no longer in effect.
+	repo.SetSuspension(ctx, "u1", &model.Suspension{Reason: "spam", Until: time.Now().Add(-time.Second)})
+	if sus, err := s.GetSuspension(ctx, "u1"); sus != nil || err != nil {
+		t.Errorf("GetSuspension() after expiry = %+v, %v", sus, err)
+	}
+
+	if err := admin.Suspend(ctx, "u1", "spam", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Unsuspend(ctx, "u1", "appeal"); err != nil {
+		t.Fatal(err)
+	}
+	if sus, err := s.GetSuspension(ctx, "u1"); sus != nil || err != nil {
+		t.Errorf("GetSuspension() after Unsuspend() = %+v, %v", sus, err)
+	}
+	if status, err := s.GetAccountStatus(ctx, "unknown"); err != nil || status.Roles != nil || status.Suspension != nil {
+		t.Errorf("GetAccountStatus() of an unknown user = %+v, %v", status, err)
+	}
+}
+
+func TestAdminActionMiddleware(t *testing.T) {
+	ctx := ContextWithActor(context.Background(), "admin")
+	repo := newMemRepository(model.User{UUID: ptr("u1")}, model.User{UUID: ptr("u2")})
+	actions := &memAdminActionRepository{}
+	s := AdminActionMiddleware(actions, log.NewNopLogger())(NewAdminService(repo))
+
+	s.UpdateUser(ctx, model.User{UUID: ptr("u1"), Roles: []string{model.RoleModerator, model.RoleModerator}}, "promotion")
+	s.Suspend(ctx, "u1", "spam", time.Time{})
+	s.Unsuspend(ctx, "u1", "appeal")
+	s.ForceDelete(ctx, "u2", "GDPR request")
+	// Failed actions aren't recorded.
+	s.ForceDelete(ctx, "u2", "again")
+	s.Suspend(ctx, "u1", "", time.Time{})
+
+	want := []struct {
+		action model.AdminActionType
+		target string
+		reason string
+	}{
+		{model.AdminActionUpdate, "u1", "promotion"},
+		{model.AdminActionSuspend, "u1", "spam"},
+		{model.AdminActionUnsuspend, "u1", "appeal"},
+		{model.AdminActionForceDelete, "u2", "GDPR request"},
+	}
+	if len(actions.actions) != len(want) {
+		t.Fatalf("recorded %d actions, want %d: %+v", len(actions.actions), len(want), actions.actions)
+	}
+	for i, a := range actions.actions {
+		if a.Action != want[i].action || a.Target != want[i].target || a.Reason != want[i].reason || a.Actor != "admin" {
+			t.Errorf("action %d = %+v, want %+v by admin", i, a, want[i])
+		}
+	}
+	if changes := actions.actions[0].Changes; len(changes) != 1 || changes[0].Field != "roles" {
+		t.Errorf("update recorded changes %+v, want the roles as stored", changes)
+	}
+}
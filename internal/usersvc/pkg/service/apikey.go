@@ -0,0 +1,202 @@
+package userservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAPIKeyNotFound is returned for an API key the caller doesn't manage.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrInvalidAPIKey is returned by VerifyAPIKey for a key that is unknown,
+	// revoked or expired.
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrInvalidScope is returned for a key without scopes or with a scope
+	// that model.ValidScope rejects.
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to scan for.
+	apiKeyPrefix = "gmk_"
+	// apiKeyDisplayLen is the length of the APIKey.Prefix shown for a key.
+	apiKeyDisplayLen = len(apiKeyPrefix) + 6
+	// lastUsedResolution limits how often using a key is written back.
+	lastUsedResolution = time.Minute
+)
+
+// APIKeyService manages the API keys bots and integrations authenticate with.
+// The caller is taken from the context: they create keys acting as
+// themselves or, if they are an admin, as a service account, and list and
+// revoke the keys they created.
+type APIKeyService interface {
+	// CreateAPIKey creates a key with the name, scopes, expiry and, for a
+	// service account, subject of k. The key itself is only returned here.
+	CreateAPIKey(ctx context.Context, k model.APIKey) (created model.APIKey, key string, err error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	// VerifyAPIKey returns the active key matching key and records its use.
+	VerifyAPIKey(ctx context.Context, key string) (model.APIKey, error)
+}
+
+// APIKeyRepository stores API keys by the SHA-256 hash of the key. GetAPIKey
+// and FindAPIKey return ErrAPIKeyNotFound for an unknown key.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, k model.APIKey, hash string) error
+	ListAPIKeys(ctx context.Context, createdBy string) ([]model.APIKey, error)
+	GetAPIKey(ctx context.Context, id string) (model.APIKey, error)
+	FindAPIKey(ctx context.Context, hash string) (model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+func NewAPIKeyService(r APIKeyRepository) APIKeyService {
+	if r == nil {
+		panic("invalid repository")
+	}
+	return apiKeyService{repo: r}
+}
+
+type apiKeyService struct {
+	repo APIKeyRepository
+}
+
+func (s apiKeyService) CreateAPIKey(ctx context.Context, k model.APIKey) (model.APIKey, string, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return model.APIKey{}, "", ErrUnauthenticated
+	}
+	now := time.Now().UTC()
+	if !k.ExpiresAt.IsZero() && !k.ExpiresAt.After(now) {
+		return model.APIKey{}, "", ErrInvalidExpiry
+	}
+	scopes, err := normalizeScopes(k.Scopes)
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	switch {
+	case k.Subject == "" || k.Subject == caller.ID:
+		k.Subject = caller.ID
+	case model.IsServiceAccount(k.Subject) && len(k.Subject) > len(model.ServiceAccountPrefix):
+		if !caller.HasRole(model.RoleAdmin) {
+			return model.APIKey{}, "", ErrPermissionDenied
+		}
+	default:
+		return model.APIKey{}, "", ErrPermissionDenied
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	key := apiKeyPrefix + secret
+	created := model.APIKey{
+		ID:        uuid.NewString(),
+		Prefix:    key[:apiKeyDisplayLen],
+		Name:      strings.TrimSpace(k.Name),
+		Subject:   k.Subject,
+		CreatedBy: caller.ID,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: k.ExpiresAt.UTC(),
+	}
+	if err := s.repo.CreateAPIKey(ctx, created, hashToken(key)); err != nil {
+		return model.APIKey{}, "", err
+	}
+	return created, key, nil
+}
+
+func (s apiKeyService) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return s.repo.ListAPIKeys(ctx, caller.ID)
+}
+
+// RevokeAPIKey revokes a key the caller created; admins may revoke any key.
+// Revoking a revoked key succeeds.
+func (s apiKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	k, err := s.repo.GetAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if k.CreatedBy != caller.ID && !caller.HasRole(model.RoleAdmin) {
+		// Don't tell others' keys from unknown ones.
+		return ErrAPIKeyNotFound
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil
+	}
+	return s.repo.RevokeAPIKey(ctx, id, time.Now().UTC())
+}
+
+func (s apiKeyService) VerifyAPIKey(ctx context.Context, key string) (model.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	k, err := s.repo.FindAPIKey(ctx, hashToken(key))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	now := time.Now().UTC()
+	if !k.Active(now) {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	if now.Sub(k.LastUsedAt) >= lastUsedResolution {
+		// Tracking use is best effort; it mustn't fail the request.
+		if err := s.repo.TouchAPIKey(ctx, k.ID, now); err == nil {
+			k.LastUsedAt = now
+		}
+	}
+	return k, nil
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	var normalized []string
+	for _, scope := range scopes {
+		if !model.ValidScope(scope) {
+			return nil, ErrInvalidScope
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return normalized, nil
+}
+
+// randomSecret returns 256 random bits, base64url encoded.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes an API key, refresh token or recovery code for storage.
+// They are random enough that a fast hash can't be brute-forced, and it lets
+// them be looked up by their hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
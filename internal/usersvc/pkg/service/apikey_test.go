@@ -0,0 +1,245 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memAPIKeyRepository is an APIKeyRepository keeping keys in memory, for tests.
+type memAPIKeyRepository struct {
+	mtx     sync.Mutex
+	keys    map[string]model.APIKey
+	hashes  map[string]string // hash to ID
+	touches int
+}
+
+func newMemAPIKeyRepository() *memAPIKeyRepository {
+	return &memAPIKeyRepository{keys: map[string]model.APIKey{}, hashes: map[string]string{}}
+}
+
+func (r *memAPIKeyRepository) CreateAPIKey(_ context.Context, k model.APIKey, hash string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.keys[k.ID], r.hashes[hash] = k, k.ID
+	return nil
+}
+
+func (r *memAPIKeyRepository) ListAPIKeys(_ context.Context, createdBy string) ([]model.APIKey, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var keys []model.APIKey
+	for _, k := range r.keys {
+		if k.CreatedBy == createdBy {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (r *memAPIKeyRepository) GetAPIKey(_ context.Context, id string) (model.APIKey, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return model.APIKey{}, ErrAPIKeyNotFound
+	}
+	return k, nil
+}
+
+func (r *memAPIKeyRepository) FindAPIKey(ctx context.Context, hash string) (model.APIKey, error) {
+	r.mtx.Lock()
+	id, ok := r.hashes[hash]
+	r.mtx.Unlock()
+	if !ok {
+		return model.APIKey{}, ErrAPIKeyNotFound
+	}
+	return r.GetAPIKey(ctx, id)
+}
+
+func (r *memAPIKeyRepository) update(id string, f func(*model.APIKey)) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	f(&k)
+	r.keys[id] = k
+	return nil
+}
+
+func (r *memAPIKeyRepository) RevokeAPIKey(_ context.Context, id string, at time.Time) error {
+	return r.update(id, func(k *model.APIKey) { k.RevokedAt = at })
+}
+
+func (r *memAPIKeyRepository) TouchAPIKey(_ context.Context, id string, at time.Time) error {
+	r.touches++
+	return r.update(id, func(k *model.APIKey) { k.LastUsedAt = at })
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	user := &Caller{ID: "u1"}
+	admin := &Caller{ID: "u2", Roles: []string{model.RoleAdmin}}
+	for _, tc := range []struct {
+		name        string
+		caller      *Caller
+		k           model.APIKey
+		wantErr     error
+		wantSubject string
+		wantScopes  []string
+	}{
+		{"anonymous", nil, model.APIKey{Scopes: []string{model.ScopeRead}}, ErrUnauthenticated, "", nil},
+		{"acting as the caller", user, model.APIKey{Name: " bot ", Scopes: []string{model.ScopeRead}}, nil, "u1", []string{model.ScopeRead}},
+		{"duplicate scopes", user, model.APIKey{Scopes: []string{model.ScopeWrite, model.ScopeRead, model.ScopeWrite}}, nil, "u1", []string{model.ScopeWrite, model.ScopeRead}},
+		{"no scopes", user, model.APIKey{}, ErrInvalidScope, "", nil},
+		{"unknown scope", user, model.APIKey{Scopes: []string{model.ScopeRead, "admin"}}, ErrInvalidScope, "", nil},
+		{"expired", user, model.APIKey{Scopes: []string{model.ScopeRead}, ExpiresAt: time.Now().Add(-time.Minute)}, ErrInvalidExpiry, "", nil},
+		{"acting as another user", user, model.APIKey{Subject: "u3", Scopes: []string{model.ScopeRead}}, ErrPermissionDenied, "", nil},
+		{"acting as another user by an admin", admin, model.APIKey{Subject: "u3", Scopes: []string{model.ScopeRead}}, ErrPermissionDenied, "", nil},
+		{"service account", user, model.APIKey{Subject: "service:ci-bot", Scopes: []string{model.ScopeRead}}, ErrPermissionDenied, "", nil},
+		{"service account by an admin", admin, model.APIKey{Subject: "service:ci-bot", Scopes: []string{model.ScopeRead}}, nil, "service:ci-bot", []string{model.ScopeRead}},
+		{"unnamed service account", admin, model.APIKey{Subject: model.ServiceAccountPrefix, Scopes: []string{model.ScopeRead}}, ErrPermissionDenied, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMemAPIKeyRepository()
+			s := NewAPIKeyService(repo)
+			ctx := context.Background()
+			if tc.caller != nil {
+				ctx = ContextWithCaller(ctx, *tc.caller)
+			}
+			created, key, err := s.CreateAPIKey(ctx, tc.k)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("CreateAPIKey() = %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				if len(repo.keys) != 0 {
+					t.Error("key stored despite the error")
+				}
+				return
+			}
+			if created.Subject != tc.wantSubject || created.CreatedBy != tc.caller.ID || !reflect.DeepEqual(created.Scopes, tc.wantScopes) {
+				t.Errorf("created %+v", created)
+			}
+			if created.Name != strings.TrimSpace(tc.k.Name) {
+				t.Errorf("name = %q", created.Name)
+			}
+			if !strings.HasPrefix(key, apiKeyPrefix) || !strings.HasPrefix(key, created.Prefix) || len(created.Prefix) != apiKeyDisplayLen {
+				t.Errorf("key %q shown as %q", key, created.Prefix)
+			}
+			// Only the hash of the key is stored.
+			if _, ok := repo.hashes[hashToken(key)]; !ok || len(repo.hashes) != 1 {
+				t.Errorf("stored hashes %v, want the hash of the key", repo.hashes)
+			}
+			if _, ok := repo.hashes[key]; ok {
+				t.Error("key stored in the clear")
+			}
+		})
+	}
+}
+
+func TestAPIKeysAreUnique(t *testing.T) {
+	s := NewAPIKeyService(newMemAPIKeyRepository())
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	seen := map[string]bool{}
+	for range 100 {
+		_, key, err := s.CreateAPIKey(ctx, model.APIKey{Scopes: []string{model.ScopeRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[key] {
+			t.Fatalf("key %q issued twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	repo := newMemAPIKeyRepository()
+	s := NewAPIKeyService(repo)
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	create := func(k model.APIKey) (model.APIKey, string) {
+		t.Helper()
+		k.Scopes = []string{model.ScopeRead}
+		created, key, err := s.CreateAPIKey(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created, key
+	}
+	valid, validKey := create(model.APIKey{})
+	revoked, revokedKey := create(model.APIKey{})
+	if err := s.RevokeAPIKey(ctx, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	expiring, expiringKey := create(model.APIKey{ExpiresAt: time.Now().Add(time.Hour)})
+	repo.update(expiring.ID, func(k *model.APIKey) { k.ExpiresAt = time.Now().Add(-time.Second) })
+
+	for _, tc := range []struct {
+		name    string
+		key     string
+		wantID  string
+		wantErr error
+	}{
+		{"valid", validKey, valid.ID, nil},
+		{"revoked", revokedKey, "", ErrInvalidAPIKey},
+		{"expired", expiringKey, "", ErrInvalidAPIKey},
+		{"unknown", apiKeyPrefix + "unknown", "", ErrInvalidAPIKey},
+		{"without the prefix", strings.TrimPrefix(validKey, apiKeyPrefix), "", ErrInvalidAPIKey},
+		{"its hash", hashToken(validKey), "", ErrInvalidAPIKey},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k, err := s.VerifyAPIKey(context.Background(), tc.key)
+			if !errors.Is(err, tc.wantErr) || k.ID != tc.wantID {
+				t.Errorf("VerifyAPIKey() = %q, %v, want %q, %v", k.ID, err, tc.wantID, tc.wantErr)
+			}
+		})
+	}
+
+	// Use is recorded at most once per lastUsedResolution.
+	repo.touches = 0
+	for range 3 {
+		if k, err := s.VerifyAPIKey(context.Background(), validKey); err != nil || k.LastUsedAt.IsZero() {
+			t.Fatalf("VerifyAPIKey() = %+v, %v", k, err)
+		}
+	}
+	if repo.touches != 0 {
+		t.Errorf("last use recorded %d more times within %s", repo.touches, lastUsedResolution)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	repo := newMemAPIKeyRepository()
+	s := NewAPIKeyService(repo)
+	owner := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	k, _, err := s.CreateAPIKey(owner, model.APIKey{Scopes: []string{model.ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		id      string
+		wantErr error
+	}{
+		{"anonymous", context.Background(), k.ID, ErrUnauthenticated},
+		{"by another user", ContextWithCaller(context.Background(), Caller{ID: "u2"}), k.ID, ErrAPIKeyNotFound},
+		{"unknown", owner, "unknown", ErrAPIKeyNotFound},
+		{"by an admin", ContextWithCaller(context.Background(), Caller{ID: "u2", Roles: []string{model.RoleAdmin}}), k.ID, nil},
+		{"again by the owner", owner, k.ID, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.RevokeAPIKey(tc.ctx, tc.id); !errors.Is(err, tc.wantErr) {
+				t.Errorf("RevokeAPIKey() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+	if keys, _ := s.ListAPIKeys(owner); len(keys) != 1 || keys[0].RevokedAt.IsZero() {
+		t.Errorf("ListAPIKeys() = %+v, want the revoked key", keys)
+	}
+}
@@ -0,0 +1,192 @@
+package userservice
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+
+	redactedValue = "[redacted]"
+)
+
+// sensitiveFields are recorded as changed in the audit log, but their values are not.
+var sensitiveFields = map[string]bool{
+	"email":       true,
+	"phoneNumber": true,
+}
+
+// AuditLog gives read access to the audit trail recorded by AuditMiddleware.
+type AuditLog interface {
+	ListAuditEntries(ctx context.Context, q model.AuditQuery) (entries []model.AuditEntry, nextPageToken string, err error)
+}
+
+// AuditRepository is an append-only store of audit entries.
+type AuditRepository interface {
+	AppendAuditEntry(ctx context.Context, e model.AuditEntry) error
+	ListAuditEntries(ctx context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error)
+}
+
+func NewAuditLog(r AuditRepository) AuditLog {
+	if r == nil {
+		panic("invalid audit repository")
+	}
+	return auditLog{repo: r}
+}
+
+type auditLog struct {
+	repo AuditRepository
+}
+
+func (a auditLog) ListAuditEntries(ctx context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error) {
+	switch {
+	case q.PageSize <= 0:
+		q.PageSize = defaultAuditPageSize
+	case q.PageSize > maxAuditPageSize:
+		q.PageSize = maxAuditPageSize
+	}
+	return a.repo.ListAuditEntries(ctx, q)
+}
+
+// AuditMiddleware returns a Middleware that appends an audit entry to r for every
+// successful Create, Update and Delete. The actor, request ID and source IP are
+// taken from the context. Failing to record an entry is logged but does not fail
+// the call, since the mutation has already been applied.
+func AuditMiddleware(r AuditRepository, logger log.Logger) Middleware {
+	return func(next Service) Service {
+		return auditMiddleware{
+			next:   next,
+			repo:   r,
+			logger: logger,
+		}
+	}
+}
+
+type auditMiddleware struct {
+	next   Service
+	repo   AuditRepository
+	logger log.Logger
+}
+
+func (mw auditMiddleware) CreateProfile(ctx context.Context, u model.User) error {
+	if err := mw.next.CreateProfile(ctx, u); err != nil {
+		return err
+	}
+	mw.record(ctx, model.AuditActionCreate, stringValue(u.UUID), diffUsers(model.User{}, u))
+	return nil
+}
+
+func (mw auditMiddleware) GetProfile(ctx context.Context, uid string, authenticated bool) (model.User, error) {
+	return mw.next.GetProfile(ctx, uid, authenticated)
+}
+
+func (mw auditMiddleware) GetSuspension(ctx context.Context, uid string) (*model.Suspension, error) {
+	return mw.next.GetSuspension(ctx, uid)
+}
+
+func (mw auditMiddleware) GetAccountStatus(ctx context.Context, uid string) (model.AccountStatus, error) {
+	return mw.next.GetAccountStatus(ctx, uid)
+}
+
+func (mw auditMiddleware) UpdateProfile(ctx context.Context, u model.User) error {
+	uid := stringValue(u.UUID)
+	old, err := mw.next.GetProfile(ctx, uid, true)
+	if err != nil {
+		return err
+	}
+	if err := mw.next.UpdateProfile(ctx, u); err != nil {
+		return err
+	}
+	mw.record(ctx, model.AuditActionUpdate, uid, diffUsers(old, u))
+	return nil
+}
+
+func (mw auditMiddleware) DeleteProfile(ctx context.Context, uid string) error {
+	old, err := mw.next.GetProfile(ctx, uid, true)
+	if err != nil {
+		return err
+	}
+	if err := mw.next.DeleteProfile(ctx, uid); err != nil {
+		return err
+	}
+	mw.record(ctx, model.AuditActionDelete, uid, diffUsers(old, model.User{}))
+	return nil
+}
+
+func (mw auditMiddleware) record(ctx context.Context, action model.AuditAction, target string, changes []model.FieldChange) {
+	actor, _ := ActorFromContext(ctx)
+	requestID, _ := RequestIDFromContext(ctx)
+	sourceIP, _ := SourceIPFromContext(ctx)
+	e := model.AuditEntry{
+		Action:    action,
+		Actor:     actor,
+		Target:    target,
+		Changes:   changes,
+		Timestamp: time.Now().UTC(),
+		RequestID: requestID,
+		SourceIP:  sourceIP,
+	}
+	if err := mw.repo.AppendAuditEntry(ctx, e); err != nil {
+		mw.logger.Log("audit", action, "target", target, "err", err)
+	}
+}
+
+// diffUsers lists the fields that differ between before and after. A nil field in
+// after means "unchanged" for updates, so a deletion is expressed by passing an
+// empty after.
+func diffUsers(before, after model.User) []model.FieldChange {
+	deleting := reflect.DeepEqual(after, model.User{})
+	fields := []struct {
+		name     string
+		old, new *string
+	}{
+		{"email", before.Email, after.Email},
+		{"phoneNumber", before.PhoneNumber, after.PhoneNumber},
+		{"userName", before.UserName, after.UserName},
+		{"profilePicture", before.ProfilePicture, after.ProfilePicture},
+		{"bio", before.Bio, after.Bio},
+		{"authProvider", before.AuthProvider, after.AuthProvider},
+	}
+
+	var changes []model.FieldChange
+	for _, f := range fields {
+		if f.new == nil && !deleting {
+			continue
+		}
+		o, n := stringValue(f.old), stringValue(f.new)
+		if o == n {
+			continue
+		}
+		if sensitiveFields[f.name] {
+			o, n = redact(o), redact(n)
+		}
+		changes = append(changes, model.FieldChange{Field: f.name, Old: o, New: n})
+	}
+	if after.Roles != nil || deleting {
+		o, n := strings.Join(before.Roles, ","), strings.Join(after.Roles, ",")
+		if o != n {
+			changes = append(changes, model.FieldChange{Field: "roles", Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
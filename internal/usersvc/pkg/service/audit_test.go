@@ -0,0 +1,199 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"testing"
+)
+
+// memAuditRepository records the entries appended to it and the queries made.
+type memAuditRepository struct {
+	entries []model.AuditEntry
+	queries []model.AuditQuery
+	err     error
+}
+
+func (r *memAuditRepository) AppendAuditEntry(_ context.Context, e model.AuditEntry) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func (r *memAuditRepository) ListAuditEntries(_ context.Context, q model.AuditQuery) ([]model.AuditEntry, string, error) {
+	r.queries = append(r.queries, q)
+	return r.entries, "", nil
+}
+
+func TestDiffUsers(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		before, after model.User
+		want          []model.FieldChange
+	}{
+		{
+			name:   "create",
+			before: model.User{},
+			after:  model.User{UUID: ptr("u1"), UserName: ptr("alice"), Bio: ptr("hi")},
+			want: []model.FieldChange{
+				{Field: "userName", New: "alice"},
+				{Field: "bio", New: "hi"},
+			},
+		},
+		{
+			name:   "nil fields are unchanged",
+			before: model.User{UserName: ptr("alice"), Bio: ptr("hi")},
+			after:  model.User{Bio: ptr("hello")},
+			want:   []model.FieldChange{{Field: "bio", Old: "hi", New: "hello"}},
+		},
+		{
+			name:   "equal values are left out",
+			before: model.User{UserName: ptr("alice")},
+			after:  model.User{UserName: ptr("alice")},
+			want:   nil,
+		},
+		{
+			name:   "sensitive values are redacted",
+			before: model.User{Email: ptr("a@example.com")},
+			after:  model.User{Email: ptr("b@example.com"), PhoneNumber: ptr("+100")},
+			want: []model.FieldChange{
+				{Field: "email", Old: redactedValue, New: redactedValue},
+				{Field: "phoneNumber", New: redactedValue},
+			},
+		},
+		{
+			name:   "roles",
+			before: model.User{Roles: []string{model.RoleUser}},
+			after:  model.User{Roles: []string{model.RoleAdmin}},
+			want:   []model.FieldChange{{Field: "roles", Old: model.RoleUser, New: model.RoleAdmin}},
+		},
+		{
+			name:   "delete",
+			before: model.User{UUID: ptr("u1"), Email: ptr("a@example.com"), UserName: ptr("alice"), Roles: []string{model.RoleModerator}},
+			after:  model.User{},
+			want: []model.FieldChange{
+				{Field: "email", Old: redactedValue},
+				{Field: "userName", Old: "alice"},
+				{Field: "roles", Old: model.RoleModerator},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := diffUsers(tc.before, tc.after); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("diffUsers() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	ctx := ContextWithActor(context.Background(), "admin1")
+	ctx = ContextWithRequestID(ctx, "req1")
+	ctx = ContextWithSourceIP(ctx, "203.0.113.7")
+
+	for _, tc := range []struct {
+		name    string
+		call    func(Service) error
+		want    []model.AuditEntry
+		wantErr error
+	}{
+		{
+			name: "create",
+			call: func(s Service) error {
+				return s.CreateProfile(ctx, model.User{UUID: ptr("u2"), UserName: ptr("bob")})
+			},
+			want: []model.AuditEntry{{
+				Action:  model.AuditActionCreate,
+				Target:  "u2",
+				Changes: []model.FieldChange{{Field: "userName", New: "bob"}},
+			}},
+		},
+		{
+			name: "update",
+			call: func(s Service) error {
+				return s.UpdateProfile(ctx, model.User{UUID: ptr("u1"), UserName: ptr("alicia")})
+			},
+			want: []model.AuditEntry{{
+				Action:  model.AuditActionUpdate,
+				Target:  "u1",
+				Changes: []model.FieldChange{{Field: "userName", Old: "alice", New: "alicia"}},
+			}},
+		},
+		{
+			name: "delete",
+			call: func(s Service) error { return s.DeleteProfile(ctx, "u1") },
+			want: []model.AuditEntry{{
+				Action:  model.AuditActionDelete,
+				Target:  "u1",
+				Changes: []model.FieldChange{{Field: "userName", Old: "alice"}},
+			}},
+		},
+		{
+			name:    "failed calls aren't recorded",
+			call:    func(s Service) error { return s.DeleteProfile(ctx, "missing") },
+			wantErr: ErrUserNotFound,
+		},
+		{
+			name: "reads aren't recorded",
+			call: func(s Service) error {
+				_, err := s.GetProfile(ctx, "u1", true)
+				return err
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			audit := &memAuditRepository{}
+			repo := newMemRepository(model.User{UUID: ptr("u1"), UserName: ptr("alice")})
+			s := AuditMiddleware(audit, log.NewNopLogger())(NewService(repo))
+
+			if err := tc.call(s); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if len(audit.entries) != len(tc.want) {
+				t.Fatalf("recorded %d entries, want %d", len(audit.entries), len(tc.want))
+			}
+			for i, got := range audit.entries {
+				want := tc.want[i]
+				want.Actor, want.RequestID, want.SourceIP = "admin1", "req1", "203.0.113.7"
+				want.Timestamp = got.Timestamp
+				if got.Timestamp.IsZero() {
+					t.Error("entry without timestamp")
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("entry = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditMiddlewareIgnoresRecordFailures(t *testing.T) {
+	audit := &memAuditRepository{err: errors.New("unavailable")}
+	s := AuditMiddleware(audit, log.NewNopLogger())(NewService(newMemRepository()))
+	if err := s.CreateProfile(context.Background(), model.User{UUID: ptr("u1")}); err != nil {
+		t.Fatalf("CreateProfile() = %v, want nil", err)
+	}
+}
+
+func TestListAuditEntriesPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		pageSize, want int
+	}{
+		{0, defaultAuditPageSize},
+		{-1, defaultAuditPageSize},
+		{20, 20},
+		{maxAuditPageSize + 1, maxAuditPageSize},
+	} {
+		repo := &memAuditRepository{}
+		if _, _, err := NewAuditLog(repo).ListAuditEntries(context.Background(), model.AuditQuery{PageSize: tc.pageSize}); err != nil {
+			t.Fatal(err)
+		}
+		if got := repo.queries[0].PageSize; got != tc.want {
+			t.Errorf("page size %d: queried %d, want %d", tc.pageSize, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package userservice
+
+import "context"
+
+type contextKey int
+
+const (
+	actorContextKey contextKey = iota
+	requestIDContextKey
+	sourceIPContextKey
+	callerContextKey
+)
+
+// ContextWithActor returns a copy of ctx carrying the ID of the user performing the call.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// ActorFromContext returns the ID of the user performing the call, if any.
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorContextKey).(string)
+	return actor, ok && actor != ""
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the originating request.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext returns the ID of the originating request, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDContextKey).(string)
+	return requestID, ok && requestID != ""
+}
+
+// ContextWithSourceIP returns a copy of ctx carrying the IP address of the end client.
+func ContextWithSourceIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, sourceIPContextKey, ip)
+}
+
+// SourceIPFromContext returns the IP address of the end client, if any.
+func SourceIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(sourceIPContextKey).(string)
+	return ip, ok && ip != ""
+}
+
+// Caller is the end user a call is made on behalf of, as verified by the
+// gateway.
+type Caller struct {
+	ID    string
+	Roles []string
+}
+
+// HasRole reports whether c holds role.
+func (c Caller) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextWithCaller returns a copy of ctx carrying the verified caller.
+func ContextWithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerContextKey, c)
+}
+
+// CallerFromContext returns the verified caller, if any.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	c, ok := ctx.Value(callerContextKey).(Caller)
+	return c, ok && c.ID != ""
+}
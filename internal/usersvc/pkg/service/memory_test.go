@@ -0,0 +1,100 @@
+package userservice
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"sort"
+	"sync"
+)
+
+// memRepository is a Repository keeping profiles in memory, for tests.
+type memRepository struct {
+	mtx   sync.Mutex
+	users map[string]model.User
+}
+
+func newMemRepository(users ...model.User) *memRepository {
+	r := &memRepository{users: map[string]model.User{}}
+	for _, u := range users {
+		r.users[*u.UUID] = u
+	}
+	return r
+}
+
+func (r *memRepository) CreateUser(_ context.Context, u model.User) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.users[*u.UUID] = u
+	return nil
+}
+
+func (r *memRepository) GetUser(_ context.Context, uid string) (model.User, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	u, ok := r.users[uid]
+	if !ok {
+		return model.User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (r *memRepository) UpdateUser(_ context.Context, u model.User) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	old, ok := r.users[*u.UUID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for _, f := range []struct{ dst, src **string }{
+		{&old.Email, &u.Email},
+		{&old.PhoneNumber, &u.PhoneNumber},
+		{&old.UserName, &u.UserName},
+		{&old.ProfilePicture, &u.ProfilePicture},
+		{&old.Bio, &u.Bio},
+		{&old.AuthProvider, &u.AuthProvider},
+	} {
+		if *f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if u.Roles != nil {
+		old.Roles = u.Roles
+	}
+	r.users[*u.UUID] = old
+	return nil
+}
+
+func (r *memRepository) DeleteUser(_ context.Context, uid string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.users[uid]; !ok {
+		return ErrUserNotFound
+	}
+	delete(r.users, uid)
+	return nil
+}
+
+func (r *memRepository) ListUsers(_ context.Context, q model.UserQuery) ([]model.User, string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var users []model.User
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return *users[i].UUID < *users[j].UUID })
+	return users, "", nil
+}
+
+func (r *memRepository) SetSuspension(_ context.Context, uid string, s *model.Suspension) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	u, ok := r.users[uid]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Suspension = s
+	r.users[uid] = u
+	return nil
+}
+
+func ptr(s string) *string { return &s }
@@ -0,0 +1,4 @@
+package userservice
+
+// Middleware describes a service (as opposed to endpoint) middleware.
+type Middleware func(Service) Service
@@ -2,7 +2,28 @@ package userservice
 
 import (
 	"context"
+	"errors"
 	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUnauthenticated is returned for a call that needs a verified caller
+	// but has none.
+	ErrUnauthenticated = errors.New("caller not authenticated")
+	// ErrPermissionDenied is returned for a call the caller may not make.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrReasonRequired is returned for an admin action given no reason.
+	ErrReasonRequired = errors.New("reason required")
+	// ErrInvalidRole is returned for a role that model.ValidRole rejects.
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrInvalidExpiry is returned for a suspension that would already have
+	// expired.
+	ErrInvalidExpiry = errors.New("invalid suspension expiry")
+	// ErrProfileUnavailable is returned for the profile of a suspended user
+	// to anyone but them and moderators.
+	ErrProfileUnavailable = errors.New("profile unavailable")
 )
 
 type Service interface {
@@ -10,13 +31,24 @@ type Service interface {
 	GetProfile(ctx context.Context, uid string, authenticated bool) (model.User, error)
 	UpdateProfile(ctx context.Context, u model.User) error
 	DeleteProfile(ctx context.Context, uid string) error
+	// GetSuspension returns the suspension in effect for uid, or nil if
+	// there is none or the user doesn't exist.
+	GetSuspension(ctx context.Context, uid string) (*model.Suspension, error)
+	// GetAccountStatus returns the roles of uid and the suspension in effect
+	// for them. Users without a profile have a zero status.
+	GetAccountStatus(ctx context.Context, uid string) (model.AccountStatus, error)
 }
 
+// Repository persists user profiles. GetUser returns ErrUserNotFound for an
+// unknown user. SetSuspension suspends a user, or lifts their suspension if s
+// is nil.
 type Repository interface {
 	CreateUser(ctx context.Context, u model.User) error
 	GetUser(ctx context.Context, uid string) (model.User, error)
 	UpdateUser(ctx context.Context, u model.User) error
 	DeleteUser(ctx context.Context, uid string) error
+	ListUsers(ctx context.Context, q model.UserQuery) (users []model.User, nextPageToken string, err error)
+	SetSuspension(ctx context.Context, uid string, s *model.Suspension) error
 }
 
 func NewService(r Repository) Service {
@@ -35,7 +67,21 @@ func (s service) CreateProfile(ctx context.Context, u model.User) error {
 }
 
 func (s service) GetProfile(ctx context.Context, uid string, authenticated bool) (model.User, error) {
-	return s.repo.GetUser(ctx, uid)
+	u, err := s.repo.GetUser(ctx, uid)
+	if err != nil {
+		return model.User{}, err
+	}
+	if u.Suspension.Active(time.Now()) && !maySeeSuspended(ctx, uid) {
+		return model.User{}, ErrProfileUnavailable
+	}
+	return u, nil
+}
+
+// maySeeSuspended reports whether the caller may see the suspended user uid:
+// only they and moderators may.
+func maySeeSuspended(ctx context.Context, uid string) bool {
+	c, ok := CallerFromContext(ctx)
+	return ok && (c.ID == uid || c.HasRole(model.RoleModerator) || c.HasRole(model.RoleAdmin))
 }
 
 func (s service) UpdateProfile(ctx context.Context, u model.User) error {
@@ -45,3 +91,23 @@ func (s service) UpdateProfile(ctx context.Context, u model.User) error {
 func (s service) DeleteProfile(ctx context.Context, uid string) error {
 	return s.repo.DeleteUser(ctx, uid)
 }
+
+func (s service) GetSuspension(ctx context.Context, uid string) (*model.Suspension, error) {
+	status, err := s.GetAccountStatus(ctx, uid)
+	return status.Suspension, err
+}
+
+func (s service) GetAccountStatus(ctx context.Context, uid string) (model.AccountStatus, error) {
+	u, err := s.repo.GetUser(ctx, uid)
+	if errors.Is(err, ErrUserNotFound) {
+		return model.AccountStatus{}, nil
+	}
+	if err != nil {
+		return model.AccountStatus{}, err
+	}
+	status := model.AccountStatus{Roles: u.Roles}
+	if u.Suspension.Active(time.Now()) {
+		status.Suspension = u.Suspension
+	}
+	return status, nil
+}
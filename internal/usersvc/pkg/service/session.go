@@ -0,0 +1,275 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrSessionNotFound is returned for a session the caller doesn't own.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionRevoked is returned by CheckSession for a session that is
+	// unknown, revoked or expired.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrInvalidRefreshToken is returned by RefreshSession for a refresh token
+	// that is malformed or doesn't belong to an active session.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned by RefreshSession for a refresh token
+	// that was already exchanged. The session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+const (
+	// refreshTokenPrefix starts every refresh token, followed by the ID of
+	// its session, a dot and the secret.
+	refreshTokenPrefix = "gmr_"
+	// maxDeviceLen bounds the length of Session.Device, which clients send.
+	maxDeviceLen = 256
+)
+
+// SessionService manages the sessions of users signed in through the
+// gateway. The caller is taken from the context: they start sessions for
+// themselves, and list and revoke their own. A session is refreshed by
+// whoever holds its refresh token, which changes on every refresh; presenting
+// a refresh token again revokes the session, as it must have leaked.
+type SessionService interface {
+	// CreateSession starts a session for the caller on device, from the
+	// source IP of the call, and returns it with its refresh token.
+	CreateSession(ctx context.Context, device string) (model.Session, string, error)
+	// RefreshSession exchanges refreshToken for a new one, extending its
+	// session. An empty device leaves it unchanged.
+	RefreshSession(ctx context.Context, refreshToken, device string) (model.Session, string, error)
+	// ListSessions returns the caller's active sessions, most recently used
+	// first.
+	ListSessions(ctx context.Context) ([]model.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	// RevokeSessions revokes all of the caller's sessions.
+	RevokeSessions(ctx context.Context) error
+	// CheckSession returns ErrSessionRevoked unless session id is active.
+	CheckSession(ctx context.Context, id string) error
+}
+
+// SessionRepository stores sessions along with the SHA-256 hash of their
+// current refresh token and of those it replaced. GetSession returns
+// ErrSessionNotFound for an unknown session.
+type SessionRepository interface {
+	CreateSession(ctx context.Context, s model.Session, hash string) error
+	GetSession(ctx context.Context, id string) (model.Session, error)
+	// ListSessions returns the sessions of userID active at t, most recently
+	// used first.
+	ListSessions(ctx context.Context, userID string, t time.Time) ([]model.Session, error)
+	// RotateSession replaces the refresh token of s.ID, if it is still
+	// oldHash and s.ID isn't revoked, and saves the Device, IP, Roles,
+	// LastUsedAt and ExpiresAt of s. Otherwise it returns
+	// ErrInvalidRefreshToken.
+	RotateSession(ctx context.Context, s model.Session, oldHash, newHash string) error
+	// RefreshTokenUsed reports whether hash is a refresh token that session
+	// id replaced.
+	RefreshTokenUsed(ctx context.Context, id, hash string) (bool, error)
+	RevokeSession(ctx context.Context, id string, at time.Time) error
+	// RevokeSessions revokes every session of userID not revoked yet.
+	RevokeSessions(ctx context.Context, userID string, at time.Time) error
+}
+
+// NewSessionService returns a SessionService storing sessions in r, which
+// last for ttl after they were last refreshed. The roles of users are read
+// from users.
+func NewSessionService(r SessionRepository, users Repository, ttl time.Duration) SessionService {
+	if r == nil || users == nil {
+		panic("invalid repository")
+	}
+	return sessionService{repo: r, users: users, ttl: ttl}
+}
+
+type sessionService struct {
+	repo  SessionRepository
+	users Repository
+	ttl   time.Duration
+}
+
+func (s sessionService) CreateSession(ctx context.Context, device string) (model.Session, string, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return model.Session{}, "", ErrUnauthenticated
+	}
+	roles, err := s.roles(ctx, caller.ID)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	now := time.Now().UTC()
+	ip, _ := SourceIPFromContext(ctx)
+	session := model.Session{
+		ID:         uuid.NewString(),
+		UserID:     caller.ID,
+		Device:     truncate(device, maxDeviceLen),
+		IP:         ip,
+		Roles:      roles,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+	token, err := newRefreshToken(session.ID)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	if err := s.repo.CreateSession(ctx, session, hashToken(token)); err != nil {
+		return model.Session{}, "", err
+	}
+	return session, token, nil
+}
+
+func (s sessionService) RefreshSession(ctx context.Context, refreshToken, device string) (model.Session, string, error) {
+	id, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+	session, err := s.repo.GetSession(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	now := time.Now().UTC()
+	if !session.Active(now) {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+
+	if device != "" {
+		session.Device = truncate(device, maxDeviceLen)
+	}
+	if ip, ok := SourceIPFromContext(ctx); ok {
+		session.IP = ip
+	}
+	if session.Roles, err = s.roles(ctx, session.UserID); err != nil {
+		return model.Session{}, "", err
+	}
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.ttl)
+	next, err := newRefreshToken(session.ID)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	err = s.repo.RotateSession(ctx, session, hashToken(refreshToken), hashToken(next))
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		if err != nil {
+			return model.Session{}, "", err
+		}
+		return session, next, nil
+	}
+
+	used, err := s.repo.RefreshTokenUsed(ctx, id, hashToken(refreshToken))
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	if !used {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+	// Both the client and whoever else got hold of the token may hold the
+	// current one, and there's no telling who is who.
+	if err := s.repo.RevokeSession(ctx, id, now); err != nil {
+		return model.Session{}, "", err
+	}
+	return model.Session{}, "", ErrRefreshTokenReused
+}
+
+func (s sessionService) ListSessions(ctx context.Context) ([]model.Session, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return s.repo.ListSessions(ctx, caller.ID, time.Now().UTC())
+}
+
+// RevokeSession revokes a session of the caller; admins may revoke any
+// session. Revoking a revoked session succeeds.
+func (s sessionService) RevokeSession(ctx context.Context, id string) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	session, err := s.repo.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.UserID != caller.ID && !caller.HasRole(model.RoleAdmin) {
+		// Don't tell others' sessions from unknown ones.
+		return ErrSessionNotFound
+	}
+	if !session.RevokedAt.IsZero() {
+		return nil
+	}
+	return s.repo.RevokeSession(ctx, id, time.Now().UTC())
+}
+
+func (s sessionService) RevokeSessions(ctx context.Context) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	return s.repo.RevokeSessions(ctx, caller.ID, time.Now().UTC())
+}
+
+func (s sessionService) CheckSession(ctx context.Context, id string) error {
+	session, err := s.repo.GetSession(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if !session.Active(time.Now()) {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// roles returns the roles of user uid, who may have no profile.
+func (s sessionService) roles(ctx context.Context, uid string) ([]string, error) {
+	u, err := s.users.GetUser(ctx, uid)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.Roles, nil
+}
+
+// newRefreshToken returns a new refresh token for session id.
+func newRefreshToken(id string) (string, error) {
+	secret, err := randomSecret()
+	if err != nil {
+		return "", err
+	}
+	return refreshTokenPrefix + id + "." + secret, nil
+}
+
+// parseRefreshToken returns the session ID in token.
+func parseRefreshToken(token string) (id string, ok bool) {
+	rest, ok := strings.CutPrefix(token, refreshTokenPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, ".")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// truncate shortens s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
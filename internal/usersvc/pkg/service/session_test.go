@@ -0,0 +1,273 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// memSessionRepository is a SessionRepository keeping sessions in memory, for
+// tests.
+type memSessionRepository struct {
+	mtx      sync.Mutex
+	sessions map[string]model.Session
+	current  map[string]string          // session ID to refresh token hash
+	used     map[string]map[string]bool // session ID to replaced hashes
+}
+
+func newMemSessionRepository() *memSessionRepository {
+	return &memSessionRepository{
+		sessions: map[string]model.Session{},
+		current:  map[string]string{},
+		used:     map[string]map[string]bool{},
+	}
+}
+
+func (r *memSessionRepository) CreateSession(_ context.Context, s model.Session, hash string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.sessions[s.ID], r.current[s.ID], r.used[s.ID] = s, hash, map[string]bool{}
+	return nil
+}
+
+func (r *memSessionRepository) GetSession(_ context.Context, id string) (model.Session, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	s, ok := r.sessions[id]
+	if !ok {
+		return model.Session{}, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (r *memSessionRepository) ListSessions(_ context.Context, userID string, t time.Time) ([]model.Session, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var sessions []model.Session
+	for _, s := range r.sessions {
+		if s.UserID == userID && s.Active(t) {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (r *memSessionRepository) RotateSession(_ context.Context, s model.Session, oldHash, newHash string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	old, ok := r.sessions[s.ID]
+	if !ok || !old.RevokedAt.IsZero() || r.current[s.ID] != oldHash {
+		return ErrInvalidRefreshToken
+	}
+	old.Device, old.IP, old.Roles, old.LastUsedAt, old.ExpiresAt = s.Device, s.IP, s.Roles, s.LastUsedAt, s.ExpiresAt
+	r.sessions[s.ID], r.current[s.ID] = old, newHash
+	r.used[s.ID][oldHash] = true
+	return nil
+}
+
+func (r *memSessionRepository) RefreshTokenUsed(_ context.Context, id, hash string) (bool, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.used[id][hash], nil
+}
+
+func (r *memSessionRepository) RevokeSession(_ context.Context, id string, at time.Time) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	s, ok := r.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.RevokedAt = at
+	r.sessions[id] = s
+	return nil
+}
+
+func (r *memSessionRepository) RevokeSessions(_ context.Context, userID string, at time.Time) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for id, s := range r.sessions {
+		if s.UserID == userID && s.RevokedAt.IsZero() {
+			s.RevokedAt = at
+			r.sessions[id] = s
+		}
+	}
+	return nil
+}
+
+// signIn starts a session for uid from ip.
+func signIn(t *testing.T, s SessionService, uid, ip string) (model.Session, string) {
+	t.Helper()
+	ctx := ContextWithSourceIP(ContextWithCaller(context.Background(), Caller{ID: uid}), ip)
+	session, token, err := s.CreateSession(ctx, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, token
+}
+
+func TestCreateSession(t *testing.T) {
+	users := newMemRepository(model.User{UUID: ptr("u1"), Roles: []string{model.RoleModerator}})
+	repo := newMemSessionRepository()
+	s := NewSessionService(repo, users, time.Hour)
+
+	if _, _, err := s.CreateSession(context.Background(), "phone"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("CreateSession() without a caller = %v", err)
+	}
+	session, token := signIn(t, s, "u1", "203.0.113.7")
+	if session.UserID != "u1" || session.IP != "203.0.113.7" || session.Device != "phone" ||
+		!reflect.DeepEqual(session.Roles, []string{model.RoleModerator}) || !session.ExpiresAt.Equal(session.CreatedAt.Add(time.Hour)) {
+		t.Errorf("session = %+v", session)
+	}
+	if id, ok := parseRefreshToken(token); !ok || id != session.ID {
+		t.Errorf("refresh token %q is not of session %s", token, session.ID)
+	}
+	if repo.current[session.ID] != hashToken(token) {
+		t.Error("refresh token not stored hashed")
+	}
+	// Users without a profile sign in with no roles.
+	if session, _ := signIn(t, s, "u2", ""); session.Roles != nil {
+		t.Errorf("roles of a user without a profile = %v", session.Roles)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	users := newMemRepository(model.User{UUID: ptr("u1")})
+	repo := newMemSessionRepository()
+	s := NewSessionService(repo, users, time.Hour)
+	session, first := signIn(t, s, "u1", "203.0.113.7")
+
+	// Each refresh returns a new token and extends the session, picking up
+	// the device, address and roles of now.
+	users.UpdateUser(context.Background(), model.User{UUID: ptr("u1"), Roles: []string{model.RoleAdmin}})
+	ctx := ContextWithSourceIP(context.Background(), "198.51.100.1")
+	refreshed, second, err := s.RefreshSession(ctx, first, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || refreshed.ID != session.ID {
+		t.Errorf("refreshed to %q of session %s", second, refreshed.ID)
+	}
+	if refreshed.Device != "laptop" || refreshed.IP != "198.51.100.1" || !reflect.DeepEqual(refreshed.Roles, []string{model.RoleAdmin}) ||
+		refreshed.ExpiresAt.Before(session.ExpiresAt) {
+		t.Errorf("refreshed session = %+v", refreshed)
+	}
+	_, third, err := s.RefreshSession(context.Background(), second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetSession(context.Background(), session.ID); got.Device != "laptop" {
+		t.Errorf("device %q after a refresh without one", got.Device)
+	}
+
+	// Presenting a replaced token again revokes the session, so that the
+	// current token stops working too.
+	if _, _, err := s.RefreshSession(context.Background(), first, ""); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if err := s.CheckSession(context.Background(), session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("CheckSession() after reuse = %v", err)
+	}
+	if _, _, err := s.RefreshSession(context.Background(), third, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("current token after reuse: %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRefreshSessionInvalidTokens(t *testing.T) {
+	users := newMemRepository()
+	repo := newMemSessionRepository()
+	s := NewSessionService(repo, users, time.Hour)
+	session, token := signIn(t, s, "u1", "")
+	expired, expiredToken := signIn(t, s, "u1", "")
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	repo.sessions[expired.ID] = expired
+	_, secret, _ := strings.Cut(token, ".")
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"without the prefix", strings.TrimPrefix(token, refreshTokenPrefix)},
+		{"without a secret", refreshTokenPrefix + session.ID + "."},
+		{"without a session", refreshTokenPrefix + "." + secret},
+		{"unknown session", refreshTokenPrefix + "unknown." + secret},
+		{"wrong secret", refreshTokenPrefix + session.ID + ".forged"},
+		{"another session's secret", refreshTokenPrefix + expired.ID + "." + secret},
+		{"expired session", expiredToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := s.RefreshSession(context.Background(), tc.token, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("RefreshSession() = %v, want %v", err, ErrInvalidRefreshToken)
+			}
+		})
+	}
+	// None of them revoked the session.
+	if err := s.CheckSession(context.Background(), session.ID); err != nil {
+		t.Errorf("CheckSession() = %v", err)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	users := newMemRepository()
+	s := NewSessionService(newMemSessionRepository(), users, time.Hour)
+	s1, _ := signIn(t, s, "u1", "")
+	s2, token := signIn(t, s, "u1", "")
+	other, _ := signIn(t, s, "u2", "")
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+
+	if err := s.RevokeSession(ctx, other.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking another user's session = %v, want %v", err, ErrSessionNotFound)
+	}
+	if err := s.RevokeSession(ctx, s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeSession(ctx, s1.ID); err != nil {
+		t.Errorf("revoking a revoked session = %v", err)
+	}
+	if sessions, _ := s.ListSessions(ctx); len(sessions) != 1 || sessions[0].ID != s2.ID {
+		t.Errorf("ListSessions() = %+v, want only %s", sessions, s2.ID)
+	}
+
+	if err := s.RevokeSessions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := s.ListSessions(ctx); len(sessions) != 0 {
+		t.Errorf("ListSessions() after RevokeSessions() = %+v", sessions)
+	}
+	if _, _, err := s.RefreshSession(context.Background(), token, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refreshing a revoked session = %v", err)
+	}
+	if err := s.CheckSession(context.Background(), other.ID); err != nil {
+		t.Errorf("other user's session revoked too: %v", err)
+	}
+	admin := ContextWithCaller(context.Background(), Caller{ID: "u3", Roles: []string{model.RoleAdmin}})
+	if err := s.RevokeSession(admin, other.ID); err != nil {
+		t.Errorf("revoking by an admin = %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"phone", 10, "phone"},
+		{"phone", 3, "pho"},
+		{"héllo", 2, "h"},
+		{"日本", 4, "日"},
+	} {
+		if got := truncate(tc.s, tc.n); got != tc.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
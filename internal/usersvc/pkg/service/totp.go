@@ -0,0 +1,125 @@
+package userservice
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, those of RFC 6238 that authenticator apps support: six
+// digit codes derived with HMAC-SHA1 from 160-bit secrets every 30 seconds.
+const (
+	totpPeriod     = 30 * time.Second
+	totpDigits     = 6
+	totpModulo     = 1_000_000 // 10^totpDigits
+	totpSecretSize = 20
+	// totpSkew is how many time steps a code may be off by either way, to
+	// allow for clock drift and for the time it takes to type it.
+	totpSkew = 1
+)
+
+// totpEncoding encodes TOTP secrets as authenticator apps expect them.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpStep returns the time step t falls in.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode returns the code of secret for time step step.
+func totpCode(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	_ = binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%totpModulo)
+}
+
+// validateTOTP returns the time step code was generated for if it is a code
+// of secret within totpSkew steps of t.
+func validateTOTP(secret, code string, t time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || !isTOTPCode(code) {
+		return 0, false
+	}
+	now := totpStep(t)
+	for s := now - totpSkew; s <= now+totpSkew; s++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// isTOTPCode reports whether code looks like a TOTP code rather than a
+// recovery code.
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// totpURI returns the otpauth URI of secret, which authenticator apps read
+// from a QR code, labelled with issuer and account.
+func totpURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// recoveryEncoding spells recovery codes in lowercase, which is easier to
+// read back.
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a recovery code such as "abcd-efgh-ijkl-mnop".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := recoveryEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// normalizeRecoveryCode undoes what users tend to do to a recovery code when
+// typing it in: changing its case and leaving out or adding separators.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
@@ -0,0 +1,87 @@
+package userservice
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCode checks the SHA-1 test vectors of RFC 6238, appendix B, of
+// which codes are the last six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		if got := totpCode(secret, totpStep(time.Unix(tc.unix, 0))); got != tc.want {
+			t.Errorf("code at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0) // step 37037037, code 050471
+	for _, tc := range []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", secret, "050471", 37037037, true},
+		{"previous step", secret, "081804", 37037036, true},
+		{"next step", secret, totpCode([]byte("12345678901234567890"), 37037038), 37037038, true},
+		{"two steps ago", secret, totpCode([]byte("12345678901234567890"), 37037035), 0, false},
+		{"wrong code", secret, "123456", 0, false},
+		{"too short", secret, "05047", 0, false},
+		{"not digits", secret, "05047a", 0, false},
+		{"other secret", totpEncoding.EncodeToString([]byte("09876543210987654321")), "050471", 0, false},
+		{"malformed secret", "not base32!", "050471", 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := validateTOTP(tc.secret, tc.code, now)
+			if step != tc.wantStep || ok != tc.wantOK {
+				t.Errorf("validateTOTP() = %d, %t, want %d, %t", step, ok, tc.wantStep, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := totpURI("gommunigate", "jane@example.com", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/gommunigate:jane@example.com?algorithm=SHA1&digits=6&issuer=gommunigate&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("totpURI() = %s, want %s", got, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		code, err := newRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 19 || strings.Count(code, "-") != 3 || isTOTPCode(code) {
+			t.Fatalf("malformed recovery code %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("recovery code %q given twice", code)
+		}
+		seen[code] = true
+		for _, typed := range []string{code, strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), strings.ReplaceAll(code, "-", " ")} {
+			if got := normalizeRecoveryCode(typed); got != code {
+				t.Fatalf("normalizeRecoveryCode(%q) = %q, want %q", typed, got, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"time"
+)
+
+var (
+	// ErrTwoFactorEnabled is returned by EnrollTOTP for a user whose second
+	// factor is already enabled.
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+	// ErrTwoFactorNotEnabled is returned for a user without a second factor,
+	// or by ConfirmTOTP for one who didn't start enrolling.
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrTwoFactorRequired is returned when no code was given for a user
+	// whose second factor is enabled.
+	ErrTwoFactorRequired = errors.New("two-factor code required")
+	// ErrInvalidTwoFactorCode is returned for a TOTP code that is wrong,
+	// expired or already used, and for an unknown or used recovery code.
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	// ErrTwoFactorLocked is returned for a user whose second factor is
+	// locked after too many invalid codes, whatever the code.
+	ErrTwoFactorLocked = errors.New("two-factor authentication temporarily locked")
+)
+
+// recoveryCodeCount is the number of recovery codes given on enabling a
+// second factor.
+const recoveryCodeCount = 10
+
+// TwoFactorService manages the TOTP second factor of users, which the gateway
+// asks for on top of their token before sensitive calls. The caller is taken
+// from the context and must have a profile. Codes are either TOTP codes or
+// recovery codes, each of which can be used once.
+type TwoFactorService interface {
+	GetTwoFactor(ctx context.Context) (model.TwoFactor, error)
+	// EnrollTOTP starts enrolling the caller with a new secret, replacing
+	// the one of an unfinished enrollment, and returns it with its otpauth
+	// URI for an authenticator app.
+	EnrollTOTP(ctx context.Context) (secret, uri string, err error)
+	// ConfirmTOTP enables the secret being enrolled if code is a TOTP code of
+	// it, and returns new recovery codes. They are only returned here.
+	ConfirmTOTP(ctx context.Context, code string) (recoveryCodes []string, err error)
+	// VerifyTOTP checks and uses up a code of the caller's second factor.
+	VerifyTOTP(ctx context.Context, code string) error
+	// DisableTOTP disables the caller's second factor, once code was checked
+	// as by VerifyTOTP.
+	DisableTOTP(ctx context.Context, code string) error
+}
+
+// TwoFactorRepository stores the second factor of users on their profile,
+// along with the SHA-256 hash of their recovery codes. Methods return
+// ErrUserNotFound for a user without a profile.
+type TwoFactorRepository interface {
+	// GetTwoFactor returns a zero TwoFactor for a user who never enrolled.
+	GetTwoFactor(ctx context.Context, uid string) (model.TwoFactor, error)
+	// SetTOTPSecret saves the secret uid is enrolling, unless their second
+	// factor is enabled, in which case it returns ErrTwoFactorEnabled.
+	SetTOTPSecret(ctx context.Context, uid, secret string) error
+	// EnableTwoFactor enables the second factor of uid if secret is still
+	// the one being enrolled, using up time step step. Otherwise it returns
+	// ErrInvalidTwoFactorCode.
+	EnableTwoFactor(ctx context.Context, uid, secret string, step int64, recoveryHashes []string, at time.Time) error
+	// UseTOTPStep records that a code of time step step was used, unless one
+	// of it or a later step was, in which case it returns
+	// ErrInvalidTwoFactorCode.
+	UseTOTPStep(ctx context.Context, uid string, step int64) error
+	// UseRecoveryCode removes the recovery code hashing to hash, or returns
+	// ErrInvalidTwoFactorCode if uid has none such.
+	UseRecoveryCode(ctx context.Context, uid, hash string) error
+	// RecordTwoFactorFailure counts an invalid code given for uid and
+	// returns the number of them in a row.
+	RecordTwoFactorFailure(ctx context.Context, uid string) (int, error)
+	ResetTwoFactorFailures(ctx context.Context, uid string) error
+	// LockTwoFactor locks the second factor of uid until until, and resets
+	// its count of invalid codes.
+	LockTwoFactor(ctx context.Context, uid string, until time.Time) error
+	DisableTwoFactor(ctx context.Context, uid string) error
+}
+
+// TwoFactorLockout locks a second factor for Duration once MaxAttempts codes
+// in a row were invalid. A million TOTP codes are otherwise quickly guessed.
+type TwoFactorLockout struct {
+	MaxAttempts int
+	Duration    time.Duration
+}
+
+// NewTwoFactorService returns a TwoFactorService storing second factors in r,
+// locked as l says. Authenticator apps show them under issuer and the email,
+// user name or ID of the user, read from users.
+func NewTwoFactorService(r TwoFactorRepository, users Repository, issuer string, l TwoFactorLockout) TwoFactorService {
+	if r == nil || users == nil {
+		panic("invalid repository")
+	}
+	return twoFactorService{repo: r, users: users, issuer: issuer, lockout: l}
+}
+
+type twoFactorService struct {
+	repo    TwoFactorRepository
+	users   Repository
+	issuer  string
+	lockout TwoFactorLockout
+}
+
+func (s twoFactorService) GetTwoFactor(ctx context.Context) (model.TwoFactor, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return model.TwoFactor{}, ErrUnauthenticated
+	}
+	return s.repo.GetTwoFactor(ctx, caller.ID)
+}
+
+func (s twoFactorService) EnrollTOTP(ctx context.Context) (string, string, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return "", "", ErrUnauthenticated
+	}
+	u, err := s.users.GetUser(ctx, caller.ID)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.repo.SetTOTPSecret(ctx, caller.ID, secret); err != nil {
+		return "", "", err
+	}
+	account := caller.ID
+	switch {
+	case u.Email != nil && *u.Email != "":
+		account = *u.Email
+	case u.UserName != nil && *u.UserName != "":
+		account = *u.UserName
+	}
+	return secret, totpURI(s.issuer, account, secret), nil
+}
+
+func (s twoFactorService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	tf, err := s.repo.GetTwoFactor(ctx, caller.ID)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !tf.Pending() {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	step, ok := validateTOTP(tf.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		c, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+		hashes = append(hashes, hashToken(c))
+	}
+	if err := s.repo.EnableTwoFactor(ctx, caller.ID, tf.Secret, step, hashes, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s twoFactorService) VerifyTOTP(ctx context.Context, code string) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	return s.verify(ctx, caller.ID, code)
+}
+
+func (s twoFactorService) DisableTOTP(ctx context.Context, code string) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if err := s.verify(ctx, caller.ID, code); err != nil {
+		return err
+	}
+	return s.repo.DisableTwoFactor(ctx, caller.ID)
+}
+
+// verify checks and uses up code, a TOTP or recovery code of uid. Invalid
+// codes are counted towards locking the second factor of uid.
+func (s twoFactorService) verify(ctx context.Context, uid, code string) error {
+	tf, err := s.repo.GetTwoFactor(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if code == "" {
+		return ErrTwoFactorRequired
+	}
+	now := time.Now()
+	if tf.Locked(now) {
+		return ErrTwoFactorLocked
+	}
+	if isTOTPCode(code) {
+		step, ok := validateTOTP(tf.Secret, code, now)
+		if !ok || step <= tf.LastStep {
+			err = ErrInvalidTwoFactorCode
+		} else {
+			err = s.repo.UseTOTPStep(ctx, uid, step)
+		}
+	} else {
+		err = s.repo.UseRecoveryCode(ctx, uid, hashToken(normalizeRecoveryCode(code)))
+	}
+	switch {
+	case err == nil:
+		if tf.FailedAttempts > 0 {
+			return s.repo.ResetTwoFactorFailures(ctx, uid)
+		}
+		return nil
+	case !errors.Is(err, ErrInvalidTwoFactorCode):
+		return err
+	}
+	failures, err := s.repo.RecordTwoFactorFailure(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if s.lockout.MaxAttempts > 0 && failures >= s.lockout.MaxAttempts {
+		if err := s.repo.LockTwoFactor(ctx, uid, now.Add(s.lockout.Duration)); err != nil {
+			return err
+		}
+	}
+	return ErrInvalidTwoFactorCode
+}
@@ -0,0 +1,270 @@
+package userservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memTwoFactorRepository is a TwoFactorRepository keeping second factors in
+// memory, for tests.
+type memTwoFactorRepository struct {
+	mtx      sync.Mutex
+	factors  map[string]model.TwoFactor
+	recovery map[string][]string // recovery code hashes by user
+}
+
+func newMemTwoFactorRepository() *memTwoFactorRepository {
+	return &memTwoFactorRepository{factors: map[string]model.TwoFactor{}, recovery: map[string][]string{}}
+}
+
+func (r *memTwoFactorRepository) GetTwoFactor(_ context.Context, uid string) (model.TwoFactor, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	tf := r.factors[uid]
+	tf.RecoveryCodes = len(r.recovery[uid])
+	return tf, nil
+}
+
+func (r *memTwoFactorRepository) SetTOTPSecret(_ context.Context, uid, secret string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.factors[uid].Enabled {
+		return ErrTwoFactorEnabled
+	}
+	r.factors[uid] = model.TwoFactor{Secret: secret}
+	return nil
+}
+
+func (r *memTwoFactorRepository) EnableTwoFactor(_ context.Context, uid, secret string, step int64, recoveryHashes []string, at time.Time) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	tf := r.factors[uid]
+	if tf.Enabled || tf.Secret != secret {
+		return ErrInvalidTwoFactorCode
+	}
+	tf.Enabled, tf.EnabledAt, tf.LastStep = true, at, step
+	r.factors[uid], r.recovery[uid] = tf, recoveryHashes
+	return nil
+}
+
+// update applies f to the enabled second factor of uid.
+func (r *memTwoFactorRepository) update(uid string, f func(*model.TwoFactor) error) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	tf := r.factors[uid]
+	if !tf.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := f(&tf); err != nil {
+		return err
+	}
+	r.factors[uid] = tf
+	return nil
+}
+
+func (r *memTwoFactorRepository) UseTOTPStep(_ context.Context, uid string, step int64) error {
+	return r.update(uid, func(tf *model.TwoFactor) error {
+		if step <= tf.LastStep {
+			return ErrInvalidTwoFactorCode
+		}
+		tf.LastStep = step
+		return nil
+	})
+}
+
+func (r *memTwoFactorRepository) UseRecoveryCode(_ context.Context, uid, hash string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	i := slices.Index(r.recovery[uid], hash)
+	if !r.factors[uid].Enabled || i < 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	r.recovery[uid] = slices.Delete(r.recovery[uid], i, i+1)
+	return nil
+}
+
+func (r *memTwoFactorRepository) RecordTwoFactorFailure(_ context.Context, uid string) (int, error) {
+	var failures int
+	err := r.update(uid, func(tf *model.TwoFactor) error {
+		tf.FailedAttempts++
+		failures = tf.FailedAttempts
+		return nil
+	})
+	return failures, err
+}
+
+func (r *memTwoFactorRepository) ResetTwoFactorFailures(_ context.Context, uid string) error {
+	return r.update(uid, func(tf *model.TwoFactor) error {
+		tf.FailedAttempts = 0
+		return nil
+	})
+}
+
+func (r *memTwoFactorRepository) LockTwoFactor(_ context.Context, uid string, until time.Time) error {
+	return r.update(uid, func(tf *model.TwoFactor) error {
+		tf.FailedAttempts, tf.LockedUntil = 0, until
+		return nil
+	})
+}
+
+func (r *memTwoFactorRepository) DisableTwoFactor(_ context.Context, uid string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.factors, uid)
+	delete(r.recovery, uid)
+	return nil
+}
+
+// enableTOTP enrolls u1 in s, returning their secret and recovery codes.
+func enableTOTP(t *testing.T, s TwoFactorService) (secret []byte, recoveryCodes []string) {
+	t.Helper()
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	encoded, uri, err := s.EnrollTOTP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "otpauth://totp/gommunigate:jane@example.com?"; uri[:len(want)] != want {
+		t.Errorf("URI = %s, want prefix %s", uri, want)
+	}
+	secret, err = totpEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConfirmTOTP(ctx, wrongCode(secret)); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("ConfirmTOTP() with a wrong code = %v", err)
+	}
+	recoveryCodes, err = s.ConfirmTOTP(ctx, totpCode(secret, totpStep(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Errorf("%d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	return secret, recoveryCodes
+}
+
+// wrongCode returns a TOTP code that isn't one of secret at the moment.
+func wrongCode(secret []byte) string {
+	now := totpStep(time.Now())
+	for n := 0; ; n++ {
+		code := fmt.Sprintf("%06d", n)
+		if !slices.ContainsFunc([]int64{now - 2, now - 1, now, now + 1, now + 2}, func(step int64) bool {
+			return totpCode(secret, step) == code
+		}) {
+			return code
+		}
+	}
+}
+
+func newTestTwoFactorService(repo TwoFactorRepository) TwoFactorService {
+	users := newMemRepository(model.User{UUID: ptr("u1"), Email: ptr("jane@example.com")})
+	return NewTwoFactorService(repo, users, "gommunigate", TwoFactorLockout{MaxAttempts: 3, Duration: time.Minute})
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	s := newTestTwoFactorService(newMemTwoFactorRepository())
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	if err := s.VerifyTOTP(ctx, "123456"); !errors.Is(err, ErrTwoFactorNotEnabled) {
+		t.Errorf("VerifyTOTP() before enrolling = %v", err)
+	}
+	secret, recoveryCodes := enableTOTP(t, s)
+	if _, _, err := s.EnrollTOTP(ctx); !errors.Is(err, ErrTwoFactorEnabled) {
+		t.Errorf("EnrollTOTP() once enabled = %v", err)
+	}
+
+	now := totpStep(time.Now())
+	for _, tc := range []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{"no code", "", ErrTwoFactorRequired},
+		{"code used to confirm", totpCode(secret, now), ErrInvalidTwoFactorCode},
+		{"next code", totpCode(secret, now+1), nil},
+		{"reused code", totpCode(secret, now+1), ErrInvalidTwoFactorCode},
+		{"recovery code", recoveryCodes[0], nil},
+		{"used recovery code", recoveryCodes[0], ErrInvalidTwoFactorCode},
+		{"retyped recovery code", "  " + recoveryCodes[1][:9] + recoveryCodes[1][10:], nil},
+		{"unknown recovery code", "aaaa-bbbb-cccc-dddd", ErrInvalidTwoFactorCode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.VerifyTOTP(ctx, tc.code); !errors.Is(err, tc.wantErr) {
+				t.Errorf("VerifyTOTP() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+	if tf, _ := s.GetTwoFactor(ctx); tf.RecoveryCodes != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", tf.RecoveryCodes, recoveryCodeCount-2)
+	}
+
+	if err := s.DisableTOTP(ctx, "aaaa-bbbb-cccc-dddd"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("DisableTOTP() with a wrong code = %v", err)
+	}
+	if err := s.DisableTOTP(ctx, recoveryCodes[2]); err != nil {
+		t.Fatal(err)
+	}
+	if tf, _ := s.GetTwoFactor(ctx); tf.Enabled || tf.Pending() {
+		t.Errorf("second factor %+v left after disabling it", tf)
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	repo := newMemTwoFactorRepository()
+	s := newTestTwoFactorService(repo)
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	secret, recoveryCodes := enableTOTP(t, s)
+	wrong := wrongCode(secret)
+
+	// A valid code resets the count.
+	s.VerifyTOTP(ctx, "aaaa-bbbb-cccc-dddd")
+	s.VerifyTOTP(ctx, wrong)
+	if err := s.VerifyTOTP(ctx, recoveryCodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if tf, _ := repo.GetTwoFactor(ctx, "u1"); tf.FailedAttempts != 0 {
+		t.Errorf("%d failed attempts after a valid code", tf.FailedAttempts)
+	}
+
+	// TOTP and recovery codes count alike.
+	for i, code := range []string{wrong, "aaaa-bbbb-cccc-dddd", wrong} {
+		if err := s.VerifyTOTP(ctx, code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("invalid code %d = %v", i+1, err)
+		}
+	}
+	for _, code := range []string{recoveryCodes[1], wrong} {
+		if err := s.VerifyTOTP(ctx, code); !errors.Is(err, ErrTwoFactorLocked) {
+			t.Errorf("VerifyTOTP(%q) once locked = %v, want %v", code, err, ErrTwoFactorLocked)
+		}
+	}
+	if err := s.DisableTOTP(ctx, recoveryCodes[1]); !errors.Is(err, ErrTwoFactorLocked) {
+		t.Errorf("DisableTOTP() once locked = %v, want %v", err, ErrTwoFactorLocked)
+	}
+	if tf, _ := s.GetTwoFactor(ctx); tf.RecoveryCodes != recoveryCodeCount-1 {
+		t.Errorf("a recovery code was used up while locked")
+	}
+
+	// The lock expires.
+	repo.LockTwoFactor(ctx, "u1", time.Now().Add(-time.Second))
+	if err := s.VerifyTOTP(ctx, recoveryCodes[1]); err != nil {
+		t.Errorf("VerifyTOTP() after the lock expired = %v", err)
+	}
+}
+
+func TestTwoFactorWithoutLockout(t *testing.T) {
+	users := newMemRepository(model.User{UUID: ptr("u1"), Email: ptr("jane@example.com")})
+	s := NewTwoFactorService(newMemTwoFactorRepository(), users, "gommunigate", TwoFactorLockout{})
+	ctx := ContextWithCaller(context.Background(), Caller{ID: "u1"})
+	secret, recoveryCodes := enableTOTP(t, s)
+	for range 10 {
+		s.VerifyTOTP(ctx, wrongCode(secret))
+	}
+	if err := s.VerifyTOTP(ctx, recoveryCodes[0]); err != nil {
+		t.Errorf("VerifyTOTP() = %v without a lockout", err)
+	}
+}
@@ -0,0 +1,49 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// AccessLogInterceptor logs one line per gRPC call with its outcome. A call
+// without a request ID from the gateway is given one, which reaches the
+// service through grpcMetadataToContext like a forwarded one.
+func AccessLogInterceptor(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		begin := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+		requestID := firstMetadataValue(md, requestIDMetadataKey)
+		if requestID == "" {
+			requestID = uuid.NewString()
+			md = md.Copy()
+			md.Set(requestIDMetadataKey, requestID)
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+
+		resp, err := handler(ctx, req)
+
+		keyvals := []interface{}{
+			"method", info.FullMethod,
+			"status", status.Code(err),
+			"took", time.Since(begin),
+			"user", firstMetadataValue(md, actorMetadataKey),
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			keyvals = append(keyvals, "peer", p.Addr.String())
+		}
+		// Service errors travel in the reply rather than as gRPC errors.
+		if r, ok := resp.(interface{ GetErr() string }); ok && r.GetErr() != "" {
+			keyvals = append(keyvals, "err", r.GetErr())
+		} else if err != nil {
+			keyvals = append(keyvals, "err", err)
+		}
+		log.With(logger, "request_id", requestID).Log(keyvals...)
+		return resp, err
+	}
+}
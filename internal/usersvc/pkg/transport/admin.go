@@ -0,0 +1,295 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+	"time"
+)
+
+// decodeGRPCListUsersRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC list users request to a user-domain request. Primarily useful in a server.
+func decodeGRPCListUsersRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListUsersRequest)
+	return userendpoint.ListUsersRequest{
+		Role:          req.Role,
+		SuspendedOnly: req.SuspendedOnly,
+		PageToken:     req.PageToken,
+		PageSize:      int(req.PageSize),
+	}, nil
+}
+
+// encodeGRPCListUsersResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC list users reply. Primarily useful in a server.
+func encodeGRPCListUsersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ListUsersResponse)
+	users := make([]*pb.UserRecord, 0, len(resp.Users))
+	for _, u := range resp.Users {
+		users = append(users, userToRecord(u))
+	}
+	return &pb.ListUsersReply{
+		Users:         users,
+		NextPageToken: resp.NextPageToken,
+		Err:           err2str(resp.Err),
+	}, nil
+}
+
+// encodeGRPCListUsersRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC list users request. Primarily useful in a client.
+func encodeGRPCListUsersRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ListUsersRequest)
+	return &pb.ListUsersRequest{
+		Role:          req.Role,
+		SuspendedOnly: req.SuspendedOnly,
+		PageToken:     req.PageToken,
+		PageSize:      int32(req.PageSize),
+	}, nil
+}
+
+// decodeGRPCListUsersResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCListUsersResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ListUsersReply)
+	users := make([]model.User, 0, len(reply.Users))
+	for _, r := range reply.Users {
+		users = append(users, recordToUser(r))
+	}
+	return userendpoint.ListUsersResponse{
+		Users:         users,
+		NextPageToken: reply.NextPageToken,
+		Err:           str2err(reply.Err),
+	}, nil
+}
+
+// decodeGRPCGetUserRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC get user request to a user-domain request. Primarily useful in a server.
+func decodeGRPCGetUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetUserRequest)
+	return userendpoint.GetUserRequest{UUID: req.Uuid}, nil
+}
+
+// encodeGRPCGetUserResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC get user reply. Primarily useful in a server.
+func encodeGRPCGetUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.GetUserResponse)
+	reply := &pb.GetUserReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.User = userToRecord(resp.User)
+	}
+	return reply, nil
+}
+
+// encodeGRPCGetUserRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC get user request. Primarily useful in a client.
+func encodeGRPCGetUserRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.GetUserRequest)
+	return &pb.GetUserRequest{Uuid: req.UUID}, nil
+}
+
+// decodeGRPCGetUserResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCGetUserResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.GetUserReply)
+	resp := userendpoint.GetUserResponse{Err: str2err(reply.Err)}
+	if reply.User != nil {
+		resp.User = recordToUser(reply.User)
+	}
+	return resp, nil
+}
+
+// decodeGRPCUpdateUserRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC update user request to a user-domain request. Primarily useful in a server.
+func decodeGRPCUpdateUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UpdateUserRequest)
+	r := userendpoint.UpdateUserRequest{
+		UUID:           stringPtrOrNil(req.Uuid),
+		Email:          stringPtrOrNil(req.Email),
+		PhoneNumber:    stringPtrOrNil(req.Phone),
+		UserName:       stringPtrOrNil(req.Name),
+		ProfilePicture: stringPtrOrNil(req.Profile),
+		Bio:            stringPtrOrNil(req.Bio),
+		Reason:         req.Reason,
+	}
+	if req.Roles != nil {
+		r.Roles = append([]string{}, req.Roles.Roles...)
+	}
+	return r, nil
+}
+
+// encodeGRPCUpdateUserResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC update user reply. Primarily useful in a server.
+func encodeGRPCUpdateUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.UpdateUserResponse)
+	return &pb.UpdateUserReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCUpdateUserRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC update user request. Primarily useful in a client.
+func encodeGRPCUpdateUserRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.UpdateUserRequest)
+	r := &pb.UpdateUserRequest{
+		Uuid:    stringSafeDeref(req.UUID),
+		Email:   stringSafeDeref(req.Email),
+		Phone:   stringSafeDeref(req.PhoneNumber),
+		Name:    stringSafeDeref(req.UserName),
+		Profile: stringSafeDeref(req.ProfilePicture),
+		Bio:     stringSafeDeref(req.Bio),
+		Reason:  req.Reason,
+	}
+	if req.Roles != nil {
+		r.Roles = &pb.Roles{Roles: req.Roles}
+	}
+	return r, nil
+}
+
+// decodeGRPCUpdateUserResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCUpdateUserResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.UpdateUserReply)
+	return userendpoint.UpdateUserResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCSuspendRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC suspend request to a user-domain request. Primarily useful in a server.
+func decodeGRPCSuspendRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SuspendRequest)
+	return userendpoint.SuspendRequest{UUID: req.Uuid, Reason: req.Reason, Until: timeFromUnixNano(req.Until)}, nil
+}
+
+// encodeGRPCSuspendResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC suspend reply. Primarily useful in a server.
+func encodeGRPCSuspendResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.SuspendResponse)
+	return &pb.SuspendReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCSuspendRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC suspend request. Primarily useful in a client.
+func encodeGRPCSuspendRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.SuspendRequest)
+	return &pb.SuspendRequest{Uuid: req.UUID, Reason: req.Reason, Until: unixNano(req.Until)}, nil
+}
+
+// decodeGRPCSuspendResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCSuspendResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.SuspendReply)
+	return userendpoint.SuspendResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCUnsuspendRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC unsuspend request to a user-domain request. Primarily useful in a server.
+func decodeGRPCUnsuspendRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UnsuspendRequest)
+	return userendpoint.UnsuspendRequest{UUID: req.Uuid, Reason: req.Reason}, nil
+}
+
+// encodeGRPCUnsuspendResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC unsuspend reply. Primarily useful in a server.
+func encodeGRPCUnsuspendResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.UnsuspendResponse)
+	return &pb.UnsuspendReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCUnsuspendRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC unsuspend request. Primarily useful in a client.
+func encodeGRPCUnsuspendRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.UnsuspendRequest)
+	return &pb.UnsuspendRequest{Uuid: req.UUID, Reason: req.Reason}, nil
+}
+
+// decodeGRPCUnsuspendResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCUnsuspendResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.UnsuspendReply)
+	return userendpoint.UnsuspendResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCForceDeleteRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC force delete request to a user-domain request. Primarily useful in a server.
+func decodeGRPCForceDeleteRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ForceDeleteRequest)
+	return userendpoint.ForceDeleteRequest{UUID: req.Uuid, Reason: req.Reason}, nil
+}
+
+// encodeGRPCForceDeleteResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC force delete reply. Primarily useful in a server.
+func encodeGRPCForceDeleteResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ForceDeleteResponse)
+	return &pb.ForceDeleteReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCForceDeleteRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC force delete request. Primarily useful in a client.
+func encodeGRPCForceDeleteRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ForceDeleteRequest)
+	return &pb.ForceDeleteRequest{Uuid: req.UUID, Reason: req.Reason}, nil
+}
+
+// decodeGRPCForceDeleteResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCForceDeleteResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ForceDeleteReply)
+	return userendpoint.ForceDeleteResponse{Err: str2err(reply.Err)}, nil
+}
+
+func userToRecord(u model.User) *pb.UserRecord {
+	r := &pb.UserRecord{
+		Uuid:         stringSafeDeref(u.UUID),
+		Email:        stringSafeDeref(u.Email),
+		Phone:        stringSafeDeref(u.PhoneNumber),
+		Name:         stringSafeDeref(u.UserName),
+		Profile:      stringSafeDeref(u.ProfilePicture),
+		Bio:          stringSafeDeref(u.Bio),
+		AuthProvider: stringSafeDeref(u.AuthProvider),
+		Roles:        u.Roles,
+	}
+	r.Suspension = suspensionToPB(u.Suspension)
+	return r
+}
+
+func recordToUser(r *pb.UserRecord) model.User {
+	u := model.User{
+		UUID:           stringPtrOrNil(r.Uuid),
+		Email:          stringPtrOrNil(r.Email),
+		PhoneNumber:    stringPtrOrNil(r.Phone),
+		UserName:       stringPtrOrNil(r.Name),
+		ProfilePicture: stringPtrOrNil(r.Profile),
+		Bio:            stringPtrOrNil(r.Bio),
+		AuthProvider:   stringPtrOrNil(r.AuthProvider),
+		Roles:          r.Roles,
+	}
+	u.Suspension = pbToSuspension(r.Suspension)
+	return u
+}
+
+func suspensionToPB(s *model.Suspension) *pb.Suspension {
+	if s == nil {
+		return nil
+	}
+	return &pb.Suspension{Reason: s.Reason, Actor: s.Actor, Since: s.Since.UnixNano(), Until: unixNano(s.Until)}
+}
+
+func pbToSuspension(s *pb.Suspension) *model.Suspension {
+	if s == nil {
+		return nil
+	}
+	return &model.Suspension{Reason: s.Reason, Actor: s.Actor, Since: time.Unix(0, s.Since).UTC(), Until: timeFromUnixNano(s.Until)}
+}
+
+// unixNano returns t in Unix nanoseconds, or 0 if t is zero.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// timeFromUnixNano is the inverse of unixNano.
+func timeFromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
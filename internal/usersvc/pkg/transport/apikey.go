@@ -0,0 +1,182 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+)
+
+// decodeGRPCCreateAPIKeyRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC create API key request to a user-domain request. Primarily useful in a server.
+func decodeGRPCCreateAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateAPIKeyRequest)
+	return userendpoint.CreateAPIKeyRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: timeFromUnixNano(req.ExpiresAt),
+		Subject:   req.Subject,
+	}, nil
+}
+
+// encodeGRPCCreateAPIKeyResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC create API key reply. Primarily useful in a server.
+func encodeGRPCCreateAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.CreateAPIKeyResponse)
+	reply := &pb.CreateAPIKeyReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.ApiKey = apiKeyToPB(resp.APIKey)
+		reply.Key = resp.Key
+	}
+	return reply, nil
+}
+
+// encodeGRPCCreateAPIKeyRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC create API key request. Primarily useful in a client.
+func encodeGRPCCreateAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.CreateAPIKeyRequest)
+	return &pb.CreateAPIKeyRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: unixNano(req.ExpiresAt),
+		Subject:   req.Subject,
+	}, nil
+}
+
+// decodeGRPCCreateAPIKeyResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCCreateAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.CreateAPIKeyReply)
+	return userendpoint.CreateAPIKeyResponse{
+		APIKey: pbToAPIKey(reply.ApiKey),
+		Key:    reply.Key,
+		Err:    str2err(reply.Err),
+	}, nil
+}
+
+// decodeGRPCListAPIKeysRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC list API keys request to a user-domain request. Primarily useful in a server.
+func decodeGRPCListAPIKeysRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return userendpoint.ListAPIKeysRequest{}, nil
+}
+
+// encodeGRPCListAPIKeysResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC list API keys reply. Primarily useful in a server.
+func encodeGRPCListAPIKeysResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ListAPIKeysResponse)
+	keys := make([]*pb.APIKey, 0, len(resp.APIKeys))
+	for _, k := range resp.APIKeys {
+		keys = append(keys, apiKeyToPB(k))
+	}
+	return &pb.ListAPIKeysReply{ApiKeys: keys, Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCListAPIKeysRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC list API keys request. Primarily useful in a client.
+func encodeGRPCListAPIKeysRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.ListAPIKeysRequest{}, nil
+}
+
+// decodeGRPCListAPIKeysResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCListAPIKeysResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ListAPIKeysReply)
+	keys := make([]model.APIKey, 0, len(reply.ApiKeys))
+	for _, k := range reply.ApiKeys {
+		keys = append(keys, pbToAPIKey(k))
+	}
+	return userendpoint.ListAPIKeysResponse{APIKeys: keys, Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCRevokeAPIKeyRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC revoke API key request to a user-domain request. Primarily useful in a server.
+func decodeGRPCRevokeAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RevokeAPIKeyRequest)
+	return userendpoint.RevokeAPIKeyRequest{ID: req.Id}, nil
+}
+
+// encodeGRPCRevokeAPIKeyResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC revoke API key reply. Primarily useful in a server.
+func encodeGRPCRevokeAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.RevokeAPIKeyResponse)
+	return &pb.RevokeAPIKeyReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCRevokeAPIKeyRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC revoke API key request. Primarily useful in a client.
+func encodeGRPCRevokeAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.RevokeAPIKeyRequest)
+	return &pb.RevokeAPIKeyRequest{Id: req.ID}, nil
+}
+
+// decodeGRPCRevokeAPIKeyResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCRevokeAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RevokeAPIKeyReply)
+	return userendpoint.RevokeAPIKeyResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCVerifyAPIKeyRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC verify API key request to a user-domain request. Primarily useful in a server.
+func decodeGRPCVerifyAPIKeyRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.VerifyAPIKeyRequest)
+	return userendpoint.VerifyAPIKeyRequest{Key: req.Key}, nil
+}
+
+// encodeGRPCVerifyAPIKeyResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC verify API key reply. Primarily useful in a server.
+func encodeGRPCVerifyAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.VerifyAPIKeyResponse)
+	reply := &pb.VerifyAPIKeyReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.ApiKey = apiKeyToPB(resp.APIKey)
+	}
+	return reply, nil
+}
+
+// encodeGRPCVerifyAPIKeyRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC verify API key request. Primarily useful in a client.
+func encodeGRPCVerifyAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.VerifyAPIKeyRequest)
+	return &pb.VerifyAPIKeyRequest{Key: req.Key}, nil
+}
+
+// decodeGRPCVerifyAPIKeyResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCVerifyAPIKeyResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.VerifyAPIKeyReply)
+	return userendpoint.VerifyAPIKeyResponse{APIKey: pbToAPIKey(reply.ApiKey), Err: str2err(reply.Err)}, nil
+}
+
+func apiKeyToPB(k model.APIKey) *pb.APIKey {
+	return &pb.APIKey{
+		Id:         k.ID,
+		Prefix:     k.Prefix,
+		Name:       k.Name,
+		Subject:    k.Subject,
+		CreatedBy:  k.CreatedBy,
+		Scopes:     k.Scopes,
+		CreatedAt:  unixNano(k.CreatedAt),
+		ExpiresAt:  unixNano(k.ExpiresAt),
+		LastUsedAt: unixNano(k.LastUsedAt),
+		RevokedAt:  unixNano(k.RevokedAt),
+	}
+}
+
+func pbToAPIKey(k *pb.APIKey) model.APIKey {
+	if k == nil {
+		return model.APIKey{}
+	}
+	return model.APIKey{
+		ID:         k.Id,
+		Prefix:     k.Prefix,
+		Name:       k.Name,
+		Subject:    k.Subject,
+		CreatedBy:  k.CreatedBy,
+		Scopes:     k.Scopes,
+		CreatedAt:  timeFromUnixNano(k.CreatedAt),
+		ExpiresAt:  timeFromUnixNano(k.ExpiresAt),
+		LastUsedAt: timeFromUnixNano(k.LastUsedAt),
+		RevokedAt:  timeFromUnixNano(k.RevokedAt),
+	}
+}
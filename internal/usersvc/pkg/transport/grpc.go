@@ -9,8 +9,10 @@ import (
 	grpctransport "github.com/go-kit/kit/transport/grpc"
 	"github.com/yuisofull/gommunigate/internal/usersvc/pb"
 	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
 	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
 	"google.golang.org/grpc"
+	"time"
 )
 
 type grpcServer struct {
@@ -18,14 +20,47 @@ type grpcServer struct {
 	getProfile    grpctransport.Handler
 	updateProfile grpctransport.Handler
 	deleteProfile grpctransport.Handler
+	getSuspension grpctransport.Handler
+
+	getAccountStatus grpctransport.Handler
+
+	listAuditEntries grpctransport.Handler
+
+	listUsers   grpctransport.Handler
+	getUser     grpctransport.Handler
+	updateUser  grpctransport.Handler
+	suspend     grpctransport.Handler
+	unsuspend   grpctransport.Handler
+	forceDelete grpctransport.Handler
+
+	createAPIKey grpctransport.Handler
+	listAPIKeys  grpctransport.Handler
+	revokeAPIKey grpctransport.Handler
+	verifyAPIKey grpctransport.Handler
+
+	createSession  grpctransport.Handler
+	refreshSession grpctransport.Handler
+	listSessions   grpctransport.Handler
+	revokeSession  grpctransport.Handler
+	revokeSessions grpctransport.Handler
+	checkSession   grpctransport.Handler
+
+	getTwoFactor grpctransport.Handler
+	enrollTOTP   grpctransport.Handler
+	confirmTOTP  grpctransport.Handler
+	verifyTOTP   grpctransport.Handler
+	disableTOTP  grpctransport.Handler
 	pb.UnimplementedUserServer
 }
 
 // NewGRPCServer makes a set of endpoints available as a gRPC UserServer.
-func NewGRPCServer(endpoints userendpoint.Set, logger log.Logger) pb.UserServer {
-	options := []grpctransport.ServerOption{
+// Additional options, such as an IdentityVerifier's VerifyCaller, apply to
+// every method.
+func NewGRPCServer(endpoints userendpoint.Set, logger log.Logger, extra ...grpctransport.ServerOption) pb.UserServer {
+	options := append([]grpctransport.ServerOption{
 		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-	}
+		grpctransport.ServerBefore(grpcMetadataToContext),
+	}, extra...)
 	return &grpcServer{
 		createProfile: grpctransport.NewServer(
 			endpoints.CreateProfileEndpoint,
@@ -51,6 +86,150 @@ func NewGRPCServer(endpoints userendpoint.Set, logger log.Logger) pb.UserServer
 			encodeGRPCDeleteResponse,
 			options...,
 		),
+		getSuspension: grpctransport.NewServer(
+			endpoints.GetSuspensionEndpoint,
+			decodeGRPCGetSuspensionRequest,
+			encodeGRPCGetSuspensionResponse,
+			options...,
+		),
+		getAccountStatus: grpctransport.NewServer(
+			endpoints.GetAccountStatusEndpoint,
+			decodeGRPCGetAccountStatusRequest,
+			encodeGRPCGetAccountStatusResponse,
+			options...,
+		),
+		listAuditEntries: grpctransport.NewServer(
+			endpoints.ListAuditEntriesEndpoint,
+			decodeGRPCListAuditEntriesRequest,
+			encodeGRPCListAuditEntriesResponse,
+			options...,
+		),
+		listUsers: grpctransport.NewServer(
+			endpoints.ListUsersEndpoint,
+			decodeGRPCListUsersRequest,
+			encodeGRPCListUsersResponse,
+			options...,
+		),
+		getUser: grpctransport.NewServer(
+			endpoints.GetUserEndpoint,
+			decodeGRPCGetUserRequest,
+			encodeGRPCGetUserResponse,
+			options...,
+		),
+		updateUser: grpctransport.NewServer(
+			endpoints.UpdateUserEndpoint,
+			decodeGRPCUpdateUserRequest,
+			encodeGRPCUpdateUserResponse,
+			options...,
+		),
+		suspend: grpctransport.NewServer(
+			endpoints.SuspendEndpoint,
+			decodeGRPCSuspendRequest,
+			encodeGRPCSuspendResponse,
+			options...,
+		),
+		unsuspend: grpctransport.NewServer(
+			endpoints.UnsuspendEndpoint,
+			decodeGRPCUnsuspendRequest,
+			encodeGRPCUnsuspendResponse,
+			options...,
+		),
+		forceDelete: grpctransport.NewServer(
+			endpoints.ForceDeleteEndpoint,
+			decodeGRPCForceDeleteRequest,
+			encodeGRPCForceDeleteResponse,
+			options...,
+		),
+		createAPIKey: grpctransport.NewServer(
+			endpoints.CreateAPIKeyEndpoint,
+			decodeGRPCCreateAPIKeyRequest,
+			encodeGRPCCreateAPIKeyResponse,
+			options...,
+		),
+		listAPIKeys: grpctransport.NewServer(
+			endpoints.ListAPIKeysEndpoint,
+			decodeGRPCListAPIKeysRequest,
+			encodeGRPCListAPIKeysResponse,
+			options...,
+		),
+		revokeAPIKey: grpctransport.NewServer(
+			endpoints.RevokeAPIKeyEndpoint,
+			decodeGRPCRevokeAPIKeyRequest,
+			encodeGRPCRevokeAPIKeyResponse,
+			options...,
+		),
+		verifyAPIKey: grpctransport.NewServer(
+			endpoints.VerifyAPIKeyEndpoint,
+			decodeGRPCVerifyAPIKeyRequest,
+			encodeGRPCVerifyAPIKeyResponse,
+			options...,
+		),
+		createSession: grpctransport.NewServer(
+			endpoints.CreateSessionEndpoint,
+			decodeGRPCCreateSessionRequest,
+			encodeGRPCCreateSessionResponse,
+			options...,
+		),
+		refreshSession: grpctransport.NewServer(
+			endpoints.RefreshSessionEndpoint,
+			decodeGRPCRefreshSessionRequest,
+			encodeGRPCRefreshSessionResponse,
+			options...,
+		),
+		listSessions: grpctransport.NewServer(
+			endpoints.ListSessionsEndpoint,
+			decodeGRPCListSessionsRequest,
+			encodeGRPCListSessionsResponse,
+			options...,
+		),
+		revokeSession: grpctransport.NewServer(
+			endpoints.RevokeSessionEndpoint,
+			decodeGRPCRevokeSessionRequest,
+			encodeGRPCRevokeSessionResponse,
+			options...,
+		),
+		revokeSessions: grpctransport.NewServer(
+			endpoints.RevokeSessionsEndpoint,
+			decodeGRPCRevokeSessionsRequest,
+			encodeGRPCRevokeSessionsResponse,
+			options...,
+		),
+		checkSession: grpctransport.NewServer(
+			endpoints.CheckSessionEndpoint,
+			decodeGRPCCheckSessionRequest,
+			encodeGRPCCheckSessionResponse,
+			options...,
+		),
+		getTwoFactor: grpctransport.NewServer(
+			endpoints.GetTwoFactorEndpoint,
+			decodeGRPCGetTwoFactorRequest,
+			encodeGRPCGetTwoFactorResponse,
+			options...,
+		),
+		enrollTOTP: grpctransport.NewServer(
+			endpoints.EnrollTOTPEndpoint,
+			decodeGRPCEnrollTOTPRequest,
+			encodeGRPCEnrollTOTPResponse,
+			options...,
+		),
+		confirmTOTP: grpctransport.NewServer(
+			endpoints.ConfirmTOTPEndpoint,
+			decodeGRPCConfirmTOTPRequest,
+			encodeGRPCConfirmTOTPResponse,
+			options...,
+		),
+		verifyTOTP: grpctransport.NewServer(
+			endpoints.VerifyTOTPEndpoint,
+			decodeGRPCVerifyTOTPRequest,
+			encodeGRPCVerifyTOTPResponse,
+			options...,
+		),
+		disableTOTP: grpctransport.NewServer(
+			endpoints.DisableTOTPEndpoint,
+			decodeGRPCDisableTOTPRequest,
+			encodeGRPCDisableTOTPResponse,
+			options...,
+		),
 	}
 }
 
@@ -86,7 +265,206 @@ func (g *grpcServer) Delete(ctx context.Context, request *pb.DeleteRequest) (*pb
 	return rep.(*pb.DeleteReply), nil
 }
 
-func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service {
+func (g *grpcServer) GetSuspension(ctx context.Context, request *pb.GetSuspensionRequest) (*pb.GetSuspensionReply, error) {
+	_, rep, err := g.getSuspension.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.GetSuspensionReply), nil
+}
+
+func (g *grpcServer) GetAccountStatus(ctx context.Context, request *pb.GetAccountStatusRequest) (*pb.GetAccountStatusReply, error) {
+	_, rep, err := g.getAccountStatus.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.GetAccountStatusReply), nil
+}
+
+func (g *grpcServer) ListAuditEntries(ctx context.Context, request *pb.ListAuditEntriesRequest) (*pb.ListAuditEntriesReply, error) {
+	_, rep, err := g.listAuditEntries.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListAuditEntriesReply), nil
+}
+
+func (g *grpcServer) ListUsers(ctx context.Context, request *pb.ListUsersRequest) (*pb.ListUsersReply, error) {
+	_, rep, err := g.listUsers.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListUsersReply), nil
+}
+
+func (g *grpcServer) GetUser(ctx context.Context, request *pb.GetUserRequest) (*pb.GetUserReply, error) {
+	_, rep, err := g.getUser.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.GetUserReply), nil
+}
+
+func (g *grpcServer) UpdateUser(ctx context.Context, request *pb.UpdateUserRequest) (*pb.UpdateUserReply, error) {
+	_, rep, err := g.updateUser.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.UpdateUserReply), nil
+}
+
+func (g *grpcServer) Suspend(ctx context.Context, request *pb.SuspendRequest) (*pb.SuspendReply, error) {
+	_, rep, err := g.suspend.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.SuspendReply), nil
+}
+
+func (g *grpcServer) Unsuspend(ctx context.Context, request *pb.UnsuspendRequest) (*pb.UnsuspendReply, error) {
+	_, rep, err := g.unsuspend.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.UnsuspendReply), nil
+}
+
+func (g *grpcServer) ForceDelete(ctx context.Context, request *pb.ForceDeleteRequest) (*pb.ForceDeleteReply, error) {
+	_, rep, err := g.forceDelete.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ForceDeleteReply), nil
+}
+
+func (g *grpcServer) CreateAPIKey(ctx context.Context, request *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyReply, error) {
+	_, rep, err := g.createAPIKey.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.CreateAPIKeyReply), nil
+}
+
+func (g *grpcServer) ListAPIKeys(ctx context.Context, request *pb.ListAPIKeysRequest) (*pb.ListAPIKeysReply, error) {
+	_, rep, err := g.listAPIKeys.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListAPIKeysReply), nil
+}
+
+func (g *grpcServer) RevokeAPIKey(ctx context.Context, request *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyReply, error) {
+	_, rep, err := g.revokeAPIKey.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RevokeAPIKeyReply), nil
+}
+
+func (g *grpcServer) VerifyAPIKey(ctx context.Context, request *pb.VerifyAPIKeyRequest) (*pb.VerifyAPIKeyReply, error) {
+	_, rep, err := g.verifyAPIKey.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.VerifyAPIKeyReply), nil
+}
+
+func (g *grpcServer) CreateSession(ctx context.Context, request *pb.CreateSessionRequest) (*pb.CreateSessionReply, error) {
+	_, rep, err := g.createSession.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.CreateSessionReply), nil
+}
+
+func (g *grpcServer) RefreshSession(ctx context.Context, request *pb.RefreshSessionRequest) (*pb.RefreshSessionReply, error) {
+	_, rep, err := g.refreshSession.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RefreshSessionReply), nil
+}
+
+func (g *grpcServer) ListSessions(ctx context.Context, request *pb.ListSessionsRequest) (*pb.ListSessionsReply, error) {
+	_, rep, err := g.listSessions.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListSessionsReply), nil
+}
+
+func (g *grpcServer) RevokeSession(ctx context.Context, request *pb.RevokeSessionRequest) (*pb.RevokeSessionReply, error) {
+	_, rep, err := g.revokeSession.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RevokeSessionReply), nil
+}
+
+func (g *grpcServer) RevokeSessions(ctx context.Context, request *pb.RevokeSessionsRequest) (*pb.RevokeSessionsReply, error) {
+	_, rep, err := g.revokeSessions.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RevokeSessionsReply), nil
+}
+
+func (g *grpcServer) CheckSession(ctx context.Context, request *pb.CheckSessionRequest) (*pb.CheckSessionReply, error) {
+	_, rep, err := g.checkSession.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.CheckSessionReply), nil
+}
+
+func (g *grpcServer) GetTwoFactor(ctx context.Context, request *pb.GetTwoFactorRequest) (*pb.GetTwoFactorReply, error) {
+	_, rep, err := g.getTwoFactor.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.GetTwoFactorReply), nil
+}
+
+func (g *grpcServer) EnrollTOTP(ctx context.Context, request *pb.EnrollTOTPRequest) (*pb.EnrollTOTPReply, error) {
+	_, rep, err := g.enrollTOTP.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.EnrollTOTPReply), nil
+}
+
+func (g *grpcServer) ConfirmTOTP(ctx context.Context, request *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPReply, error) {
+	_, rep, err := g.confirmTOTP.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ConfirmTOTPReply), nil
+}
+
+func (g *grpcServer) VerifyTOTP(ctx context.Context, request *pb.VerifyTOTPRequest) (*pb.VerifyTOTPReply, error) {
+	_, rep, err := g.verifyTOTP.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.VerifyTOTPReply), nil
+}
+
+func (g *grpcServer) DisableTOTP(ctx context.Context, request *pb.DisableTOTPRequest) (*pb.DisableTOTPReply, error) {
+	_, rep, err := g.disableTOTP.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.DisableTOTPReply), nil
+}
+
+// NewGRPCClient returns a Set calling usersvc over conn, which implements
+// Service, AuditLog, AdminService, APIKeyService, SessionService and
+// TwoFactorService. Additional options, such as an IdentitySigner's
+// SignCaller, apply to every method.
+func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger, extra ...grpctransport.ClientOption) userendpoint.Set {
+	options := append([]grpctransport.ClientOption{
+		grpctransport.ClientBefore(contextToGRPCMetadata),
+	}, extra...)
 	var createProfileEndpoint endpoint.Endpoint
 	{
 		createProfileEndpoint = grpctransport.NewClient(
@@ -96,6 +474,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service
 			encodeGRPCCreateRequest,
 			decodeGRPCCreateResponse,
 			pb.CreateReply{},
+			options...,
 		).Endpoint()
 	}
 	var getProfileEndpoint endpoint.Endpoint
@@ -107,6 +486,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service
 			encodeGRPCRetrieveRequest,
 			decodeGRPCRetrieveResponse,
 			pb.RetrieveReply{},
+			options...,
 		).Endpoint()
 	}
 	var updateProfileEndpoint endpoint.Endpoint
@@ -118,6 +498,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service
 			encodeGRPCUpdateRequest,
 			decodeGRPCUpdateResponse,
 			pb.UpdateReply{},
+			options...,
 		).Endpoint()
 	}
 	var deleteProfileEndpoint endpoint.Endpoint
@@ -129,13 +510,330 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service
 			encodeGRPCDeleteRequest,
 			decodeGRPCDeleteResponse,
 			pb.DeleteReply{},
+			options...,
+		).Endpoint()
+	}
+	var getSuspensionEndpoint endpoint.Endpoint
+	{
+		getSuspensionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"GetSuspension",
+			encodeGRPCGetSuspensionRequest,
+			decodeGRPCGetSuspensionResponse,
+			pb.GetSuspensionReply{},
+			options...,
+		).Endpoint()
+	}
+	var getAccountStatusEndpoint endpoint.Endpoint
+	{
+		getAccountStatusEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"GetAccountStatus",
+			encodeGRPCGetAccountStatusRequest,
+			decodeGRPCGetAccountStatusResponse,
+			pb.GetAccountStatusReply{},
+			options...,
+		).Endpoint()
+	}
+	var listAuditEntriesEndpoint endpoint.Endpoint
+	{
+		listAuditEntriesEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ListAuditEntries",
+			encodeGRPCListAuditEntriesRequest,
+			decodeGRPCListAuditEntriesResponse,
+			pb.ListAuditEntriesReply{},
+			options...,
+		).Endpoint()
+	}
+	var listUsersEndpoint endpoint.Endpoint
+	{
+		listUsersEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ListUsers",
+			encodeGRPCListUsersRequest,
+			decodeGRPCListUsersResponse,
+			pb.ListUsersReply{},
+			options...,
+		).Endpoint()
+	}
+	var getUserEndpoint endpoint.Endpoint
+	{
+		getUserEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"GetUser",
+			encodeGRPCGetUserRequest,
+			decodeGRPCGetUserResponse,
+			pb.GetUserReply{},
+			options...,
+		).Endpoint()
+	}
+	var updateUserEndpoint endpoint.Endpoint
+	{
+		updateUserEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"UpdateUser",
+			encodeGRPCUpdateUserRequest,
+			decodeGRPCUpdateUserResponse,
+			pb.UpdateUserReply{},
+			options...,
+		).Endpoint()
+	}
+	var suspendEndpoint endpoint.Endpoint
+	{
+		suspendEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"Suspend",
+			encodeGRPCSuspendRequest,
+			decodeGRPCSuspendResponse,
+			pb.SuspendReply{},
+			options...,
+		).Endpoint()
+	}
+	var unsuspendEndpoint endpoint.Endpoint
+	{
+		unsuspendEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"Unsuspend",
+			encodeGRPCUnsuspendRequest,
+			decodeGRPCUnsuspendResponse,
+			pb.UnsuspendReply{},
+			options...,
+		).Endpoint()
+	}
+	var forceDeleteEndpoint endpoint.Endpoint
+	{
+		forceDeleteEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ForceDelete",
+			encodeGRPCForceDeleteRequest,
+			decodeGRPCForceDeleteResponse,
+			pb.ForceDeleteReply{},
+			options...,
+		).Endpoint()
+	}
+	var createAPIKeyEndpoint endpoint.Endpoint
+	{
+		createAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"CreateAPIKey",
+			encodeGRPCCreateAPIKeyRequest,
+			decodeGRPCCreateAPIKeyResponse,
+			pb.CreateAPIKeyReply{},
+			options...,
+		).Endpoint()
+	}
+	var listAPIKeysEndpoint endpoint.Endpoint
+	{
+		listAPIKeysEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ListAPIKeys",
+			encodeGRPCListAPIKeysRequest,
+			decodeGRPCListAPIKeysResponse,
+			pb.ListAPIKeysReply{},
+			options...,
+		).Endpoint()
+	}
+	var revokeAPIKeyEndpoint endpoint.Endpoint
+	{
+		revokeAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"RevokeAPIKey",
+			encodeGRPCRevokeAPIKeyRequest,
+			decodeGRPCRevokeAPIKeyResponse,
+			pb.RevokeAPIKeyReply{},
+			options...,
+		).Endpoint()
+	}
+	var verifyAPIKeyEndpoint endpoint.Endpoint
+	{
+		verifyAPIKeyEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"VerifyAPIKey",
+			encodeGRPCVerifyAPIKeyRequest,
+			decodeGRPCVerifyAPIKeyResponse,
+			pb.VerifyAPIKeyReply{},
+			options...,
+		).Endpoint()
+	}
+	var createSessionEndpoint endpoint.Endpoint
+	{
+		createSessionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"CreateSession",
+			encodeGRPCCreateSessionRequest,
+			decodeGRPCCreateSessionResponse,
+			pb.CreateSessionReply{},
+			options...,
+		).Endpoint()
+	}
+	var refreshSessionEndpoint endpoint.Endpoint
+	{
+		refreshSessionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"RefreshSession",
+			encodeGRPCRefreshSessionRequest,
+			decodeGRPCRefreshSessionResponse,
+			pb.RefreshSessionReply{},
+			options...,
+		).Endpoint()
+	}
+	var listSessionsEndpoint endpoint.Endpoint
+	{
+		listSessionsEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ListSessions",
+			encodeGRPCListSessionsRequest,
+			decodeGRPCListSessionsResponse,
+			pb.ListSessionsReply{},
+			options...,
+		).Endpoint()
+	}
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"RevokeSession",
+			encodeGRPCRevokeSessionRequest,
+			decodeGRPCRevokeSessionResponse,
+			pb.RevokeSessionReply{},
+			options...,
+		).Endpoint()
+	}
+	var revokeSessionsEndpoint endpoint.Endpoint
+	{
+		revokeSessionsEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"RevokeSessions",
+			encodeGRPCRevokeSessionsRequest,
+			decodeGRPCRevokeSessionsResponse,
+			pb.RevokeSessionsReply{},
+			options...,
+		).Endpoint()
+	}
+	var checkSessionEndpoint endpoint.Endpoint
+	{
+		checkSessionEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"CheckSession",
+			encodeGRPCCheckSessionRequest,
+			decodeGRPCCheckSessionResponse,
+			pb.CheckSessionReply{},
+			options...,
+		).Endpoint()
+	}
+	var getTwoFactorEndpoint endpoint.Endpoint
+	{
+		getTwoFactorEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"GetTwoFactor",
+			encodeGRPCGetTwoFactorRequest,
+			decodeGRPCGetTwoFactorResponse,
+			pb.GetTwoFactorReply{},
+			options...,
+		).Endpoint()
+	}
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"EnrollTOTP",
+			encodeGRPCEnrollTOTPRequest,
+			decodeGRPCEnrollTOTPResponse,
+			pb.EnrollTOTPReply{},
+			options...,
+		).Endpoint()
+	}
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ConfirmTOTP",
+			encodeGRPCConfirmTOTPRequest,
+			decodeGRPCConfirmTOTPResponse,
+			pb.ConfirmTOTPReply{},
+			options...,
+		).Endpoint()
+	}
+	var verifyTOTPEndpoint endpoint.Endpoint
+	{
+		verifyTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"VerifyTOTP",
+			encodeGRPCVerifyTOTPRequest,
+			decodeGRPCVerifyTOTPResponse,
+			pb.VerifyTOTPReply{},
+			options...,
+		).Endpoint()
+	}
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"DisableTOTP",
+			encodeGRPCDisableTOTPRequest,
+			decodeGRPCDisableTOTPResponse,
+			pb.DisableTOTPReply{},
+			options...,
 		).Endpoint()
 	}
 	return userendpoint.Set{
-		CreateProfileEndpoint: createProfileEndpoint,
-		GetProfileEndpoint:    getProfileEndpoint,
-		UpdateProfileEndpoint: updateProfileEndpoint,
-		DeleteProfileEndpoint: deleteProfileEndpoint,
+		CreateProfileEndpoint:    createProfileEndpoint,
+		GetProfileEndpoint:       getProfileEndpoint,
+		UpdateProfileEndpoint:    updateProfileEndpoint,
+		DeleteProfileEndpoint:    deleteProfileEndpoint,
+		GetSuspensionEndpoint:    getSuspensionEndpoint,
+		GetAccountStatusEndpoint: getAccountStatusEndpoint,
+		ListAuditEntriesEndpoint: listAuditEntriesEndpoint,
+
+		ListUsersEndpoint:   listUsersEndpoint,
+		GetUserEndpoint:     getUserEndpoint,
+		UpdateUserEndpoint:  updateUserEndpoint,
+		SuspendEndpoint:     suspendEndpoint,
+		UnsuspendEndpoint:   unsuspendEndpoint,
+		ForceDeleteEndpoint: forceDeleteEndpoint,
+
+		CreateAPIKeyEndpoint: createAPIKeyEndpoint,
+		ListAPIKeysEndpoint:  listAPIKeysEndpoint,
+		RevokeAPIKeyEndpoint: revokeAPIKeyEndpoint,
+		VerifyAPIKeyEndpoint: verifyAPIKeyEndpoint,
+
+		CreateSessionEndpoint:  createSessionEndpoint,
+		RefreshSessionEndpoint: refreshSessionEndpoint,
+		ListSessionsEndpoint:   listSessionsEndpoint,
+		RevokeSessionEndpoint:  revokeSessionEndpoint,
+		RevokeSessionsEndpoint: revokeSessionsEndpoint,
+		CheckSessionEndpoint:   checkSessionEndpoint,
+
+		GetTwoFactorEndpoint: getTwoFactorEndpoint,
+		EnrollTOTPEndpoint:   enrollTOTPEndpoint,
+		ConfirmTOTPEndpoint:  confirmTOTPEndpoint,
+		VerifyTOTPEndpoint:   verifyTOTPEndpoint,
+		DisableTOTPEndpoint:  disableTOTPEndpoint,
 	}
 }
 
@@ -218,6 +916,69 @@ func decodeGRPCDeleteResponse(_ context.Context, grpcReply interface{}) (interfa
 	return userendpoint.DeleteProfileResponse{Err: str2err(reply.Err)}, nil
 }
 
+// decodeGRPCGetSuspensionRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC get suspension request to a user-domain request. Primarily useful in a server.
+func decodeGRPCGetSuspensionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetSuspensionRequest)
+	return userendpoint.GetSuspensionRequest{UUID: req.Uuid}, nil
+}
+
+// encodeGRPCGetSuspensionResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC get suspension reply. Primarily useful in a server.
+func encodeGRPCGetSuspensionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.GetSuspensionResponse)
+	return &pb.GetSuspensionReply{Suspension: suspensionToPB(resp.Suspension), Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCGetSuspensionRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC get suspension request. Primarily useful in a client.
+func encodeGRPCGetSuspensionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.GetSuspensionRequest)
+	return &pb.GetSuspensionRequest{Uuid: req.UUID}, nil
+}
+
+// decodeGRPCGetSuspensionResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCGetSuspensionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.GetSuspensionReply)
+	return userendpoint.GetSuspensionResponse{Suspension: pbToSuspension(reply.Suspension), Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCGetAccountStatusRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC get account status request to a user-domain request. Primarily useful in a server.
+func decodeGRPCGetAccountStatusRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetAccountStatusRequest)
+	return userendpoint.GetAccountStatusRequest{UUID: req.Uuid}, nil
+}
+
+// encodeGRPCGetAccountStatusResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC get account status reply. Primarily useful in a server.
+func encodeGRPCGetAccountStatusResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.GetAccountStatusResponse)
+	return &pb.GetAccountStatusReply{
+		Roles:      resp.Status.Roles,
+		Suspension: suspensionToPB(resp.Status.Suspension),
+		Err:        err2str(resp.Err),
+	}, nil
+}
+
+// encodeGRPCGetAccountStatusRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC get account status request. Primarily useful in a client.
+func encodeGRPCGetAccountStatusRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.GetAccountStatusRequest)
+	return &pb.GetAccountStatusRequest{Uuid: req.UUID}, nil
+}
+
+// decodeGRPCGetAccountStatusResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCGetAccountStatusResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.GetAccountStatusReply)
+	return userendpoint.GetAccountStatusResponse{
+		Status: model.AccountStatus{Roles: reply.Roles, Suspension: pbToSuspension(reply.Suspension)},
+		Err:    str2err(reply.Err),
+	}, nil
+}
+
 // encodeGRPCCreateResponse is a transport/grpc.EncodeResponseFunc that converts a
 // user-domain response to a gRPC create user reply. Primarily useful in a server.
 func encodeGRPCCreateResponse(_ context.Context, response interface{}) (interface{}, error) {
@@ -297,9 +1058,130 @@ func encodeGRPCDeleteRequest(_ context.Context, request interface{}) (interface{
 	return &pb.DeleteRequest{Uuid: stringSafeDeref(&req.UUID)}, nil
 }
 
+// decodeGRPCListAuditEntriesRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC list audit entries request to a user-domain request. Primarily useful in a server.
+func decodeGRPCListAuditEntriesRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListAuditEntriesRequest)
+	return userendpoint.ListAuditEntriesRequest{
+		Actor:     req.Actor,
+		Target:    req.Target,
+		PageToken: req.PageToken,
+		PageSize:  int(req.PageSize),
+	}, nil
+}
+
+// encodeGRPCListAuditEntriesResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC list audit entries reply. Primarily useful in a server.
+func encodeGRPCListAuditEntriesResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ListAuditEntriesResponse)
+	entries := make([]*pb.AuditEntry, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		changes := make([]*pb.FieldChange, 0, len(e.Changes))
+		for _, c := range e.Changes {
+			changes = append(changes, &pb.FieldChange{Field: c.Field, Old: c.Old, New: c.New})
+		}
+		entries = append(entries, &pb.AuditEntry{
+			Id:        e.ID,
+			Action:    string(e.Action),
+			Actor:     e.Actor,
+			Target:    e.Target,
+			Changes:   changes,
+			Timestamp: e.Timestamp.UnixNano(),
+			RequestId: e.RequestID,
+			SourceIp:  e.SourceIP,
+		})
+	}
+	return &pb.ListAuditEntriesReply{
+		Entries:       entries,
+		NextPageToken: resp.NextPageToken,
+		Err:           err2str(resp.Err),
+	}, nil
+}
+
+// encodeGRPCListAuditEntriesRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC list audit entries request. Primarily useful in a client.
+func encodeGRPCListAuditEntriesRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ListAuditEntriesRequest)
+	return &pb.ListAuditEntriesRequest{
+		Actor:     req.Actor,
+		Target:    req.Target,
+		PageToken: req.PageToken,
+		PageSize:  int32(req.PageSize),
+	}, nil
+}
+
+// decodeGRPCListAuditEntriesResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCListAuditEntriesResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ListAuditEntriesReply)
+	entries := make([]model.AuditEntry, 0, len(reply.Entries))
+	for _, e := range reply.Entries {
+		var changes []model.FieldChange
+		for _, c := range e.Changes {
+			changes = append(changes, model.FieldChange{Field: c.Field, Old: c.Old, New: c.New})
+		}
+		entries = append(entries, model.AuditEntry{
+			ID:        e.Id,
+			Action:    model.AuditAction(e.Action),
+			Actor:     e.Actor,
+			Target:    e.Target,
+			Changes:   changes,
+			Timestamp: time.Unix(0, e.Timestamp).UTC(),
+			RequestID: e.RequestId,
+			SourceIP:  e.SourceIp,
+		})
+	}
+	return userendpoint.ListAuditEntriesResponse{
+		Entries:       entries,
+		NextPageToken: reply.NextPageToken,
+		Err:           str2err(reply.Err),
+	}, nil
+}
+
+// str2err restores the service errors that callers tell apart, which travel as
+// their message.
 func str2err(s string) error {
-	if s == "" {
+	switch s {
+	case "":
 		return nil
+	case userservice.ErrUserNotFound.Error():
+		return userservice.ErrUserNotFound
+	case userservice.ErrUnauthenticated.Error():
+		return userservice.ErrUnauthenticated
+	case userservice.ErrPermissionDenied.Error():
+		return userservice.ErrPermissionDenied
+	case userservice.ErrReasonRequired.Error():
+		return userservice.ErrReasonRequired
+	case userservice.ErrInvalidRole.Error():
+		return userservice.ErrInvalidRole
+	case userservice.ErrInvalidExpiry.Error():
+		return userservice.ErrInvalidExpiry
+	case userservice.ErrProfileUnavailable.Error():
+		return userservice.ErrProfileUnavailable
+	case userservice.ErrAPIKeyNotFound.Error():
+		return userservice.ErrAPIKeyNotFound
+	case userservice.ErrInvalidAPIKey.Error():
+		return userservice.ErrInvalidAPIKey
+	case userservice.ErrInvalidScope.Error():
+		return userservice.ErrInvalidScope
+	case userservice.ErrSessionNotFound.Error():
+		return userservice.ErrSessionNotFound
+	case userservice.ErrSessionRevoked.Error():
+		return userservice.ErrSessionRevoked
+	case userservice.ErrInvalidRefreshToken.Error():
+		return userservice.ErrInvalidRefreshToken
+	case userservice.ErrRefreshTokenReused.Error():
+		return userservice.ErrRefreshTokenReused
+	case userservice.ErrTwoFactorEnabled.Error():
+		return userservice.ErrTwoFactorEnabled
+	case userservice.ErrTwoFactorNotEnabled.Error():
+		return userservice.ErrTwoFactorNotEnabled
+	case userservice.ErrTwoFactorRequired.Error():
+		return userservice.ErrTwoFactorRequired
+	case userservice.ErrInvalidTwoFactorCode.Error():
+		return userservice.ErrInvalidTwoFactorCode
+	case userservice.ErrTwoFactorLocked.Error():
+		return userservice.ErrTwoFactorLocked
 	}
 	return errors.New(s)
 }
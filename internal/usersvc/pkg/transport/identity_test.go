@@ -0,0 +1,138 @@
+package usertransport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"google.golang.org/grpc/metadata"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testIdentityKey = []byte("0123456789abcdef0123456789abcdef")
+
+// forward sends the call context of ctx from a gateway signing with
+// signingKey to a usersvc verifying with testIdentityKey, and returns the
+// context usersvc serves the call with. extra is added to the metadata on the
+// way, as a client could.
+func forward(ctx context.Context, signingKey []byte, extra metadata.MD) context.Context {
+	md := metadata.MD{}
+	ctx = contextToGRPCMetadata(ctx, &md)
+	NewIdentitySigner(signingKey, time.Minute).SignCaller(ctx, &md)
+	for k, v := range extra {
+		md[k] = v
+	}
+	server := grpcMetadataToContext(context.Background(), md)
+	return NewIdentityVerifier(testIdentityKey, log.NewNopLogger()).VerifyCaller(server, md)
+}
+
+func TestForwardedSourceIP(t *testing.T) {
+	caller := userservice.Caller{ID: "u1", Roles: []string{"admin"}}
+	for _, tc := range []struct {
+		name       string
+		caller     *userservice.Caller
+		signingKey []byte
+		extra      metadata.MD
+		wantCaller bool
+		wantIP     string
+	}{
+		{
+			name:       "signed with the caller",
+			caller:     &caller,
+			signingKey: testIdentityKey,
+			wantCaller: true,
+			wantIP:     "203.0.113.7",
+		},
+		{
+			name:       "forwarded address replaced by the signed one",
+			caller:     &caller,
+			signingKey: testIdentityKey,
+			extra:      metadata.Pairs(sourceIPMetadataKey, "198.51.100.1"),
+			wantCaller: true,
+			wantIP:     "203.0.113.7",
+		},
+		{
+			name:       "identity signed with another key",
+			caller:     &caller,
+			signingKey: []byte("another key of at least 32 bytes!"),
+			wantIP:     "203.0.113.7",
+		},
+		{
+			name:   "no caller",
+			wantIP: "203.0.113.7",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := userservice.ContextWithSourceIP(context.Background(), "203.0.113.7")
+			if tc.caller != nil {
+				ctx = userservice.ContextWithCaller(ctx, *tc.caller)
+			}
+			got := forward(ctx, tc.signingKey, tc.extra)
+
+			c, ok := userservice.CallerFromContext(got)
+			if ok != tc.wantCaller {
+				t.Fatalf("caller = %+v, %v, want caller %v", c, ok, tc.wantCaller)
+			}
+			if ok && c.ID != caller.ID {
+				t.Errorf("caller ID = %q, want %q", c.ID, caller.ID)
+			}
+			if ip, _ := userservice.SourceIPFromContext(got); ip != tc.wantIP {
+				t.Errorf("source IP = %q, want %q", ip, tc.wantIP)
+			}
+		})
+	}
+}
+
+func TestIdentityVerifierVerify(t *testing.T) {
+	now := time.Now()
+	v := NewIdentityVerifier(testIdentityKey, log.NewNopLogger())
+	token := func(key []byte, claims identityClaims) string {
+		payload, _ := json.Marshal(claims)
+		encoded := base64.RawURLEncoding.EncodeToString(payload)
+		return encoded + "." + sign(key, encoded)
+	}
+	valid := identityClaims{Subject: "u1", Roles: []string{"admin"}, SourceIP: "203.0.113.7", ExpiresAt: now.Add(time.Minute).Unix()}
+	tampered := token(testIdentityKey, valid)
+	_, signature, _ := strings.Cut(tampered, ".")
+	forged, _ := json.Marshal(identityClaims{Subject: "u2", Roles: []string{"admin"}, ExpiresAt: valid.ExpiresAt})
+	tampered = base64.RawURLEncoding.EncodeToString(forged) + "." + signature
+
+	for _, tc := range []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", token(testIdentityKey, valid), false},
+		{"expired", token(testIdentityKey, identityClaims{Subject: "u1", ExpiresAt: now.Add(-time.Second).Unix()}), true},
+		{"signed with another key", token([]byte("another key of at least 32 bytes!"), valid), true},
+		{"payload changed after signing", tampered, true},
+		{"without signature", strings.Split(token(testIdentityKey, valid), ".")[0], true},
+		{"without subject", token(testIdentityKey, identityClaims{ExpiresAt: valid.ExpiresAt}), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.verify(tc.token, now)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("verify() = %+v, %v, want error %v", claims, err, tc.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(claims, valid) {
+				t.Errorf("verify() = %+v, want %+v", claims, valid)
+			}
+		})
+	}
+}
+
+func TestForwardedRoles(t *testing.T) {
+	ctx := userservice.ContextWithCaller(context.Background(), userservice.Caller{ID: "u1", Roles: []string{"moderator"}})
+	got := forward(ctx, testIdentityKey, nil)
+	c, _ := userservice.CallerFromContext(got)
+	if !c.HasRole("moderator") || c.HasRole("admin") {
+		t.Errorf("caller roles = %v, want [moderator]", c.Roles)
+	}
+	if actor, _ := userservice.ActorFromContext(got); actor != "u1" {
+		t.Errorf("actor = %q, want the caller", actor)
+	}
+}
@@ -0,0 +1,59 @@
+package usertransport
+
+import (
+	"context"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
+)
+
+// gRPC metadata keys used to carry call context from the gateway to usersvc.
+const (
+	actorMetadataKey     = "x-actor-id"
+	requestIDMetadataKey = "x-request-id"
+	sourceIPMetadataKey  = "x-forwarded-for"
+)
+
+// contextToGRPCMetadata is a transport/grpc.ClientRequestFunc that copies the
+// call context set up by the caller into outgoing gRPC metadata.
+func contextToGRPCMetadata(ctx context.Context, md *metadata.MD) context.Context {
+	if actor, ok := userservice.ActorFromContext(ctx); ok {
+		(*md)[actorMetadataKey] = []string{actor}
+	}
+	if requestID, ok := userservice.RequestIDFromContext(ctx); ok {
+		(*md)[requestIDMetadataKey] = []string{requestID}
+	}
+	if ip, ok := userservice.SourceIPFromContext(ctx); ok {
+		(*md)[sourceIPMetadataKey] = []string{ip}
+	}
+	return ctx
+}
+
+// grpcMetadataToContext is a transport/grpc.ServerRequestFunc that moves the call
+// context sent by contextToGRPCMetadata into the request context. Without a
+// forwarded address the source IP falls back to the gRPC peer.
+func grpcMetadataToContext(ctx context.Context, md metadata.MD) context.Context {
+	if actor := firstMetadataValue(md, actorMetadataKey); actor != "" {
+		ctx = userservice.ContextWithActor(ctx, actor)
+	}
+	if requestID := firstMetadataValue(md, requestIDMetadataKey); requestID != "" {
+		ctx = userservice.ContextWithRequestID(ctx, requestID)
+	}
+	if ip := firstMetadataValue(md, sourceIPMetadataKey); ip != "" {
+		ctx = userservice.ContextWithSourceIP(ctx, strings.TrimSpace(strings.Split(ip, ",")[0]))
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			ctx = userservice.ContextWithSourceIP(ctx, host)
+		}
+	}
+	return ctx
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	userservice "github.com/yuisofull/gommunigate/internal/usersvc/pkg/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+func TestCallMetadata(t *testing.T) {
+	from := context.Background()
+	from = userservice.ContextWithActor(from, "u1")
+	from = userservice.ContextWithRequestID(from, "req-1")
+	from = userservice.ContextWithSourceIP(from, "203.0.113.7")
+	md := metadata.MD{}
+	contextToGRPCMetadata(from, &md)
+
+	ctx := grpcMetadataToContext(context.Background(), md)
+	if actor, _ := userservice.ActorFromContext(ctx); actor != "u1" {
+		t.Errorf("actor = %q", actor)
+	}
+	if id, _ := userservice.RequestIDFromContext(ctx); id != "req-1" {
+		t.Errorf("request ID = %q", id)
+	}
+	if ip, _ := userservice.SourceIPFromContext(ctx); ip != "203.0.113.7" {
+		t.Errorf("source IP = %q", ip)
+	}
+}
+
+func TestSourceIPFallsBackToPeer(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 5000}})
+	ctx = grpcMetadataToContext(ctx, metadata.MD{})
+	if ip, _ := userservice.SourceIPFromContext(ctx); ip != "10.0.0.5" {
+		t.Errorf("source IP = %q, want the peer's", ip)
+	}
+}
+
+func TestAccessLogInterceptor(t *testing.T) {
+	var logged []interface{}
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		logged = keyvals
+		return nil
+	})
+	interceptor := AccessLogInterceptor(logger)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.User/GetProfile"}
+
+	for _, tc := range []struct {
+		name      string
+		requestID string
+	}{
+		{"forwarded request ID", "req-1"},
+		{"request ID assigned", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tc.requestID != "" {
+				md.Set(requestIDMetadataKey, tc.requestID)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+			var served string
+			interceptor(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				served, _ = userservice.RequestIDFromContext(grpcMetadataToContext(ctx, md))
+				return nil, nil
+			})
+			if served == "" || (tc.requestID != "" && served != tc.requestID) {
+				t.Errorf("served with request ID %q", served)
+			}
+			if len(logged) < 2 || logged[0] != "request_id" || logged[1] != served {
+				t.Errorf("logged %v, want request_id %q first", logged, served)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+)
+
+// decodeGRPCCreateSessionRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC create session request to a user-domain request. Primarily useful in a server.
+func decodeGRPCCreateSessionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateSessionRequest)
+	return userendpoint.CreateSessionRequest{Device: req.Device}, nil
+}
+
+// encodeGRPCCreateSessionResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC create session reply. Primarily useful in a server.
+func encodeGRPCCreateSessionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.CreateSessionResponse)
+	reply := &pb.CreateSessionReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.Session = sessionToPB(resp.Session)
+		reply.RefreshToken = resp.RefreshToken
+	}
+	return reply, nil
+}
+
+// encodeGRPCCreateSessionRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC create session request. Primarily useful in a client.
+func encodeGRPCCreateSessionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.CreateSessionRequest)
+	return &pb.CreateSessionRequest{Device: req.Device}, nil
+}
+
+// decodeGRPCCreateSessionResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCCreateSessionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.CreateSessionReply)
+	return userendpoint.CreateSessionResponse{
+		Session:      pbToSession(reply.Session),
+		RefreshToken: reply.RefreshToken,
+		Err:          str2err(reply.Err),
+	}, nil
+}
+
+// decodeGRPCRefreshSessionRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC refresh session request to a user-domain request. Primarily useful in a server.
+func decodeGRPCRefreshSessionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RefreshSessionRequest)
+	return userendpoint.RefreshSessionRequest{RefreshToken: req.RefreshToken, Device: req.Device}, nil
+}
+
+// encodeGRPCRefreshSessionResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC refresh session reply. Primarily useful in a server.
+func encodeGRPCRefreshSessionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.RefreshSessionResponse)
+	reply := &pb.RefreshSessionReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.Session = sessionToPB(resp.Session)
+		reply.RefreshToken = resp.RefreshToken
+	}
+	return reply, nil
+}
+
+// encodeGRPCRefreshSessionRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC refresh session request. Primarily useful in a client.
+func encodeGRPCRefreshSessionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.RefreshSessionRequest)
+	return &pb.RefreshSessionRequest{RefreshToken: req.RefreshToken, Device: req.Device}, nil
+}
+
+// decodeGRPCRefreshSessionResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCRefreshSessionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RefreshSessionReply)
+	return userendpoint.RefreshSessionResponse{
+		Session:      pbToSession(reply.Session),
+		RefreshToken: reply.RefreshToken,
+		Err:          str2err(reply.Err),
+	}, nil
+}
+
+// decodeGRPCListSessionsRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC list sessions request to a user-domain request. Primarily useful in a server.
+func decodeGRPCListSessionsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return userendpoint.ListSessionsRequest{}, nil
+}
+
+// encodeGRPCListSessionsResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC list sessions reply. Primarily useful in a server.
+func encodeGRPCListSessionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ListSessionsResponse)
+	sessions := make([]*pb.Session, 0, len(resp.Sessions))
+	for _, s := range resp.Sessions {
+		sessions = append(sessions, sessionToPB(s))
+	}
+	return &pb.ListSessionsReply{Sessions: sessions, Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCListSessionsRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC list sessions request. Primarily useful in a client.
+func encodeGRPCListSessionsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.ListSessionsRequest{}, nil
+}
+
+// decodeGRPCListSessionsResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCListSessionsResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ListSessionsReply)
+	sessions := make([]model.Session, 0, len(reply.Sessions))
+	for _, s := range reply.Sessions {
+		sessions = append(sessions, pbToSession(s))
+	}
+	return userendpoint.ListSessionsResponse{Sessions: sessions, Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCRevokeSessionRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC revoke session request to a user-domain request. Primarily useful in a server.
+func decodeGRPCRevokeSessionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RevokeSessionRequest)
+	return userendpoint.RevokeSessionRequest{ID: req.Id}, nil
+}
+
+// encodeGRPCRevokeSessionResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC revoke session reply. Primarily useful in a server.
+func encodeGRPCRevokeSessionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.RevokeSessionResponse)
+	return &pb.RevokeSessionReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCRevokeSessionRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC revoke session request. Primarily useful in a client.
+func encodeGRPCRevokeSessionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.RevokeSessionRequest)
+	return &pb.RevokeSessionRequest{Id: req.ID}, nil
+}
+
+// decodeGRPCRevokeSessionResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCRevokeSessionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RevokeSessionReply)
+	return userendpoint.RevokeSessionResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCRevokeSessionsRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC revoke sessions request to a user-domain request. Primarily useful in a server.
+func decodeGRPCRevokeSessionsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return userendpoint.RevokeSessionsRequest{}, nil
+}
+
+// encodeGRPCRevokeSessionsResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC revoke sessions reply. Primarily useful in a server.
+func encodeGRPCRevokeSessionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.RevokeSessionsResponse)
+	return &pb.RevokeSessionsReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCRevokeSessionsRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC revoke sessions request. Primarily useful in a client.
+func encodeGRPCRevokeSessionsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.RevokeSessionsRequest{}, nil
+}
+
+// decodeGRPCRevokeSessionsResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCRevokeSessionsResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.RevokeSessionsReply)
+	return userendpoint.RevokeSessionsResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCCheckSessionRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC check session request to a user-domain request. Primarily useful in a server.
+func decodeGRPCCheckSessionRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CheckSessionRequest)
+	return userendpoint.CheckSessionRequest{ID: req.Id}, nil
+}
+
+// encodeGRPCCheckSessionResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC check session reply. Primarily useful in a server.
+func encodeGRPCCheckSessionResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.CheckSessionResponse)
+	return &pb.CheckSessionReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCCheckSessionRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC check session request. Primarily useful in a client.
+func encodeGRPCCheckSessionRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.CheckSessionRequest)
+	return &pb.CheckSessionRequest{Id: req.ID}, nil
+}
+
+// decodeGRPCCheckSessionResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCCheckSessionResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.CheckSessionReply)
+	return userendpoint.CheckSessionResponse{Err: str2err(reply.Err)}, nil
+}
+
+func sessionToPB(s model.Session) *pb.Session {
+	return &pb.Session{
+		Id:         s.ID,
+		UserId:     s.UserID,
+		Device:     s.Device,
+		Ip:         s.IP,
+		Roles:      s.Roles,
+		CreatedAt:  unixNano(s.CreatedAt),
+		LastUsedAt: unixNano(s.LastUsedAt),
+		ExpiresAt:  unixNano(s.ExpiresAt),
+		RevokedAt:  unixNano(s.RevokedAt),
+	}
+}
+
+func pbToSession(s *pb.Session) model.Session {
+	if s == nil {
+		return model.Session{}
+	}
+	return model.Session{
+		ID:         s.Id,
+		UserID:     s.UserId,
+		Device:     s.Device,
+		IP:         s.Ip,
+		Roles:      s.Roles,
+		CreatedAt:  timeFromUnixNano(s.CreatedAt),
+		LastUsedAt: timeFromUnixNano(s.LastUsedAt),
+		ExpiresAt:  timeFromUnixNano(s.ExpiresAt),
+		RevokedAt:  timeFromUnixNano(s.RevokedAt),
+	}
+}
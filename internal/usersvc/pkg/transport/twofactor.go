@@ -0,0 +1,167 @@
+package usertransport
+
+import (
+	"context"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pb"
+	userendpoint "github.com/yuisofull/gommunigate/internal/usersvc/pkg/endpoint"
+	"github.com/yuisofull/gommunigate/internal/usersvc/pkg/model"
+)
+
+// decodeGRPCGetTwoFactorRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC get two factor request to a user-domain request. Primarily useful in a server.
+func decodeGRPCGetTwoFactorRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return userendpoint.GetTwoFactorRequest{}, nil
+}
+
+// encodeGRPCGetTwoFactorResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC get two factor reply. Primarily useful in a server.
+func encodeGRPCGetTwoFactorResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.GetTwoFactorResponse)
+	reply := &pb.GetTwoFactorReply{Err: err2str(resp.Err)}
+	if resp.Err == nil {
+		reply.TwoFactor = twoFactorToPB(resp.TwoFactor)
+	}
+	return reply, nil
+}
+
+// encodeGRPCGetTwoFactorRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC get two factor request. Primarily useful in a client.
+func encodeGRPCGetTwoFactorRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.GetTwoFactorRequest{}, nil
+}
+
+// decodeGRPCGetTwoFactorResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCGetTwoFactorResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.GetTwoFactorReply)
+	return userendpoint.GetTwoFactorResponse{TwoFactor: pbToTwoFactor(reply.TwoFactor), Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCEnrollTOTPRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC enroll TOTP request to a user-domain request. Primarily useful in a server.
+func decodeGRPCEnrollTOTPRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return userendpoint.EnrollTOTPRequest{}, nil
+}
+
+// encodeGRPCEnrollTOTPResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC enroll TOTP reply. Primarily useful in a server.
+func encodeGRPCEnrollTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.EnrollTOTPResponse)
+	return &pb.EnrollTOTPReply{Secret: resp.Secret, Uri: resp.URI, Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCEnrollTOTPRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC enroll TOTP request. Primarily useful in a client.
+func encodeGRPCEnrollTOTPRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.EnrollTOTPRequest{}, nil
+}
+
+// decodeGRPCEnrollTOTPResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCEnrollTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.EnrollTOTPReply)
+	return userendpoint.EnrollTOTPResponse{Secret: reply.Secret, URI: reply.Uri, Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCConfirmTOTPRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC confirm TOTP request to a user-domain request. Primarily useful in a server.
+func decodeGRPCConfirmTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ConfirmTOTPRequest)
+	return userendpoint.ConfirmTOTPRequest{Code: req.Code}, nil
+}
+
+// encodeGRPCConfirmTOTPResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC confirm TOTP reply. Primarily useful in a server.
+func encodeGRPCConfirmTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ConfirmTOTPResponse)
+	return &pb.ConfirmTOTPReply{RecoveryCodes: resp.RecoveryCodes, Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCConfirmTOTPRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC confirm TOTP request. Primarily useful in a client.
+func encodeGRPCConfirmTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ConfirmTOTPRequest)
+	return &pb.ConfirmTOTPRequest{Code: req.Code}, nil
+}
+
+// decodeGRPCConfirmTOTPResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCConfirmTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ConfirmTOTPReply)
+	return userendpoint.ConfirmTOTPResponse{RecoveryCodes: reply.RecoveryCodes, Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCVerifyTOTPRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC verify TOTP request to a user-domain request. Primarily useful in a server.
+func decodeGRPCVerifyTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.VerifyTOTPRequest)
+	return userendpoint.VerifyTOTPRequest{Code: req.Code}, nil
+}
+
+// encodeGRPCVerifyTOTPResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC verify TOTP reply. Primarily useful in a server.
+func encodeGRPCVerifyTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.VerifyTOTPResponse)
+	return &pb.VerifyTOTPReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCVerifyTOTPRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC verify TOTP request. Primarily useful in a client.
+func encodeGRPCVerifyTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.VerifyTOTPRequest)
+	return &pb.VerifyTOTPRequest{Code: req.Code}, nil
+}
+
+// decodeGRPCVerifyTOTPResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCVerifyTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.VerifyTOTPReply)
+	return userendpoint.VerifyTOTPResponse{Err: str2err(reply.Err)}, nil
+}
+
+// decodeGRPCDisableTOTPRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC disable TOTP request to a user-domain request. Primarily useful in a server.
+func decodeGRPCDisableTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DisableTOTPRequest)
+	return userendpoint.DisableTOTPRequest{Code: req.Code}, nil
+}
+
+// encodeGRPCDisableTOTPResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain response to a gRPC disable TOTP reply. Primarily useful in a server.
+func encodeGRPCDisableTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.DisableTOTPResponse)
+	return &pb.DisableTOTPReply{Err: err2str(resp.Err)}, nil
+}
+
+// encodeGRPCDisableTOTPRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain request to a gRPC disable TOTP request. Primarily useful in a client.
+func encodeGRPCDisableTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.DisableTOTPRequest)
+	return &pb.DisableTOTPRequest{Code: req.Code}, nil
+}
+
+// decodeGRPCDisableTOTPResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC reply to a user-domain response. Primarily useful in a client.
+func decodeGRPCDisableTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.DisableTOTPReply)
+	return userendpoint.DisableTOTPResponse{Err: str2err(reply.Err)}, nil
+}
+
+func twoFactorToPB(t model.TwoFactor) *pb.TwoFactor {
+	return &pb.TwoFactor{
+		Enabled:       t.Enabled,
+		EnabledAt:     unixNano(t.EnabledAt),
+		RecoveryCodes: int32(t.RecoveryCodes),
+	}
+}
+
+func pbToTwoFactor(t *pb.TwoFactor) model.TwoFactor {
+	if t == nil {
+		return model.TwoFactor{}
+	}
+	return model.TwoFactor{
+		Enabled:       t.Enabled,
+		EnabledAt:     timeFromUnixNano(t.EnabledAt),
+		RecoveryCodes: int(t.RecoveryCodes),
+	}
+}